@@ -63,6 +63,36 @@ func runBackupRawCommand(command *cobra.Command, cmdName string) error {
 	return nil
 }
 
+func runBackupGCCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.BackupGCConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+
+	ctx := GetDefaultContext()
+	if err := task.RunBackupGC(ctx, gluetikv.Glue{}, cmdName, &cfg); err != nil {
+		log.Error("failed to gc backups", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+func runBackupCopyCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.BackupCopyConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+
+	ctx := GetDefaultContext()
+	if err := task.RunBackupCopy(ctx, gluetikv.Glue{}, cmdName, &cfg); err != nil {
+		log.Error("failed to copy backup", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
 // NewBackupCommand return a full backup subcommand.
 func NewBackupCommand() *cobra.Command {
 	command := &cobra.Command{
@@ -89,6 +119,8 @@ func NewBackupCommand() *cobra.Command {
 		newDBBackupCommand(),
 		newTableBackupCommand(),
 		newRawBackupCommand(),
+		newBackupGCCommand(),
+		newBackupCopyCommand(),
 	)
 
 	task.DefineBackupFlags(command.PersistentFlags())
@@ -155,3 +187,33 @@ func newRawBackupCommand() *cobra.Command {
 	task.DefineRawBackupFlags(command)
 	return command
 }
+
+// newBackupGCCommand return a subcommand removing the expired backups.
+func newBackupGCCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "gc",
+		Short: "remove the expired backups in the sub directories of the storage",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			return runBackupGCCommand(command, "Backup gc")
+		},
+	}
+
+	task.DefineBackupGCFlags(command)
+	return command
+}
+
+// newBackupCopyCommand return a subcommand copying a backup to another storage.
+func newBackupCopyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "copy",
+		Short: "copy a backup to another storage, optionally re-encrypting or recompressing a raw backup",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			return runBackupCopyCommand(command, "Backup copy")
+		},
+	}
+
+	task.DefineBackupCopyFlags(command)
+	return command
+}
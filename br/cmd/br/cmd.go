@@ -5,6 +5,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -14,6 +15,7 @@ import (
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
 	tidbutils "github.com/pingcap/tidb-tools/pkg/utils"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/gluetidb"
 	"github.com/tikv/migration/br/pkg/redact"
 	"github.com/tikv/migration/br/pkg/summary"
@@ -61,6 +63,13 @@ const (
 	FlagRedactLog = "redact-log"
 	// FlagRedactInfoLog is whether to redact sensitive information in log.
 	FlagRedactInfoLog = "redact-info-log"
+	// FlagOutputFormat is the name of output-format flag.
+	FlagOutputFormat = "output-format"
+	// FlagOutputFile is the name of output-file flag.
+	FlagOutputFile = "output-file"
+
+	outputFormatText = "text"
+	outputFormatJSON = "json"
 
 	flagVersion      = "version"
 	flagVersionShort = "V"
@@ -86,6 +95,11 @@ func AddFlags(cmd *cobra.Command) {
 		"Set whether to redact sensitive info in log, already deprecated by --redact-info-log")
 	cmd.PersistentFlags().Bool(FlagRedactInfoLog, false,
 		"Set whether to redact sensitive info in log")
+	cmd.PersistentFlags().String(FlagOutputFormat, outputFormatText,
+		"Set the format of the progress and the summary, value can be one of 'text|json', "+
+			"the json format writes the progress events and the summary as JSON lines")
+	cmd.PersistentFlags().String(FlagOutputFile, "",
+		"Set the file to write the JSON progress events and summary to. If not set, they are written to stdout")
 	cmd.PersistentFlags().String(FlagStatusAddr, "",
 		"Set the HTTP listening address for the status report service. Set to empty string to disable")
 	task.DefineCommonFlags(cmd.PersistentFlags())
@@ -163,11 +177,68 @@ func Init(cmd *cobra.Command) (err error) {
 			return
 		}
 		redact.InitRedact(redactLog || redactInfoLog)
+		if err = initOutput(cmd); err != nil {
+			return
+		}
 		err = startPProf(cmd)
 	})
 	return errors.Trace(err)
 }
 
+// jsonOutputFile is the file of --output-file, it's closed by closeOutput.
+var jsonOutputFile *os.File
+
+// initOutput makes the progress and the summary written as JSON lines if the
+// output format is json.
+func initOutput(cmd *cobra.Command) error {
+	// Some subcommands define their own output-format flag for the data they
+	// output, so the flag is read from the root.
+	format, err := cmd.Root().PersistentFlags().GetString(FlagOutputFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	switch format {
+	case outputFormatText:
+		return nil
+	case outputFormatJSON:
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %s", FlagOutputFormat, format)
+	}
+	outputFile, err := cmd.Root().PersistentFlags().GetString(FlagOutputFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var output io.Writer = os.Stdout
+	if len(outputFile) > 0 {
+		f, err := os.Create(outputFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		output = f
+		jsonOutputFile = f
+	}
+	// The summary isn't duplicated to stdout, which is for the JSON lines.
+	summary.SetLogCollector(summary.NewLogCollector(log.Info))
+	summary.SetJSONOutput(output)
+	return nil
+}
+
+// closeOutput syncs and closes the file of --output-file if it's set, nothing
+// is written to the JSON output after that.
+func closeOutput() error {
+	summary.SetJSONOutput(nil)
+	if jsonOutputFile == nil {
+		return nil
+	}
+	f := jsonOutputFile
+	jsonOutputFile = nil
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return errors.Annotatef(err, "failed to sync the output file %s", f.Name())
+	}
+	return errors.Annotatef(f.Close(), "failed to close the output file %s", f.Name())
+}
+
 func startPProf(cmd *cobra.Command) error {
 	// Initialize the pprof server.
 	statusAddr, err := cmd.Flags().GetString(FlagStatusAddr)
@@ -8,6 +8,8 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"io"
+	"os"
 	"path"
 	"reflect"
 
@@ -53,6 +55,8 @@ func NewDebugCommand() *cobra.Command {
 	meta.AddCommand(decodeBackupMetaCommand())
 	meta.AddCommand(encodeBackupMetaCommand())
 	meta.AddCommand(setPDConfigCommand())
+	meta.AddCommand(newDumpSSTCommand())
+	meta.AddCommand(newVerifyCommand())
 	meta.Hidden = true
 
 	return meta
@@ -347,6 +351,9 @@ func encodeBackupMetaCommand() *cobra.Command {
 				fileName += "_from_json"
 			}
 
+			if err = cfg.LoadDataKey(ctx, s); err != nil {
+				return errors.Trace(err)
+			}
 			encryptedContent, iv, err := metautil.Encrypt(backupMeta, &cfg.CipherInfo)
 			if err != nil {
 				return errors.Trace(err)
@@ -391,3 +398,59 @@ func setPDConfigCommand() *cobra.Command {
 	}
 	return pdConfigCmd
 }
+
+func newDumpSSTCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "dump-sst",
+		Short: "dump the kv pairs in the raw backup files",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.DumpRawConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return errors.Trace(err)
+			}
+
+			var out io.Writer = cmd.OutOrStdout()
+			if len(output) > 0 {
+				f, err := os.Create(output)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				defer f.Close()
+				out = f
+			}
+			return errors.Trace(task.RunDumpRaw(ctx, &cfg, out))
+		},
+	}
+	task.DefineDumpRawFlags(command)
+	command.Flags().String("output", "", "the local file to write the kv pairs to, they're printed by default")
+	return command
+}
+
+func newVerifyCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use: "verify",
+		Short: "verify the files of the raw backup are intact and cover the backed up ranges, " +
+			"without connecting to the cluster",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := context.WithCancel(GetDefaultContext())
+			defer cancel()
+
+			var cfg task.VerifyRawConfig
+			if err := cfg.ParseFromFlags(cmd.Flags()); err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Trace(task.RunVerifyRaw(ctx, &cfg, cmd.OutOrStdout()))
+		},
+	}
+	task.DefineVerifyRawFlags(command)
+	return command
+}
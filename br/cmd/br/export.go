@@ -0,0 +1,63 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func runExportRawCommand(command *cobra.Command, cmdName string) error {
+	cfg := task.ExportRawConfig{Config: task.Config{LogProgress: HasLogFile()}}
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+
+	ctx := GetDefaultContext()
+	if err := task.RunExportRaw(ctx, gluetikv.Glue{}, cmdName, &cfg); err != nil {
+		log.Error("failed to export raw kv", zap.Error(err))
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// NewExportCommand return an export subcommand.
+func NewExportCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:          "export",
+		Short:        "export the backup data to files of other formats",
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+	}
+	command.AddCommand(newRawExportCommand())
+	return command
+}
+
+// newRawExportCommand return a raw kv backup export subcommand.
+func newRawExportCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "raw",
+		Short: "export the kv pairs in a raw backup to parquet or csv files",
+		Args:  cobra.NoArgs,
+		RunE: func(command *cobra.Command, _ []string) error {
+			return runExportRawCommand(command, "Raw export")
+		},
+	}
+	task.DefineExportRawFlags(command)
+	return command
+}
@@ -8,6 +8,7 @@ import (
 	"syscall"
 
 	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -48,6 +49,8 @@ func main() {
 		NewDebugCommand(),
 		NewBackupCommand(),
 		NewRestoreCommand(),
+		NewExportCommand(),
+		NewServerCommand(),
 	)
 	// Ouputs cmd.Print to stdout.
 	rootCmd.SetOut(os.Stdout)
@@ -56,6 +59,14 @@ func main() {
 	if err := rootCmd.Execute(); err != nil {
 		cancel()
 		log.Error("br failed", zap.Error(err))
+		_ = summary.WriteJSONEvent(summary.ErrorEvent{Type: summary.EventError, Error: err.Error()})
+		if err = closeOutput(); err != nil {
+			log.Error("br failed to close the output", zap.Error(err))
+		}
 		os.Exit(1) // nolint:gocritic
 	}
+	if err := closeOutput(); err != nil {
+		log.Error("br failed to close the output", zap.Error(err))
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,140 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	tidbutils "github.com/pingcap/tidb-tools/pkg/utils"
+	"github.com/tikv/migration/br/pkg/rawkv"
+	"github.com/tikv/migration/br/pkg/server"
+	"github.com/tikv/migration/br/pkg/task"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/tikv/migration/br/pkg/version/build"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+const (
+	flagServerAddr      = "addr"
+	flagServerDataDir   = "data-dir"
+	flagServerTokenFile = "token-file"
+)
+
+func runServerCommand(command *cobra.Command) error {
+	var cfg task.Config
+	if err := cfg.ParseFromFlags(command.Flags()); err != nil {
+		command.SilenceUsage = false
+		return errors.Trace(err)
+	}
+	addr, err := command.Flags().GetString(flagServerAddr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dataDir, err := command.Flags().GetString(flagServerDataDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tokenFile, err := command.Flags().GetString(flagServerTokenFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var token string
+	if len(tokenFile) > 0 {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return errors.Annotatef(err, "failed to read the token file %s", tokenFile)
+		}
+		token = strings.TrimSpace(string(content))
+		if len(token) == 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "the token file %s is empty", tokenFile)
+		}
+	}
+	// The server is secured by the same certificates as the status address,
+	// and the clients must present the certificates signed by the same CA.
+	tlsWrapper, err := tidbutils.NewTLS(cfg.TLS.CA, cfg.TLS.Cert, cfg.TLS.Key, "localhost", nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tlsConfig := tlsWrapper.TLSConfig()
+	if tlsConfig == nil && len(token) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the server requires authentication, please specify --%s or the tls certificates", flagServerTokenFile)
+	}
+
+	ctx, cancel := context.WithCancel(GetDefaultContext())
+	defer cancel()
+	s, err := server.New(ctx, server.Config{
+		DataDir: dataDir,
+		PD:      cfg.PD,
+		TLS:     rawkv.TLSOptions{CA: cfg.TLS.CA, Cert: cfg.TLS.Cert, Key: cfg.TLS.Key},
+		Token:   token,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer s.Close()
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Annotatef(err, "failed to listen on %s", addr)
+	}
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	httpServer := &http.Server{Handler: s.Handler()}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.Serve(listener)
+	}()
+	log.Info("server started", zap.Stringer("addr", listener.Addr()), zap.String("data-dir", dataDir))
+	command.Printf("BR server is listening on %s\n", listener.Addr())
+
+	select {
+	case <-ctx.Done():
+		log.Info("server is shutting down")
+		return errors.Trace(httpServer.Close())
+	case err = <-errCh:
+		return errors.Annotate(err, "server stopped")
+	}
+}
+
+// NewServerCommand returns a server subcommand.
+func NewServerCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use: "server",
+		Short: "(experimental) run the raw backup and restore jobs submitted by the HTTP API, " +
+			"the backup jobs can run on cron schedules",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := Init(c); err != nil {
+				return errors.Trace(err)
+			}
+			build.LogInfo(build.BR)
+			utils.LogEnvVariables()
+			task.LogArguments(c)
+			return nil
+		},
+		RunE: func(command *cobra.Command, _ []string) error {
+			return runServerCommand(command)
+		},
+	}
+	command.Flags().String(flagServerAddr, "127.0.0.1:8288", "the address the server listens on")
+	command.Flags().String(flagServerDataDir, "br-server",
+		"the directory keeping the jobs, the jobs are resumed from it when the server restarts")
+	command.Flags().String(flagServerTokenFile, "",
+		"the file containing the token the requests must carry as \"Authorization: Bearer <token>\", "+
+			"it's required unless the tls certificates are specified to verify the clients")
+	return command
+}
@@ -0,0 +1,184 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"context"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// CheckpointMetaFile is the file identifying the backup task of the checkpoint.
+	CheckpointMetaFile = "checkpoint.meta"
+	// checkpointRangesFileFormat is the format of the files of the backed up
+	// ranges, a new file is written for the ranges backed up since the last flush.
+	checkpointRangesFileFormat = "checkpoint.%06d"
+)
+
+// CheckpointMeta identifies the backup task of a checkpoint, only the same
+// task can resume from the checkpoint.
+type CheckpointMeta struct {
+	ClusterID    uint64 `json:"cluster-id"`
+	StartVersion uint64 `json:"start-version"`
+	EndVersion   uint64 `json:"end-version"`
+	IsRawKv      bool   `json:"is-raw-kv"`
+	// RawRanges are the ranges to backup of the raw backup.
+	RawRanges []*backuppb.RawRange `json:"raw-ranges"`
+}
+
+// Check checks whether the backup task is the same as the one of the checkpoint.
+// The EndVersion isn't checked because it's decided by the checkpoint.
+func (m *CheckpointMeta) Check(other *CheckpointMeta) error {
+	if m.ClusterID != other.ClusterID {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the checkpoint is of cluster %d, but the cluster is %d", m.ClusterID, other.ClusterID)
+	}
+	if m.StartVersion != other.StartVersion || m.IsRawKv != other.IsRawKv {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the checkpoint is of backup from %d, raw %t, but the backup is from %d, raw %t",
+			m.StartVersion, m.IsRawKv, other.StartVersion, other.IsRawKv)
+	}
+	if len(m.RawRanges) != len(other.RawRanges) {
+		return errors.Annotate(berrors.ErrInvalidArgument, "the ranges to backup are different from the checkpoint")
+	}
+	for i := range m.RawRanges {
+		if !proto.Equal(m.RawRanges[i], other.RawRanges[i]) {
+			return errors.Annotate(berrors.ErrInvalidArgument, "the ranges to backup are different from the checkpoint")
+		}
+	}
+	return nil
+}
+
+// CheckpointRange is a backed up sub-range and its files.
+type CheckpointRange struct {
+	Cf       string           `json:"cf"`
+	StartKey []byte           `json:"start-key"`
+	EndKey   []byte           `json:"end-key"`
+	Files    []*backuppb.File `json:"files"`
+}
+
+// CheckpointRunner records the backed up sub-ranges and flushes them to the
+// external storage periodically, so that an unfinished backup can be resumed.
+// A nil CheckpointRunner records nothing.
+type CheckpointRunner struct {
+	runner *metautil.CheckpointRunner
+	meta   CheckpointMeta
+
+	// loaded are the ranges backed up before the backup is resumed.
+	loaded []CheckpointRange
+}
+
+// NewCheckpointRunner creates a checkpoint runner for a new backup task, and
+// writes the meta of the checkpoint to the storage.
+func NewCheckpointRunner(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+	meta CheckpointMeta,
+) (*CheckpointRunner, error) {
+	runner, err := metautil.NewCheckpointRunner(ctx, s, cipher, CheckpointMetaFile, checkpointRangesFileFormat, &meta)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &CheckpointRunner{runner: runner, meta: meta}, nil
+}
+
+// LoadCheckpointRunner loads the checkpoint of an unfinished backup task from
+// the storage, it returns nil if there is no checkpoint.
+func LoadCheckpointRunner(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+) (*CheckpointRunner, error) {
+	r := &CheckpointRunner{}
+	runner, err := metautil.LoadCheckpointRunner(ctx, s, cipher, CheckpointMetaFile, checkpointRangesFileFormat, &r.meta,
+		func(unmarshal func(v interface{}) error) error {
+			var ranges []CheckpointRange
+			if err := unmarshal(&ranges); err != nil {
+				return errors.Trace(err)
+			}
+			r.loaded = append(r.loaded, ranges...)
+			return nil
+		})
+	if err != nil || runner == nil {
+		return nil, errors.Trace(err)
+	}
+	r.runner = runner
+	log.Info("load backup checkpoint", zap.Uint64("backupTS", r.meta.EndVersion), zap.Int("ranges", len(r.loaded)))
+	return r, nil
+}
+
+// Meta returns the meta of the checkpoint.
+func (r *CheckpointRunner) Meta() CheckpointMeta {
+	return r.meta
+}
+
+// LoadedRanges returns the number of the ranges backed up before resuming.
+func (r *CheckpointRunner) LoadedRanges() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.loaded)
+}
+
+// BackedUpRanges returns the range tree of the sub-ranges of [startKey, endKey)
+// in the cf which have been backed up before resuming.
+func (r *CheckpointRunner) BackedUpRanges(cf string, startKey, endKey []byte) rtree.RangeTree {
+	rangeTree := rtree.NewRangeTree()
+	if r == nil {
+		return rangeTree
+	}
+	requestRange := rtree.Range{StartKey: startKey, EndKey: endKey}
+	for _, rg := range r.loaded {
+		if rg.Cf != cf {
+			continue
+		}
+		if _, _, ok := requestRange.Intersect(rg.StartKey, rg.EndKey); ok {
+			rangeTree.Put(rg.StartKey, rg.EndKey, rg.Files)
+		}
+	}
+	return rangeTree
+}
+
+// Append records a backed up sub-range, it's flushed to the storage later.
+func (r *CheckpointRunner) Append(cf string, startKey, endKey []byte, files []*backuppb.File) {
+	if r == nil {
+		return
+	}
+	r.runner.Append(CheckpointRange{Cf: cf, StartKey: startKey, EndKey: endKey, Files: files})
+}
+
+// Flush writes the sub-ranges backed up since the last flush to the storage.
+func (r *CheckpointRunner) Flush(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	return errors.Trace(r.runner.Flush(ctx))
+}
+
+// FlushPeriodically flushes the checkpoint every interval until ctx is done.
+func (r *CheckpointRunner) FlushPeriodically(ctx context.Context, interval time.Duration) {
+	if r == nil {
+		return
+	}
+	r.runner.FlushPeriodically(ctx, interval)
+}
+
+// Remove removes the checkpoint files from the storage after the backup
+// finishes, nothing is flushed after that.
+func (r *CheckpointRunner) Remove(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	return errors.Trace(r.runner.Remove(ctx))
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"context"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckpointRunner(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{
+		CipherType: encryptionpb.EncryptionMethod_AES128_CTR,
+		CipherKey:  []byte("0123456789abcdef"),
+	}
+	meta := backup.CheckpointMeta{
+		ClusterID:  1,
+		EndVersion: 100,
+		IsRawKv:    true,
+		RawRanges:  []*backuppb.RawRange{{StartKey: []byte("a"), EndKey: []byte("z"), Cf: "default"}},
+	}
+
+	loaded, err := backup.LoadCheckpointRunner(ctx, s, cipher)
+	require.NoError(t, err)
+	require.Nil(t, loaded)
+
+	runner, err := backup.NewCheckpointRunner(ctx, s, cipher, meta)
+	require.NoError(t, err)
+	runner.Append("default", []byte("a"), []byte("c"), []*backuppb.File{{Name: "1.sst"}})
+	require.NoError(t, runner.Flush(ctx))
+	runner.Append("default", []byte("e"), []byte("g"), []*backuppb.File{{Name: "2.sst"}})
+	runner.Append("write", []byte("c"), []byte("e"), []*backuppb.File{{Name: "3.sst"}})
+	require.NoError(t, runner.Flush(ctx))
+	// Nothing is pending.
+	require.NoError(t, runner.Flush(ctx))
+
+	loaded, err = backup.LoadCheckpointRunner(ctx, s, cipher)
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	require.Equal(t, 3, loaded.LoadedRanges())
+	loadedMeta := loaded.Meta()
+	require.NoError(t, loadedMeta.Check(&meta))
+
+	backedUp := loaded.BackedUpRanges("default", []byte("a"), []byte("z"))
+	require.Equal(t, 2, backedUp.Len())
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("c"), EndKey: []byte("e")},
+		{StartKey: []byte("g"), EndKey: []byte("z")},
+	}, backedUp.GetIncompleteRange([]byte("a"), []byte("z")))
+	require.Equal(t, 0, loaded.BackedUpRanges("default", []byte("x"), []byte("z")).Len())
+
+	// The ranges to backup are changed.
+	other := meta
+	other.RawRanges = []*backuppb.RawRange{{StartKey: []byte("a"), EndKey: []byte("y"), Cf: "default"}}
+	require.True(t, berrors.ErrInvalidArgument.Equal(loadedMeta.Check(&other)))
+
+	// The cipher key is wrong.
+	wrongCipher := &backuppb.CipherInfo{
+		CipherType: encryptionpb.EncryptionMethod_AES128_CTR,
+		CipherKey:  []byte("fedcba9876543210"),
+	}
+	_, err = backup.LoadCheckpointRunner(ctx, s, wrongCipher)
+	require.True(t, berrors.ErrInvalidMetaFile.Equal(err))
+
+	// Nothing is flushed after removed.
+	loaded.Append("default", []byte("c"), []byte("e"), []*backuppb.File{{Name: "4.sst"}})
+	require.NoError(t, loaded.Remove(ctx))
+	require.NoError(t, loaded.Flush(ctx))
+	loaded, err = backup.LoadCheckpointRunner(ctx, s, cipher)
+	require.NoError(t, err)
+	require.Nil(t, loaded)
+}
@@ -86,6 +86,12 @@ type Client struct {
 	backend *backuppb.StorageBackend
 
 	gcTTL int64
+
+	checkpoint *CheckpointRunner
+
+	// rawExpireTS is the time in seconds, the raw keys expired at it are
+	// removed from the backed up files, 0 means all the keys are kept.
+	rawExpireTS uint64
 }
 
 // NewBackupClient returns a new backup client.
@@ -145,6 +151,19 @@ func (bc *Client) SetLockFile(ctx context.Context) error {
 			"This file exists to remind other backup jobs won't use this path"))
 }
 
+// SetCheckpointRunner sets the checkpoint runner recording the backed up ranges,
+// the ranges already recorded by it are skipped.
+func (bc *Client) SetCheckpointRunner(checkpoint *CheckpointRunner) {
+	bc.checkpoint = checkpoint
+}
+
+// SetRawExpireTS makes the raw backup remove the keys expired at ts in
+// seconds from the backed up files, it's only for the clusters with ttl
+// enabled.
+func (bc *Client) SetRawExpireTS(ts uint64) {
+	bc.rawExpireTS = ts
+}
+
 // SetGCTTL set gcTTL for client.
 func (bc *Client) SetGCTTL(ttl int64) {
 	if ttl <= 0 {
@@ -188,6 +207,12 @@ func (bc *Client) SetStorage(ctx context.Context, backend *backuppb.StorageBacke
 	return nil
 }
 
+// SetRelayBackend makes TiKV write the backup files to the relay backend
+// instead of the storage, the relay uploads the files to the storage.
+func (bc *Client) SetRelayBackend(backend *backuppb.StorageBackend) {
+	bc.backend = backend
+}
+
 // GetClusterID returns the cluster ID of the tidb cluster to backup.
 func (bc *Client) GetClusterID() uint64 {
 	return bc.clusterID
@@ -455,7 +480,9 @@ func (bc *Client) BackupRanges(
 	progressCallBack func(ProgressUnit),
 ) error {
 	init := time.Now()
-	defer log.Info("Backup Ranges", zap.Duration("take", time.Since(init)))
+	defer func() {
+		log.Info("Backup Ranges", zap.Duration("take", time.Since(init)))
+	}()
 
 	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
 		span1 := span.Tracer().StartSpan("Client.BackupRanges", opentracing.ChildOf(span.Context()))
@@ -502,7 +529,7 @@ func (bc *Client) BackupRange(
 		logutil.CL(ctx).Info("backup range finished", zap.Duration("take", elapsed))
 		key := "range start:" + hex.EncodeToString(startKey) + " end:" + hex.EncodeToString(endKey)
 		if err != nil {
-			summary.CollectFailureUnit(key, err)
+			summary.FromContext(ctx).CollectFailureUnit(key, err)
 		}
 	}()
 	logutil.CL(ctx).Info("backup started",
@@ -520,20 +547,39 @@ func (bc *Client) BackupRange(
 	req.EndKey = endKey
 	req.StorageBackend = bc.backend
 
-	push := newPushDown(bc.mgr, len(allStores))
-
-	var results rtree.RangeTree
-	results, err = push.pushBackup(ctx, req, allStores, progressCallBack)
-	if err != nil {
-		return errors.Trace(err)
+	// Skip the sub-ranges backed up before resuming, and push down the gaps.
+	results := bc.checkpoint.BackedUpRanges(req.Cf, startKey, endKey)
+	remains := []rtree.Range{{StartKey: startKey, EndKey: endKey}}
+	if results.Len() > 0 {
+		remains = results.GetIncompleteRange(startKey, endKey)
+		logutil.CL(ctx).Info("resume backup range from checkpoint",
+			zap.Int("backed-up-range-count", results.Len()),
+			zap.Int("remaining-range-count", len(remains)))
 	}
+	for _, rg := range remains {
+		req.StartKey = rg.StartKey
+		req.EndKey = rg.EndKey
+		push := newPushDown(bc.mgr, len(allStores))
+		push.checkpoint = bc.checkpoint
+		push.filterFiles = bc.rawFilesFilter(req)
+		var pushed rtree.RangeTree
+		pushed, err = push.pushBackup(ctx, req, allStores, progressCallBack)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		pushed.Ascend(func(i btree.Item) bool {
+			r := i.(*rtree.Range)
+			results.Put(r.StartKey, r.EndKey, r.Files)
+			return true
+		})
+	}
+	req.StartKey = startKey
+	req.EndKey = endKey
 	logutil.CL(ctx).Info("finish backup push down", zap.Int("small-range-count", results.Len()))
 
 	// Find and backup remaining ranges.
 	// TODO: test fine grained backup.
-	err = bc.fineGrainedBackup(
-		ctx, startKey, endKey, req.StartVersion, req.EndVersion, req.CompressionType, req.CompressionLevel,
-		req.RateLimit, req.Concurrency, results, progressCallBack)
+	err = bc.fineGrainedBackup(ctx, startKey, endKey, req, results, progressCallBack)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -552,12 +598,13 @@ func (bc *Client) BackupRange(
 			zap.Reflect("EndVersion", req.EndVersion))
 	}
 
+	collector := summary.FromContext(ctx)
 	var ascendErr error
 	results.Ascend(func(i btree.Item) bool {
 		r := i.(*rtree.Range)
 		for _, f := range r.Files {
-			summary.CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
-			summary.CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
+			collector.CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
+			collector.CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
 		}
 		// we need keep the files in order after we support multi_ingest sst.
 		// default_sst and write_sst need to be together.
@@ -605,12 +652,7 @@ func (bc *Client) findRegionLeader(ctx context.Context, key []byte) (*metapb.Pee
 func (bc *Client) fineGrainedBackup(
 	ctx context.Context,
 	startKey, endKey []byte,
-	lastBackupTS uint64,
-	backupTS uint64,
-	compressType backuppb.CompressionType,
-	compressLevel int32,
-	rateLimit uint64,
-	concurrency uint32,
+	req backuppb.BackupRequest,
 	rangeTree rtree.RangeTree,
 	progressCallBack func(ProgressUnit),
 ) error {
@@ -660,8 +702,7 @@ func (bc *Client) fineGrainedBackup(
 				defer wg.Done()
 				for rg := range retry {
 					backoffMs, err :=
-						bc.handleFineGrained(ctx, boFork, rg, lastBackupTS, backupTS,
-							compressType, compressLevel, rateLimit, concurrency, respCh)
+						bc.handleFineGrained(ctx, boFork, rg, req, respCh)
 					if err != nil {
 						errCh <- err
 						return
@@ -706,7 +747,15 @@ func (bc *Client) fineGrainedBackup(
 					logutil.Key("fine-grained-range-start", resp.StartKey),
 					logutil.Key("fine-grained-range-end", resp.EndKey),
 				)
+				if filterFiles := bc.rawFilesFilter(req); filterFiles != nil {
+					files, err := filterFiles(ctx, resp.Files)
+					if err != nil {
+						return errors.Trace(err)
+					}
+					resp.Files = files
+				}
 				rangeTree.Put(resp.StartKey, resp.EndKey, resp.Files)
+				bc.checkpoint.Append(req.Cf, resp.StartKey, resp.EndKey, resp.Files)
 
 				// Update progress
 				progressCallBack(RegionUnit)
@@ -800,12 +849,7 @@ func (bc *Client) handleFineGrained(
 	ctx context.Context,
 	bo *tikv.Backoffer,
 	rg rtree.Range,
-	lastBackupTS uint64,
-	backupTS uint64,
-	compressType backuppb.CompressionType,
-	compressionLevel int32,
-	rateLimit uint64,
-	concurrency uint32,
+	req backuppb.BackupRequest,
 	respCh chan<- *backuppb.BackupResponse,
 ) (int, error) {
 	leader, pderr := bc.findRegionLeader(ctx, rg.StartKey)
@@ -814,18 +858,12 @@ func (bc *Client) handleFineGrained(
 	}
 	storeID := leader.GetStoreId()
 
-	req := backuppb.BackupRequest{
-		ClusterId:        bc.clusterID,
-		StartKey:         rg.StartKey, // TODO: the range may cross region.
-		EndKey:           rg.EndKey,
-		StartVersion:     lastBackupTS,
-		EndVersion:       backupTS,
-		StorageBackend:   bc.backend,
-		RateLimit:        rateLimit,
-		Concurrency:      concurrency,
-		CompressionType:  compressType,
-		CompressionLevel: compressionLevel,
-	}
+	// Keep the other fields of the request, e.g. IsRawKv and Cf, so that
+	// the retried range is backed up in the same way as the others.
+	req.ClusterId = bc.clusterID
+	req.StartKey = rg.StartKey // TODO: the range may cross region.
+	req.EndKey = rg.EndKey
+	req.StorageBackend = bc.backend
 	lockResolver := bc.mgr.GetLockResolver()
 	client, err := bc.mgr.GetBackupClient(ctx, storeID)
 	if err != nil {
@@ -846,7 +884,7 @@ func (bc *Client) handleFineGrained(
 		// Handle responses with the same backoffer.
 		func(resp *backuppb.BackupResponse) error {
 			response, shouldBackoff, err1 :=
-				OnBackupResponse(storeID, bo, backupTS, lockResolver, resp)
+				OnBackupResponse(storeID, bo, req.EndVersion, lockResolver, resp)
 			if err1 != nil {
 				return err1
 			}
@@ -854,6 +892,7 @@ func (bc *Client) handleFineGrained(
 				backoffMill = shouldBackoff
 			}
 			if response != nil {
+				collectBackupFiles(ctx, storeID, response.GetFiles())
 				respCh <- response
 			}
 			// When meet an error, we need to set hasProgress too, in case of
@@ -16,6 +16,7 @@ import (
 	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/redact"
 	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
 	"go.uber.org/zap"
 )
@@ -25,6 +26,12 @@ type pushDown struct {
 	mgr    ClientMgr
 	respCh chan responseAndStore
 	errCh  chan error
+
+	// checkpoint records the ranges backed up, it's nil if checkpoint is disabled.
+	checkpoint *CheckpointRunner
+	// filterFiles rewrites the backed up files before they are recorded, it's
+	// nil if the files are kept as they are.
+	filterFiles func(context.Context, []*backuppb.File) ([]*backuppb.File, error)
 }
 
 type responseAndStore struct {
@@ -141,8 +148,17 @@ func (push *pushDown) pushBackup(
 			})
 			if resp.GetError() == nil {
 				// None error means range has been backuped successfully.
+				if push.filterFiles != nil {
+					files, err := push.filterFiles(ctx, resp.GetFiles())
+					if err != nil {
+						return res, errors.Trace(err)
+					}
+					resp.Files = files
+				}
 				res.Put(
 					resp.GetStartKey(), resp.GetEndKey(), resp.GetFiles())
+				push.checkpoint.Append(req.Cf, resp.GetStartKey(), resp.GetEndKey(), resp.GetFiles())
+				collectBackupFiles(ctx, store.GetId(), resp.GetFiles())
 
 				// Update progress
 				progressCallBack(RegionUnit)
@@ -189,3 +205,14 @@ func (push *pushDown) pushBackup(
 		}
 	}
 }
+
+// collectBackupFiles collects the files backed up by the store into the summary
+// of the stores. The kvs and bytes of the files are collected by BackupRange
+// once the range is finished.
+func collectBackupFiles(ctx context.Context, storeID uint64, files []*backuppb.File) {
+	var size uint64
+	for _, f := range files {
+		size += f.GetSize_()
+	}
+	summary.FromContext(ctx).CollectStoreUnit(storeID, len(files), size)
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package backup_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/client-go/v2/txnkv/txnlock"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+type fakeBackupPDClient struct {
+	pd.Client
+	stores []*metapb.Store
+}
+
+func (c *fakeBackupPDClient) GetClusterID(context.Context) uint64 {
+	return 1
+}
+
+func (c *fakeBackupPDClient) GetAllStores(context.Context, ...pd.GetStoreOption) ([]*metapb.Store, error) {
+	return c.stores, nil
+}
+
+// fakeBackupClient returns the responses of a store.
+type fakeBackupClient struct {
+	backuppb.BackupClient
+	resps []*backuppb.BackupResponse
+}
+
+func (c *fakeBackupClient) Backup(
+	context.Context, *backuppb.BackupRequest, ...grpc.CallOption,
+) (backuppb.Backup_BackupClient, error) {
+	return &fakeBackupStream{resps: c.resps}, nil
+}
+
+type fakeBackupStream struct {
+	grpc.ClientStream
+	resps []*backuppb.BackupResponse
+}
+
+func (s *fakeBackupStream) Recv() (*backuppb.BackupResponse, error) {
+	if len(s.resps) == 0 {
+		return nil, io.EOF
+	}
+	resp := s.resps[0]
+	s.resps = s.resps[1:]
+	return resp, nil
+}
+
+func (s *fakeBackupStream) CloseSend() error {
+	return nil
+}
+
+type fakeBackupMgr struct {
+	pdClient *fakeBackupPDClient
+	clients  map[uint64]*fakeBackupClient
+}
+
+func (m *fakeBackupMgr) GetBackupClient(_ context.Context, storeID uint64) (backuppb.BackupClient, error) {
+	return m.clients[storeID], nil
+}
+
+func (m *fakeBackupMgr) ResetBackupClient(ctx context.Context, storeID uint64) (backuppb.BackupClient, error) {
+	return m.GetBackupClient(ctx, storeID)
+}
+
+func (m *fakeBackupMgr) GetPDClient() pd.Client {
+	return m.pdClient
+}
+
+func (m *fakeBackupMgr) GetLockResolver() *txnlock.LockResolver {
+	return nil
+}
+
+func (m *fakeBackupMgr) Close() {}
+
+func TestBackupRangeSummary(t *testing.T) {
+	ctx := context.Background()
+	file := func(name, start, end string, kvs, bytes uint64) *backuppb.File {
+		return &backuppb.File{
+			Name: name, StartKey: []byte(start), EndKey: []byte(end),
+			TotalKvs: kvs, TotalBytes: bytes, Size_: bytes / 2, Cf: "default",
+		}
+	}
+	mgr := &fakeBackupMgr{
+		pdClient: &fakeBackupPDClient{stores: []*metapb.Store{
+			{Id: 1, State: metapb.StoreState_Up},
+			{Id: 2, State: metapb.StoreState_Up},
+		}},
+		clients: map[uint64]*fakeBackupClient{
+			1: {resps: []*backuppb.BackupResponse{{
+				StartKey: []byte("a"), EndKey: []byte("m"),
+				Files: []*backuppb.File{file("1.sst", "a", "m", 10, 100)},
+			}}},
+			2: {resps: []*backuppb.BackupResponse{{
+				StartKey: []byte("m"), EndKey: []byte("z"),
+				Files: []*backuppb.File{file("2.sst", "m", "t", 20, 200), file("3.sst", "t", "z", 30, 300)},
+			}}},
+		},
+	}
+	client, err := backup.NewBackupClient(ctx, mgr)
+	require.NoError(t, err)
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	metaWriter := metautil.NewMetaWriter(s, metautil.MetaFileSize, false, nil)
+
+	collector := summary.NewLogCollector(func(string, ...zap.Field) {})
+	ctx = summary.WithCollector(ctx, collector)
+	metaWriter.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+	req := backuppb.BackupRequest{IsRawKv: true, Cf: "default"}
+	require.NoError(t, client.BackupRange(ctx, []byte("a"), []byte("z"), req, metaWriter, func(backup.ProgressUnit) {}))
+	require.NoError(t, metaWriter.FinishWriteMetas(ctx, metautil.AppendDataFile))
+
+	var kvs, bytes uint64
+	files := metaWriter.Backupmeta().GetFiles()
+	require.Len(t, files, 3)
+	for _, f := range files {
+		kvs += f.GetTotalKvs()
+		bytes += f.GetTotalBytes()
+	}
+	report := collector.Snapshot("backup")
+	require.Equal(t, kvs, report.TotalKVs)
+	require.Equal(t, bytes, report.TotalBytes)
+	require.Equal(t, 3, report.Files)
+}
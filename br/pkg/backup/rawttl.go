@@ -0,0 +1,82 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/restore"
+	"go.uber.org/zap"
+)
+
+// rawFilesFilter returns the function removing the expired keys from the
+// files backed up by req, or nil if the keys are kept.
+func (bc *Client) rawFilesFilter(
+	req backuppb.BackupRequest,
+) func(context.Context, []*backuppb.File) ([]*backuppb.File, error) {
+	if !req.IsRawKv || bc.rawExpireTS == 0 {
+		return nil
+	}
+	return func(ctx context.Context, files []*backuppb.File) ([]*backuppb.File, error) {
+		filtered := make([]*backuppb.File, 0, len(files))
+		for _, file := range files {
+			f, err := bc.filterExpiredRawFile(ctx, file, req.CompressionType, req.CipherInfo)
+			if err != nil {
+				return nil, errors.Annotatef(err, "failed to remove the expired keys from file %s", file.GetName())
+			}
+			if f != nil {
+				filtered = append(filtered, f)
+			}
+		}
+		return filtered, nil
+	}
+}
+
+// filterExpiredRawFile rewrites the file without the expired keys. It returns
+// the file as it is if no key has expired, or nil if all the keys have
+// expired, in which case the file is deleted.
+func (bc *Client) filterExpiredRawFile(
+	ctx context.Context,
+	file *backuppb.File,
+	compression backuppb.CompressionType,
+	cipher *backuppb.CipherInfo,
+) (*backuppb.File, error) {
+	content, err := restore.ReadRawSSTFile(ctx, bc.storage, file, cipher)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	content, removed, kept, err := restore.FilterExpiredRawSST(content, compression, bc.rawExpireTS)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if removed.TotalKvs == 0 {
+		return file, nil
+	}
+	log.Debug("remove expired raw keys from file",
+		zap.String("file", file.GetName()), zap.Uint64("expired", removed.TotalKvs), zap.Uint64("kept", kept))
+	if kept == 0 {
+		return nil, errors.Trace(bc.storage.DeleteFile(ctx, file.GetName()))
+	}
+	content, iv, err := metautil.Encrypt(content, cipher)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = bc.storage.WriteFile(ctx, file.GetName(), content); err != nil {
+		return nil, errors.Trace(err)
+	}
+	checksum := sha256.Sum256(content)
+	rewritten := proto.Clone(file).(*backuppb.File)
+	rewritten.Sha256 = checksum[:]
+	rewritten.Size_ = uint64(len(content))
+	rewritten.CipherIv = iv
+	rewritten.Crc64Xor ^= removed.Crc64Xor
+	rewritten.TotalKvs -= removed.TotalKvs
+	rewritten.TotalBytes -= removed.TotalBytes
+	return rewritten, nil
+}
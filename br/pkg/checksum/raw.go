@@ -0,0 +1,313 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package checksum
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"hash/crc64"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/kvproto/pkg/tikvpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/pingcap/tidb/util/codec"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	rawChecksumRetryTimes    = 10
+	rawChecksumRetryInterval = 500 * time.Millisecond
+)
+
+// RawChecksum is the CRC64-XOR checksum, the number of kvs and the number of
+// bytes of the raw kv pairs in a key range.
+type RawChecksum struct {
+	Crc64Xor   uint64 `json:"crc64xor"`
+	TotalKvs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+}
+
+// RawRangeChecksum is the checksum of a backed up raw range of a cf.
+type RawRangeChecksum struct {
+	StartKey []byte `json:"start-key"`
+	EndKey   []byte `json:"end-key"`
+	CF       string `json:"cf"`
+	RawChecksum
+}
+
+// rawCRC64Table is the table of the CRC64 TiKV calculates the checksum with.
+var rawCRC64Table = crc64.MakeTable(crc64.ECMA)
+
+// CalcRawKVChecksum calculates the checksum of a raw kv pair the same way as
+// TiKV, the key is without the data key prefix and the value is as stored.
+func CalcRawKVChecksum(key, value []byte) RawChecksum {
+	crc := crc64.Update(0, rawCRC64Table, key)
+	return RawChecksum{
+		Crc64Xor:   crc64.Update(crc, rawCRC64Table, value),
+		TotalKvs:   1,
+		TotalBytes: uint64(len(key) + len(value)),
+	}
+}
+
+// Update merges the checksum of another disjoint key range into c.
+func (c *RawChecksum) Update(other RawChecksum) {
+	c.Crc64Xor ^= other.Crc64Xor
+	c.TotalKvs += other.TotalKvs
+	c.TotalBytes += other.TotalBytes
+}
+
+// CalcRawFilesChecksum calculates the checksum of the given raw backup files,
+// which is the checksum of the key ranges covered by the files at backup time.
+func CalcRawFilesChecksum(files []*backuppb.File) RawChecksum {
+	var c RawChecksum
+	for _, f := range files {
+		c.Update(RawChecksum{Crc64Xor: f.Crc64Xor, TotalKvs: f.TotalKvs, TotalBytes: f.TotalBytes})
+	}
+	return c
+}
+
+// CalcRawRangeChecksums calculates the checksum of each backed up range from
+// the files backed up in it.
+func CalcRawRangeChecksums(ranges []*backuppb.RawRange, files []*backuppb.File) []RawRangeChecksum {
+	checksums := make([]RawRangeChecksum, 0, len(ranges))
+	for _, rg := range ranges {
+		c := RawRangeChecksum{StartKey: rg.GetStartKey(), EndKey: rg.GetEndKey(), CF: rg.GetCf()}
+		for _, f := range files {
+			// Each file is backed up from a single range.
+			if f.GetCf() != rg.GetCf() || bytes.Compare(f.GetStartKey(), rg.GetStartKey()) < 0 ||
+				(len(rg.GetEndKey()) > 0 && bytes.Compare(f.GetStartKey(), rg.GetEndKey()) >= 0) {
+				continue
+			}
+			c.Update(RawChecksum{Crc64Xor: f.Crc64Xor, TotalKvs: f.TotalKvs, TotalBytes: f.TotalBytes})
+		}
+		checksums = append(checksums, c)
+	}
+	return checksums
+}
+
+// RawExecutor calculates the checksum of raw kv ranges on a TiKV cluster.
+type RawExecutor struct {
+	pdClient      pd.Client
+	tlsConf       *tls.Config
+	keepaliveConf keepalive.ClientParameters
+	concurrency   uint
+
+	mu      sync.Mutex
+	conns   map[uint64]*grpc.ClientConn
+	clients map[uint64]tikvpb.TikvClient
+}
+
+// NewRawExecutor returns a new raw checksum executor.
+func NewRawExecutor(
+	pdClient pd.Client,
+	tlsConf *tls.Config,
+	keepaliveConf keepalive.ClientParameters,
+	concurrency uint,
+) *RawExecutor {
+	return &RawExecutor{
+		pdClient:      pdClient,
+		tlsConf:       tlsConf,
+		keepaliveConf: keepaliveConf,
+		concurrency:   concurrency,
+		conns:         make(map[uint64]*grpc.ClientConn),
+		clients:       make(map[uint64]tikvpb.TikvClient),
+	}
+}
+
+// Close closes all the connections to TiKV.
+func (exec *RawExecutor) Close() {
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	for storeID, conn := range exec.conns {
+		if err := conn.Close(); err != nil {
+			log.Warn("failed to close connection", zap.Uint64("storeID", storeID), zap.Error(err))
+		}
+	}
+	exec.conns = make(map[uint64]*grpc.ClientConn)
+	exec.clients = make(map[uint64]tikvpb.TikvClient)
+}
+
+// ValidateRanges checks whether the checksum of each range on the cluster
+// matches the checksum of its backup files, and returns the mismatched ranges.
+func (exec *RawExecutor) ValidateRanges(ctx context.Context, ranges []rtree.Range) ([]rtree.Range, error) {
+	start := time.Now()
+	defer func() {
+		log.Info("raw checksum finished", zap.Int("ranges", len(ranges)), zap.Duration("take", time.Since(start)))
+	}()
+
+	var (
+		mu         sync.Mutex
+		mismatched []rtree.Range
+	)
+	workerPool := utils.NewWorkerPool(exec.concurrency, "raw checksum")
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, r := range ranges {
+		rg := r
+		workerPool.ApplyOnErrorGroup(eg, func() error {
+			expected := CalcRawFilesChecksum(rg.Files)
+			actual, err := exec.Checksum(ectx, rg.StartKey, rg.EndKey)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if actual != expected {
+				log.Error("raw checksum mismatch",
+					logutil.Key("startKey", rg.StartKey),
+					logutil.Key("endKey", rg.EndKey),
+					zap.Uint64("backup crc64", expected.Crc64Xor),
+					zap.Uint64("calculated crc64", actual.Crc64Xor),
+					zap.Uint64("backup total kvs", expected.TotalKvs),
+					zap.Uint64("calculated total kvs", actual.TotalKvs),
+					zap.Uint64("backup total bytes", expected.TotalBytes),
+					zap.Uint64("calculated total bytes", actual.TotalBytes))
+				mu.Lock()
+				mismatched = append(mismatched, rg)
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return mismatched, nil
+}
+
+// Checksum calculates the checksum of the raw kv pairs in [startKey, endKey)
+// region by region.
+func (exec *RawExecutor) Checksum(ctx context.Context, startKey, endKey []byte) (RawChecksum, error) {
+	var result RawChecksum
+	key := startKey
+	retry := 0
+	for len(endKey) == 0 || bytes.Compare(key, endKey) < 0 {
+		// Region boundaries are encoded, so the key must be encoded
+		// in order to find the correct region.
+		region, err := exec.pdClient.GetRegion(ctx, codec.EncodeBytes([]byte{}, key))
+		if err != nil {
+			return result, errors.Trace(err)
+		}
+		if region == nil || region.Meta == nil || region.Leader == nil {
+			err = errors.Annotatef(berrors.ErrKVNotLeader, "no leader of region at key %s", redact.Key(key))
+		} else {
+			subEnd := clipEndKey(decodeRegionKey(region.Meta.GetEndKey()), endKey)
+			var c RawChecksum
+			c, err = exec.checksumInRegion(ctx, region.Meta, region.Leader, key, subEnd)
+			if err == nil {
+				result.Update(c)
+				if len(subEnd) == 0 {
+					break
+				}
+				key = subEnd
+				retry = 0
+				continue
+			}
+		}
+		retry++
+		if retry >= rawChecksumRetryTimes {
+			return result, errors.Trace(err)
+		}
+		log.Warn("raw checksum failed, retrying", logutil.Key("key", key), zap.Int("retry", retry), logutil.ShortError(err))
+		select {
+		case <-ctx.Done():
+			return result, errors.Trace(ctx.Err())
+		case <-time.After(rawChecksumRetryInterval):
+		}
+	}
+	return result, nil
+}
+
+func (exec *RawExecutor) checksumInRegion(
+	ctx context.Context,
+	region *metapb.Region,
+	leader *metapb.Peer,
+	startKey, endKey []byte,
+) (RawChecksum, error) {
+	client, err := exec.getClient(ctx, leader.GetStoreId())
+	if err != nil {
+		return RawChecksum{}, errors.Trace(err)
+	}
+	resp, err := client.RawChecksum(ctx, &kvrpcpb.RawChecksumRequest{
+		Context: &kvrpcpb.Context{
+			RegionId:    region.GetId(),
+			RegionEpoch: region.GetRegionEpoch(),
+			Peer:        leader,
+		},
+		Algorithm: kvrpcpb.ChecksumAlgorithm_Crc64_Xor,
+		Ranges:    []*kvrpcpb.KeyRange{{StartKey: startKey, EndKey: endKey}},
+	})
+	if err != nil {
+		return RawChecksum{}, errors.Trace(err)
+	}
+	if resp.GetRegionError() != nil {
+		return RawChecksum{}, errors.Annotatef(berrors.ErrKVEpochNotMatch, "%s", resp.GetRegionError())
+	}
+	if resp.GetError() != "" {
+		return RawChecksum{}, errors.Annotate(berrors.ErrKVUnknown, resp.GetError())
+	}
+	return RawChecksum{
+		Crc64Xor:   resp.GetChecksum(),
+		TotalKvs:   resp.GetTotalKvs(),
+		TotalBytes: resp.GetTotalBytes(),
+	}, nil
+}
+
+func (exec *RawExecutor) getClient(ctx context.Context, storeID uint64) (tikvpb.TikvClient, error) {
+	exec.mu.Lock()
+	defer exec.mu.Unlock()
+	if client, ok := exec.clients[storeID]; ok {
+		return client, nil
+	}
+	store, err := exec.pdClient.GetStore(ctx, storeID)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	opt := grpc.WithInsecure()
+	if exec.tlsConf != nil {
+		opt = grpc.WithTransportCredentials(credentials.NewTLS(exec.tlsConf))
+	}
+	conn, err := grpc.DialContext(ctx, store.GetAddress(), opt, grpc.WithKeepaliveParams(exec.keepaliveConf))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	client := tikvpb.NewTikvClient(conn)
+	exec.conns[storeID] = conn
+	exec.clients[storeID] = client
+	return client, nil
+}
+
+// decodeRegionKey decodes the encoded region boundary to a raw key.
+// The key is returned as is if it isn't encoded.
+func decodeRegionKey(key []byte) []byte {
+	if len(key) == 0 {
+		return key
+	}
+	_, decoded, err := codec.DecodeBytes(key, nil)
+	if err != nil {
+		return key
+	}
+	return decoded
+}
+
+// clipEndKey returns the smaller one of two exclusive end keys,
+// an empty end key means the max key.
+func clipEndKey(regionEndKey, endKey []byte) []byte {
+	if utils.CompareEndKey(regionEndKey, endKey) < 0 {
+		return regionEndKey
+	}
+	return endKey
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package checksum
+
+import (
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/tidb/util/codec"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalcRawFilesChecksum(t *testing.T) {
+	files := []*backuppb.File{
+		{Crc64Xor: 0b1010, TotalKvs: 2, TotalBytes: 20},
+		{Crc64Xor: 0b0110, TotalKvs: 3, TotalBytes: 30},
+	}
+	require.Equal(t, RawChecksum{Crc64Xor: 0b1100, TotalKvs: 5, TotalBytes: 50}, CalcRawFilesChecksum(files))
+	require.Equal(t, RawChecksum{}, CalcRawFilesChecksum(nil))
+}
+
+func TestCalcRawRangeChecksums(t *testing.T) {
+	ranges := []*backuppb.RawRange{
+		{StartKey: []byte("a"), EndKey: []byte("c"), Cf: "default"},
+		{StartKey: []byte("c"), Cf: "default"},
+		{StartKey: []byte("a"), EndKey: []byte("c"), Cf: "write"},
+	}
+	files := []*backuppb.File{
+		{StartKey: []byte("a"), EndKey: []byte("b"), Cf: "default", Crc64Xor: 0b1010, TotalKvs: 2, TotalBytes: 20},
+		{StartKey: []byte("b"), EndKey: []byte("c"), Cf: "default", Crc64Xor: 0b0110, TotalKvs: 3, TotalBytes: 30},
+		{StartKey: []byte("x"), EndKey: []byte("y"), Cf: "default", Crc64Xor: 0b0001, TotalKvs: 1, TotalBytes: 10},
+		{StartKey: []byte("a"), EndKey: []byte("b"), Cf: "write", Crc64Xor: 0b0011, TotalKvs: 4, TotalBytes: 40},
+	}
+	require.Equal(t, []RawRangeChecksum{
+		{StartKey: []byte("a"), EndKey: []byte("c"), CF: "default",
+			RawChecksum: RawChecksum{Crc64Xor: 0b1100, TotalKvs: 5, TotalBytes: 50}},
+		{StartKey: []byte("c"), CF: "default",
+			RawChecksum: RawChecksum{Crc64Xor: 0b0001, TotalKvs: 1, TotalBytes: 10}},
+		{StartKey: []byte("a"), EndKey: []byte("c"), CF: "write",
+			RawChecksum: RawChecksum{Crc64Xor: 0b0011, TotalKvs: 4, TotalBytes: 40}},
+	}, CalcRawRangeChecksums(ranges, files))
+}
+
+func TestCalcRawKVChecksum(t *testing.T) {
+	c := CalcRawKVChecksum([]byte("key"), []byte("value"))
+	require.Equal(t, uint64(1), c.TotalKvs)
+	require.Equal(t, uint64(8), c.TotalBytes)
+	// The checksum is of the key followed by the value.
+	require.Equal(t, c.Crc64Xor, CalcRawKVChecksum([]byte("keyva"), []byte("lue")).Crc64Xor)
+	require.NotEqual(t, c.Crc64Xor, CalcRawKVChecksum([]byte("value"), []byte("key")).Crc64Xor)
+}
+
+func TestRawRegionBoundary(t *testing.T) {
+	require.Equal(t, []byte("abc"), decodeRegionKey(codec.EncodeBytes([]byte{}, []byte("abc"))))
+	require.Equal(t, []byte("abc"), decodeRegionKey([]byte("abc")))
+	require.Empty(t, decodeRegionKey(nil))
+
+	require.Equal(t, []byte("b"), clipEndKey([]byte("b"), []byte("c")))
+	require.Equal(t, []byte("b"), clipEndKey([]byte("c"), []byte("b")))
+	require.Equal(t, []byte("b"), clipEndKey(nil, []byte("b")))
+	require.Equal(t, []byte("b"), clipEndKey([]byte("b"), nil))
+	require.Empty(t, clipEndKey(nil, nil))
+}
@@ -5,7 +5,9 @@ package conn
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"sync"
 	"time"
@@ -14,10 +16,12 @@ import (
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
 	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/httputil"
 	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/pdutil"
 	"github.com/tikv/migration/br/pkg/utils"
@@ -413,6 +417,105 @@ func (mgr *Mgr) GetTLSConfig() *tls.Config {
 	return mgr.tlsConf
 }
 
+// IsTTLEnabled checks whether RawKV TTL is enabled on all the TiKV stores by
+// reading their configs from the status address.
+func (mgr *Mgr) IsTTLEnabled(ctx context.Context) (bool, error) {
+	stores, configs, err := mgr.getTiKVStorageConfigs(ctx)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	for i := 1; i < len(configs); i++ {
+		if configs[i].EnableTTL != configs[0].EnableTTL {
+			return false, errors.Annotatef(berrors.ErrKVConfigMismatch,
+				"storage.enable-ttl of store %d is %t, but it's %t on the other stores",
+				stores[i].GetId(), configs[i].EnableTTL, configs[0].EnableTTL)
+		}
+	}
+	return len(configs) > 0 && configs[0].EnableTTL, nil
+}
+
+// GetAPIVersion returns the API version of all the TiKV stores, which is
+// APIVersion_V1TTL if the stores are in API V1 with RawKV TTL enabled.
+func (mgr *Mgr) GetAPIVersion(ctx context.Context) (kvrpcpb.APIVersion, error) {
+	stores, configs, err := mgr.getTiKVStorageConfigs(ctx)
+	if err != nil {
+		return kvrpcpb.APIVersion_V1, errors.Trace(err)
+	}
+	apiVersion := kvrpcpb.APIVersion_V1
+	for i, cfg := range configs {
+		storeVersion := cfg.apiVersion()
+		if i > 0 && storeVersion != apiVersion {
+			return kvrpcpb.APIVersion_V1, errors.Annotatef(berrors.ErrKVConfigMismatch,
+				"the api version of store %d is %s, but it's %s on the other stores",
+				stores[i].GetId(), storeVersion, apiVersion)
+		}
+		apiVersion = storeVersion
+	}
+	return apiVersion, nil
+}
+
+// tikvStorageConfig is the part of the storage config of TiKV about RawKV.
+type tikvStorageConfig struct {
+	APIVersion int  `json:"api-version"`
+	EnableTTL  bool `json:"enable-ttl"`
+}
+
+func (cfg *tikvStorageConfig) apiVersion() kvrpcpb.APIVersion {
+	switch {
+	case cfg.APIVersion == 2:
+		return kvrpcpb.APIVersion_V2
+	case cfg.EnableTTL:
+		return kvrpcpb.APIVersion_V1TTL
+	default:
+		return kvrpcpb.APIVersion_V1
+	}
+}
+
+// getTiKVStorageConfigs reads the storage configs of all the TiKV stores from
+// their status addresses.
+func (mgr *Mgr) getTiKVStorageConfigs(ctx context.Context) ([]*metapb.Store, []*tikvStorageConfig, error) {
+	stores, err := GetAllTiKVStores(ctx, mgr.GetPDClient(), SkipTiFlash)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	schema := "http"
+	if mgr.tlsConf != nil {
+		schema = "https"
+	}
+	cli := httputil.NewClient(mgr.tlsConf)
+	configs := make([]*tikvStorageConfig, 0, len(stores))
+	for _, store := range stores {
+		cfg, err := getTiKVStorageConfig(ctx, cli, fmt.Sprintf("%s://%s", schema, store.GetStatusAddress()))
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "failed to get the config of store %d", store.GetId())
+		}
+		configs = append(configs, cfg)
+	}
+	return stores, configs, nil
+}
+
+func getTiKVStorageConfig(ctx context.Context, cli *http.Client, statusURL string) (*tikvStorageConfig, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, statusURL+"/config", nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := cli.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Annotatef(berrors.ErrKVUnknown, "get %s/config returns %s", statusURL, resp.Status)
+	}
+	cfg := struct {
+		Storage tikvStorageConfig `json:"storage"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &cfg.Storage, nil
+}
+
 // GetLockResolver gets the LockResolver.
 func (mgr *Mgr) GetLockResolver() *txnlock.LockResolver {
 	return mgr.tikvStore.GetLockResolver()
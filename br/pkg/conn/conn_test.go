@@ -4,11 +4,17 @@ package conn
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/pdutil"
 	"github.com/stretchr/testify/require"
 	pd "github.com/tikv/pd/client"
@@ -269,3 +275,87 @@ func TestGetConnOnCanceledContext(t *testing.T) {
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "context canceled")
 }
+
+func TestIsTTLEnabled(t *testing.T) {
+	newStatusServer := func(enableTTL bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "/config", r.URL.Path)
+			_, _ = fmt.Fprintf(w, `{"storage":{"enable-ttl":%t,"reserve-space":"0KB"}}`, enableTTL)
+		}))
+	}
+	ttlServer := newStatusServer(true)
+	defer ttlServer.Close()
+	noTTLServer := newStatusServer(false)
+	defer noTTLServer.Close()
+
+	newMgr := func(servers ...*httptest.Server) *Mgr {
+		stores := make([]*metapb.Store, 0, len(servers))
+		for i, server := range servers {
+			stores = append(stores, &metapb.Store{
+				Id:            uint64(i + 1),
+				State:         metapb.StoreState_Up,
+				StatusAddress: strings.TrimPrefix(server.URL, "http://"),
+			})
+		}
+		mgr := &Mgr{PdController: &pdutil.PdController{}}
+		mgr.SetPDClient(fakePDClient{stores: stores})
+		return mgr
+	}
+
+	ctx := context.Background()
+	enabled, err := newMgr(ttlServer, ttlServer).IsTTLEnabled(ctx)
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	enabled, err = newMgr(noTTLServer).IsTTLEnabled(ctx)
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	_, err = newMgr(ttlServer, noTTLServer).IsTTLEnabled(ctx)
+	require.True(t, berrors.ErrKVConfigMismatch.Equal(errors.Cause(err)))
+}
+
+func TestGetAPIVersion(t *testing.T) {
+	newStatusServer := func(apiVersion int, enableTTL bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = fmt.Fprintf(w, `{"storage":{"api-version":%d,"enable-ttl":%t}}`, apiVersion, enableTTL)
+		}))
+	}
+	v1Server := newStatusServer(1, false)
+	defer v1Server.Close()
+	v1TTLServer := newStatusServer(1, true)
+	defer v1TTLServer.Close()
+	v2Server := newStatusServer(2, true)
+	defer v2Server.Close()
+
+	newMgr := func(servers ...*httptest.Server) *Mgr {
+		stores := make([]*metapb.Store, 0, len(servers))
+		for i, server := range servers {
+			stores = append(stores, &metapb.Store{
+				Id:            uint64(i + 1),
+				State:         metapb.StoreState_Up,
+				StatusAddress: strings.TrimPrefix(server.URL, "http://"),
+			})
+		}
+		mgr := &Mgr{PdController: &pdutil.PdController{}}
+		mgr.SetPDClient(fakePDClient{stores: stores})
+		return mgr
+	}
+
+	ctx := context.Background()
+	for _, c := range []struct {
+		server   *httptest.Server
+		expected kvrpcpb.APIVersion
+	}{
+		{v1Server, kvrpcpb.APIVersion_V1},
+		{v1TTLServer, kvrpcpb.APIVersion_V1TTL},
+		{v2Server, kvrpcpb.APIVersion_V2},
+	} {
+		apiVersion, err := newMgr(c.server, c.server).GetAPIVersion(ctx)
+		require.NoError(t, err)
+		require.Equal(t, c.expected, apiVersion)
+	}
+
+	_, err := newMgr(v1TTLServer, v2Server).GetAPIVersion(ctx)
+	require.True(t, berrors.ErrKVConfigMismatch.Equal(errors.Cause(err)))
+}
@@ -23,8 +23,9 @@ type Glue interface {
 
 	StartProgress(ctx context.Context, cmdName string, total int64, redirectLog bool) Progress
 
-	// Record records some information useful for log-less summary.
-	Record(name string, value uint64)
+	// Record records some information useful for log-less summary into the
+	// summary collector of the context.
+	Record(ctx context.Context, name string, value uint64)
 
 	// GetVersion gets BR package version to run backup/restore job
 	GetVersion() string
@@ -93,8 +93,8 @@ func (g Glue) StartProgress(ctx context.Context, cmdName string, total int64, re
 }
 
 // Record implements glue.Glue.
-func (g Glue) Record(name string, value uint64) {
-	g.tikvGlue.Record(name, value)
+func (g Glue) Record(ctx context.Context, name string, value uint64) {
+	g.tikvGlue.Record(ctx, name, value)
 }
 
 // GetVersion implements glue.Glue.
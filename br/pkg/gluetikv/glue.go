@@ -52,8 +52,8 @@ func (Glue) StartProgress(ctx context.Context, cmdName string, total int64, redi
 }
 
 // Record implements glue.Glue.
-func (Glue) Record(name string, val uint64) {
-	summary.CollectSuccessUnit(name, 1, val)
+func (Glue) Record(ctx context.Context, name string, val uint64) {
+	summary.FromContext(ctx).CollectSuccessUnit(name, 1, val)
 }
 
 // GetVersion implements glue.Glue.
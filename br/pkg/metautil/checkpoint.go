@@ -0,0 +1,247 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+// checkpointFile is the content of the checkpoint files in the storage.
+type checkpointFile struct {
+	CipherIv []byte `json:"cipher-iv"`
+	// Sha256 is the checksum of the content before encrypted.
+	Sha256  []byte `json:"sha256"`
+	Content []byte `json:"content"`
+}
+
+// WriteCheckpointFile marshals v to json, encrypts it with the cipher and
+// writes it to the storage along with its checksum.
+func WriteCheckpointFile(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+	name string,
+	v interface{},
+) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	checksum := sha256.Sum256(content)
+	encrypted, iv, err := Encrypt(content, cipher)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := json.Marshal(&checkpointFile{CipherIv: iv, Sha256: checksum[:], Content: encrypted})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, name, data))
+}
+
+// ReadCheckpointFile reads a file written by WriteCheckpointFile from the
+// storage, and unmarshals it to v after decrypted and verified.
+func ReadCheckpointFile(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+	name string,
+	v interface{},
+) error {
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	file := &checkpointFile{}
+	if err = json.Unmarshal(data, file); err != nil {
+		return errors.Annotatef(err, "failed to unmarshal checkpoint file %s", name)
+	}
+	content, err := Decrypt(file.Content, cipher, file.CipherIv)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	checksum := sha256.Sum256(content)
+	if !bytes.Equal(checksum[:], file.Sha256) {
+		return errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"checksum mismatch of checkpoint file %s, the cipher key may be wrong", name)
+	}
+	return errors.Trace(json.Unmarshal(content, v))
+}
+
+// CheckpointRunner records the progress of a task and flushes it to the
+// external storage periodically, so that an unfinished task can be resumed.
+// The checkpoint consists of a meta file identifying the task and a sequence
+// of files, each of them holds the records appended since the last flush.
+// A nil CheckpointRunner records nothing.
+type CheckpointRunner struct {
+	storage storage.ExternalStorage
+	cipher  *backuppb.CipherInfo
+	// metaFile is the name of the meta file.
+	metaFile string
+	// recordsFileFormat is the format of the names of the records files,
+	// formatted with the sequence number of the file.
+	recordsFileFormat string
+
+	mu      sync.Mutex
+	pending []interface{}
+
+	flushMu sync.Mutex
+	nextSeq int
+	removed bool
+}
+
+// NewCheckpointRunner creates a checkpoint runner for a new task, and writes
+// the meta of the checkpoint to the storage.
+func NewCheckpointRunner(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+	metaFile string,
+	recordsFileFormat string,
+	meta interface{},
+) (*CheckpointRunner, error) {
+	if err := WriteCheckpointFile(ctx, s, cipher, metaFile, meta); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &CheckpointRunner{
+		storage:           s,
+		cipher:            cipher,
+		metaFile:          metaFile,
+		recordsFileFormat: recordsFileFormat,
+		nextSeq:           1,
+	}, nil
+}
+
+// LoadCheckpointRunner loads the checkpoint of an unfinished task from the
+// storage, it returns nil if there is no checkpoint. The meta is unmarshaled
+// to meta, and loadRecords is called for each records file in order with a
+// function unmarshaling the records of the file.
+func LoadCheckpointRunner(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+	metaFile string,
+	recordsFileFormat string,
+	meta interface{},
+	loadRecords func(unmarshal func(v interface{}) error) error,
+) (*CheckpointRunner, error) {
+	exist, err := s.FileExists(ctx, metaFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !exist {
+		return nil, nil
+	}
+	if err = ReadCheckpointFile(ctx, s, cipher, metaFile, meta); err != nil {
+		return nil, errors.Trace(err)
+	}
+	r := &CheckpointRunner{storage: s, cipher: cipher, metaFile: metaFile, recordsFileFormat: recordsFileFormat}
+	for r.nextSeq = 1; ; r.nextSeq++ {
+		name := fmt.Sprintf(recordsFileFormat, r.nextSeq)
+		exist, err = s.FileExists(ctx, name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if !exist {
+			break
+		}
+		err = loadRecords(func(v interface{}) error {
+			return errors.Trace(ReadCheckpointFile(ctx, s, cipher, name, v))
+		})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	log.Info("load checkpoint", zap.String("meta", metaFile), zap.Int("files", r.nextSeq-1))
+	return r, nil
+}
+
+// Append records a record, it's flushed to the storage later.
+func (r *CheckpointRunner) Append(record interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, record)
+}
+
+// Flush writes the records appended since the last flush to the storage.
+func (r *CheckpointRunner) Flush(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	if r.removed {
+		return nil
+	}
+
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	name := fmt.Sprintf(r.recordsFileFormat, r.nextSeq)
+	if err := WriteCheckpointFile(ctx, r.storage, r.cipher, name, pending); err != nil {
+		// Put the records back to flush them next time.
+		r.mu.Lock()
+		r.pending = append(pending, r.pending...)
+		r.mu.Unlock()
+		return errors.Trace(err)
+	}
+	r.nextSeq++
+	return nil
+}
+
+// FlushPeriodically flushes the checkpoint every interval until ctx is done.
+func (r *CheckpointRunner) FlushPeriodically(ctx context.Context, interval time.Duration) {
+	if r == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				log.Warn("failed to flush checkpoint", zap.String("meta", r.metaFile), zap.Error(err))
+			}
+		}
+	}
+}
+
+// Remove removes the checkpoint files from the storage after the task
+// finishes, nothing is flushed after that.
+func (r *CheckpointRunner) Remove(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.removed = true
+	for seq := 1; seq < r.nextSeq; seq++ {
+		if err := r.storage.DeleteFile(ctx, fmt.Sprintf(r.recordsFileFormat, seq)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(r.storage.DeleteFile(ctx, r.metaFile))
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	mockstorage "github.com/tikv/migration/br/pkg/mock/storage"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+type testCheckpointRecord struct {
+	Name string `json:"name"`
+}
+
+func TestCheckpointRunner(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+	load := func() (*CheckpointRunner, string, []testCheckpointRecord) {
+		var (
+			meta    string
+			records []testCheckpointRecord
+		)
+		r, err := LoadCheckpointRunner(ctx, s, cipher, "test.meta", "test.%06d", &meta,
+			func(unmarshal func(v interface{}) error) error {
+				var rs []testCheckpointRecord
+				if err := unmarshal(&rs); err != nil {
+					return err
+				}
+				records = append(records, rs...)
+				return nil
+			})
+		require.NoError(t, err)
+		return r, meta, records
+	}
+
+	r, _, _ := load()
+	require.Nil(t, r)
+
+	r, err = NewCheckpointRunner(ctx, s, cipher, "test.meta", "test.%06d", "meta")
+	require.NoError(t, err)
+	r.Append(testCheckpointRecord{Name: "a"})
+	require.NoError(t, r.Flush(ctx))
+	r.Append(testCheckpointRecord{Name: "b"})
+	r.Append(testCheckpointRecord{Name: "c"})
+	require.NoError(t, r.Flush(ctx))
+	// Nothing is pending.
+	require.NoError(t, r.Flush(ctx))
+
+	r, meta, records := load()
+	require.NotNil(t, r)
+	require.Equal(t, "meta", meta)
+	require.Equal(t, []testCheckpointRecord{{Name: "a"}, {Name: "b"}, {Name: "c"}}, records)
+
+	// The records are appended to a new file after resumed.
+	r.Append(testCheckpointRecord{Name: "d"})
+	require.NoError(t, r.Flush(ctx))
+	_, _, records = load()
+	require.Len(t, records, 4)
+
+	// Nothing is flushed after removed.
+	r.Append(testCheckpointRecord{Name: "e"})
+	require.NoError(t, r.Remove(ctx))
+	require.NoError(t, r.Flush(ctx))
+	r, _, _ = load()
+	require.Nil(t, r)
+}
+
+func TestCheckpointRunnerFlushFailed(t *testing.T) {
+	ctx := context.Background()
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	mockStorage := mockstorage.NewMockExternalStorage(controller)
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+
+	var written []byte
+	gomock.InOrder(
+		mockStorage.EXPECT().WriteFile(ctx, "test.meta", gomock.Any()).Return(nil),
+		mockStorage.EXPECT().WriteFile(ctx, "test.000001", gomock.Any()).Return(errors.New("unavailable")),
+		mockStorage.EXPECT().WriteFile(ctx, "test.000001", gomock.Any()).DoAndReturn(
+			func(_ context.Context, _ string, data []byte) error {
+				written = data
+				return nil
+			}),
+	)
+	r, err := NewCheckpointRunner(ctx, mockStorage, cipher, "test.meta", "test.%06d", "meta")
+	require.NoError(t, err)
+	r.Append(testCheckpointRecord{Name: "a"})
+	require.Error(t, r.Flush(ctx))
+	// The records failed to flush are flushed next time.
+	r.Append(testCheckpointRecord{Name: "b"})
+	require.NoError(t, r.Flush(ctx))
+
+	mockStorage.EXPECT().ReadFile(ctx, "test.000001").Return(written, nil)
+	var records []testCheckpointRecord
+	require.NoError(t, ReadCheckpointFile(ctx, mockStorage, cipher, "test.000001", &records))
+	require.Equal(t, []testCheckpointRecord{{Name: "a"}, {Name: "b"}}, records)
+}
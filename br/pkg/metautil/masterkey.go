@@ -0,0 +1,347 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+const (
+	// DataKeyFile is the file storing the data key of a backup, which is
+	// encrypted by the master key. It's stored next to the backupmeta, as the
+	// backupmeta itself is encrypted by the data key.
+	DataKeyFile = "backupmeta.datakey"
+
+	masterKeyLocal   = "local"
+	masterKeyVault   = "vault"
+	masterKeyAWSKMS  = "aws-kms"
+	vaultTokenEnvVar = "VAULT_TOKEN"
+
+	masterKeyLen = 32
+)
+
+// MasterKey encrypts and decrypts the data keys of the backups.
+type MasterKey interface {
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+	// String describes the master key without the secrets.
+	String() string
+}
+
+// NewMasterKey creates the master key from the url, such as
+// "local:///path/to/key-file", "vault://host:port/<mount>/<key-name>" or
+// "aws-kms:///<key-id>?region=<region>&endpoint=<endpoint>". The key file
+// contains a 256 bits key in hex. The token of vault is read from the
+// environment variable VAULT_TOKEN, and "?scheme=http" disables TLS.
+func NewMasterKey(rawURL string) (MasterKey, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid master key url %s", rawURL)
+	}
+	switch u.Scheme {
+	case masterKeyLocal:
+		return newLocalMasterKey(u.Path)
+	case masterKeyVault:
+		return newVaultMasterKey(u)
+	case masterKeyAWSKMS:
+		return newAWSKMSMasterKey(u)
+	}
+	return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+		"unsupported master key %s, support %s|%s|%s", u.Scheme, masterKeyLocal, masterKeyVault, masterKeyAWSKMS)
+}
+
+// localMasterKey is a master key in a local file, the data keys are encrypted
+// by AES256-GCM.
+type localMasterKey struct {
+	path string
+	aead cipher.AEAD
+}
+
+func newLocalMasterKey(path string) (*localMasterKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "failed to read master key file %s: %s", path, err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(key) != masterKeyLen {
+		return nil, errors.Annotatef(berrors.ErrMasterKey,
+			"the master key file %s should contain a %d bytes key in hex", path, masterKeyLen)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &localMasterKey{path: path, aead: aead}, nil
+}
+
+func (k *localMasterKey) Encrypt(_ context.Context, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (k *localMasterKey) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < k.aead.NonceSize() {
+		return nil, errors.Annotate(berrors.ErrMasterKey, "the encrypted data key is too short")
+	}
+	nonceSize := k.aead.NonceSize()
+	plaintext, err := k.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "failed to decrypt the data key with %s", k)
+	}
+	return plaintext, nil
+}
+
+func (k *localMasterKey) String() string {
+	return masterKeyLocal + "://" + k.path
+}
+
+// vaultMasterKey is a key of the transit secrets engine of vault.
+type vaultMasterKey struct {
+	scheme string
+	host   string
+	mount  string
+	name   string
+	token  string
+	cli    *http.Client
+}
+
+func newVaultMasterKey(u *url.URL) (*vaultMasterKey, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(u.Host) == 0 || len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the vault master key should be vault://host:port/<mount>/<key-name>, but got %s", u.Redacted())
+	}
+	scheme := u.Query().Get("scheme")
+	if len(scheme) == 0 {
+		scheme = "https"
+	}
+	token, ok := os.LookupEnv(vaultTokenEnvVar)
+	if !ok {
+		return nil, errors.Annotatef(berrors.ErrEnvNotSpecified, "%s is required by the vault master key", vaultTokenEnvVar)
+	}
+	return &vaultMasterKey{
+		scheme: scheme,
+		host:   u.Host,
+		mount:  parts[0],
+		name:   parts[1],
+		token:  token,
+		cli:    http.DefaultClient,
+	}, nil
+}
+
+type vaultTransitResponse struct {
+	Data struct {
+		Ciphertext string `json:"ciphertext"`
+		Plaintext  string `json:"plaintext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// call sends the request to the transit endpoint, op is either encrypt or decrypt.
+func (k *vaultMasterKey) call(ctx context.Context, op string, body map[string]string) (*vaultTransitResponse, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	reqURL := fmt.Sprintf("%s://%s/v1/%s/%s/%s", k.scheme, k.host, k.mount, op, k.name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("X-Vault-Token", k.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := k.cli.Do(req)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "failed to %s the data key with %s: %s", op, k, err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var res vaultTransitResponse
+	if err = json.Unmarshal(content, &res); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "invalid response of %s: %s", k, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "failed to %s the data key with %s: %s %v",
+			op, k, resp.Status, res.Errors)
+	}
+	return &res, nil
+}
+
+func (k *vaultMasterKey) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	res, err := k.call(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plaintext)})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return []byte(res.Data.Ciphertext), nil
+}
+
+func (k *vaultMasterKey) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	res, err := k.call(ctx, "decrypt", map[string]string{"ciphertext": string(ciphertext)})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(res.Data.Plaintext)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "invalid plaintext returned by %s", k)
+	}
+	return plaintext, nil
+}
+
+func (k *vaultMasterKey) String() string {
+	return fmt.Sprintf("%s://%s/%s/%s", masterKeyVault, k.host, k.mount, k.name)
+}
+
+// awsKMSMasterKey is a key of AWS KMS, or the services compatible with it.
+type awsKMSMasterKey struct {
+	keyID string
+	cli   kmsiface.KMSAPI
+}
+
+func newAWSKMSMasterKey(u *url.URL) (*awsKMSMasterKey, error) {
+	keyID := strings.Trim(u.Path, "/")
+	if len(keyID) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the aws kms master key should be aws-kms:///<key-id>, but got %s", u.Redacted())
+	}
+	awsConfig := aws.NewConfig()
+	if region := u.Query().Get("region"); len(region) > 0 {
+		awsConfig.WithRegion(region)
+	}
+	if endpoint := u.Query().Get("endpoint"); len(endpoint) > 0 {
+		awsConfig.WithEndpoint(endpoint)
+	}
+	ses, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "failed to create aws session: %s", err)
+	}
+	return &awsKMSMasterKey{keyID: keyID, cli: kms.New(ses)}, nil
+}
+
+func (k *awsKMSMasterKey) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	out, err := k.cli.EncryptWithContext(ctx, &kms.EncryptInput{KeyId: aws.String(k.keyID), Plaintext: plaintext})
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "failed to encrypt the data key with %s: %s", k, err)
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *awsKMSMasterKey) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	out, err := k.cli.DecryptWithContext(ctx, &kms.DecryptInput{KeyId: aws.String(k.keyID), CiphertextBlob: ciphertext})
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrMasterKey, "failed to decrypt the data key with %s: %s", k, err)
+	}
+	return out.Plaintext, nil
+}
+
+func (k *awsKMSMasterKey) String() string {
+	return masterKeyAWSKMS + ":///" + k.keyID
+}
+
+// encryptedDataKey is the content of the data key file.
+type encryptedDataKey struct {
+	Method encryptionpb.EncryptionMethod `json:"method"`
+	// MasterKey describes the master key used to encrypt the data key.
+	MasterKey  string `json:"master-key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// GenerateDataKey generates a random data key for the encryption method.
+func GenerateDataKey(method encryptionpb.EncryptionMethod) (*backuppb.CipherInfo, error) {
+	var keyLen int
+	switch method {
+	case encryptionpb.EncryptionMethod_AES128_CTR:
+		keyLen = 16
+	case encryptionpb.EncryptionMethod_AES192_CTR:
+		keyLen = 24
+	case encryptionpb.EncryptionMethod_AES256_CTR:
+		keyLen = 32
+	default:
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "can't generate data key for %s", method)
+	}
+	key := make([]byte, keyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &backuppb.CipherInfo{CipherType: method, CipherKey: key}, nil
+}
+
+// WriteDataKey encrypts the data key by the master key, and writes it to the file.
+func WriteDataKey(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	name string,
+	masterKey MasterKey,
+	dataKey *backuppb.CipherInfo,
+) error {
+	ciphertext, err := masterKey.Encrypt(ctx, dataKey.CipherKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	data, err := json.Marshal(&encryptedDataKey{
+		Method:     dataKey.CipherType,
+		MasterKey:  masterKey.String(),
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.WriteFile(ctx, name, data))
+}
+
+// ReadDataKey reads the data key from the file and decrypts it by the master key.
+func ReadDataKey(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	name string,
+	masterKey MasterKey,
+) (*backuppb.CipherInfo, error) {
+	data, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var encrypted encryptedDataKey
+	if err = json.Unmarshal(data, &encrypted); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile, "failed to parse %s: %s", name, err)
+	}
+	key, err := masterKey.Decrypt(ctx, encrypted.Ciphertext)
+	if err != nil {
+		return nil, errors.Annotatef(err, "the data key is encrypted by %s", encrypted.MasterKey)
+	}
+	return &backuppb.CipherInfo{CipherType: encrypted.Method, CipherKey: key}, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package metautil
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLocalMasterKey(t *testing.T, key string) MasterKey {
+	path := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, os.WriteFile(path, []byte(key+"\n"), 0o600))
+	masterKey, err := NewMasterKey("local://" + path)
+	require.NoError(t, err)
+	return masterKey
+}
+
+func TestDataKeyWithLocalMasterKey(t *testing.T) {
+	ctx := context.Background()
+	masterKey := writeLocalMasterKey(t, strings.Repeat("01", 32))
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+
+	dataKey, err := GenerateDataKey(encryptionpb.EncryptionMethod_AES192_CTR)
+	require.NoError(t, err)
+	require.Len(t, dataKey.CipherKey, 24)
+	require.NoError(t, WriteDataKey(ctx, s, DataKeyFile, masterKey, dataKey))
+	content, err := s.ReadFile(ctx, DataKeyFile)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), string(dataKey.CipherKey))
+
+	loaded, err := ReadDataKey(ctx, s, DataKeyFile, masterKey)
+	require.NoError(t, err)
+	require.Equal(t, dataKey.CipherType, loaded.CipherType)
+	require.Equal(t, dataKey.CipherKey, loaded.CipherKey)
+
+	otherKey := writeLocalMasterKey(t, strings.Repeat("02", 32))
+	_, err = ReadDataKey(ctx, s, DataKeyFile, otherKey)
+	require.True(t, berrors.Is(err, berrors.ErrMasterKey))
+
+	_, err = GenerateDataKey(encryptionpb.EncryptionMethod_PLAINTEXT)
+	require.Error(t, err)
+	_, err = NewMasterKey("local://" + filepath.Join(t.TempDir(), "not-exist"))
+	require.True(t, berrors.Is(err, berrors.ErrMasterKey))
+	_, err = NewMasterKey("gcp-kms:///key")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
+
+func TestVaultMasterKey(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		// A fake transit engine which only adds a prefix.
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/br":
+			_, _ = w.Write([]byte(`{"data":{"ciphertext":"vault:v1:` + body["plaintext"] + `"}}`))
+		case "/v1/transit/decrypt/br":
+			_, _ = w.Write([]byte(`{"data":{"plaintext":"` + strings.TrimPrefix(body["ciphertext"], "vault:v1:") + `"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	require.NoError(t, os.Setenv(vaultTokenEnvVar, "token"))
+	defer os.Unsetenv(vaultTokenEnvVar)
+	masterKey, err := NewMasterKey("vault://" + srv.Listener.Addr().String() + "/transit/br?scheme=http")
+	require.NoError(t, err)
+	masterKey.(*vaultMasterKey).cli = srv.Client()
+	ciphertext, err := masterKey.Encrypt(ctx, []byte("data key"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(ciphertext), "vault:v1:"))
+	plaintext, err := masterKey.Decrypt(ctx, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data key"), plaintext)
+
+	masterKey.(*vaultMasterKey).token = "wrong"
+	_, err = masterKey.Decrypt(ctx, ciphertext)
+	require.True(t, berrors.Is(err, berrors.ErrMasterKey))
+	require.Contains(t, err.Error(), "permission denied")
+
+	_, err = NewMasterKey("vault://" + srv.Listener.Addr().String() + "/br")
+	require.True(t, berrors.Is(err, berrors.ErrInvalidArgument))
+}
+
+type mockKMS struct {
+	kmsiface.KMSAPI
+}
+
+func (mockKMS) EncryptWithContext(_ aws.Context, in *kms.EncryptInput, _ ...request.Option) (*kms.EncryptOutput, error) {
+	return &kms.EncryptOutput{CiphertextBlob: append([]byte(aws.StringValue(in.KeyId)), in.Plaintext...)}, nil
+}
+
+func (mockKMS) DecryptWithContext(_ aws.Context, in *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: in.CiphertextBlob[len(aws.StringValue(in.KeyId)):]}, nil
+}
+
+func TestAWSKMSMasterKey(t *testing.T) {
+	ctx := context.Background()
+	masterKey, err := NewMasterKey("aws-kms:///key-id?region=us-west-2&endpoint=http://127.0.0.1:4566")
+	require.NoError(t, err)
+	require.Equal(t, "aws-kms:///key-id", masterKey.String())
+	masterKey.(*awsKMSMasterKey).cli = mockKMS{}
+
+	ciphertext, err := masterKey.Encrypt(ctx, []byte("data key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("key-iddata key"), ciphertext)
+	plaintext, err := masterKey.Decrypt(ctx, ciphertext)
+	require.NoError(t, err)
+	require.Equal(t, []byte("data key"), plaintext)
+}
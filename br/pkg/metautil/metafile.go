@@ -37,6 +37,9 @@ const (
 	MetaFile = "backupmeta"
 	// MetaJSONFile represents backup meta json file name
 	MetaJSONFile = "backupmeta.json"
+	// RawChecksumFile is the checksum of each range of a raw backup, which
+	// is written by WriteCheckpointFile.
+	RawChecksumFile = "backupmeta.rawchecksum"
 	// MaxBatchSize represents the internal channel buffer size of MetaWriter and MetaReader.
 	MaxBatchSize = 1024
 
@@ -102,33 +105,70 @@ func walkLeafMetaFile(
 		return nil
 	}
 	for _, node := range file.MetaFiles {
-		content, err := storage.ReadFile(ctx, node.Name)
+		child, err := readMetaFile(ctx, storage, node, cipher)
 		if err != nil {
 			return errors.Trace(err)
 		}
-
-		decryptContent, err := Decrypt(content, cipher, node.CipherIv)
-		if err != nil {
+		if err = walkLeafMetaFile(ctx, storage, child, cipher, output); err != nil {
 			return errors.Trace(err)
 		}
+	}
+	return nil
+}
 
-		checksum := sha256.Sum256(decryptContent)
-		if !bytes.Equal(node.Sha256, checksum[:]) {
-			return errors.Annotatef(berrors.ErrInvalidMetaFile,
-				"checksum mismatch expect %x, got %x", node.Sha256, checksum[:])
-		}
-
-		child := &backuppb.MetaFile{}
-		if err = proto.Unmarshal(decryptContent, child); err != nil {
+// walkMetaFileNodes calls output on each metafile referenced by the index
+// recursively, the metafiles are read to verify their checksums.
+func walkMetaFileNodes(
+	ctx context.Context,
+	storage storage.ExternalStorage,
+	file *backuppb.MetaFile,
+	cipher *backuppb.CipherInfo,
+	output func(*backuppb.File)) error {
+	if file == nil {
+		return nil
+	}
+	for _, node := range file.MetaFiles {
+		child, err := readMetaFile(ctx, storage, node, cipher)
+		if err != nil {
 			return errors.Trace(err)
 		}
-		if err = walkLeafMetaFile(ctx, storage, child, cipher, output); err != nil {
+		output(node)
+		if err = walkMetaFileNodes(ctx, storage, child, cipher, output); err != nil {
 			return errors.Trace(err)
 		}
 	}
 	return nil
 }
 
+// readMetaFile reads the metafile and verifies its checksum.
+func readMetaFile(
+	ctx context.Context,
+	storage storage.ExternalStorage,
+	node *backuppb.File,
+	cipher *backuppb.CipherInfo) (*backuppb.MetaFile, error) {
+	content, err := storage.ReadFile(ctx, node.Name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	decryptContent, err := Decrypt(content, cipher, node.CipherIv)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	checksum := sha256.Sum256(decryptContent)
+	if !bytes.Equal(node.Sha256, checksum[:]) {
+		return nil, errors.Annotatef(berrors.ErrInvalidMetaFile,
+			"checksum mismatch expect %x, got %x", node.Sha256, checksum[:])
+	}
+
+	child := &backuppb.MetaFile{}
+	if err = proto.Unmarshal(decryptContent, child); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return child, nil
+}
+
 // Table wraps the schema and files of a table.
 type Table struct {
 	DB              *model.DBInfo
@@ -209,6 +249,27 @@ func (reader *MetaReader) readDataFiles(ctx context.Context, output func(*backup
 	return walkLeafMetaFile(ctx, reader.storage, reader.backupMeta.FileIndex, reader.cipher, outputFn)
 }
 
+// ReadDataFiles reads the data files of both backupmeta v1 and v2.
+func (reader *MetaReader) ReadDataFiles(ctx context.Context, output func(*backuppb.File)) error {
+	return errors.Trace(reader.readDataFiles(ctx, output))
+}
+
+// ReadMetaFiles reads the metafiles referenced by the indexes of backupmeta
+// v2, and verifies their checksums. Backupmeta v1 has no metafile.
+func (reader *MetaReader) ReadMetaFiles(ctx context.Context, output func(*backuppb.File)) error {
+	indexes := []*backuppb.MetaFile{
+		reader.backupMeta.FileIndex,
+		reader.backupMeta.SchemaIndex,
+		reader.backupMeta.DdlIndexes,
+	}
+	for _, index := range indexes {
+		if err := walkMetaFileNodes(ctx, reader.storage, index, reader.cipher, output); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
 // ArchiveSize return the size of Archive data
 func (reader *MetaReader) ArchiveSize(ctx context.Context, files []*backuppb.File) uint64 {
 	total := uint64(0)
@@ -597,7 +658,7 @@ func (writer *MetaWriter) FinishWriteMetas(ctx context.Context, op AppendOp) err
 
 	costs := time.Since(writer.start)
 	if op == AppendDataFile {
-		summary.CollectSuccessUnit("backup ranges", writer.flushedItemNum, costs)
+		summary.FromContext(ctx).CollectSuccessUnit("backup ranges", writer.flushedItemNum, costs)
 	}
 	log.Info("finish the write metas", zap.Int("item", writer.flushedItemNum),
 		zap.String("type", op.name()), zap.Duration("costs", costs))
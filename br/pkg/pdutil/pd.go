@@ -35,6 +35,7 @@ const (
 	clusterVersionPrefix = "pd/api/v1/config/cluster-version"
 	regionCountPrefix    = "pd/api/v1/stats/region"
 	storePrefix          = "pd/api/v1/store"
+	storesPrefix         = "pd/api/v1/stores"
 	schedulerPrefix      = "pd/api/v1/schedulers"
 	maxMsgSize           = int(128 * units.MiB) // pd.ScanRegion may return a large response
 	scheduleConfigPrefix = "pd/api/v1/config/schedule"
@@ -376,6 +377,30 @@ func (p *PdController) getStoreInfoWith(
 	return nil, errors.Trace(err)
 }
 
+// GetAllStoresInfo returns the info of all the stores, including the status
+// reported by the stores' heartbeats.
+func (p *PdController) GetAllStoresInfo(ctx context.Context) (*pdapi.StoresInfo, error) {
+	return p.getAllStoresInfoWith(ctx, pdRequest)
+}
+
+func (p *PdController) getAllStoresInfoWith(ctx context.Context, get pdHTTPRequest) (*pdapi.StoresInfo, error) {
+	var err error
+	for _, addr := range p.addrs {
+		v, e := get(ctx, addr, storesPrefix, p.cli, http.MethodGet, nil)
+		if e != nil {
+			err = e
+			continue
+		}
+		stores := pdapi.StoresInfo{}
+		err = json.Unmarshal(v, &stores)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return &stores, nil
+	}
+	return nil, errors.Trace(err)
+}
+
 func (p *PdController) doPauseSchedulers(ctx context.Context, schedulers []string, post pdHTTPRequest) ([]string, error) {
 	// pause this scheduler with 300 seconds
 	body, err := json.Marshal(pauseSchedulerBody{Delay: int64(pauseTimeout)})
@@ -234,3 +234,30 @@ func TestStoreInfo(t *testing.T) {
 	require.Equal(t, "Tombstone", resp.Store.StateName)
 	require.Equal(t, uint64(1024), uint64(resp.Status.Available))
 }
+
+func TestAllStoresInfo(t *testing.T) {
+	storesInfo := api.StoresInfo{
+		Count: 1,
+		Stores: []*api.StoreInfo{{
+			Status: &api.StoreStatus{SlowScore: 80, IsBusy: true},
+			Store:  &api.MetaStore{StoreID: 1, StateName: "Up"},
+		}},
+	}
+	mock := func(
+		_ context.Context, addr string, prefix string, _ *http.Client, _ string, _ io.Reader,
+	) ([]byte, error) {
+		query := fmt.Sprintf("%s/%s", addr, prefix)
+		require.Equal(t, "http://mock/pd/api/v1/stores", query)
+		ret, err := json.Marshal(storesInfo)
+		require.NoError(t, err)
+		return ret, nil
+	}
+
+	pdController := &PdController{addrs: []string{"http://mock"}}
+	resp, err := pdController.getAllStoresInfoWith(context.Background(), mock)
+	require.NoError(t, err)
+	require.Len(t, resp.Stores, 1)
+	require.Equal(t, uint64(1), resp.Stores[0].Store.StoreID)
+	require.Equal(t, uint64(80), resp.Stores[0].Status.SlowScore)
+	require.True(t, resp.Stores[0].Status.IsBusy)
+}
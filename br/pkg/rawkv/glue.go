@@ -0,0 +1,60 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package rawkv
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+	"github.com/tikv/migration/br/pkg/summary"
+)
+
+// taskGlue reports the progress to the callback instead of the progress bar.
+// The infos are recorded into the collector of the task in the context.
+type taskGlue struct {
+	gluetikv.Glue
+	onProgress func(Progress)
+}
+
+// StartProgress implements glue.Glue.
+func (g *taskGlue) StartProgress(_ context.Context, cmdName string, total int64, _ bool) glue.Progress {
+	return &progress{step: cmdName, total: total, onProgress: g.onProgress}
+}
+
+type progress struct {
+	step       string
+	total      int64
+	completed  int64
+	onProgress func(Progress)
+}
+
+// Inc implements glue.Progress.
+func (p *progress) Inc() {
+	p.report(atomic.AddInt64(&p.completed, 1))
+}
+
+// Close implements glue.Progress.
+func (p *progress) Close() {
+	p.report(p.total)
+}
+
+func (p *progress) report(completed int64) {
+	if p.onProgress != nil {
+		p.onProgress(Progress{Step: p.step, Completed: completed, Total: p.total})
+	}
+}
+
+// resultCollector keeps the report of the task when the summary is logged,
+// after which the collected infos are reset.
+type resultCollector struct {
+	summary.LogCollector
+	report *summary.Report
+}
+
+// Summary implements summary.LogCollector.
+func (c *resultCollector) Summary(name string) {
+	c.report = c.LogCollector.Snapshot(name)
+	c.LogCollector.Summary(name)
+}
@@ -0,0 +1,273 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+// Package rawkv backs up and restores the raw kv of TiKV from Go code. Each
+// task collects its own summary and reports its own progress, so multiple
+// tasks can run in one process.
+package rawkv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/migration/br/pkg/task"
+	pd "github.com/tikv/pd/client"
+)
+
+const (
+	backupName  = "Raw backup"
+	restoreName = "Raw restore"
+
+	// backupTSField is the field the raw backup records its ts in.
+	backupTSField = "BackupTS"
+)
+
+// KeyRange is the raw key range [StartKey, EndKey), an empty EndKey means the
+// max key.
+type KeyRange struct {
+	StartKey []byte `json:"start-key"`
+	EndKey   []byte `json:"end-key"`
+}
+
+// TLSOptions are the paths of the certificates to connect the cluster, the
+// connection isn't secure if CA is empty.
+type TLSOptions struct {
+	CA   string `json:"ca"`
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// Progress is the progress of a step of a task.
+type Progress struct {
+	Step      string `json:"step"`
+	Completed int64  `json:"completed"`
+	Total     int64  `json:"total"`
+}
+
+// Options are the options of both backup and restore, the zero values mean
+// the defaults of the command line.
+type Options struct {
+	// PD are the addresses of PD.
+	PD  []string   `json:"pd"`
+	TLS TLSOptions `json:"tls"`
+	// Storage is the url of the backup, e.g. s3://bucket/path.
+	Storage        string                 `json:"storage"`
+	BackendOptions storage.BackendOptions `json:"backend"`
+	// Cipher encrypts the backup, the backup is in plaintext if it's nil.
+	Cipher *backuppb.CipherInfo `json:"cipher"`
+
+	// Ranges are the disjoint ranges to back up or restore, all the keys are
+	// backed up and all the backed up ranges are restored if it's empty.
+	Ranges []KeyRange `json:"ranges"`
+	CFs    []string   `json:"cfs"`
+	// RateLimit is the speed limit of each store in bytes per second.
+	RateLimit    uint64 `json:"ratelimit"`
+	Concurrency  uint32 `json:"concurrency"`
+	SkipChecksum bool   `json:"skip-checksum"`
+	// Resume resumes the unfinished task from its checkpoint.
+	Resume bool `json:"resume"`
+
+	// OnProgress is called from multiple goroutines when a step of the task
+	// makes progress.
+	OnProgress func(Progress) `json:"-"`
+}
+
+// BackupOptions are the options of the raw backup.
+type BackupOptions struct {
+	Options
+
+	// BackupTS is the ts to back up, the current ts is used if it's 0.
+	BackupTS uint64 `json:"backup-ts"`
+	// LastBackupTS makes the backup an incremental backup, which only contains
+	// the keys changed in (LastBackupTS, BackupTS], it's only supported in an
+	// API V2 cluster.
+	LastBackupTS uint64 `json:"last-backup-ts"`
+	// GCTTL is the TTL in seconds of the GC safepoint kept during the backup.
+	GCTTL            int64                    `json:"gcttl"`
+	CompressionType  backuppb.CompressionType `json:"compression-type"`
+	CompressionLevel int32                    `json:"compression-level"`
+}
+
+// RestoreOptions are the options of the raw restore.
+type RestoreOptions struct {
+	Options
+
+	// IncrementalStorages are the incremental backups applied in order after
+	// the backup in Storage.
+	IncrementalStorages []string `json:"incremental-storages"`
+	// Online restores without switching the cluster to import mode.
+	Online bool `json:"online"`
+	// The keys with OldKeyPrefix are restored under NewKeyPrefix.
+	OldKeyPrefix []byte `json:"old-key-prefix"`
+	NewKeyPrefix []byte `json:"new-key-prefix"`
+	// KeyspaceID restores only the keyspace of an API V2 backup, and
+	// TargetKeyspaceID is the keyspace of the API V2 cluster to restore into.
+	KeyspaceID       *uint32 `json:"keyspace-id"`
+	TargetKeyspaceID *uint32 `json:"target-keyspace-id"`
+	// CheckpointStorage is where the ingested files are recorded, the restore
+	// can only be resumed if it's set.
+	CheckpointStorage string `json:"checkpoint-storage"`
+	// OnConflict is how to restore into the ranges with existing keys, one of
+	// "overwrite", "skip-existing" and "fail", defaults to "overwrite".
+	OnConflict string `json:"on-conflict"`
+}
+
+// StoreResult is the amount of data a store backs up or restores.
+type StoreResult struct {
+	StoreID uint64 `json:"store-id"`
+	Files   int    `json:"files"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// Result is the summary of a task.
+type Result struct {
+	Duration      time.Duration `json:"duration"`
+	TotalRanges   int           `json:"total-ranges"`
+	SucceedRanges int           `json:"ranges-succeed"`
+	FailedRanges  int           `json:"ranges-failed"`
+	Files         int           `json:"files"`
+	TotalKVs      uint64        `json:"total-kvs"`
+	TotalBytes    uint64        `json:"total-bytes"`
+	// DataSize is the size of the backup files after compressed.
+	DataSize uint64        `json:"data-size"`
+	Stores   []StoreResult `json:"stores"`
+	// BackupTS is the ts the backup is taken at, it's only set by Backup.
+	BackupTS uint64 `json:"backup-ts"`
+	// Fields are the other infos collected by the task, such as the time
+	// taken by the steps.
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// Backup backs up the raw kv, the result is returned even if the backup fails.
+func Backup(ctx context.Context, opts *BackupOptions) (*Result, error) {
+	cfg := task.DefaultRawBackupConfig()
+	if err := opts.Options.apply(&cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg.BackupTS = opts.BackupTS
+	cfg.LastBackupTS = opts.LastBackupTS
+	if opts.GCTTL != 0 {
+		cfg.GCTTL = opts.GCTTL
+	}
+	if opts.CompressionType != backuppb.CompressionType_UNKNOWN {
+		cfg.CompressionType = opts.CompressionType
+	}
+	cfg.CompressionLevel = opts.CompressionLevel
+	return run(ctx, summary.BackupUnit, backupName, opts.OnProgress,
+		func(ctx context.Context, g *taskGlue) error {
+			return task.RunBackupRaw(ctx, g, backupName, &cfg)
+		})
+}
+
+// Restore restores the raw kv, the result is returned even if the restore
+// fails.
+func Restore(ctx context.Context, opts *RestoreOptions) (*Result, error) {
+	cfg := task.DefaultRawRestoreConfig()
+	if err := opts.Options.apply(&cfg.RawKvConfig); err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg.IncrementalStorages = opts.IncrementalStorages
+	cfg.Online = opts.Online
+	cfg.OldKeyPrefix = opts.OldKeyPrefix
+	cfg.NewKeyPrefix = opts.NewKeyPrefix
+	cfg.KeyspaceID = opts.KeyspaceID
+	cfg.TargetKeyspaceID = opts.TargetKeyspaceID
+	cfg.CheckpointStorage = opts.CheckpointStorage
+	if cfg.Resume && len(cfg.CheckpointStorage) == 0 {
+		return nil, errors.Annotate(berrors.ErrInvalidArgument, "resuming the restore requires the checkpoint storage")
+	}
+	if len(opts.OnConflict) > 0 {
+		cfg.OnConflict = opts.OnConflict
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return run(ctx, summary.RestoreUnit, restoreName, opts.OnProgress,
+		func(ctx context.Context, g *taskGlue) error {
+			return task.RunRestoreRaw(ctx, g, restoreName, &cfg)
+		})
+}
+
+// ClusterID reads the id of the cluster of the options from PD.
+func ClusterID(ctx context.Context, opts *Options) (uint64, error) {
+	pdClient, err := pd.NewClientWithContext(ctx, opts.PD, pd.SecurityOption{
+		CAPath:   opts.TLS.CA,
+		CertPath: opts.TLS.Cert,
+		KeyPath:  opts.TLS.Key,
+	})
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer pdClient.Close()
+	return pdClient.GetClusterID(ctx), nil
+}
+
+// apply sets the options to the config with the default values.
+func (opts *Options) apply(cfg *task.RawKvConfig) error {
+	cfg.PD = append([]string{}, opts.PD...)
+	cfg.TLS = task.TLSConfig{CA: opts.TLS.CA, Cert: opts.TLS.Cert, Key: opts.TLS.Key}
+	cfg.Storage = opts.Storage
+	cfg.BackendOptions = opts.BackendOptions
+	if opts.Cipher != nil {
+		cfg.CipherInfo = *opts.Cipher
+	}
+	cfg.Ranges = make([]task.KeyRange, 0, len(opts.Ranges))
+	for _, rg := range opts.Ranges {
+		cfg.Ranges = append(cfg.Ranges, task.KeyRange{StartKey: rg.StartKey, EndKey: rg.EndKey})
+	}
+	if len(opts.CFs) > 0 {
+		cfg.CFs = opts.CFs
+	}
+	cfg.RateLimit = opts.RateLimit
+	if opts.Concurrency > 0 {
+		cfg.Concurrency = opts.Concurrency
+	}
+	cfg.Checksum = !opts.SkipChecksum
+	cfg.Resume = opts.Resume
+	return errors.Trace(cfg.Validate())
+}
+
+// run runs the task with its own summary and progress.
+func run(
+	ctx context.Context,
+	unit, name string,
+	onProgress func(Progress),
+	runTask func(ctx context.Context, g *taskGlue) error,
+) (*Result, error) {
+	collector := &resultCollector{LogCollector: summary.NewLogCollector(log.Info)}
+	collector.SetUnit(unit)
+	g := &taskGlue{onProgress: onProgress}
+	err := runTask(summary.WithCollector(ctx, collector), g)
+	report := collector.report
+	if report == nil {
+		report = collector.Snapshot(name)
+	}
+	return newResult(report), errors.Trace(err)
+}
+
+func newResult(report *summary.Report) *Result {
+	result := &Result{
+		Duration:      time.Duration(report.DurationSeconds * float64(time.Second)),
+		TotalRanges:   report.TotalRanges,
+		SucceedRanges: report.SucceedRanges,
+		FailedRanges:  report.FailedRanges,
+		Files:         report.Files,
+		TotalKVs:      report.TotalKVs,
+		TotalBytes:    report.TotalBytes,
+		DataSize:      report.DataSize,
+		Stores:        make([]StoreResult, 0, len(report.Stores)),
+		Fields:        report.Fields,
+	}
+	if ts, ok := report.Fields[backupTSField].(uint64); ok {
+		result.BackupTS = ts
+	}
+	for _, s := range report.Stores {
+		result.Stores = append(result.Stores, StoreResult{StoreID: s.StoreID, Files: s.Files, Bytes: s.Bytes})
+	}
+	return result
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package rawkv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/summary"
+)
+
+func TestRunWithOwnSummary(t *testing.T) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	results := make([]*Result, 2)
+	progresses := make([][]Progress, 2)
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			onProgress := func(p Progress) {
+				progresses[i] = append(progresses[i], p)
+			}
+			result, err := run(ctx, summary.BackupUnit, backupName, onProgress,
+				func(ctx context.Context, g *taskGlue) error {
+					defer summary.FromContext(ctx).Summary(backupName)
+					updateCh := g.StartProgress(ctx, "step", 2, false)
+					updateCh.Inc()
+					updateCh.Close()
+					summary.FromContext(ctx).CollectStoreUnit(uint64(i+1), i+1, 100)
+					g.Record(ctx, summary.BackupDataSize, uint64(10*(i+1)))
+					g.Record(ctx, backupTSField, uint64(100+i))
+					return nil
+				})
+			require.NoError(t, err)
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		require.Equal(t, i+1, result.Files)
+		require.Equal(t, uint64(10*(i+1)), result.DataSize)
+		require.Equal(t, uint64(100+i), result.BackupTS)
+		require.Equal(t, []StoreResult{{StoreID: uint64(i + 1), Files: i + 1, Bytes: 100}}, result.Stores)
+		require.Equal(t, []Progress{
+			{Step: "step", Completed: 1, Total: 2},
+			{Step: "step", Completed: 2, Total: 2},
+		}, progresses[i])
+	}
+	// Nothing is collected into the global summary.
+	require.Zero(t, summary.Snapshot(backupName).Files)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	ctx := context.Background()
+	_, err := Backup(ctx, &BackupOptions{Options: Options{Storage: "local:///tmp/backup"}})
+	require.True(t, berrors.ErrInvalidArgument.Equal(err))
+
+	_, err = Backup(ctx, &BackupOptions{Options: Options{
+		PD:      []string{"127.0.0.1:2379"},
+		Storage: "local:///tmp/backup",
+		Ranges: []KeyRange{
+			{StartKey: []byte("a"), EndKey: []byte("c")},
+			{StartKey: []byte("b"), EndKey: []byte("d")},
+		},
+	}})
+	require.True(t, berrors.ErrBackupInvalidRange.Equal(err))
+
+	_, err = Restore(ctx, &RestoreOptions{Options: Options{
+		PD:      []string{"127.0.0.1:2379"},
+		Storage: "local:///tmp/backup",
+		Resume:  true,
+	}})
+	require.True(t, berrors.ErrInvalidArgument.Equal(err))
+}
+
+func TestConcurrentRestoreSummary(t *testing.T) {
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	results := make([]*Result, 2)
+	for i := range results {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := run(ctx, summary.RestoreUnit, restoreName, nil,
+				func(ctx context.Context, g *taskGlue) error {
+					defer summary.FromContext(ctx).Summary(restoreName)
+					client := &restore.Client{}
+					client.SetConcurrency(1)
+					// The file can't be restored without the rewrite rule of
+					// its keys, the failure is collected.
+					name := fmt.Sprintf("%d.sst", i)
+					files := []*backuppb.File{{Name: name, StartKey: []byte("a"), EndKey: []byte("b")}}
+					err := client.RestoreFiles(ctx, files, &restore.RewriteRules{}, g.StartProgress(ctx, name, 1, false))
+					require.True(t, berrors.ErrRestoreInvalidRewrite.Equal(err))
+					g.Record(ctx, summary.RestoreDataSize, uint64(10*(i+1)))
+					return nil
+				})
+			require.NoError(t, err)
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		require.Equal(t, 1, result.FailedRanges, i)
+		require.Equal(t, uint64(10*(i+1)), result.DataSize)
+	}
+	report := summary.Snapshot(restoreName)
+	require.Zero(t, report.FailedRanges)
+	require.Zero(t, report.DataSize)
+}
@@ -0,0 +1,695 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+// Package relay serves the backup storage of BR to TiKV, for the clusters in
+// which TiKV can't reach the storage but BR can.
+//
+// The relay server speaks a subset of the S3 API, which is enough for TiKV to
+// write the backup files and read them for restore. TiKV is given an S3
+// backend pointing to the server, and the requests are relayed to the
+// ExternalStorage of BR.
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// region is the region of the S3 backend, it's required by the signature.
+	region = "us-east-1"
+
+	amzDateFormat = "20060102T150405Z"
+	// unsignedPayload is the x-amz-content-sha256 of the requests whose
+	// payload isn't signed.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	// maxClockSkew is the max difference between the time a request is signed
+	// and the time it's received, as S3 does, so a captured request can't be
+	// replayed later.
+	maxClockSkew = 15 * time.Minute
+	// maxRequestBodySize is the max size of the requests except uploading,
+	// whose body is read into memory.
+	maxRequestBodySize = 1 << 20
+
+	// defaultUploadTTL is how long an unfinished multipart upload is kept
+	// since its last request, the abandoned uploads are removed after that.
+	defaultUploadTTL = 30 * time.Minute
+)
+
+// errContentSHA256Mismatch is returned when reading the payload of a request
+// whose sha256 doesn't match the x-amz-content-sha256 header.
+var errContentSHA256Mismatch = errors.New("the payload doesn't match x-amz-content-sha256")
+
+// Server relays the S3 requests from TiKV to the storages of BR. A storage is
+// exposed as a bucket after it's registered.
+type Server struct {
+	listener      net.Listener
+	server        *http.Server
+	advertiseAddr string
+
+	accessKey string
+	secretKey string
+
+	// spoolDir keeps the uploaded payloads until they're written to the storages.
+	spoolDir  string
+	uploadTTL time.Duration
+	stopGC    chan struct{}
+	gcDone    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	buckets  map[string]storage.ExternalStorage
+	uploads  map[string]*multipartUpload
+	uploadID int
+}
+
+// multipartUpload is an unfinished multipart upload, the parts are spooled to
+// the files in the spool directory until the upload is completed.
+type multipartUpload struct {
+	bucket string
+	key    string
+	// parts are the paths of the spooled parts by the part numbers.
+	parts map[int]string
+	// lastActive is the time of the last request of the upload.
+	lastActive time.Time
+}
+
+// remove removes the spooled parts of the upload.
+func (u *multipartUpload) remove() {
+	for _, path := range u.parts {
+		_ = os.Remove(path)
+	}
+}
+
+// NewServer starts a relay server listening on the address. TiKV reaches the
+// server by the advertise address, the listening address is used if it's empty.
+func NewServer(listenAddr, advertiseAddr string) (*Server, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to listen on %s for relay: %s", listenAddr, err)
+	}
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	if len(advertiseAddr) == 0 {
+		advertiseAddr = listener.Addr().String()
+	} else if _, _, err := net.SplitHostPort(advertiseAddr); err != nil {
+		// Only the host is given, the port is the listening port.
+		advertiseAddr = net.JoinHostPort(advertiseAddr, port)
+	}
+	spoolDir, err := os.MkdirTemp("", "br-relay-")
+	if err != nil {
+		_ = listener.Close()
+		return nil, errors.Trace(err)
+	}
+	s := &Server{
+		listener:      listener,
+		advertiseAddr: advertiseAddr,
+		accessKey:     randomHex(10),
+		secretKey:     randomHex(20),
+		spoolDir:      spoolDir,
+		uploadTTL:     defaultUploadTTL,
+		stopGC:        make(chan struct{}),
+		gcDone:        make(chan struct{}),
+		buckets:       make(map[string]storage.ExternalStorage),
+		uploads:       make(map[string]*multipartUpload),
+	}
+	s.server = &http.Server{Handler: s}
+	go s.gcUploadsLoop()
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Warn("relay server stopped", zap.Error(err))
+		}
+	}()
+	log.Info("relay server started",
+		zap.Stringer("listen-addr", listener.Addr()), zap.String("advertise-addr", advertiseAddr))
+	return s, nil
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Register exposes the storage as the bucket.
+func (s *Server) Register(bucket string, store storage.ExternalStorage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[bucket] = store
+}
+
+// Backend returns the storage backend for TiKV to access the bucket through
+// the relay server.
+func (s *Server) Backend(bucket string) *backuppb.StorageBackend {
+	return &backuppb.StorageBackend{
+		Backend: &backuppb.StorageBackend_S3{
+			S3: &backuppb.S3{
+				Endpoint:        "http://" + s.advertiseAddr,
+				Region:          region,
+				Bucket:          bucket,
+				ForcePathStyle:  true,
+				AccessKey:       s.accessKey,
+				SecretAccessKey: s.secretKey,
+			},
+		},
+	}
+}
+
+// Close stops the server, and removes the unfinished uploads.
+func (s *Server) Close() error {
+	err := s.server.Close()
+	s.closeOnce.Do(func() { close(s.stopGC) })
+	<-s.gcDone
+	s.mu.Lock()
+	for uploadID, upload := range s.uploads {
+		upload.remove()
+		delete(s.uploads, uploadID)
+	}
+	s.mu.Unlock()
+	if rmErr := os.RemoveAll(s.spoolDir); rmErr != nil && err == nil {
+		err = rmErr
+	}
+	return errors.Trace(err)
+}
+
+// gcUploadsLoop removes the abandoned uploads periodically until the server
+// is closed, TiKV may never complete or abort an upload if it fails.
+func (s *Server) gcUploadsLoop() {
+	defer close(s.gcDone)
+	ticker := time.NewTicker(s.uploadTTL / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopGC:
+			return
+		case now := <-ticker.C:
+			s.gcUploads(now)
+		}
+	}
+}
+
+// gcUploads removes the uploads without any request for the upload ttl.
+func (s *Server) gcUploads(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uploadID, upload := range s.uploads {
+		if now.Sub(upload.lastActive) > s.uploadTTL {
+			log.Info("remove abandoned relay upload",
+				zap.String("upload-id", uploadID), zap.String("key", upload.key), zap.Int("parts", len(upload.parts)))
+			upload.remove()
+			delete(s.uploads, uploadID)
+		}
+	}
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(&s3Error{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payloadSHA256, err := s.authenticate(r, time.Now())
+	if err != nil {
+		log.Warn("rejected relay request", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.Error(err))
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	body := newPayloadReader(r.Body, payloadSHA256)
+	// Only the uploaded objects and parts are streamed, the other requests
+	// are small and read at once to be verified before handled.
+	var payload []byte
+	if r.Method != http.MethodPut {
+		if payload, err = io.ReadAll(io.LimitReader(body, maxRequestBodySize+1)); err != nil {
+			writePayloadError(w, r, err)
+			return
+		}
+		if len(payload) > maxRequestBodySize {
+			writeError(w, http.StatusBadRequest, "MaxMessageLengthExceeded", "the request is too large")
+			return
+		}
+	}
+	// The buckets are always in the path as the backend forces path style.
+	bucket, key := strings.TrimPrefix(r.URL.Path, "/"), ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	s.mu.Lock()
+	store, ok := s.buckets[bucket]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "bucket "+bucket+" isn't relayed")
+		return
+	}
+	if len(key) == 0 {
+		if r.Method == http.MethodHead {
+			return
+		}
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "bucket operations aren't supported by relay")
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && hasQuery(query, "uploads"):
+		s.createMultipartUpload(w, bucket, key)
+	case r.Method == http.MethodPut && hasQuery(query, "uploadId"):
+		err = s.uploadPart(w, body, query)
+	case r.Method == http.MethodPost && hasQuery(query, "uploadId"):
+		err = s.completeMultipartUpload(ctx, w, payload, store, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && hasQuery(query, "uploadId"):
+		s.mu.Lock()
+		if upload, ok := s.uploads[query.Get("uploadId")]; ok {
+			upload.remove()
+			delete(s.uploads, query.Get("uploadId"))
+		}
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		err = s.putObject(ctx, w, body, store, key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		err = getObject(ctx, w, r, store, key)
+	case r.Method == http.MethodDelete:
+		if err = store.DeleteFile(ctx, key); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" isn't supported by relay")
+	}
+	if err != nil {
+		writePayloadError(w, r, err)
+	}
+}
+
+func writePayloadError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Cause(err) == errContentSHA256Mismatch {
+		log.Warn("rejected relay request", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.Error(err))
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", err.Error())
+		return
+	}
+	log.Warn("failed to relay request", zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.Error(err))
+	writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+}
+
+// payloadReader reads the payload of a request, and verifies its sha256
+// against the x-amz-content-sha256 header once the payload is read to the end.
+type payloadReader struct {
+	r    io.Reader
+	hash hash.Hash
+	// expected is the signed sha256 of the payload, nil if it isn't signed.
+	expected []byte
+}
+
+func newPayloadReader(r io.Reader, expected []byte) *payloadReader {
+	return &payloadReader{r: r, hash: sha256.New(), expected: expected}
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.hash.Write(b[:n])
+	if err == io.EOF && p.expected != nil && !bytes.Equal(p.hash.Sum(nil), p.expected) {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// authenticate verifies the AWS signature V4 of the request, so only the
+// requests from TiKV holding the generated credentials are relayed. It
+// returns the signed sha256 of the payload, which is nil if the payload is
+// unsigned, the payload is verified against it when it's read.
+func (s *Server) authenticate(r *http.Request, now time.Time) ([]byte, error) {
+	auth := r.Header.Get("Authorization")
+	var credential, signedHeaders string
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ",") {
+		field = strings.TrimSpace(field)
+		if strings.HasPrefix(field, "Credential=") {
+			credential = strings.TrimPrefix(field, "Credential=")
+		} else if strings.HasPrefix(field, "SignedHeaders=") {
+			signedHeaders = strings.TrimPrefix(field, "SignedHeaders=")
+		}
+	}
+	if !strings.HasPrefix(credential, s.accessKey+"/") {
+		return nil, errors.New("unknown access key")
+	}
+	signTime, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return nil, errors.New("invalid X-Amz-Date")
+	}
+	if skew := now.Sub(signTime); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, errors.Errorf("the difference between the request time %s and the server time %s is too large",
+			signTime.Format(amzDateFormat), now.UTC().Format(amzDateFormat))
+	}
+	// The payload hash must be signed, otherwise the signature is computed
+	// over the hash of an empty payload and the payload isn't protected.
+	contentSHA256 := r.Header.Get("X-Amz-Content-Sha256")
+	if !containsHeader(signedHeaders, "x-amz-content-sha256") {
+		return nil, errors.New("x-amz-content-sha256 isn't signed")
+	}
+	var payloadSHA256 []byte
+	if contentSHA256 != unsignedPayload {
+		if payloadSHA256, err = hex.DecodeString(contentSHA256); err != nil || len(payloadSHA256) != sha256.Size {
+			return nil, errors.New("invalid x-amz-content-sha256")
+		}
+	}
+
+	// Sign the request again by the signed headers, and compare the signatures.
+	signed, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, h := range strings.Split(signedHeaders, ";") {
+		if h == "host" {
+			continue
+		}
+		if h == "content-length" {
+			signed.ContentLength = r.ContentLength
+		}
+		signed.Header[http.CanonicalHeaderKey(h)] = r.Header.Values(h)
+	}
+	signer := v4.NewSigner(credentials.NewStaticCredentials(s.accessKey, s.secretKey, ""), func(v4s *v4.Signer) {
+		v4s.DisableURIPathEscaping = true
+	})
+	if _, err = signer.Sign(signed, nil, "s3", region, signTime); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if signed.Header.Get("Authorization") != auth {
+		return nil, errors.New("signature mismatch")
+	}
+	return payloadSHA256, nil
+}
+
+func containsHeader(signedHeaders, header string) bool {
+	for _, h := range strings.Split(signedHeaders, ";") {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+func hasQuery(query url.Values, key string) bool {
+	_, ok := query[key]
+	return ok
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, bucket, key string) {
+	s.mu.Lock()
+	s.uploadID++
+	uploadID := strconv.Itoa(s.uploadID)
+	s.uploads[uploadID] = &multipartUpload{bucket: bucket, key: key, parts: make(map[int]string), lastActive: time.Now()}
+	s.mu.Unlock()
+	writeXML(w, &initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadID: uploadID})
+}
+
+// spool writes the payload to a file in the spool directory, and returns the
+// path and the etag of the payload.
+func (s *Server) spool(body io.Reader) (path string, etag string, err error) {
+	f, err := os.CreateTemp(s.spoolDir, "payload-")
+	if err != nil {
+		return "", "", errors.Trace(err)
+	}
+	sum := md5.New() // #nosec
+	_, err = io.Copy(io.MultiWriter(f, sum), body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", "", errors.Trace(err)
+	}
+	return f.Name(), `"` + hex.EncodeToString(sum.Sum(nil)) + `"`, nil
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, body io.Reader, query url.Values) error {
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return nil
+	}
+	path, etag, err := s.spool(body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.mu.Lock()
+	upload, ok := s.uploads[query.Get("uploadId")]
+	if ok {
+		// The part may be uploaded again when it's retried.
+		if old, exists := upload.parts[partNumber]; exists {
+			_ = os.Remove(old)
+		}
+		upload.parts[partNumber] = path
+		upload.lastActive = time.Now()
+	}
+	s.mu.Unlock()
+	if !ok {
+		_ = os.Remove(path)
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "upload "+query.Get("uploadId")+" not found")
+		return nil
+	}
+	w.Header().Set("ETag", etag)
+	return nil
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int `xml:"PartNumber"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (s *Server) completeMultipartUpload(
+	ctx context.Context,
+	w http.ResponseWriter,
+	payload []byte,
+	store storage.ExternalStorage,
+	uploadID string,
+) error {
+	var complete completeMultipartUpload
+	if err := xml.Unmarshal(payload, &complete); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return nil
+	}
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	delete(s.uploads, uploadID)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "upload "+uploadID+" not found")
+		return nil
+	}
+	defer upload.remove()
+	sort.Slice(complete.Parts, func(i, j int) bool {
+		return complete.Parts[i].PartNumber < complete.Parts[j].PartNumber
+	})
+	writer, err := store.Create(ctx, upload.key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, part := range complete.Parts {
+		path, ok := upload.parts[part.PartNumber]
+		if !ok {
+			_ = writer.Close(ctx)
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d not found", part.PartNumber))
+			return nil
+		}
+		if err = copySpooled(ctx, writer, path); err != nil {
+			_ = writer.Close(ctx)
+			return errors.Trace(err)
+		}
+	}
+	if err = writer.Close(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	writeXML(w, &completeMultipartUploadResult{Bucket: upload.bucket, Key: upload.key, ETag: `"` + uploadID + `"`})
+	return nil
+}
+
+// copySpooled streams a spooled payload to the writer of the storage.
+func copySpooled(ctx context.Context, writer storage.ExternalFileWriter, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer f.Close()
+	_, err = io.Copy(&storageWriter{ctx: ctx, w: writer}, f)
+	return errors.Trace(err)
+}
+
+// storageWriter adapts a storage.ExternalFileWriter to io.Writer.
+type storageWriter struct {
+	ctx context.Context
+	w   storage.ExternalFileWriter
+}
+
+func (w *storageWriter) Write(p []byte) (int, error) {
+	return w.w.Write(w.ctx, p)
+}
+
+// putObject spools the object until it's verified, so that a corrupted object
+// is never written to the storage.
+func (s *Server) putObject(
+	ctx context.Context,
+	w http.ResponseWriter,
+	body io.Reader,
+	store storage.ExternalStorage,
+	key string,
+) error {
+	path, etag, err := s.spool(body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.Remove(path)
+	writer, err := store.Create(ctx, key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = copySpooled(ctx, writer, path); err != nil {
+		_ = writer.Close(ctx)
+		return errors.Trace(err)
+	}
+	if err = writer.Close(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	w.Header().Set("ETag", etag)
+	return nil
+}
+
+// parseRange parses the range header in the form of "bytes=start-" or
+// "bytes=start-end", and returns the offset and length to read.
+func parseRange(header string, size int64) (offset, length int64, ok bool) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	i := strings.Index(spec, "-")
+	if spec == header || i <= 0 {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(spec[:i], 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if len(spec[i+1:]) > 0 {
+		end, err = strconv.ParseInt(spec[i+1:], 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+func getObject(ctx context.Context, w http.ResponseWriter, r *http.Request, store storage.ExternalStorage, key string) error {
+	exists, err := store.FileExists(ctx, key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !exists {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "key "+key+" not found")
+		}
+		return nil
+	}
+	reader, err := store.Open(ctx, key)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer reader.Close()
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	offset, length, status := int64(0), size, http.StatusOK
+	if header := r.Header.Get("Range"); len(header) > 0 {
+		var ok bool
+		if offset, length, ok = parseRange(header, size); !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "invalid range "+header)
+			return nil
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	if _, err = reader.Seek(offset, io.SeekStart); err != nil {
+		return errors.Trace(err)
+	}
+	w.WriteHeader(status)
+	// The error can't be returned after the header is written.
+	if _, err = io.Copy(w, io.LimitReader(reader, length)); err != nil {
+		log.Warn("failed to relay file", zap.String("key", key), zap.Error(err))
+	}
+	return nil
+}
+
+// LocalAddrTo returns the local IP address used to connect the remote
+// address, which is likely reachable from the remote.
+func LocalAddrTo(remote string) (string, error) {
+	if u, err := url.Parse(remote); err == nil && len(u.Host) > 0 {
+		remote = u.Host
+	}
+	conn, err := net.Dial("udp", remote)
+	if err != nil {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "failed to find the local address to %s: %s", remote, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
@@ -0,0 +1,196 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package relay
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/gogo/protobuf/proto"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayServer(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	local, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	server, err := NewServer("127.0.0.1:0", "")
+	require.NoError(t, err)
+	defer server.Close()
+	server.Register("backup", local)
+
+	// Access the relay server as TiKV does, by the S3 backend.
+	backend := server.Backend("backup")
+	s, err := storage.New(ctx, proto.Clone(backend).(*backuppb.StorageBackend),
+		&storage.ExternalStorageOptions{SendCredentials: true})
+	require.NoError(t, err)
+
+	exists, err := s.FileExists(ctx, "a.sst")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, s.WriteFile(ctx, "a.sst", []byte("0123456789")))
+	exists, err = s.FileExists(ctx, "a.sst")
+	require.NoError(t, err)
+	require.True(t, exists)
+	data, err := os.ReadFile(filepath.Join(dir, "a.sst"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("0123456789"), data)
+	data, err = s.ReadFile(ctx, "a.sst")
+	require.NoError(t, err)
+	require.Equal(t, []byte("0123456789"), data)
+	_, err = s.ReadFile(ctx, "not-exist")
+	require.Error(t, err)
+
+	r, err := s.Open(ctx, "a.sst")
+	require.NoError(t, err)
+	_, err = r.Seek(6, io.SeekStart)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "6789", string(rest))
+	require.NoError(t, r.Close())
+
+	// The large files are uploaded by multipart upload.
+	content := bytes.Repeat([]byte("relay"), 1024*1024)
+	w, err := s.Create(ctx, "b.sst")
+	require.NoError(t, err)
+	for i := 0; i < len(content); i += 1024 * 1024 {
+		_, err = w.Write(ctx, content[i:i+1024*1024])
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close(ctx))
+	data, err = local.ReadFile(ctx, "b.sst")
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+
+	require.NoError(t, s.DeleteFile(ctx, "a.sst"))
+	exists, err = local.FileExists(ctx, "a.sst")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// The requests without the credentials are rejected.
+	backend.GetS3().SecretAccessKey = "wrong"
+	s, err = storage.New(ctx, backend, &storage.ExternalStorageOptions{SendCredentials: true})
+	require.NoError(t, err)
+	_, err = s.ReadFile(ctx, "b.sst")
+	require.Error(t, err)
+	backend = server.Backend("other")
+	s, err = storage.New(ctx, backend, &storage.ExternalStorageOptions{SendCredentials: true})
+	require.NoError(t, err)
+	_, err = s.ReadFile(ctx, "b.sst")
+	require.Error(t, err)
+}
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		header         string
+		offset, length int64
+		ok             bool
+	}{
+		{"bytes=0-", 0, 10, true},
+		{"bytes=3-5", 3, 3, true},
+		{"bytes=8-20", 8, 2, true},
+		{"bytes=10-", 0, 0, false},
+		{"bytes=-5", 0, 0, false},
+		{"bytes=5-3", 0, 0, false},
+		{"items=0-1", 0, 0, false},
+	}
+	for _, c := range cases {
+		offset, length, ok := parseRange(c.header, 10)
+		require.Equal(t, c.ok, ok, c.header)
+		require.Equal(t, c.offset, offset, c.header)
+		require.Equal(t, c.length, length, c.header)
+	}
+}
+
+func TestNewServerAdvertiseAddr(t *testing.T) {
+	server, err := NewServer("127.0.0.1:0", "10.0.0.1")
+	require.NoError(t, err)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	require.Equal(t, "http://10.0.0.1:"+port, server.Backend("backup").GetS3().Endpoint)
+
+	server2, err := NewServer("127.0.0.1:0", "relay.example.com:8080")
+	require.NoError(t, err)
+	defer server2.Close()
+	require.Equal(t, "http://relay.example.com:8080", server2.Backend("backup").GetS3().Endpoint)
+
+	addr, err := LocalAddrTo("http://127.0.0.1:2379")
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", addr)
+}
+
+func TestRelayServerAuthenticate(t *testing.T) {
+	dir := t.TempDir()
+	local, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	server, err := NewServer("127.0.0.1:0", "")
+	require.NoError(t, err)
+	defer server.Close()
+	server.Register("backup", local)
+
+	send := func(body, signedBody string, signTime time.Time) int {
+		req, err := http.NewRequest(http.MethodPut, "http://"+server.advertiseAddr+"/backup/a.sst", nil)
+		require.NoError(t, err)
+		sum := sha256.Sum256([]byte(signedBody))
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		signer := v4.NewSigner(credentials.NewStaticCredentials(server.accessKey, server.secretKey, ""))
+		_, err = signer.Sign(req, strings.NewReader(body), "s3", region, signTime)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, send("data", "data", time.Now()))
+	// The payload is changed after signed.
+	require.Equal(t, http.StatusBadRequest, send("changed", "data", time.Now()))
+	// The request is replayed or the clock is skewed.
+	require.Equal(t, http.StatusForbidden, send("data", "data", time.Now().Add(-time.Hour)))
+	require.Equal(t, http.StatusForbidden, send("data", "data", time.Now().Add(time.Hour)))
+
+	data, err := os.ReadFile(filepath.Join(dir, "a.sst"))
+	require.NoError(t, err)
+	require.Equal(t, "data", string(data))
+}
+
+func TestRelayServerGCUploads(t *testing.T) {
+	server, err := NewServer("127.0.0.1:0", "")
+	require.NoError(t, err)
+	defer server.Close()
+
+	now := time.Now()
+	for i, lastActive := range []time.Time{now.Add(-time.Hour), now.Add(-time.Minute)} {
+		path, _, err := server.spool(strings.NewReader("part"))
+		require.NoError(t, err)
+		server.uploads[strconv.Itoa(i)] = &multipartUpload{key: "a.sst", parts: map[int]string{1: path}, lastActive: lastActive}
+	}
+	abandoned := server.uploads["0"].parts[1]
+	server.gcUploads(now)
+	require.Len(t, server.uploads, 1)
+	require.Contains(t, server.uploads, "1")
+	_, err = os.Stat(abandoned)
+	require.True(t, os.IsNotExist(err))
+
+	// The spooled parts are removed after the server is closed.
+	require.NoError(t, server.Close())
+	_, err = os.Stat(server.spoolDir)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,185 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	// CheckpointMetaFile is the file identifying the restore task of the checkpoint.
+	CheckpointMetaFile = "restore-checkpoint.meta"
+	// checkpointFilesFileFormat is the format of the files of the ingested
+	// backup files, a new file is written for the files ingested since the last flush.
+	checkpointFilesFileFormat = "restore-checkpoint.%06d"
+)
+
+// CheckpointMeta identifies the restore task of a checkpoint, only the same
+// task can resume from the checkpoint.
+type CheckpointMeta struct {
+	// ClusterID is the id of the cluster to restore to.
+	ClusterID uint64 `json:"cluster-id"`
+	// BackupTSs are the end versions of the restored backups in order.
+	BackupTSs []uint64 `json:"backup-tss"`
+	// Ranges are the ranges to restore of each cf.
+	Ranges       []*backuppb.RawRange `json:"ranges"`
+	OldKeyPrefix []byte               `json:"old-key-prefix"`
+	NewKeyPrefix []byte               `json:"new-key-prefix"`
+	TTLMode      string               `json:"ttl-mode"`
+	OnConflict   string               `json:"on-conflict"`
+}
+
+// Check checks whether the restore task is the same as the one of the checkpoint.
+func (m *CheckpointMeta) Check(other *CheckpointMeta) error {
+	if m.ClusterID != other.ClusterID {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"the checkpoint is of cluster %d, but the cluster is %d", m.ClusterID, other.ClusterID)
+	}
+	if len(m.BackupTSs) != len(other.BackupTSs) {
+		return errors.Annotate(berrors.ErrInvalidArgument, "the backups to restore are different from the checkpoint")
+	}
+	for i := range m.BackupTSs {
+		if m.BackupTSs[i] != other.BackupTSs[i] {
+			return errors.Annotate(berrors.ErrInvalidArgument, "the backups to restore are different from the checkpoint")
+		}
+	}
+	if len(m.Ranges) != len(other.Ranges) {
+		return errors.Annotate(berrors.ErrInvalidArgument, "the ranges to restore are different from the checkpoint")
+	}
+	for i := range m.Ranges {
+		if !proto.Equal(m.Ranges[i], other.Ranges[i]) {
+			return errors.Annotate(berrors.ErrInvalidArgument, "the ranges to restore are different from the checkpoint")
+		}
+	}
+	if !bytes.Equal(m.OldKeyPrefix, other.OldKeyPrefix) || !bytes.Equal(m.NewKeyPrefix, other.NewKeyPrefix) ||
+		m.TTLMode != other.TTLMode || m.OnConflict != other.OnConflict {
+		return errors.Annotate(berrors.ErrInvalidArgument, "the restore options are different from the checkpoint")
+	}
+	return nil
+}
+
+// IngestedFile is a backup file restored in a range.
+type IngestedFile struct {
+	BackupTS uint64 `json:"backup-ts"`
+	// RangeStartKey is the start key of the restore range, a file crossing
+	// several restore ranges is restored once in each of them.
+	RangeStartKey []byte `json:"range-start-key"`
+	Name          string `json:"name"`
+}
+
+func (f IngestedFile) key() string {
+	return fmt.Sprintf("%d/%x/%s", f.BackupTS, f.RangeStartKey, f.Name)
+}
+
+// CheckpointRunner records the ingested backup files and flushes them to the
+// external storage periodically, so that an unfinished restore can be resumed.
+// A nil CheckpointRunner records nothing.
+type CheckpointRunner struct {
+	runner *metautil.CheckpointRunner
+	meta   CheckpointMeta
+
+	// ingested are the files ingested before the restore is resumed.
+	ingested map[string]struct{}
+}
+
+// NewCheckpointRunner creates a checkpoint runner for a new restore task, and
+// writes the meta of the checkpoint to the storage.
+func NewCheckpointRunner(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+	meta CheckpointMeta,
+) (*CheckpointRunner, error) {
+	runner, err := metautil.NewCheckpointRunner(ctx, s, cipher, CheckpointMetaFile, checkpointFilesFileFormat, &meta)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &CheckpointRunner{runner: runner, meta: meta, ingested: make(map[string]struct{})}, nil
+}
+
+// LoadCheckpointRunner loads the checkpoint of an unfinished restore task from
+// the storage, it returns nil if there is no checkpoint.
+func LoadCheckpointRunner(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+) (*CheckpointRunner, error) {
+	r := &CheckpointRunner{ingested: make(map[string]struct{})}
+	runner, err := metautil.LoadCheckpointRunner(ctx, s, cipher, CheckpointMetaFile, checkpointFilesFileFormat, &r.meta,
+		func(unmarshal func(v interface{}) error) error {
+			var files []IngestedFile
+			if err := unmarshal(&files); err != nil {
+				return errors.Trace(err)
+			}
+			for _, f := range files {
+				r.ingested[f.key()] = struct{}{}
+			}
+			return nil
+		})
+	if err != nil || runner == nil {
+		return nil, errors.Trace(err)
+	}
+	r.runner = runner
+	log.Info("load restore checkpoint", zap.Int("ingested", len(r.ingested)))
+	return r, nil
+}
+
+// Meta returns the meta of the checkpoint.
+func (r *CheckpointRunner) Meta() CheckpointMeta {
+	return r.meta
+}
+
+// IsIngested checks whether the file of the backup has been restored in the
+// range starting at rangeStartKey before resuming.
+func (r *CheckpointRunner) IsIngested(backupTS uint64, rangeStartKey []byte, file *backuppb.File) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.ingested[IngestedFile{BackupTS: backupTS, RangeStartKey: rangeStartKey, Name: file.GetName()}.key()]
+	return ok
+}
+
+// Append records an ingested file, it's flushed to the storage later.
+func (r *CheckpointRunner) Append(backupTS uint64, rangeStartKey []byte, file *backuppb.File) {
+	if r == nil {
+		return
+	}
+	r.runner.Append(IngestedFile{BackupTS: backupTS, RangeStartKey: rangeStartKey, Name: file.GetName()})
+}
+
+// Flush writes the files ingested since the last flush to the storage.
+func (r *CheckpointRunner) Flush(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	return errors.Trace(r.runner.Flush(ctx))
+}
+
+// FlushPeriodically flushes the checkpoint every interval until ctx is done.
+func (r *CheckpointRunner) FlushPeriodically(ctx context.Context, interval time.Duration) {
+	if r == nil {
+		return
+	}
+	r.runner.FlushPeriodically(ctx, interval)
+}
+
+// Remove removes the checkpoint files from the storage after the restore
+// finishes, nothing is flushed after that.
+func (r *CheckpointRunner) Remove(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	return errors.Trace(r.runner.Remove(ctx))
+}
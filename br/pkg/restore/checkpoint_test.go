@@ -0,0 +1,63 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"testing"
+
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+func TestCheckpointRunner(t *testing.T) {
+	ctx := context.Background()
+	s, err := storage.NewLocalStorage(t.TempDir())
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+	meta := restore.CheckpointMeta{
+		ClusterID:  1,
+		BackupTSs:  []uint64{100, 200},
+		Ranges:     []*backuppb.RawRange{{StartKey: []byte("a"), EndKey: []byte("z"), Cf: "default"}},
+		TTLMode:    "absolute",
+		OnConflict: "fail",
+	}
+	file1 := &backuppb.File{Name: "1.sst"}
+	file2 := &backuppb.File{Name: "2.sst"}
+
+	runner, err := restore.NewCheckpointRunner(ctx, s, cipher, meta)
+	require.NoError(t, err)
+	runner.Append(100, []byte("a"), file1)
+	runner.Append(200, []byte("a"), file2)
+	require.NoError(t, runner.Flush(ctx))
+
+	loaded, err := restore.LoadCheckpointRunner(ctx, s, cipher)
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+	loadedMeta := loaded.Meta()
+	require.NoError(t, loadedMeta.Check(&meta))
+	require.True(t, loaded.IsIngested(100, []byte("a"), file1))
+	require.True(t, loaded.IsIngested(200, []byte("a"), file2))
+	// The same file of another backup or restored in another range.
+	require.False(t, loaded.IsIngested(200, []byte("a"), file1))
+	require.False(t, loaded.IsIngested(100, []byte("b"), file1))
+
+	other := meta
+	other.NewKeyPrefix = []byte("new")
+	require.True(t, berrors.ErrInvalidArgument.Equal(loadedMeta.Check(&other)))
+	other = meta
+	other.BackupTSs = []uint64{100}
+	require.True(t, berrors.ErrInvalidArgument.Equal(loadedMeta.Check(&other)))
+	other = meta
+	other.OnConflict = "overwrite"
+	require.True(t, berrors.ErrInvalidArgument.Equal(loadedMeta.Check(&other)))
+
+	require.NoError(t, loaded.Remove(ctx))
+	loaded, err = restore.LoadCheckpointRunner(ctx, s, cipher)
+	require.NoError(t, err)
+	require.Nil(t, loaded)
+}
@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
@@ -29,6 +30,7 @@ import (
 	"github.com/tikv/migration/br/pkg/metautil"
 	"github.com/tikv/migration/br/pkg/pdutil"
 	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/rtree"
 	"github.com/tikv/migration/br/pkg/storage"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
@@ -57,6 +59,7 @@ const defaultChecksumConcurrency = 64
 type Client struct {
 	pdClient      pd.Client
 	toolClient    SplitClient
+	importClient  ImporterClient
 	fileImporter  FileImporter
 	workerPool    *utils.WorkerPool
 	tlsConf       *tls.Config
@@ -81,6 +84,8 @@ type Client struct {
 	hasSpeedLimited bool
 
 	restoreStores []uint64
+	// restoreLabels are the labels of the restore stores.
+	restoreLabels []*metapb.StoreLabel
 
 	cipher             *backuppb.CipherInfo
 	storage            storage.ExternalStorage
@@ -88,6 +93,12 @@ type Client struct {
 	switchModeInterval time.Duration
 	switchCh           chan struct{}
 
+	// checkpoint records the ingested raw files, it's nil if checkpoint is disabled.
+	checkpoint *CheckpointRunner
+	// throttler adapts the restore to the pressure of the cluster, it's nil
+	// if the adaptive throttling is disabled.
+	throttler *Throttler
+
 	// statHandler and dom are used for analyze table after restore.
 	// it will backup stats with #dump.DumpStatsToJSON
 	// and restore stats with #dump.LoadStatsFromJSON
@@ -118,9 +129,11 @@ func NewRestoreClient(
 		statsHandle = dom.StatsHandle()
 	}
 
+	toolClient := NewSplitClient(pdClient, tlsConf)
 	return &Client{
 		pdClient:      pdClient,
-		toolClient:    NewSplitClient(pdClient, tlsConf),
+		toolClient:    toolClient,
+		importClient:  NewImportClient(toolClient, tlsConf, keepaliveConf),
 		db:            db,
 		tlsConf:       tlsConf,
 		keepaliveConf: keepaliveConf,
@@ -139,6 +152,32 @@ func (rc *Client) SetCrypter(crypter *backuppb.CipherInfo) {
 	rc.cipher = crypter
 }
 
+// SetCheckpointRunner sets the checkpoint runner recording the ingested raw files.
+func (rc *Client) SetCheckpointRunner(checkpoint *CheckpointRunner) {
+	rc.checkpoint = checkpoint
+}
+
+// SetThrottler sets the throttler limiting the concurrency of restoring raw files.
+func (rc *Client) SetThrottler(throttler *Throttler) {
+	rc.throttler = throttler
+}
+
+// SetDownloadSpeedLimit sets the download speed limit of all the TiKV stores
+// in bytes per second.
+func (rc *Client) SetDownloadSpeedLimit(ctx context.Context, rateLimit uint64) error {
+	stores, err := conn.GetAllTiKVStores(ctx, rc.pdClient, conn.SkipTiFlash)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req := &import_sstpb.SetDownloadSpeedLimitRequest{SpeedLimit: rateLimit}
+	for _, store := range stores {
+		if _, err = rc.importClient.SetDownloadSpeedLimit(ctx, store.GetId(), req); err != nil {
+			return errors.Annotatef(err, "failed to set download speed limit of store %d", store.GetId())
+		}
+	}
+	return nil
+}
+
 // SetStorage set ExternalStorage for client.
 func (rc *Client) SetStorage(ctx context.Context, backend *backuppb.StorageBackend, opts *storage.ExternalStorageOptions) error {
 	var err error
@@ -203,11 +242,13 @@ func (rc *Client) InitBackupMeta(
 		rc.ddlJobs = ddlJobs
 	}
 	rc.backupMeta = backupMeta
+	rc.storage = externalStorage
 	log.Info("load backupmeta", zap.Int("databases", len(rc.databases)), zap.Int("jobs", len(rc.ddlJobs)))
 
 	metaClient := NewSplitClient(rc.pdClient, rc.tlsConf)
 	importCli := NewImportClient(metaClient, rc.tlsConf, rc.keepaliveConf)
-	rc.fileImporter = NewFileImporter(metaClient, importCli, backend, rc.backupMeta.IsRawKv, rc.rateLimit)
+	rc.fileImporter = NewFileImporter(
+		metaClient, importCli, backend, rc.backupMeta.IsRawKv, rc.backupMeta.ApiVersion, rc.rateLimit)
 	return rc.fileImporter.CheckMultiIngestSupport(c, rc.pdClient)
 }
 
@@ -607,7 +648,7 @@ func (rc *Client) RestoreFiles(
 		elapsed := time.Since(start)
 		if err == nil {
 			log.Info("Restore files", zap.Duration("take", elapsed), logutil.Files(files))
-			summary.CollectSuccessUnit("files", len(files), elapsed)
+			summary.FromContext(ctx).CollectSuccessUnit("files", len(files), elapsed)
 		}
 	}()
 
@@ -642,7 +683,7 @@ func (rc *Client) RestoreFiles(
 	}
 
 	if err := eg.Wait(); err != nil {
-		summary.CollectFailureUnit("file", err)
+		summary.FromContext(ctx).CollectFailureUnit("file", err)
 		log.Error(
 			"restore files failed",
 			zap.Error(err),
@@ -653,8 +694,14 @@ func (rc *Client) RestoreFiles(
 }
 
 // RestoreRaw tries to restore raw keys in the specified range.
+// The keys are restored under a new prefix if a rewrite rule is given.
 func (rc *Client) RestoreRaw(
-	ctx context.Context, startKey []byte, endKey []byte, files []*backuppb.File, updateCh glue.Progress,
+	ctx context.Context,
+	startKey []byte,
+	endKey []byte,
+	files []*backuppb.File,
+	rewriteRules *RewriteRules,
+	updateCh glue.Progress,
 ) error {
 	start := time.Now()
 	defer func() {
@@ -678,7 +725,16 @@ func (rc *Client) RestoreRaw(
 		rc.workerPool.ApplyOnErrorGroup(eg,
 			func() error {
 				defer updateCh.Inc()
-				return rc.fileImporter.Import(ectx, []*backuppb.File{fileReplica}, EmptyRewriteRule(), rc.cipher)
+				if err := rc.throttler.Acquire(ectx); err != nil {
+					return errors.Trace(err)
+				}
+				defer rc.throttler.Release()
+				err := rc.fileImporter.Import(ectx, []*backuppb.File{fileReplica}, rewriteRules, rc.cipher)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				rc.checkpoint.Append(rc.backupMeta.GetEndVersion(), startKey, fileReplica)
+				return nil
 			})
 	}
 	if err := eg.Wait(); err != nil {
@@ -698,6 +754,216 @@ func (rc *Client) RestoreRaw(
 	return nil
 }
 
+// RawKVBatchClient writes raw kv pairs.
+type RawKVBatchClient interface {
+	BatchPut(ctx context.Context, keys, values [][]byte, ttls []uint64) error
+	// PutIfAbsent puts the key only if it doesn't exist, the check and the
+	// put are atomic. It returns whether the key is put.
+	PutIfAbsent(ctx context.Context, key, value []byte, ttl uint64) (bool, error)
+}
+
+// RawWriteOptions are how RestoreRawByWriting writes the keys.
+type RawWriteOptions struct {
+	// TTLEnabled means the values in the backup carry their expire timestamps.
+	TTLEnabled bool
+	// RemainingTTL makes the keys expire after their remaining TTL at backup
+	// time from now, otherwise the keys keep their original expiry.
+	RemainingTTL bool
+	// SkipExisting keeps the keys existing in the cluster instead of
+	// overwriting them, see RestoreRawByWriting for its cost and limit.
+	SkipExisting bool
+}
+
+// RestoreRawByWriting restores raw keys in the specified range by writing them
+// through the RawKV API instead of ingesting the files. The keys which have
+// expired are skipped.
+//
+// The keys existing in the cluster are kept if opts.SkipExisting is set, each
+// key is written only if it doesn't exist with a compare-and-swap of its own,
+// which is much slower than writing in batches. The compare-and-swap is only
+// atomic against the other compare-and-swap writers, a plain put of an online
+// client may still be overwritten unless the cluster is in the atomic mode.
+func (rc *Client) RestoreRawByWriting(
+	ctx context.Context,
+	startKey []byte,
+	endKey []byte,
+	files []*backuppb.File,
+	rewriteRules *RewriteRules,
+	rawClient RawKVBatchClient,
+	opts RawWriteOptions,
+	updateCh glue.Progress,
+) error {
+	start := time.Now()
+	var restoredKvs, expiredKvs, existingKvs uint64
+	defer func() {
+		log.Info("Restore Raw by writing",
+			logutil.Key("startKey", startKey),
+			logutil.Key("endKey", endKey),
+			zap.Uint64("restored kvs", atomic.LoadUint64(&restoredKvs)),
+			zap.Uint64("expired kvs", atomic.LoadUint64(&expiredKvs)),
+			zap.Uint64("existing kvs", atomic.LoadUint64(&existingKvs)),
+			zap.Duration("take", time.Since(start)))
+		if opts.SkipExisting {
+			summary.FromContext(ctx).CollectUInt("restore skipped existing kvs", atomic.LoadUint64(&existingKvs))
+		}
+	}()
+	// The expire timestamps of the keys are in seconds.
+	ttlBase := uint64(start.Unix())
+	if opts.RemainingTTL {
+		ttlBase = uint64(oracle.GetTimeFromTS(rc.backupMeta.GetEndVersion()).Unix())
+	}
+	var rule *import_sstpb.RewriteRule
+	if rewriteRules != nil && len(rewriteRules.Data) > 0 {
+		rule = matchOldPrefix(startKey, rewriteRules)
+	}
+
+	for _, file := range files {
+		if file.GetCf() != defaultCFName {
+			return errors.Annotatef(berrors.ErrUnsupportedOperation,
+				"only the default cf can be restored through the raw kv api, but file %s is of cf %s",
+				file.GetName(), file.GetCf())
+		}
+	}
+
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, file := range files {
+		fileReplica := file
+		rc.workerPool.ApplyOnErrorGroup(eg,
+			func() error {
+				defer updateCh.Inc()
+				if err := rc.throttler.Acquire(ectx); err != nil {
+					return errors.Trace(err)
+				}
+				defer rc.throttler.Release()
+				content, err := ReadRawSSTFile(ectx, rc.storage, fileReplica, rc.cipher)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				batch := newRawTTLBatch()
+				flush := func() error {
+					written, err := batch.flush(ectx, rawClient, opts.SkipExisting)
+					if err != nil {
+						return errors.Trace(err)
+					}
+					atomic.AddUint64(&restoredKvs, uint64(written))
+					atomic.AddUint64(&existingKvs, uint64(batch.len()-written))
+					batch.reset()
+					return nil
+				}
+				err = IterateRawSST(content, func(key, value []byte) error {
+					if bytes.Compare(key, startKey) < 0 || (len(endKey) > 0 && bytes.Compare(key, endKey) >= 0) {
+						return nil
+					}
+					var ttl uint64
+					if opts.TTLEnabled {
+						var expireTS uint64
+						if value, expireTS, err = DecodeRawTTLValue(value); err != nil {
+							return errors.Trace(err)
+						}
+						if expireTS > 0 {
+							if expireTS <= ttlBase {
+								atomic.AddUint64(&expiredKvs, 1)
+								return nil
+							}
+							ttl = expireTS - ttlBase
+						}
+					}
+					if rule != nil {
+						key = append(append([]byte{}, rule.GetNewKeyPrefix()...), key[len(rule.GetOldKeyPrefix()):]...)
+					}
+					batch.add(key, value, ttl)
+					if batch.len() < rawTTLBatchSize {
+						return nil
+					}
+					return flush()
+				})
+				if err != nil {
+					return errors.Annotatef(err, "failed to restore file %s", fileReplica.GetName())
+				}
+				if err = flush(); err != nil {
+					return errors.Trace(err)
+				}
+				rc.checkpoint.Append(rc.backupMeta.GetEndVersion(), startKey, fileReplica)
+				return nil
+			})
+	}
+	return errors.Trace(eg.Wait())
+}
+
+const (
+	rawTTLBatchSize = 512
+	// rawPutIfAbsentConcurrency is the number of the compare-and-swaps in
+	// flight for a batch when the existing keys are skipped.
+	rawPutIfAbsentConcurrency = 16
+)
+
+// rawTTLBatch is a batch of raw kv pairs with their TTLs.
+type rawTTLBatch struct {
+	keys   [][]byte
+	values [][]byte
+	ttls   []uint64
+}
+
+func newRawTTLBatch() *rawTTLBatch {
+	return &rawTTLBatch{
+		keys:   make([][]byte, 0, rawTTLBatchSize),
+		values: make([][]byte, 0, rawTTLBatchSize),
+		ttls:   make([]uint64, 0, rawTTLBatchSize),
+	}
+}
+
+func (b *rawTTLBatch) add(key, value []byte, ttl uint64) {
+	// The key and value are only valid during the iteration.
+	b.keys = append(b.keys, append([]byte{}, key...))
+	b.values = append(b.values, append([]byte{}, value...))
+	b.ttls = append(b.ttls, ttl)
+}
+
+func (b *rawTTLBatch) len() int {
+	return len(b.keys)
+}
+
+// flush writes the batch and returns the number of the keys written, the
+// keys existing in the cluster aren't written if skipExisting is set.
+func (b *rawTTLBatch) flush(ctx context.Context, rawClient RawKVBatchClient, skipExisting bool) (int, error) {
+	if b.len() == 0 {
+		return 0, nil
+	}
+	if !skipExisting {
+		if err := rawClient.BatchPut(ctx, b.keys, b.values, b.ttls); err != nil {
+			return 0, errors.Trace(err)
+		}
+		return b.len(), nil
+	}
+	// A key may be written between checking and putting it, so every key is
+	// put with its own compare-and-swap. The compare-and-swaps are sent
+	// concurrently, the round trips would dominate the restore otherwise.
+	var written int64
+	pool := utils.NewWorkerPool(rawPutIfAbsentConcurrency, "put if absent")
+	eg, ectx := errgroup.WithContext(ctx)
+	for i := range b.keys {
+		i := i
+		pool.ApplyOnErrorGroup(eg, func() error {
+			put, err := rawClient.PutIfAbsent(ectx, b.keys[i], b.values[i], b.ttls[i])
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if put {
+				atomic.AddInt64(&written, 1)
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return int(written), nil
+}
+
+func (b *rawTTLBatch) reset() {
+	b.keys, b.values, b.ttls = b.keys[:0], b.values[:0], b.ttls[:0]
+}
+
 // SwitchToImportMode switch tikv cluster to import mode.
 func (rc *Client) SwitchToImportMode(ctx context.Context) {
 	// tikv automatically switch to normal mode in every 10 minutes
@@ -825,7 +1091,7 @@ func (rc *Client) GoValidateChecksum(
 					start := time.Now()
 					defer func() {
 						elapsed := time.Since(start)
-						summary.CollectSuccessUnit("table checksum", 1, elapsed)
+						summary.FromContext(ctx).CollectSuccessUnit("table checksum", 1, elapsed)
 					}()
 					err := rc.execChecksum(ectx, tbl, kvClient, concurrency, loadStatCh)
 					if err != nil {
@@ -950,6 +1216,20 @@ const (
 	restoreLabelValue = "restore"
 )
 
+// SetRestoreStoreLabels sets the labels of the stores to restore data onto,
+// the stores with the exclusive restore label are used if it's not set.
+func (rc *Client) SetRestoreStoreLabels(labels []*metapb.StoreLabel) {
+	rc.restoreLabels = labels
+}
+
+// restoreStoreLabels returns the labels the restore stores must have.
+func (rc *Client) restoreStoreLabels() []*metapb.StoreLabel {
+	if len(rc.restoreLabels) > 0 {
+		return rc.restoreLabels
+	}
+	return []*metapb.StoreLabel{{Key: restoreLabelKey, Value: restoreLabelValue}}
+}
+
 // LoadRestoreStores loads the stores used to restore data.
 func (rc *Client) LoadRestoreStores(ctx context.Context) error {
 	if !rc.isOnline {
@@ -965,47 +1245,87 @@ func (rc *Client) LoadRestoreStores(ctx context.Context) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	labels := rc.restoreStoreLabels()
 	for _, s := range stores {
 		if s.GetState() != metapb.StoreState_Up {
 			continue
 		}
-		for _, l := range s.GetLabels() {
-			if l.GetKey() == restoreLabelKey && l.GetValue() == restoreLabelValue {
-				rc.restoreStores = append(rc.restoreStores, s.GetId())
-				break
-			}
+		if storeHasLabels(s, labels) {
+			rc.restoreStores = append(rc.restoreStores, s.GetId())
 		}
 	}
 	log.Info("load restore stores", zap.Uint64s("store-ids", rc.restoreStores))
+	if len(rc.restoreLabels) > 0 && len(rc.restoreStores) == 0 {
+		return errors.Annotatef(berrors.ErrRestoreNoStore, "no up store has the labels %s", formatStoreLabels(labels))
+	}
 	return nil
 }
 
-// ResetRestoreLabels removes the exclusive labels of the restore stores.
+// RestoreStores returns the stores loaded by LoadRestoreStores.
+func (rc *Client) RestoreStores() []uint64 {
+	return rc.restoreStores
+}
+
+func storeHasLabels(store *metapb.Store, labels []*metapb.StoreLabel) bool {
+NEXT_LABEL:
+	for _, label := range labels {
+		for _, l := range store.GetLabels() {
+			if l.GetKey() == label.GetKey() && l.GetValue() == label.GetValue() {
+				continue NEXT_LABEL
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func formatStoreLabels(labels []*metapb.StoreLabel) string {
+	labelStrs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		labelStrs = append(labelStrs, l.GetKey()+"="+l.GetValue())
+	}
+	return strings.Join(labelStrs, ",")
+}
+
+// ResetRestoreLabels removes the exclusive labels of the restore stores. The
+// labels given by SetRestoreStoreLabels are kept.
 func (rc *Client) ResetRestoreLabels(ctx context.Context) error {
-	if !rc.isOnline {
+	if !rc.isOnline || len(rc.restoreLabels) > 0 {
 		return nil
 	}
 	log.Info("start reseting store labels")
 	return rc.toolClient.SetStoresLabel(ctx, rc.restoreStores, restoreLabelKey, "")
 }
 
+// restorePlacementRule returns the rule placing the regions onto the restore
+// stores, the ID and the key range of the rule are left to fill in.
+func (rc *Client) restorePlacementRule(ctx context.Context) (placement.Rule, error) {
+	rule, err := rc.toolClient.GetPlacementRule(ctx, "pd", "default")
+	if err != nil {
+		return rule, errors.Trace(err)
+	}
+	rule.Index = 100
+	rule.Override = true
+	for _, l := range rc.restoreStoreLabels() {
+		rule.LabelConstraints = append(rule.LabelConstraints, placement.LabelConstraint{
+			Key:    l.GetKey(),
+			Op:     "in",
+			Values: []string{l.GetValue()},
+		})
+	}
+	return rule, nil
+}
+
 // SetupPlacementRules sets rules for the tables' regions.
 func (rc *Client) SetupPlacementRules(ctx context.Context, tables []*model.TableInfo) error {
 	if !rc.isOnline || len(rc.restoreStores) == 0 {
 		return nil
 	}
 	log.Info("start setting placement rules")
-	rule, err := rc.toolClient.GetPlacementRule(ctx, "pd", "default")
+	rule, err := rc.restorePlacementRule(ctx)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	rule.Index = 100
-	rule.Override = true
-	rule.LabelConstraints = append(rule.LabelConstraints, placement.LabelConstraint{
-		Key:    restoreLabelKey,
-		Op:     "in",
-		Values: []string{restoreLabelValue},
-	})
 	for _, t := range tables {
 		rule.ID = rc.getRuleID(t.ID)
 		rule.StartKeyHex = hex.EncodeToString(codec.EncodeBytes([]byte{}, tablecodec.EncodeTablePrefix(t.ID)))
@@ -1024,13 +1344,22 @@ func (rc *Client) WaitPlacementSchedule(ctx context.Context, tables []*model.Tab
 	if !rc.isOnline || len(rc.restoreStores) == 0 {
 		return nil
 	}
+	return rc.waitPlacementSchedule(ctx, func(ctx context.Context) (bool, string, error) {
+		return rc.checkRegions(ctx, tables)
+	})
+}
+
+func (rc *Client) waitPlacementSchedule(
+	ctx context.Context,
+	check func(ctx context.Context) (bool, string, error),
+) error {
 	log.Info("start waiting placement schedule")
 	ticker := time.NewTicker(time.Second * 10)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			ok, progress, err := rc.checkRegions(ctx, tables)
+			ok, progress, err := check(ctx)
 			if err != nil {
 				return errors.Trace(err)
 			}
@@ -1103,6 +1432,112 @@ func (rc *Client) getRuleID(tableID int64) string {
 	return "restore-t" + strconv.FormatInt(tableID, 10)
 }
 
+// SetupRawPlacementRules sets rules for the regions of the raw ranges, so the
+// restored regions are placed onto the restore stores.
+func (rc *Client) SetupRawPlacementRules(ctx context.Context, ranges []rtree.Range) error {
+	if !rc.isOnline || len(rc.restoreStores) == 0 {
+		return nil
+	}
+	log.Info("start setting raw placement rules")
+	rule, err := rc.restorePlacementRule(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i, rg := range MergeRawPlacementRanges(ranges) {
+		rule.ID = rc.getRawRuleID(i)
+		rule.StartKeyHex = hex.EncodeToString(encodeRawRegionKey(rg.StartKey))
+		rule.EndKeyHex = hex.EncodeToString(encodeRawRegionKey(rg.EndKey))
+		err = rc.toolClient.SetPlacementRule(ctx, rule)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("finish setting raw placement rules")
+	return nil
+}
+
+// WaitRawPlacementSchedule waits PD to move the regions of the raw ranges to
+// the restore stores.
+func (rc *Client) WaitRawPlacementSchedule(ctx context.Context, ranges []rtree.Range) error {
+	if !rc.isOnline || len(rc.restoreStores) == 0 {
+		return nil
+	}
+	merged := MergeRawPlacementRanges(ranges)
+	return rc.waitPlacementSchedule(ctx, func(ctx context.Context) (bool, string, error) {
+		for i, rg := range merged {
+			ok, regionProgress, err := rc.checkRange(ctx, encodeRawRegionKey(rg.StartKey), encodeRawRegionKey(rg.EndKey))
+			if err != nil {
+				return false, "", errors.Trace(err)
+			}
+			if !ok {
+				return false, fmt.Sprintf("range %v/%v, %s", i, len(merged), regionProgress), nil
+			}
+		}
+		return true, "", nil
+	})
+}
+
+// ResetRawPlacementRules removes the placement rules for the raw ranges, and
+// the regions are scheduled by PD as usual.
+func (rc *Client) ResetRawPlacementRules(ctx context.Context, ranges []rtree.Range) error {
+	if !rc.isOnline || len(rc.restoreStores) == 0 {
+		return nil
+	}
+	log.Info("start reseting raw placement rules")
+	var failedRules []string
+	for i := range MergeRawPlacementRanges(ranges) {
+		ruleID := rc.getRawRuleID(i)
+		err := rc.toolClient.DeletePlacementRule(ctx, "pd", ruleID)
+		if err != nil {
+			log.Info("failed to delete placement rule for raw range", zap.String("rule-id", ruleID))
+			failedRules = append(failedRules, ruleID)
+		}
+	}
+	if len(failedRules) > 0 {
+		return errors.Annotatef(berrors.ErrPDInvalidResponse, "failed to delete placement rules %v", failedRules)
+	}
+	return nil
+}
+
+func (rc *Client) getRawRuleID(i int) string {
+	return "restore-r" + strconv.Itoa(i)
+}
+
+// MergeRawPlacementRanges sorts the raw ranges and merges the overlapping
+// ones, since the overlapping rules would place more replicas onto the stores.
+func MergeRawPlacementRanges(ranges []rtree.Range) []rtree.Range {
+	sorted := append([]rtree.Range{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].StartKey, sorted[j].StartKey) < 0
+	})
+	merged := make([]rtree.Range, 0, len(sorted))
+	for _, rg := range sorted {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if len(last.EndKey) == 0 {
+				break
+			}
+			if bytes.Compare(rg.StartKey, last.EndKey) <= 0 {
+				if utils.CompareEndKey(rg.EndKey, last.EndKey) > 0 {
+					last.EndKey = rg.EndKey
+				}
+				continue
+			}
+		}
+		merged = append(merged, rg)
+	}
+	return merged
+}
+
+// encodeRawRegionKey encodes the raw key to the key of the regions, the empty
+// key is kept for the unbounded range.
+func encodeRawRegionKey(key []byte) []byte {
+	if len(key) == 0 {
+		return []byte{}
+	}
+	return codec.EncodeBytes([]byte{}, key)
+}
+
 // IsIncremental returns whether this backup is incremental.
 func (rc *Client) IsIncremental() bool {
 	return !(rc.backupMeta.StartVersion == rc.backupMeta.EndVersion ||
@@ -10,10 +10,12 @@ import (
 	"time"
 
 	"github.com/pingcap/kvproto/pkg/metapb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/gluetidb"
 	"github.com/tikv/migration/br/pkg/metautil"
 	"github.com/tikv/migration/br/pkg/mock"
 	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
 	"github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tidb/parser/mysql"
 	"github.com/pingcap/tidb/parser/types"
@@ -96,6 +98,54 @@ func TestIsOnline(t *testing.T) {
 	require.True(t, client.IsOnline())
 }
 
+func TestLoadRestoreStores(t *testing.T) {
+	m := mc
+	stores := []*metapb.Store{
+		{Id: 1, Labels: []*metapb.StoreLabel{{Key: "disk", Value: "ssd"}, {Key: "zone", Value: "z1"}}},
+		{Id: 2, Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z1"}}},
+		{Id: 3, Labels: []*metapb.StoreLabel{{Key: "disk", Value: "ssd"}, {Key: "zone", Value: "z1"}},
+			State: metapb.StoreState_Offline},
+		{Id: 4, Labels: []*metapb.StoreLabel{{Key: "exclusive", Value: "restore"}}},
+	}
+	ctx := context.Background()
+	labels := []*metapb.StoreLabel{{Key: "disk", Value: "ssd"}, {Key: "zone", Value: "z1"}}
+
+	// The stores aren't loaded for the offline restore.
+	client, err := restore.NewRestoreClient(gluetidb.New(), fakePDClient{stores: stores}, m.Storage, nil, defaultKeepaliveCfg)
+	require.NoError(t, err)
+	client.SetRestoreStoreLabels(labels)
+	require.NoError(t, client.LoadRestoreStores(ctx))
+
+	// The up stores with all the labels are used.
+	client.EnableOnline()
+	require.NoError(t, client.LoadRestoreStores(ctx))
+
+	// It fails if no store has the labels.
+	client, err = restore.NewRestoreClient(gluetidb.New(), fakePDClient{stores: stores}, m.Storage, nil, defaultKeepaliveCfg)
+	require.NoError(t, err)
+	client.EnableOnline()
+	client.SetRestoreStoreLabels([]*metapb.StoreLabel{{Key: "zone", Value: "z2"}})
+	err = client.LoadRestoreStores(ctx)
+	require.True(t, berrors.ErrRestoreNoStore.Equal(err))
+}
+
+func TestMergeRawPlacementRanges(t *testing.T) {
+	ranges := []rtree.Range{
+		{StartKey: []byte("e"), EndKey: []byte("g")},
+		{StartKey: []byte("a"), EndKey: []byte("c")},
+		{StartKey: []byte("b"), EndKey: []byte("d")},
+		{StartKey: []byte("f"), EndKey: []byte("f1")},
+		{StartKey: []byte("g"), EndKey: []byte("h")},
+		{StartKey: []byte("x"), EndKey: []byte("")},
+		{StartKey: []byte("y"), EndKey: []byte("z")},
+	}
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("d")},
+		{StartKey: []byte("e"), EndKey: []byte("h")},
+		{StartKey: []byte("x"), EndKey: []byte("")},
+	}, restore.MergeRawPlacementRanges(ranges))
+}
+
 func TestPreCheckTableClusterIndex(t *testing.T) {
 	m := mc
 	client, err := restore.NewRestoreClient(gluetidb.New(), m.PDClient, m.Storage, nil, defaultKeepaliveCfg)
@@ -21,6 +21,7 @@ import (
 	"github.com/tikv/migration/br/pkg/conn"
 	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/logutil"
+	"github.com/tikv/migration/br/pkg/rtree"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
 	pd "github.com/tikv/pd/client"
@@ -207,6 +208,10 @@ type FileImporter struct {
 	rawStartKey        []byte
 	rawEndKey          []byte
 	supportMultiIngest bool
+
+	// apiVersion is the api version of the backup, TiKV converts the raw kv
+	// pairs if it differs from the api version of the cluster.
+	apiVersion kvrpcpb.APIVersion
 }
 
 // NewFileImporter returns a new file importClient.
@@ -215,6 +220,7 @@ func NewFileImporter(
 	importClient ImporterClient,
 	backend *backuppb.StorageBackend,
 	isRawKvMode bool,
+	apiVersion kvrpcpb.APIVersion,
 	rateLimit uint64,
 ) FileImporter {
 	return FileImporter{
@@ -222,6 +228,7 @@ func NewFileImporter(
 		backend:      backend,
 		importClient: importClient,
 		isRawKvMode:  isRawKvMode,
+		apiVersion:   apiVersion,
 		rateLimit:    rateLimit,
 	}
 }
@@ -274,6 +281,12 @@ func (importer *FileImporter) Import(
 	if importer.isRawKvMode {
 		startKey = files[0].StartKey
 		endKey = files[0].EndKey
+		if rule := importer.rawRewriteRule(rewriteRules); rule != nil {
+			// Only the part of the file in the restoring range can be rewritten.
+			startKey, endKey, _ = (&rtree.Range{StartKey: startKey, EndKey: endKey}).Intersect(
+				importer.rawStartKey, importer.rawEndKey)
+			startKey, endKey = rewriteRawRange(startKey, endKey, rule)
+		}
 	} else {
 		for _, f := range files {
 			start, end, err := rewriteFileKeys(f, rewriteRules)
@@ -294,6 +307,10 @@ func (importer *FileImporter) Import(
 		logutil.Key("startKey", startKey),
 		logutil.Key("endKey", endKey))
 
+	var size uint64
+	for _, f := range files {
+		size += f.GetSize_()
+	}
 	err := utils.WithRetry(ctx, func() error {
 		tctx, cancel := context.WithTimeout(ctx, importScanRegionTime)
 		defer cancel()
@@ -317,7 +334,7 @@ func (importer *FileImporter) Import(
 				for i, f := range remainFiles {
 					var downloadMeta *import_sstpb.SSTMeta
 					if importer.isRawKvMode {
-						downloadMeta, e = importer.downloadRawKVSST(ctx, info, f, cipher)
+						downloadMeta, e = importer.downloadRawKVSST(ctx, info, f, rewriteRules, cipher)
 					} else {
 						downloadMeta, e = importer.downloadSST(ctx, info, f, rewriteRules, cipher)
 					}
@@ -431,11 +448,16 @@ func (importer *FileImporter) Import(
 					zap.Error(errIngest))
 				return errors.Trace(errIngest)
 			}
+			// The files are downloaded by all the peers of the region.
+			for _, peer := range info.Region.GetPeers() {
+				summary.FromContext(ctx).CollectStoreUnit(peer.GetStoreId(), len(files), size)
+			}
 		}
 		log.Debug("ingest file done", zap.String("file-sample", files[0].Name), zap.Stringer("take", time.Since(start)))
+		collector := summary.FromContext(ctx)
 		for _, f := range files {
-			summary.CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
-			summary.CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
+			collector.CollectSuccessUnit(summary.TotalKV, 1, f.TotalKvs)
+			collector.CollectSuccessUnit(summary.TotalBytes, 1, f.TotalBytes)
 		}
 
 		return nil
@@ -523,25 +545,42 @@ func (importer *FileImporter) downloadSST(
 	return &sstMeta, nil
 }
 
+// rawRewriteRule returns the rule rewriting the keys in the restoring range,
+// it returns nil if the keys needn't be rewritten.
+func (importer *FileImporter) rawRewriteRule(rewriteRules *RewriteRules) *import_sstpb.RewriteRule {
+	if rewriteRules == nil || len(rewriteRules.Data) == 0 {
+		return nil
+	}
+	return matchOldPrefix(importer.rawStartKey, rewriteRules)
+}
+
 func (importer *FileImporter) downloadRawKVSST(
 	ctx context.Context,
 	regionInfo *RegionInfo,
 	file *backuppb.File,
+	rewriteRules *RewriteRules,
 	cipher *backuppb.CipherInfo,
 ) (*import_sstpb.SSTMeta, error) {
 	uid := uuid.New()
 	id := uid[:]
-	// Empty rule
 	var rule import_sstpb.RewriteRule
+	if r := importer.rawRewriteRule(rewriteRules); r != nil {
+		rule = *r
+	}
 	sstMeta := GetSSTMetaFromFile(id, file, regionInfo.Region, &rule)
+	sstMeta.ApiVersion = importer.apiVersion
 
 	// Cut the SST file's range to fit in the restoring range.
-	if bytes.Compare(importer.rawStartKey, sstMeta.Range.GetStart()) > 0 {
-		sstMeta.Range.Start = importer.rawStartKey
+	rawStartKey, rawEndKey := importer.rawStartKey, importer.rawEndKey
+	if len(rule.GetOldKeyPrefix()) > 0 {
+		rawStartKey, rawEndKey = rewriteRawRange(rawStartKey, rawEndKey, &rule)
+	}
+	if bytes.Compare(rawStartKey, sstMeta.Range.GetStart()) > 0 {
+		sstMeta.Range.Start = rawStartKey
 	}
-	if len(importer.rawEndKey) > 0 &&
-		(len(sstMeta.Range.GetEnd()) == 0 || bytes.Compare(importer.rawEndKey, sstMeta.Range.GetEnd()) <= 0) {
-		sstMeta.Range.End = importer.rawEndKey
+	if len(rawEndKey) > 0 &&
+		(len(sstMeta.Range.GetEnd()) == 0 || bytes.Compare(rawEndKey, sstMeta.Range.GetEnd()) <= 0) {
+		sstMeta.Range.End = rawEndKey
 		sstMeta.EndKeyExclusive = true
 	}
 	if bytes.Compare(sstMeta.Range.GetStart(), sstMeta.Range.GetEnd()) > 0 {
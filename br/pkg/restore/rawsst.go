@@ -0,0 +1,256 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/tikv/migration/br/pkg/checksum"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/storage"
+)
+
+const (
+	// dataKeyPrefix is the prefix TiKV adds to the keys in the SST files.
+	dataKeyPrefix = 'z'
+	// rawTTLSuffixLen is the length of the expire timestamp appended to the
+	// raw values when TTL is enabled.
+	rawTTLSuffixLen = 8
+
+	// rawV2ValueHasExpireTS is the meta flag of the raw values in API V2,
+	// which have the expire timestamp before the flags.
+	rawV2ValueHasExpireTS = 1 << 0
+	// rawV2ValueIsDeleted is the meta flag of the deleted raw values in API V2.
+	rawV2ValueIsDeleted = 1 << 1
+)
+
+// ReadRawSSTFile reads the content of a raw backup file from the storage,
+// checks its sha256 and decrypts it.
+func ReadRawSSTFile(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	file *backuppb.File,
+	cipher *backuppb.CipherInfo,
+) ([]byte, error) {
+	content, err := s.ReadFile(ctx, file.GetName())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(file.GetSha256()) > 0 {
+		checksum := sha256.Sum256(content)
+		if !bytes.Equal(checksum[:], file.GetSha256()) {
+			return nil, errors.Annotatef(berrors.ErrBackupChecksumMismatch,
+				"sha256 of file %s mismatch, expect %x, got %x", file.GetName(), file.GetSha256(), checksum[:])
+		}
+	}
+	content, err = metautil.Decrypt(content, cipher, file.GetCipherIv())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return content, nil
+}
+
+// IterateRawSST calls fn on each raw kv pair in the SST file content in order.
+// The keys passed to fn are the raw keys without the data key prefix, and
+// they are only valid until fn returns.
+func IterateRawSST(content []byte, fn func(key, value []byte) error) error {
+	reader, err := sstable.NewReader(&memSSTFile{Reader: bytes.NewReader(content)}, sstable.ReaderOptions{})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer reader.Close()
+	iter, err := reader.NewIter(nil, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer iter.Close()
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		key := k.UserKey
+		if len(key) == 0 || key[0] != dataKeyPrefix {
+			return errors.Annotatef(berrors.ErrRestoreInvalidBackup, "invalid data key %s", redact.Key(key))
+		}
+		if err = fn(key[1:], v); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return errors.Trace(iter.Error())
+}
+
+// RewriteRawSST rewrites the SST file content with the compression, the kv
+// pairs are kept as they are.
+func RewriteRawSST(content []byte, compression backuppb.CompressionType) ([]byte, error) {
+	rewritten, _, err := rewriteRawSST(content, compression, nil)
+	return rewritten, errors.Trace(err)
+}
+
+// FilterExpiredRawSST removes the raw kv pairs which have expired at expireTS
+// in seconds from the SST file content of a backup taken with ttl enabled. It
+// returns the rewritten content, the checksum of the removed kv pairs, and the
+// number of the kv pairs kept. The content is nil if nothing is removed.
+func FilterExpiredRawSST(
+	content []byte,
+	compression backuppb.CompressionType,
+	expireTS uint64,
+) (filtered []byte, removed checksum.RawChecksum, kept uint64, err error) {
+	var decodeErr error
+	filtered, kept, err = rewriteRawSST(content, compression, func(key, value []byte) bool {
+		_, ts, err := DecodeRawTTLValue(value)
+		if err != nil {
+			decodeErr = err
+			return true
+		}
+		if ts > 0 && ts <= expireTS {
+			removed.Update(checksum.CalcRawKVChecksum(key, value))
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, removed, 0, errors.Trace(err)
+	}
+	if decodeErr != nil {
+		return nil, removed, 0, errors.Trace(decodeErr)
+	}
+	if removed.TotalKvs == 0 {
+		return nil, removed, kept, nil
+	}
+	return filtered, removed, kept, nil
+}
+
+// rewriteRawSST rewrites the SST file content with the compression, only the
+// kv pairs keep returns true for are kept if keep isn't nil. It returns the
+// new content and the number of the kv pairs kept.
+func rewriteRawSST(
+	content []byte,
+	compression backuppb.CompressionType,
+	keep func(key, value []byte) bool,
+) ([]byte, uint64, error) {
+	var opts sstable.WriterOptions
+	switch compression {
+	case backuppb.CompressionType_SNAPPY:
+		opts.Compression = sstable.SnappyCompression
+	case backuppb.CompressionType_ZSTD:
+		opts.Compression = sstable.ZstdCompression
+	default:
+		return nil, 0, errors.Annotatef(berrors.ErrInvalidArgument, "can't rewrite sst with compression %s", compression)
+	}
+	reader, err := sstable.NewReader(&memSSTFile{Reader: bytes.NewReader(content)}, sstable.ReaderOptions{})
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	defer reader.Close()
+	iter, err := reader.NewIter(nil, nil)
+	if err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	defer iter.Close()
+
+	out := &memSSTWriter{}
+	writer := sstable.NewWriter(out, opts)
+	var kept uint64
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if keep != nil {
+			key := k.UserKey
+			if len(key) == 0 || key[0] != dataKeyPrefix {
+				_ = writer.Close()
+				return nil, 0, errors.Annotatef(berrors.ErrRestoreInvalidBackup, "invalid data key %s", redact.Key(key))
+			}
+			if !keep(key[1:], v) {
+				continue
+			}
+		}
+		if err = writer.Add(*k, v); err != nil {
+			_ = writer.Close()
+			return nil, 0, errors.Trace(err)
+		}
+		kept++
+	}
+	if err = iter.Error(); err != nil {
+		_ = writer.Close()
+		return nil, 0, errors.Trace(err)
+	}
+	if err = writer.Close(); err != nil {
+		return nil, 0, errors.Trace(err)
+	}
+	return out.Bytes(), kept, nil
+}
+
+// DecodeRawTTLValue splits a raw value written with TTL enabled into the user
+// value and the expire timestamp in seconds, a zero timestamp means the key
+// never expires.
+func DecodeRawTTLValue(value []byte) (userValue []byte, expireTS uint64, err error) {
+	if len(value) < rawTTLSuffixLen {
+		return nil, 0, errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+			"the raw value with ttl is too short, length %d", len(value))
+	}
+	n := len(value) - rawTTLSuffixLen
+	return value[:n], binary.BigEndian.Uint64(value[n:]), nil
+}
+
+// DecodeRawV2Value decodes a raw value in API V2, which is the user value
+// followed by an optional expire timestamp and a byte of meta flags, into the
+// user value and the expire timestamp in seconds, a zero timestamp means the
+// key never expires.
+func DecodeRawV2Value(value []byte) (userValue []byte, expireTS uint64, deleted bool, err error) {
+	if len(value) == 0 {
+		return nil, 0, false, errors.Annotate(berrors.ErrRestoreInvalidBackup, "the raw value of API V2 is empty")
+	}
+	n := len(value) - 1
+	flags := value[n]
+	if flags&rawV2ValueHasExpireTS != 0 {
+		if n < rawTTLSuffixLen {
+			return nil, 0, false, errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+				"the raw value of API V2 with ttl is too short, length %d", len(value))
+		}
+		n -= rawTTLSuffixLen
+		expireTS = binary.BigEndian.Uint64(value[n:])
+	}
+	return value[:n], expireTS, flags&rawV2ValueIsDeleted != 0, nil
+}
+
+// memSSTFile is an in-memory SST file for the SST reader.
+type memSSTFile struct {
+	*bytes.Reader
+}
+
+func (f *memSSTFile) Close() error {
+	return nil
+}
+
+// memSSTWriter is an in-memory SST file for the SST writer.
+type memSSTWriter struct {
+	bytes.Buffer
+}
+
+func (w *memSSTWriter) Close() error {
+	return nil
+}
+
+func (w *memSSTWriter) Sync() error {
+	return nil
+}
+
+func (f *memSSTFile) Stat() (os.FileInfo, error) {
+	return memSSTFileInfo{size: f.Size()}, nil
+}
+
+type memSSTFileInfo struct {
+	size int64
+}
+
+func (i memSSTFileInfo) Name() string       { return "memory" }
+func (i memSSTFileInfo) Size() int64        { return i.size }
+func (i memSSTFileInfo) Mode() os.FileMode  { return 0 }
+func (i memSSTFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memSSTFileInfo) IsDir() bool        { return false }
+func (i memSSTFileInfo) Sys() interface{}   { return nil }
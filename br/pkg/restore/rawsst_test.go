@@ -0,0 +1,254 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/pebble/sstable"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/tikv/migration/br/pkg/checksum"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeRawTTLValue(value []byte, expireTS uint64) []byte {
+	suffix := make([]byte, 8)
+	binary.BigEndian.PutUint64(suffix, expireTS)
+	return append(append([]byte{}, value...), suffix...)
+}
+
+func writeRawSST(t *testing.T, dir, name string, kvs [][2][]byte) *backuppb.File {
+	f, err := os.Create(filepath.Join(dir, name))
+	require.NoError(t, err)
+	w := sstable.NewWriter(f, sstable.WriterOptions{})
+	for _, kv := range kvs {
+		require.NoError(t, w.Set(append([]byte{'z'}, kv[0]...), kv[1]))
+	}
+	require.NoError(t, w.Close())
+
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	checksum := sha256.Sum256(content)
+	return &backuppb.File{Name: name, Sha256: checksum[:], Cf: "default"}
+}
+
+func TestReadRawSSTFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+
+	kvs := [][2][]byte{
+		{[]byte("a"), encodeRawTTLValue([]byte("v1"), 0)},
+		{[]byte("b"), encodeRawTTLValue([]byte("v2"), 1000)},
+	}
+	file := writeRawSST(t, dir, "1.sst", kvs)
+	content, err := restore.ReadRawSSTFile(ctx, s, file, cipher)
+	require.NoError(t, err)
+
+	var (
+		keys      [][]byte
+		values    [][]byte
+		expireTSs []uint64
+	)
+	err = restore.IterateRawSST(content, func(key, value []byte) error {
+		userValue, expireTS, err := restore.DecodeRawTTLValue(value)
+		require.NoError(t, err)
+		keys = append(keys, append([]byte{}, key...))
+		values = append(values, append([]byte{}, userValue...))
+		expireTSs = append(expireTSs, expireTS)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{[]byte("a"), []byte("b")}, keys)
+	require.Equal(t, [][]byte{[]byte("v1"), []byte("v2")}, values)
+	require.Equal(t, []uint64{0, 1000}, expireTSs)
+
+	// The file is changed after backup.
+	file.Sha256 = make([]byte, sha256.Size)
+	_, err = restore.ReadRawSSTFile(ctx, s, file, cipher)
+	require.True(t, berrors.ErrBackupChecksumMismatch.Equal(err))
+
+	_, _, err = restore.DecodeRawTTLValue([]byte("short"))
+	require.True(t, berrors.ErrRestoreInvalidBackup.Equal(err))
+}
+
+func TestDecodeRawV2Value(t *testing.T) {
+	userValue, expireTS, deleted, err := restore.DecodeRawV2Value([]byte("v\x00"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v"), userValue)
+	require.Equal(t, uint64(0), expireTS)
+	require.False(t, deleted)
+
+	userValue, expireTS, deleted, err = restore.DecodeRawV2Value(append(encodeRawTTLValue([]byte("v"), 100), 1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("v"), userValue)
+	require.Equal(t, uint64(100), expireTS)
+	require.False(t, deleted)
+
+	_, _, deleted, err = restore.DecodeRawV2Value([]byte{2})
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	_, _, _, err = restore.DecodeRawV2Value([]byte("short\x01"))
+	require.True(t, berrors.ErrRestoreInvalidBackup.Equal(err))
+	_, _, _, err = restore.DecodeRawV2Value(nil)
+	require.True(t, berrors.ErrRestoreInvalidBackup.Equal(err))
+}
+
+func TestRewriteRawSST(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+	kvs := [][2][]byte{{[]byte("a"), []byte("v1")}, {[]byte("b"), []byte("v2")}}
+	content, err := restore.ReadRawSSTFile(ctx, s, writeRawSST(t, dir, "1.sst", kvs), cipher)
+	require.NoError(t, err)
+
+	for _, compression := range []backuppb.CompressionType{backuppb.CompressionType_ZSTD, backuppb.CompressionType_SNAPPY} {
+		rewritten, err := restore.RewriteRawSST(content, compression)
+		require.NoError(t, err)
+		var got [][2][]byte
+		require.NoError(t, restore.IterateRawSST(rewritten, func(key, value []byte) error {
+			got = append(got, [2][]byte{append([]byte{}, key...), append([]byte{}, value...)})
+			return nil
+		}))
+		require.Equal(t, kvs, got, compression.String())
+	}
+	_, err = restore.RewriteRawSST(content, backuppb.CompressionType_LZ4)
+	require.True(t, berrors.ErrInvalidArgument.Equal(err))
+}
+
+func TestFilterExpiredRawSST(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+	kvs := [][2][]byte{
+		{[]byte("a"), encodeRawTTLValue([]byte("v1"), 0)},
+		{[]byte("b"), encodeRawTTLValue([]byte("v2"), 100)},
+		{[]byte("c"), encodeRawTTLValue([]byte("v3"), 200)},
+	}
+	content, err := restore.ReadRawSSTFile(ctx, s, writeRawSST(t, dir, "1.sst", kvs), cipher)
+	require.NoError(t, err)
+
+	// Nothing has expired.
+	filtered, removed, kept, err := restore.FilterExpiredRawSST(content, backuppb.CompressionType_ZSTD, 99)
+	require.NoError(t, err)
+	require.Nil(t, filtered)
+	require.Equal(t, checksum.RawChecksum{}, removed)
+	require.Equal(t, uint64(3), kept)
+
+	filtered, removed, kept, err = restore.FilterExpiredRawSST(content, backuppb.CompressionType_ZSTD, 100)
+	require.NoError(t, err)
+	require.Equal(t, checksum.CalcRawKVChecksum(kvs[1][0], kvs[1][1]), removed)
+	require.Equal(t, uint64(2), kept)
+	var got [][2][]byte
+	require.NoError(t, restore.IterateRawSST(filtered, func(key, value []byte) error {
+		got = append(got, [2][]byte{append([]byte{}, key...), append([]byte{}, value...)})
+		return nil
+	}))
+	require.Equal(t, [][2][]byte{kvs[0], kvs[2]}, got)
+
+	_, removed, kept, err = restore.FilterExpiredRawSST(content, backuppb.CompressionType_SNAPPY, 300)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), removed.TotalKvs)
+	require.Equal(t, uint64(1), kept)
+}
+
+// fakeRawKVClient is a RawKVBatchClient keeping the keys in memory, every
+// PutIfAbsent takes a round trip of delay.
+type fakeRawKVClient struct {
+	mu          sync.Mutex
+	kvs         map[string][]byte
+	delay       time.Duration
+	inflight    int
+	maxInflight int
+}
+
+func (c *fakeRawKVClient) BatchPut(_ context.Context, keys, values [][]byte, _ []uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range keys {
+		c.kvs[string(keys[i])] = values[i]
+	}
+	return nil
+}
+
+func (c *fakeRawKVClient) PutIfAbsent(_ context.Context, key, value []byte, _ uint64) (bool, error) {
+	c.mu.Lock()
+	c.inflight++
+	if c.inflight > c.maxInflight {
+		c.maxInflight = c.inflight
+	}
+	c.mu.Unlock()
+	time.Sleep(c.delay)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	if _, ok := c.kvs[string(key)]; ok {
+		return false, nil
+	}
+	c.kvs[string(key)] = value
+	return true, nil
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Inc()   {}
+func (nopProgress) Close() {}
+
+func TestRestoreRawByWritingSkipExisting(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var kvs [][2][]byte
+	for i := 0; i < 1000; i++ {
+		kvs = append(kvs, [2][]byte{[]byte(fmt.Sprintf("k%04d", i)), []byte("new")})
+	}
+	file := writeRawSST(t, dir, "1.sst", kvs)
+
+	client := &restore.Client{}
+	client.SetConcurrency(1)
+	client.SetCrypter(&backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT})
+	backend, err := storage.ParseBackend(dir, nil)
+	require.NoError(t, err)
+	require.NoError(t, client.SetStorage(ctx, backend, &storage.ExternalStorageOptions{}))
+
+	// Every other key exists in the cluster.
+	rawClient := &fakeRawKVClient{kvs: make(map[string][]byte), delay: time.Millisecond}
+	for i := 0; i < 1000; i += 2 {
+		rawClient.kvs[fmt.Sprintf("k%04d", i)] = []byte("old")
+	}
+	start := time.Now()
+	err = client.RestoreRawByWriting(ctx, []byte("k"), []byte("l"), []*backuppb.File{file}, nil,
+		rawClient, restore.RawWriteOptions{SkipExisting: true}, nopProgress{})
+	require.NoError(t, err)
+
+	require.Len(t, rawClient.kvs, 1000)
+	for i := 0; i < 1000; i++ {
+		expected := "new"
+		if i%2 == 0 {
+			expected = "old"
+		}
+		require.Equal(t, expected, string(rawClient.kvs[fmt.Sprintf("k%04d", i)]))
+	}
+	// The compare-and-swaps are sent concurrently instead of one by one.
+	require.Greater(t, rawClient.maxInflight, 1)
+	require.Less(t, time.Since(start), 1000*rawClient.delay)
+}
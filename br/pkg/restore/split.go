@@ -185,6 +185,32 @@ SplitRegions:
 	return nil
 }
 
+// SplitKeys returns the keys the regions would be split by for the ranges,
+// grouped by region id, without splitting anything.
+func (rs *RegionSplitter) SplitKeys(
+	ctx context.Context,
+	ranges []rtree.Range,
+	rewriteRules *RewriteRules,
+) (map[uint64][][]byte, error) {
+	if len(ranges) == 0 {
+		return map[uint64][][]byte{}, nil
+	}
+	sortedRanges, err := SortRanges(ranges, rewriteRules)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	minKey := codec.EncodeBytes(sortedRanges[0].StartKey)
+	maxKey := []byte{}
+	if endKey := sortedRanges[len(sortedRanges)-1].EndKey; len(endKey) > 0 {
+		maxKey = codec.EncodeBytes(endKey)
+	}
+	regions, err := PaginateScanRegion(ctx, rs.client, minKey, maxKey, ScanRegionPaginationLimit)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return getSplitKeys(rewriteRules, sortedRanges, regions), nil
+}
+
 func (rs *RegionSplitter) hasRegion(ctx context.Context, regionID uint64) (bool, error) {
 	regionInfo, err := rs.client.GetRegionByID(ctx, regionID)
 	if err != nil {
@@ -552,3 +552,18 @@ func TestRegionConsistency(t *testing.T) {
 		require.Regexp(t, ca.err, err.Error())
 	}
 }
+
+func TestSplitKeys(t *testing.T) {
+	client := initTestClient()
+	regionSplitter := restore.NewRegionSplitter(client)
+
+	splitKeys, err := regionSplitter.SplitKeys(context.Background(), initRanges(), initRewriteRules())
+	require.NoError(t, err)
+	require.Equal(t, map[uint64][][]byte{
+		3: {[]byte("bbf")},
+		4: {[]byte("bbj")},
+		5: {[]byte("xxe"), []byte("xxz")},
+	}, splitKeys)
+	// Nothing is split.
+	require.Len(t, client.GetAllRegions(), 5)
+}
@@ -0,0 +1,236 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/log"
+	pdapi "github.com/tikv/pd/server/api"
+	"go.uber.org/zap"
+)
+
+// StoresInfoGetter gets the info of all the stores from PD.
+type StoresInfoGetter interface {
+	GetAllStoresInfo(ctx context.Context) (*pdapi.StoresInfo, error)
+}
+
+// ThrottleConfig is the configuration of adapting the restore to the pressure
+// of the cluster.
+type ThrottleConfig struct {
+	// Interval is the interval of checking the pressure of the cluster.
+	Interval time.Duration
+	// Concurrency and RateLimit are the limits when the cluster isn't under
+	// pressure. RateLimit is the download speed limit of each store in bytes
+	// per second, it isn't adjusted if it's 0.
+	Concurrency uint
+	RateLimit   uint64
+	// MaxSlowScore is the slow score of a store, which TiKV computes from the
+	// latency of the disk, from which the store is under pressure.
+	MaxSlowScore uint64
+	// MaxHeartbeatLag is the time since the last heartbeat of a store, from
+	// which the store is under pressure.
+	MaxHeartbeatLag time.Duration
+}
+
+// Throttler lowers the concurrency of restoring files and the download speed
+// limit of the stores when the cluster is under pressure, and ramps them back
+// up when the pressure is gone.
+type Throttler struct {
+	cfg          ThrottleConfig
+	stores       StoresInfoGetter
+	setRateLimit func(ctx context.Context, rateLimit uint64) error
+
+	mu          sync.Mutex
+	concurrency uint
+	running     uint
+	// released is closed when a file is done or the concurrency is raised, to
+	// wake up the files waiting to run.
+	released chan struct{}
+}
+
+// NewThrottler returns a throttler, setRateLimit sets the download speed
+// limit of all the stores.
+func NewThrottler(
+	cfg ThrottleConfig,
+	stores StoresInfoGetter,
+	setRateLimit func(ctx context.Context, rateLimit uint64) error,
+) *Throttler {
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = 1
+	}
+	return &Throttler{
+		cfg:          cfg,
+		stores:       stores,
+		setRateLimit: setRateLimit,
+		concurrency:  cfg.Concurrency,
+		released:     make(chan struct{}),
+	}
+}
+
+// Acquire blocks until a file can be restored under the current concurrency.
+// Release must be called after the file is restored.
+func (t *Throttler) Acquire(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	for {
+		t.mu.Lock()
+		if t.running < t.concurrency {
+			t.running++
+			t.mu.Unlock()
+			return nil
+		}
+		released := t.released
+		t.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		}
+	}
+}
+
+// Release releases the concurrency acquired by Acquire.
+func (t *Throttler) Release() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.running--
+	t.wakeUp()
+}
+
+// wakeUp wakes up the waiting files, the caller must hold mu.
+func (t *Throttler) wakeUp() {
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// Concurrency returns the current concurrency of restoring files.
+func (t *Throttler) Concurrency() uint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.concurrency
+}
+
+// RateLimit returns the current download speed limit of each store.
+func (t *Throttler) RateLimit() uint64 {
+	return t.rateLimitOf(t.Concurrency())
+}
+
+// rateLimitOf returns the download speed limit in proportion to the concurrency.
+func (t *Throttler) rateLimitOf(concurrency uint) uint64 {
+	return t.cfg.RateLimit * uint64(concurrency) / uint64(t.cfg.Concurrency)
+}
+
+// Run adjusts the limits periodically until the context is done, and then
+// sets the download speed limit back.
+func (t *Throttler) Run(ctx context.Context) {
+	if t.cfg.RateLimit > 0 {
+		if err := t.setRateLimit(ctx, t.cfg.RateLimit); err != nil {
+			log.Warn("failed to set download speed limit", zap.Error(err))
+		}
+	}
+	ticker := time.NewTicker(t.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if t.cfg.RateLimit > 0 && t.RateLimit() < t.cfg.RateLimit {
+				if err := t.setRateLimit(context.Background(), t.cfg.RateLimit); err != nil {
+					log.Warn("failed to reset download speed limit", zap.Error(err))
+				}
+			}
+			return
+		case <-ticker.C:
+			if err := t.Adjust(ctx); err != nil {
+				log.Warn("failed to adjust restore throttle", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Adjust checks the pressure of the cluster once. The limits are halved if
+// the cluster is under pressure, otherwise they are raised step by step.
+func (t *Throttler) Adjust(ctx context.Context) error {
+	info, err := t.stores.GetAllStoresInfo(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	reason := t.checkPressure(info, time.Now())
+
+	t.mu.Lock()
+	oldConcurrency := t.concurrency
+	if len(reason) > 0 {
+		t.concurrency /= 2
+		if t.concurrency == 0 {
+			t.concurrency = 1
+		}
+	} else if t.concurrency < t.cfg.Concurrency {
+		t.concurrency++
+		t.wakeUp()
+		reason = "the cluster isn't under pressure"
+	}
+	concurrency := t.concurrency
+	t.mu.Unlock()
+
+	if concurrency == oldConcurrency {
+		if len(reason) > 0 {
+			log.Debug("restore throttle is at the lowest", zap.String("reason", reason))
+		}
+		return nil
+	}
+	rateLimit := t.rateLimitOf(concurrency)
+	log.Info("adjust restore throttle",
+		zap.String("reason", reason),
+		zap.Uint("concurrency", concurrency),
+		zap.Uint("old-concurrency", oldConcurrency),
+		zap.Uint64("rate-limit", rateLimit),
+		zap.Uint64("old-rate-limit", t.rateLimitOf(oldConcurrency)))
+	if t.cfg.RateLimit == 0 {
+		return nil
+	}
+	return errors.Trace(t.setRateLimit(ctx, rateLimit))
+}
+
+// checkPressure returns the reason why the cluster is under pressure, or an
+// empty string if it isn't.
+func (t *Throttler) checkPressure(info *pdapi.StoresInfo, now time.Time) string {
+	for _, s := range info.Stores {
+		if s.Store == nil || s.Status == nil || s.Store.StateName != metapb.StoreState_Up.String() {
+			continue
+		}
+		if isTiFlashLabels(s.Store.Labels) {
+			continue
+		}
+		id := s.Store.StoreID
+		if s.Status.IsBusy {
+			return fmt.Sprintf("store %d is busy", id)
+		}
+		if t.cfg.MaxSlowScore > 0 && s.Status.SlowScore >= t.cfg.MaxSlowScore {
+			return fmt.Sprintf("store %d is slow with score %d", id, s.Status.SlowScore)
+		}
+		if t.cfg.MaxHeartbeatLag > 0 && s.Status.LastHeartbeatTS != nil {
+			if lag := now.Sub(*s.Status.LastHeartbeatTS); lag >= t.cfg.MaxHeartbeatLag {
+				return fmt.Sprintf("store %d hasn't sent heartbeat for %s", id, lag.Round(time.Second))
+			}
+		}
+	}
+	return ""
+}
+
+func isTiFlashLabels(labels []*metapb.StoreLabel) bool {
+	for _, l := range labels {
+		if l.GetKey() == "engine" && l.GetValue() == "tiflash" {
+			return true
+		}
+	}
+	return false
+}
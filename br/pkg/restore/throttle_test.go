@@ -0,0 +1,117 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package restore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/stretchr/testify/require"
+	pdapi "github.com/tikv/pd/server/api"
+)
+
+type fakeStoresInfo struct {
+	stores []*pdapi.StoreInfo
+}
+
+func (f *fakeStoresInfo) GetAllStoresInfo(context.Context) (*pdapi.StoresInfo, error) {
+	return &pdapi.StoresInfo{Count: len(f.stores), Stores: f.stores}, nil
+}
+
+func newFakeStoreInfo(id uint64, status pdapi.StoreStatus, labels ...*metapb.StoreLabel) *pdapi.StoreInfo {
+	return &pdapi.StoreInfo{
+		Store:  &pdapi.MetaStore{StoreID: id, StateName: metapb.StoreState_Up.String(), Labels: labels},
+		Status: &status,
+	}
+}
+
+func TestThrottlerAdjust(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	stores := &fakeStoresInfo{stores: []*pdapi.StoreInfo{
+		newFakeStoreInfo(1, pdapi.StoreStatus{SlowScore: 1, LastHeartbeatTS: &now}),
+		// TiFlash isn't restored to.
+		newFakeStoreInfo(2, pdapi.StoreStatus{IsBusy: true}, &metapb.StoreLabel{Key: "engine", Value: "tiflash"}),
+	}}
+	var rateLimits []uint64
+	throttler := restore.NewThrottler(restore.ThrottleConfig{
+		Interval:        time.Second,
+		Concurrency:     8,
+		RateLimit:       800,
+		MaxSlowScore:    80,
+		MaxHeartbeatLag: time.Minute,
+	}, stores, func(_ context.Context, rateLimit uint64) error {
+		rateLimits = append(rateLimits, rateLimit)
+		return nil
+	})
+
+	// Nothing changes without pressure.
+	require.NoError(t, throttler.Adjust(ctx))
+	require.Equal(t, uint(8), throttler.Concurrency())
+	require.Empty(t, rateLimits)
+
+	// The limits are halved under pressure.
+	stores.stores[0].Status.SlowScore = 90
+	require.NoError(t, throttler.Adjust(ctx))
+	require.Equal(t, uint(4), throttler.Concurrency())
+	require.Equal(t, uint64(400), throttler.RateLimit())
+	stores.stores[0].Status.SlowScore = 1
+	stores.stores[0].Status.IsBusy = true
+	require.NoError(t, throttler.Adjust(ctx))
+	stores.stores[0].Status.IsBusy = false
+	lastHeartbeat := now.Add(-2 * time.Minute)
+	stores.stores[0].Status.LastHeartbeatTS = &lastHeartbeat
+	require.NoError(t, throttler.Adjust(ctx))
+	require.NoError(t, throttler.Adjust(ctx))
+	require.Equal(t, uint(1), throttler.Concurrency())
+	require.Equal(t, []uint64{400, 200, 100}, rateLimits)
+
+	// The limits are raised step by step after the pressure is gone.
+	stores.stores[0].Status.LastHeartbeatTS = &now
+	require.NoError(t, throttler.Adjust(ctx))
+	require.NoError(t, throttler.Adjust(ctx))
+	require.Equal(t, uint(3), throttler.Concurrency())
+	require.Equal(t, []uint64{400, 200, 100, 200, 300}, rateLimits)
+}
+
+func TestThrottlerAcquire(t *testing.T) {
+	ctx := context.Background()
+	stores := &fakeStoresInfo{stores: []*pdapi.StoreInfo{
+		newFakeStoreInfo(1, pdapi.StoreStatus{IsBusy: true}),
+	}}
+	throttler := restore.NewThrottler(restore.ThrottleConfig{Interval: time.Second, Concurrency: 2}, stores,
+		func(context.Context, uint64) error {
+			require.FailNow(t, "the rate limit isn't set if it's unlimited")
+			return nil
+		})
+	require.NoError(t, throttler.Adjust(ctx))
+	require.Equal(t, uint(1), throttler.Concurrency())
+
+	require.NoError(t, throttler.Acquire(ctx))
+	acquired := make(chan struct{})
+	go func() {
+		require.NoError(t, throttler.Acquire(ctx))
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		require.FailNow(t, "acquired more than the concurrency")
+	case <-time.After(50 * time.Millisecond):
+	}
+	throttler.Release()
+	<-acquired
+	throttler.Release()
+
+	cctx, cancel := context.WithCancel(ctx)
+	require.NoError(t, throttler.Acquire(cctx))
+	cancel()
+	require.Error(t, throttler.Acquire(cctx))
+
+	// The nil throttler doesn't limit anything.
+	var nilThrottler *restore.Throttler
+	require.NoError(t, nilThrottler.Acquire(ctx))
+	nilThrottler.Release()
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/rtree"
 	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/parser/model"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/codec"
@@ -320,6 +321,44 @@ func rewriteRawKey(key []byte, rewriteRules *RewriteRules) ([]byte, *import_sstp
 	return nil, nil
 }
 
+// rewriteRawRange rewrites the raw key range [startKey, endKey) by the rule.
+// The range must be inside the old key prefix of the rule, so an exclusive end
+// key out of the old key prefix is rewritten to the end of the new key prefix.
+func rewriteRawRange(startKey, endKey []byte, rule *import_sstpb.RewriteRule) (newStartKey, newEndKey []byte) {
+	if rule == nil {
+		return startKey, endKey
+	}
+	rules := &RewriteRules{Data: []*import_sstpb.RewriteRule{rule}}
+	newStartKey, _ = replacePrefix(startKey, rules)
+	newEndKey, matched := replacePrefix(endKey, rules)
+	// The empty end key is the end of all the keys, which matches the empty
+	// old prefix but is the end of the new prefix after rewriting.
+	if matched == nil || len(endKey) == 0 {
+		newEndKey = kv.Key(rule.GetNewKeyPrefix()).PrefixNext()
+	}
+	return newStartKey, newEndKey
+}
+
+// RewriteRawRanges cuts the ranges to the restoring range [startKey, endKey)
+// and rewrites them by the rewrite rules, so that the regions can be split by
+// the keys in the new key space.
+func RewriteRawRanges(ranges []rtree.Range, startKey, endKey []byte, rewriteRules *RewriteRules) []rtree.Range {
+	if rewriteRules == nil || len(rewriteRules.Data) == 0 {
+		return ranges
+	}
+	rule := matchOldPrefix(startKey, rewriteRules)
+	newRanges := make([]rtree.Range, 0, len(ranges))
+	for _, rg := range ranges {
+		sk, ek, ok := rg.Intersect(startKey, endKey)
+		if !ok {
+			continue
+		}
+		sk, ek = rewriteRawRange(sk, ek, rule)
+		newRanges = append(newRanges, rtree.Range{StartKey: sk, EndKey: ek, Files: rg.Files})
+	}
+	return newRanges
+}
+
 func matchOldPrefix(key []byte, rewriteRules *RewriteRules) *import_sstpb.RewriteRule {
 	for _, rule := range rewriteRules.Data {
 		if bytes.HasPrefix(key, rule.GetOldKeyPrefix()) {
@@ -355,6 +394,19 @@ func SplitRanges(
 	})
 }
 
+// PlanSplitRanges returns the keys SplitRanges would split the regions by,
+// without splitting anything.
+func PlanSplitRanges(
+	ctx context.Context,
+	client *Client,
+	ranges []rtree.Range,
+	rewriteRules *RewriteRules,
+) (map[uint64][][]byte, error) {
+	splitter := NewRegionSplitter(NewSplitClient(client.GetPDClient(), client.GetTLSConfig()))
+	splitKeys, err := splitter.SplitKeys(ctx, ranges, rewriteRules)
+	return splitKeys, errors.Trace(err)
+}
+
 func findMatchedRewriteRule(file *backuppb.File, rules *RewriteRules) *import_sstpb.RewriteRule {
 	startID := tablecodec.DecodeTableID(file.GetStartKey())
 	endID := tablecodec.DecodeTableID(file.GetEndKey())
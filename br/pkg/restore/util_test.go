@@ -11,6 +11,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
 	"github.com/pingcap/tidb/tablecodec"
 	"github.com/pingcap/tidb/util/codec"
 	"github.com/stretchr/testify/require"
@@ -276,3 +277,41 @@ func TestPaginateScanRegion(t *testing.T) {
 	require.Regexp(t, ".*region endKey not equal to next region startKey.*", err.Error())
 
 }
+
+func TestRewriteRawRanges(t *testing.T) {
+	rules := &restore.RewriteRules{
+		Data: []*import_sstpb.RewriteRule{{OldKeyPrefix: []byte("a"), NewKeyPrefix: []byte("xy")}},
+	}
+	ranges := []rtree.Range{
+		{StartKey: []byte(""), EndKey: []byte("a2")},
+		{StartKey: []byte("a2"), EndKey: []byte("a5")},
+		{StartKey: []byte("a5"), EndKey: []byte("c")},
+		{StartKey: []byte("c"), EndKey: []byte("")},
+	}
+
+	// No rule, the ranges are returned as is.
+	require.Equal(t, ranges, restore.RewriteRawRanges(ranges, []byte("a"), []byte("b"), &restore.RewriteRules{}))
+
+	newRanges := restore.RewriteRawRanges(ranges, []byte("a"), []byte("b"), rules)
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("xy"), EndKey: []byte("xy2")},
+		{StartKey: []byte("xy2"), EndKey: []byte("xy5")},
+		{StartKey: []byte("xy5"), EndKey: []byte("xz")},
+	}, newRanges)
+
+	newRanges = restore.RewriteRawRanges(ranges, []byte("a3"), []byte("a4"), rules)
+	require.Equal(t, []rtree.Range{{StartKey: []byte("xy3"), EndKey: []byte("xy4")}}, newRanges)
+
+	// All the keys are moved under the new prefix with the empty old prefix,
+	// e.g. into an API V2 keyspace.
+	rules = &restore.RewriteRules{
+		Data: []*import_sstpb.RewriteRule{{OldKeyPrefix: []byte{}, NewKeyPrefix: []byte("r\x00\x00\x01")}},
+	}
+	newRanges = restore.RewriteRawRanges(ranges, []byte(""), []byte(""), rules)
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("r\x00\x00\x01"), EndKey: []byte("r\x00\x00\x01a2")},
+		{StartKey: []byte("r\x00\x00\x01a2"), EndKey: []byte("r\x00\x00\x01a5")},
+		{StartKey: []byte("r\x00\x00\x01a5"), EndKey: []byte("r\x00\x00\x01c")},
+		{StartKey: []byte("r\x00\x00\x01c"), EndKey: []byte("r\x00\x00\x02")},
+	}, newRanges)
+}
@@ -0,0 +1,197 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/rawkv"
+	"github.com/tikv/migration/br/pkg/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	jobsPath    = "/api/v1/jobs"
+	metricsPath = "/metrics"
+
+	// maxRequestSize is the max size of the body of a request.
+	maxRequestSize = 1 << 20
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler returns the handler of the HTTP API:
+//
+//	POST   /api/v1/jobs       submits a job, the body is a JobSpec.
+//	GET    /api/v1/jobs       lists the jobs.
+//	GET    /api/v1/jobs/{id}  gets the job.
+//	DELETE /api/v1/jobs/{id}  cancels the job.
+//	GET    /metrics           exposes the metrics for Prometheus.
+//
+// The credentials of the storages and the cipher keys aren't returned. If the
+// token is configured, the requests must carry it as "Authorization: Bearer
+// <token>".
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(jobsPath, s.handleJobs)
+	mux.HandleFunc(jobsPath+"/", s.handleJob)
+	mux.Handle(metricsPath, promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	if len(s.cfg.Token) == 0 {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+			log.Warn("rejected unauthorized request", zap.String("method", r.Method), zap.String("path", r.URL.Path),
+				zap.String("remote-addr", r.RemoteAddr))
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, &errorResponse{Error: "unauthorized"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jobs := s.Jobs()
+		for _, job := range jobs {
+			redactJob(job)
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var spec JobSpec
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&spec); err != nil {
+			writeError(w, errors.Annotatef(berrors.ErrInvalidArgument, "invalid job: %s", err))
+			return
+		}
+		job, err := s.Submit(spec)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		redactJob(job)
+		writeJSON(w, http.StatusCreated, job)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{Error: "method not allowed"})
+	}
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, jobsPath+"/"), 10, 64)
+	if err != nil {
+		writeError(w, errors.Annotatef(berrors.ErrServerJobNotFound, "invalid job id in %s", r.URL.Path))
+		return
+	}
+	var job *Job
+	switch r.Method {
+	case http.MethodGet:
+		job, err = s.Job(id)
+	case http.MethodDelete:
+		job, err = s.Cancel(id)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{Error: "method not allowed"})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	redactJob(job)
+	writeJSON(w, http.StatusOK, job)
+}
+
+// redactJob removes the credentials of the storages and the cipher key from
+// the copy of the job, it returns whether anything is removed.
+func redactJob(job *Job) bool {
+	removed := false
+	spec := &job.Spec
+	if spec.Backup != nil {
+		opts := *spec.Backup
+		removed = redactOptions(&opts.Options)
+		spec.Backup = &opts
+	}
+	if spec.Restore != nil {
+		opts := *spec.Restore
+		removed = redactOptions(&opts.Options)
+		opts.IncrementalStorages = make([]string, 0, len(spec.Restore.IncrementalStorages))
+		for _, s := range spec.Restore.IncrementalStorages {
+			redacted := storage.RedactURL(s)
+			removed = removed || redacted != s
+			opts.IncrementalStorages = append(opts.IncrementalStorages, redacted)
+		}
+		spec.Restore = &opts
+	}
+	// The storages of the runs are derived from the storage of the job.
+	for _, run := range job.Runs {
+		run.Storage = storage.RedactURL(run.Storage)
+	}
+	return removed
+}
+
+// redactOptions removes the credentials and the cipher key from the options.
+func redactOptions(opts *rawkv.Options) bool {
+	removed := false
+	if redacted := storage.RedactURL(opts.Storage); redacted != opts.Storage {
+		opts.Storage = redacted
+		removed = true
+	}
+	backend := &opts.BackendOptions
+	for _, secret := range []*string{
+		&backend.S3.AccessKey,
+		&backend.S3.SecretAccessKey,
+		&backend.Azblob.AccountKey,
+		&backend.SFTP.Password,
+		&backend.SFTP.PrivateKey,
+		&backend.WebDAV.Password,
+	} {
+		if len(*secret) > 0 {
+			*secret = ""
+			removed = true
+		}
+	}
+	if opts.Cipher != nil && len(opts.Cipher.CipherKey) > 0 {
+		opts.Cipher = &backuppb.CipherInfo{CipherType: opts.Cipher.CipherType}
+		removed = true
+	}
+	return removed
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("failed to write response", zap.Error(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case berrors.Is(err, berrors.ErrInvalidArgument):
+		status = http.StatusBadRequest
+	case berrors.Is(err, berrors.ErrServerJobNotFound):
+		status = http.StatusNotFound
+	case berrors.Is(err, berrors.ErrServerJobConflict):
+		status = http.StatusConflict
+	case berrors.Is(err, berrors.ErrUnsupportedOperation):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,358 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/rawkv"
+)
+
+// JobKind is what a job does.
+type JobKind string
+
+const (
+	// JobBackup backs up the raw kv.
+	JobBackup JobKind = "backup"
+	// JobRestore restores the raw kv.
+	JobRestore JobKind = "restore"
+)
+
+// JobState is the state of a job or a run of a job.
+type JobState string
+
+const (
+	// JobRunning means the job is running.
+	JobRunning JobState = "running"
+	// JobScheduled means the scheduled job is waiting for its next run.
+	JobScheduled JobState = "scheduled"
+	// JobSucceeded means the job has finished.
+	JobSucceeded JobState = "succeeded"
+	// JobFailed means the job has failed.
+	JobFailed JobState = "failed"
+	// JobCanceled means the job is canceled by the user.
+	JobCanceled JobState = "canceled"
+	// JobSkipped means the run of a scheduled job is skipped because another
+	// job is running on its ranges.
+	JobSkipped JobState = "skipped"
+)
+
+// RunType is the type of a run of a job.
+type RunType string
+
+const (
+	// RunFullBackup is a full backup.
+	RunFullBackup RunType = "full"
+	// RunIncrementalBackup is an incremental backup based on the last
+	// succeeded backup of the job.
+	RunIncrementalBackup RunType = "incremental"
+	// RunRestore is a restore.
+	RunRestore RunType = "restore"
+)
+
+// maxRuns is the number of the latest runs kept by a scheduled job.
+const maxRuns = 100
+
+// defaultPDPort is the port of a PD address without one.
+const defaultPDPort = "2379"
+
+// JobSpec is the job submitted by the user.
+type JobSpec struct {
+	Kind    JobKind               `json:"kind"`
+	Backup  *rawkv.BackupOptions  `json:"backup,omitempty"`
+	Restore *rawkv.RestoreOptions `json:"restore,omitempty"`
+	// FullSchedule makes the backup job run a full backup on the cron
+	// schedule, the job only runs once if it's empty. Each run of a
+	// scheduled job backs up into a new directory under the storage.
+	FullSchedule string `json:"full-schedule,omitempty"`
+	// IncrementalSchedule makes the scheduled backup job run an incremental
+	// backup based on the last succeeded backup on the cron schedule.
+	IncrementalSchedule string `json:"incremental-schedule,omitempty"`
+}
+
+// Run is a run of a job.
+type Run struct {
+	Type RunType `json:"type"`
+	// Storage is where the run backs up to or restores from.
+	Storage    string    `json:"storage"`
+	State      JobState  `json:"state"`
+	StartTime  time.Time `json:"start-time"`
+	FinishTime time.Time `json:"finish-time"`
+	// Progress is the latest progress of the running run.
+	Progress *rawkv.Progress `json:"progress,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Result   *rawkv.Result   `json:"result,omitempty"`
+}
+
+// Job is a job of the server.
+type Job struct {
+	ID         uint64    `json:"id"`
+	Spec       JobSpec   `json:"spec"`
+	State      JobState  `json:"state"`
+	CreateTime time.Time `json:"create-time"`
+	// NextRunTime and NextRunType are the next run of the scheduled job.
+	NextRunTime time.Time `json:"next-run-time"`
+	NextRunType RunType   `json:"next-run-type,omitempty"`
+	// LastBackupTS is the ts of the last succeeded backup of the job, the
+	// next incremental backup is based on it.
+	LastBackupTS uint64 `json:"last-backup-ts"`
+	// ClusterID is the id of the cluster of the job read from PD at submit,
+	// it's 0 if PD couldn't be reached.
+	ClusterID uint64 `json:"cluster-id,omitempty"`
+	Runs      []*Run `json:"runs"`
+	// SecretsRemoved means the credentials of the storages or the cipher key
+	// are removed from the saved job, they're never written to the data
+	// directory, so the job can't run again after the server restarts.
+	SecretsRemoved bool `json:"secrets-removed,omitempty"`
+
+	fullSchedule        *Schedule
+	incrementalSchedule *Schedule
+}
+
+// validate checks the spec and parses its schedules.
+func (job *Job) validate() error {
+	spec := &job.Spec
+	switch spec.Kind {
+	case JobBackup:
+		if spec.Backup == nil || spec.Restore != nil {
+			return errors.Annotate(berrors.ErrInvalidArgument, "backup job requires only the backup options")
+		}
+	case JobRestore:
+		if spec.Restore == nil || spec.Backup != nil {
+			return errors.Annotate(berrors.ErrInvalidArgument, "restore job requires only the restore options")
+		}
+		if len(spec.FullSchedule) > 0 || len(spec.IncrementalSchedule) > 0 {
+			return errors.Annotate(berrors.ErrInvalidArgument, "restore job can't be scheduled")
+		}
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "unknown job kind %q", spec.Kind)
+	}
+	if len(spec.IncrementalSchedule) > 0 && len(spec.FullSchedule) == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "incremental schedule requires the full schedule")
+	}
+	if len(spec.FullSchedule) > 0 {
+		if spec.Backup.LastBackupTS > 0 || spec.Backup.BackupTS > 0 {
+			return errors.Annotate(berrors.ErrInvalidArgument, "scheduled backup job decides the ts of each run")
+		}
+		var err error
+		if job.fullSchedule, err = ParseSchedule(spec.FullSchedule); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if len(spec.IncrementalSchedule) > 0 {
+		var err error
+		if job.incrementalSchedule, err = ParseSchedule(spec.IncrementalSchedule); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// clone copies the job, the spec and the results are shared as they never
+// change.
+func (job *Job) clone() *Job {
+	c := *job
+	c.Runs = make([]*Run, 0, len(job.Runs))
+	for _, run := range job.Runs {
+		r := *run
+		c.Runs = append(c.Runs, &r)
+	}
+	return &c
+}
+
+// scheduled returns whether the job runs on schedules.
+func (job *Job) scheduled() bool {
+	return job.fullSchedule != nil
+}
+
+// finished returns whether the job won't run any more.
+func (job *Job) finished() bool {
+	switch job.State {
+	case JobSucceeded, JobFailed, JobCanceled:
+		return true
+	}
+	return false
+}
+
+// options returns the options shared by backup and restore.
+func (job *Job) options() *rawkv.Options {
+	if job.Spec.Kind == JobBackup {
+		return &job.Spec.Backup.Options
+	}
+	return &job.Spec.Restore.Options
+}
+
+// scheduleNext sets the next run of the scheduled job after the time. A full
+// backup is run instead of an incremental one if there isn't any succeeded
+// backup to base on.
+func (job *Job) scheduleNext(after time.Time) {
+	job.NextRunTime = job.fullSchedule.Next(after)
+	job.NextRunType = RunFullBackup
+	if job.incrementalSchedule != nil && job.LastBackupTS > 0 {
+		next := job.incrementalSchedule.Next(after)
+		if !next.IsZero() && (job.NextRunTime.IsZero() || next.Before(job.NextRunTime)) {
+			job.NextRunTime = next
+			job.NextRunType = RunIncrementalBackup
+		}
+	}
+	if job.NextRunTime.IsZero() {
+		job.NextRunType = ""
+	}
+}
+
+// addRun records the run and drops the oldest runs beyond maxRuns.
+func (job *Job) addRun(run *Run) {
+	job.Runs = append(job.Runs, run)
+	if len(job.Runs) > maxRuns {
+		job.Runs = append([]*Run{}, job.Runs[len(job.Runs)-maxRuns:]...)
+	}
+}
+
+// runStorage returns the storage of a run of the scheduled job, which is a
+// new directory named by its type and start time under the storage.
+func runStorage(storage string, runType RunType, start time.Time) (string, error) {
+	u, err := url.Parse(storage)
+	if err != nil {
+		return "", errors.Annotatef(berrors.ErrInvalidArgument, "invalid storage %s", storage)
+	}
+	dir := string(runType) + "-" + start.UTC().Format("20060102T150405Z")
+	u.Path = path.Join(u.Path, dir)
+	return u.String(), nil
+}
+
+// sameCluster returns whether the jobs run on the same cluster. The jobs are
+// compared by their cluster ids, or by their PD addresses if any of the ids is
+// unknown.
+func sameCluster(a, b *Job) bool {
+	if a.ClusterID != 0 && b.ClusterID != 0 {
+		return a.ClusterID == b.ClusterID
+	}
+	for _, pa := range a.options().PD {
+		for _, pb := range b.options().PD {
+			if normalizePDAddr(pa) == normalizePDAddr(pb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizePDAddr makes the different forms of a PD address the same, e.g.
+// "http://PD:2379/" and "pd" are both "pd:2379".
+func normalizePDAddr(addr string) string {
+	addr = strings.ToLower(strings.TrimSpace(addr))
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	addr = strings.TrimRight(addr, "/")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), defaultPDPort)
+	}
+	return addr
+}
+
+// overlaps returns whether the jobs access the same keys of the same cf, the
+// jobs should run on the same cluster. Empty ranges mean all the keys, and
+// empty cfs mean the default cf.
+func overlaps(a, b *rawkv.Options) bool {
+	if !overlapCFs(a.CFs, b.CFs) {
+		return false
+	}
+	if len(a.Ranges) == 0 || len(b.Ranges) == 0 {
+		return true
+	}
+	for _, ra := range a.Ranges {
+		for _, rb := range b.Ranges {
+			if (len(rb.EndKey) == 0 || bytes.Compare(ra.StartKey, rb.EndKey) < 0) &&
+				(len(ra.EndKey) == 0 || bytes.Compare(rb.StartKey, ra.EndKey) < 0) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func overlapCFs(a, b []string) bool {
+	defaultCFs := []string{"default"}
+	if len(a) == 0 {
+		a = defaultCFs
+	}
+	if len(b) == 0 {
+		b = defaultCFs
+	}
+	for _, cfa := range a {
+		for _, cfb := range b {
+			if cfa == cfb {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jobFile is the file in the data directory keeping the jobs.
+const jobFile = "jobs.json"
+
+type jobsData struct {
+	NextID uint64 `json:"next-id"`
+	Jobs   []*Job `json:"jobs"`
+}
+
+// loadJobs reads the jobs from the data directory, no job is returned if the
+// file doesn't exist.
+func loadJobs(dataDir string) (*jobsData, error) {
+	data := &jobsData{NextID: 1}
+	content, err := os.ReadFile(filepath.Join(dataDir, jobFile))
+	if os.IsNotExist(err) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err = json.Unmarshal(content, data); err != nil {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "failed to read %s: %s", jobFile, err)
+	}
+	for _, job := range data.Jobs {
+		if err = job.validate(); err != nil {
+			return nil, errors.Annotatef(err, "invalid job %d", job.ID)
+		}
+	}
+	return data, nil
+}
+
+// saveJobs writes the jobs to the data directory without the credentials and
+// the cipher keys. The file is replaced by renaming so that it isn't broken if
+// the server exits while writing.
+func saveJobs(dataDir string, nextID uint64, jobs map[uint64]*Job) error {
+	data := &jobsData{NextID: nextID, Jobs: make([]*Job, 0, len(jobs))}
+	for _, job := range jobs {
+		saved := job.clone()
+		if redactJob(saved) {
+			saved.SecretsRemoved = true
+		}
+		data.Jobs = append(data.Jobs, saved)
+	}
+	sort.Slice(data.Jobs, func(i, j int) bool {
+		return data.Jobs[i].ID < data.Jobs[j].ID
+	})
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	tmpFile := filepath.Join(dataDir, jobFile+".tmp")
+	if err = os.WriteFile(tmpFile, content, 0o600); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmpFile, filepath.Join(dataDir, jobFile)))
+}
@@ -0,0 +1,58 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics are the metrics of a server, they're registered on the registry of
+// the server so that multiple servers can run in one process.
+type metrics struct {
+	jobRunHistogram      *prometheus.HistogramVec
+	jobRunFailureCounter *prometheus.CounterVec
+	runningJobGauge      prometheus.Gauge
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		jobRunHistogram: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: "tikv_br",
+				Subsystem: "server",
+				Name:      "job_run_duration_seconds",
+				Help:      "Bucketed histogram of the duration of the job runs.",
+				Buckets:   prometheus.ExponentialBuckets(1, 2, 18),
+			}, []string{"type", "state"}),
+
+		jobRunFailureCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "tikv_br",
+				Subsystem: "server",
+				Name:      "job_run_failures_total",
+				Help:      "Counter of the job runs failed or skipped.",
+			}, []string{"type", "state"}),
+
+		runningJobGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "tikv_br",
+				Subsystem: "server",
+				Name:      "running_jobs",
+				Help:      "Number of the running jobs.",
+			}),
+	}
+}
+
+// newRegistry returns a registry with the metrics of the server and the
+// process.
+func (m *metrics) newRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		m.jobRunHistogram,
+		m.jobRunFailureCounter,
+		m.runningJobGauge,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return registry
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+// maxScheduleYears is how far Next looks for the next time, a schedule never
+// matching in it, such as 0 0 30 2 *, has no next time.
+const maxScheduleYears = 5
+
+var scheduleDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// Schedule is a cron schedule. It has the 5 fields of crontab, which are the
+// minute, the hour, the day of month, the month and the day of week. A field
+// is a list of `*`, a number or a range such as 1-5, each can be followed by
+// a step such as */10. The descriptors @hourly, @daily, @weekly and @monthly
+// are also supported.
+type Schedule struct {
+	spec string
+
+	minute, hour, dom, month, dow uint64
+	// Like crontab, a day matches if either the day of month or the day of
+	// week matches when both of them are restricted.
+	domAny, dowAny bool
+}
+
+type scheduleField struct {
+	name     string
+	min, max int
+}
+
+var scheduleFields = []scheduleField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	// 7 is also Sunday.
+	{"day of week", 0, 7},
+}
+
+// ParseSchedule parses the cron schedule.
+func ParseSchedule(spec string) (*Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if descriptor, ok := scheduleDescriptors[expr]; ok {
+		expr = descriptor
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(scheduleFields) {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid schedule %q: expect %d fields", spec, len(scheduleFields))
+	}
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseScheduleField(field, scheduleFields[i]); err != nil {
+			return nil, errors.Annotatef(err, "invalid schedule %q", spec)
+		}
+	}
+	s := &Schedule{
+		spec:   spec,
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseScheduleField(field string, f scheduleField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			rng = item[:i]
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid step in %s %q", f.name, item)
+			}
+		}
+		start, end := f.min, f.max
+		if rng != "*" {
+			var err error
+			bounds := strings.SplitN(rng, "-", 2)
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid %s %q", f.name, item)
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid %s %q", f.name, item)
+				}
+			} else if step > 1 {
+				// 5/10 means from 5 to the max every 10.
+				end = f.max
+			}
+			if start < f.min || end > f.max || start > end {
+				return 0, errors.Annotatef(berrors.ErrInvalidArgument,
+					"%s %q out of range [%d, %d]", f.name, item, f.min, f.max)
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// String returns the spec of the schedule.
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time matching the schedule after t, it returns the
+// zero time if there isn't one.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxScheduleYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
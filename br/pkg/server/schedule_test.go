@@ -0,0 +1,48 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+func TestParseSchedule(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+		"* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@yearly"} {
+		_, err := ParseSchedule(spec)
+		require.True(t, berrors.ErrInvalidArgument.Equal(err), spec)
+	}
+
+	// Wednesday.
+	now := time.Date(2022, 3, 2, 10, 17, 30, 0, time.UTC)
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2022, 3, 2, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2022, 3, 2, 10, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2022, 3, 2, 10, 25, 0, 0, time.UTC)},
+		{"0,17 * * * *", time.Date(2022, 3, 2, 11, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2022, 3, 2, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2022, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * 1-5", time.Date(2022, 3, 3, 2, 30, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2022, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2022, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// Either the day of month or the day of week matches.
+		{"0 0 15 * 5", time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		s, err := ParseSchedule(c.spec)
+		require.NoError(t, err, c.spec)
+		require.Equal(t, c.next, s.Next(now), c.spec)
+		require.Equal(t, c.spec, s.String())
+	}
+}
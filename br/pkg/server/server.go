@@ -0,0 +1,405 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+// Package server runs the raw backup and restore jobs submitted by the HTTP
+// API. A backup job can run full and incremental backups on cron schedules.
+// The jobs are kept in the data directory, so the schedules survive the
+// restarts of the server. The credentials of the storages and the cipher keys
+// aren't kept, so the scheduled jobs with them fail after the restarts and
+// have to be submitted again.
+//
+// Only one job runs on a range of a cluster at a time. A job conflicting with
+// a running job is rejected, and a scheduled run is skipped.
+package server
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/rawkv"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Config is the configuration of the server.
+type Config struct {
+	// DataDir is the directory keeping the jobs.
+	DataDir string
+	// PD and TLS are used by the jobs which don't specify the cluster.
+	PD  []string
+	TLS rawkv.TLSOptions
+	// Token authenticates the requests of the HTTP API if it isn't empty.
+	Token string
+}
+
+// clusterIDTimeout is the timeout of reading the cluster id of a job from PD.
+const clusterIDTimeout = 10 * time.Second
+
+// Server runs the jobs.
+type Server struct {
+	cfg Config
+
+	backup  func(context.Context, *rawkv.BackupOptions) (*rawkv.Result, error)
+	restore func(context.Context, *rawkv.RestoreOptions) (*rawkv.Result, error)
+	// clusterID reads the id of the cluster of a job from PD.
+	clusterID func(context.Context, *rawkv.Options) (uint64, error)
+
+	metrics  *metrics
+	registry *prometheus.Registry
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	nextID uint64
+	jobs   map[uint64]*Job
+	// running are the cancel functions of the running jobs.
+	running map[uint64]context.CancelFunc
+	// timers start the next runs of the scheduled jobs.
+	timers map[uint64]*time.Timer
+}
+
+// New loads the jobs from the data directory and starts the schedules. The
+// jobs interrupted by the last exit of the server are marked failed.
+func New(ctx context.Context, cfg Config) (*Server, error) {
+	if err := os.MkdirAll(cfg.DataDir, 0o700); err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := loadJobs(cfg.DataDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s := &Server{
+		cfg:       cfg,
+		backup:    rawkv.Backup,
+		restore:   rawkv.Restore,
+		clusterID: rawkv.ClusterID,
+		nextID:    data.NextID,
+		jobs:      make(map[uint64]*Job, len(data.Jobs)),
+		running:   make(map[uint64]context.CancelFunc),
+		timers:    make(map[uint64]*time.Timer),
+		metrics:   newMetrics(),
+	}
+	s.registry = s.metrics.newRegistry()
+	s.ctx, s.cancel = context.WithCancel(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range data.Jobs {
+		s.jobs[job.ID] = job
+		if job.State == JobRunning {
+			log.Warn("job was interrupted", zap.Uint64("job", job.ID))
+			if len(job.Runs) > 0 {
+				if run := job.Runs[len(job.Runs)-1]; run.State == JobRunning {
+					run.State = JobFailed
+					run.Error = "interrupted by the exit of the server"
+				}
+			}
+			job.State = JobFailed
+			if job.scheduled() {
+				job.State = JobScheduled
+			}
+		}
+		if job.State == JobScheduled && job.SecretsRemoved {
+			log.Warn("job can't run without the secrets", zap.Uint64("job", job.ID))
+			now := time.Now()
+			job.addRun(&Run{Type: job.NextRunType, State: JobFailed, StartTime: now, FinishTime: now,
+				Error: "the credentials and the cipher key aren't kept after the server restarts, " +
+					"please submit the job again"})
+			job.State = JobFailed
+			job.NextRunTime = time.Time{}
+			job.NextRunType = ""
+		}
+		if job.State == JobScheduled {
+			s.scheduleLocked(job)
+		}
+	}
+	if err = s.saveLocked(); err != nil {
+		s.cancel()
+		s.stopTimersLocked()
+		return nil, errors.Trace(err)
+	}
+	log.Info("server loaded jobs", zap.Int("jobs", len(s.jobs)), zap.String("data-dir", cfg.DataDir))
+	return s, nil
+}
+
+// Close stops the schedules and cancels the running jobs.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.cancel()
+	s.stopTimersLocked()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) stopTimersLocked() {
+	for id, timer := range s.timers {
+		timer.Stop()
+		delete(s.timers, id)
+	}
+}
+
+// Submit adds the job, which starts at once unless it's scheduled.
+func (s *Server) Submit(spec JobSpec) (*Job, error) {
+	job := &Job{Spec: spec, CreateTime: time.Now()}
+	if err := job.validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	opts := job.options()
+	if len(opts.PD) == 0 {
+		opts.PD = s.cfg.PD
+		if opts.TLS == (rawkv.TLSOptions{}) {
+			opts.TLS = s.cfg.TLS
+		}
+	}
+	job.ClusterID = s.readClusterID(job)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return nil, errors.Annotate(berrors.ErrUnsupportedOperation, "server is closed")
+	}
+	job.ID = s.nextID
+	if job.scheduled() {
+		job.State = JobScheduled
+		s.scheduleLocked(job)
+	} else {
+		runType := RunRestore
+		if job.Spec.Kind == JobBackup {
+			runType = RunFullBackup
+			if job.Spec.Backup.LastBackupTS > 0 {
+				runType = RunIncrementalBackup
+			}
+		}
+		if err := s.startRunLocked(job, runType); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	s.nextID++
+	s.jobs[job.ID] = job
+	log.Info("job submitted", zap.Uint64("job", job.ID), zap.String("kind", string(job.Spec.Kind)),
+		zap.String("state", string(job.State)))
+	if err := s.saveLocked(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return job.clone(), nil
+}
+
+// readClusterID reads the id of the cluster of the job from PD, the jobs are
+// compared by their PD addresses if it fails.
+func (s *Server) readClusterID(job *Job) uint64 {
+	ctx, cancel := context.WithTimeout(s.ctx, clusterIDTimeout)
+	defer cancel()
+	id, err := s.clusterID(ctx, job.options())
+	if err != nil {
+		log.Warn("failed to read the cluster id from pd", zap.Strings("pd", job.options().PD), zap.Error(err))
+		return 0
+	}
+	return id
+}
+
+// Cancel cancels the running job and stops the schedules of the job.
+func (s *Server) Cancel(id uint64) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, errors.Annotatef(berrors.ErrServerJobNotFound, "job %d", id)
+	}
+	if job.finished() {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument, "job %d has been %s", id, job.State)
+	}
+	job.State = JobCanceled
+	job.NextRunTime = time.Time{}
+	job.NextRunType = ""
+	if timer, ok := s.timers[id]; ok {
+		timer.Stop()
+		delete(s.timers, id)
+	}
+	if cancel, ok := s.running[id]; ok {
+		cancel()
+	}
+	log.Info("job canceled", zap.Uint64("job", id))
+	if err := s.saveLocked(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return job.clone(), nil
+}
+
+// Job returns the job.
+func (s *Server) Job(id uint64) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, errors.Annotatef(berrors.ErrServerJobNotFound, "job %d", id)
+	}
+	return job.clone(), nil
+}
+
+// Jobs returns all the jobs in the order they were submitted.
+func (s *Server) Jobs() []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.clone())
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs
+}
+
+// scheduleLocked arms the timer of the next run of the scheduled job.
+func (s *Server) scheduleLocked(job *Job) {
+	job.scheduleNext(time.Now())
+	if job.NextRunTime.IsZero() {
+		job.State = JobSucceeded
+		log.Info("scheduled job has no more runs", zap.Uint64("job", job.ID))
+		return
+	}
+	if s.ctx.Err() != nil {
+		return
+	}
+	id := job.ID
+	s.timers[id] = time.AfterFunc(time.Until(job.NextRunTime), func() {
+		s.runScheduled(id)
+	})
+}
+
+// runScheduled starts the next run of the scheduled job, the run is skipped
+// if it conflicts with a running job.
+func (s *Server) runScheduled(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.State != JobScheduled || s.ctx.Err() != nil {
+		return
+	}
+	delete(s.timers, id)
+	runType := job.NextRunType
+	if err := s.startRunLocked(job, runType); err != nil {
+		log.Warn("skip the scheduled run", zap.Uint64("job", id), zap.String("type", string(runType)), zap.Error(err))
+		now := time.Now()
+		job.addRun(&Run{Type: runType, State: JobSkipped, StartTime: now, FinishTime: now, Error: err.Error()})
+		s.metrics.jobRunFailureCounter.WithLabelValues(string(runType), string(JobSkipped)).Inc()
+		s.scheduleLocked(job)
+	}
+	if err := s.saveLocked(); err != nil {
+		log.Warn("failed to save jobs", zap.Error(err))
+	}
+}
+
+// startRunLocked starts a run of the job unless it conflicts with a running
+// job.
+func (s *Server) startRunLocked(job *Job, runType RunType) error {
+	opts := job.options()
+	for id := range s.running {
+		if sameCluster(job, s.jobs[id]) && overlaps(opts, s.jobs[id].options()) {
+			return errors.Annotatef(berrors.ErrServerJobConflict, "job %d is running on the ranges", id)
+		}
+	}
+	run := &Run{Type: runType, Storage: opts.Storage, State: JobRunning, StartTime: time.Now()}
+	if job.scheduled() {
+		var err error
+		if run.Storage, err = runStorage(opts.Storage, runType, run.StartTime); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.running[job.ID] = cancel
+	job.State = JobRunning
+	job.NextRunTime = time.Time{}
+	job.NextRunType = ""
+	job.addRun(run)
+	s.metrics.runningJobGauge.Inc()
+	log.Info("job run started", zap.Uint64("job", job.ID), zap.String("type", string(runType)),
+		zap.String("storage", run.Storage))
+
+	lastBackupTS := job.LastBackupTS
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		result, err := s.execute(ctx, job, run, lastBackupTS)
+		s.finishRun(job, run, result, err)
+	}()
+	return nil
+}
+
+// execute runs the task of the run. The spec of the job never changes after
+// submitted, so it's read without the lock.
+func (s *Server) execute(ctx context.Context, job *Job, run *Run, lastBackupTS uint64) (*rawkv.Result, error) {
+	onProgress := func(p rawkv.Progress) {
+		s.mu.Lock()
+		run.Progress = &p
+		s.mu.Unlock()
+	}
+	if run.Type == RunRestore {
+		opts := *job.Spec.Restore
+		opts.OnProgress = onProgress
+		return s.restore(ctx, &opts)
+	}
+	opts := *job.Spec.Backup
+	opts.Storage = run.Storage
+	opts.OnProgress = onProgress
+	if job.scheduled() {
+		opts.LastBackupTS = 0
+		if run.Type == RunIncrementalBackup {
+			opts.LastBackupTS = lastBackupTS
+		}
+	}
+	return s.backup(ctx, &opts)
+}
+
+// finishRun records the result of the run and schedules the next run.
+func (s *Server) finishRun(job *Job, run *Run, result *rawkv.Result, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running[job.ID]()
+	delete(s.running, job.ID)
+	s.metrics.runningJobGauge.Dec()
+
+	run.FinishTime = time.Now()
+	run.Result = result
+	switch {
+	case err == nil:
+		run.State = JobSucceeded
+		if run.Type != RunRestore && result != nil {
+			job.LastBackupTS = result.BackupTS
+		}
+	case job.State == JobCanceled:
+		run.State = JobCanceled
+		run.Error = err.Error()
+	default:
+		run.State = JobFailed
+		run.Error = err.Error()
+		s.metrics.jobRunFailureCounter.WithLabelValues(string(run.Type), string(JobFailed)).Inc()
+	}
+	s.metrics.jobRunHistogram.WithLabelValues(string(run.Type), string(run.State)).
+		Observe(run.FinishTime.Sub(run.StartTime).Seconds())
+	log.Info("job run finished", zap.Uint64("job", job.ID), zap.String("type", string(run.Type)),
+		zap.String("state", string(run.State)), zap.Error(err))
+
+	if job.State != JobCanceled {
+		if job.scheduled() {
+			job.State = JobScheduled
+			s.scheduleLocked(job)
+		} else {
+			job.State = run.State
+		}
+	}
+	if err = s.saveLocked(); err != nil {
+		log.Warn("failed to save jobs", zap.Error(err))
+	}
+}
+
+func (s *Server) saveLocked() error {
+	return errors.Trace(saveJobs(s.cfg.DataDir, s.nextID, s.jobs))
+}
@@ -12,6 +12,8 @@ func DefineFlags(flags *pflag.FlagSet) {
 	defineS3Flags(flags)
 	defineGCSFlags(flags)
 	defineAzblobFlags(flags)
+	defineHDFSFlags(flags)
+	defineSFTPFlags(flags)
 }
 
 // ParseFromFlags obtains the backend options from the flag set.
@@ -25,5 +27,11 @@ func (options *BackendOptions) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err := options.Azblob.parseFromFlags(flags); err != nil {
 		return errors.Trace(err)
 	}
+	if err := options.HDFS.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if err := options.SFTP.parseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 	return nil
 }
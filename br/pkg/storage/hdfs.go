@@ -5,105 +5,289 @@ package storage
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"path"
+	"strconv"
+	"strings"
 
 	"github.com/pingcap/errors"
 	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/spf13/pflag"
 )
 
-// HDFSStorage represents HDFS storage.
+const (
+	hdfsWebHDFSAddressOption = "hdfs.webhdfs-address"
+	hdfsUserOption           = "hdfs.user"
+
+	// defaultWebHDFSPort is the default http port of the namenode since Hadoop 3.
+	defaultWebHDFSPort = "9870"
+	hdfsUserEnvVar     = "HADOOP_USER_NAME"
+	webHDFSPrefix      = "/webhdfs/v1"
+)
+
+// HDFSBackendOptions contains options for accessing HDFS by WebHDFS.
+//
+// These options are only used by BR, TiKV accesses HDFS by the remote url.
+type HDFSBackendOptions struct {
+	// WebHDFSAddress is the http address of the namenode, such as
+	// http://namenode:9870. The host of the remote url with the default port
+	// is used if it's empty.
+	WebHDFSAddress string `json:"webhdfs-address" toml:"webhdfs-address"`
+	// User is the user to access HDFS, HADOOP_USER_NAME is used if it's empty.
+	User string `json:"user" toml:"user"`
+}
+
+func defineHDFSFlags(flags *pflag.FlagSet) {
+	flags.String(hdfsWebHDFSAddressOption, "",
+		"(experimental) Set the WebHDFS address of the namenode, such as http://namenode:9870")
+	flags.String(hdfsUserOption, "", "Specify the user to access HDFS")
+}
+
+func (options *HDFSBackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	options.WebHDFSAddress, err = flags.GetString(hdfsWebHDFSAddressOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.User, err = flags.GetString(hdfsUserOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// HDFSStorage represents HDFS storage, which is accessed by WebHDFS.
 type HDFSStorage struct {
 	remote string
+	// base is the path of the remote url.
+	base    string
+	address string
+	user    string
+	cli     *http.Client
 }
 
-func NewHDFSStorage(remote string) *HDFSStorage {
-	return &HDFSStorage{
-		remote: remote,
+// NewHDFSStorage creates the HDFS storage of the remote url, such as
+// hdfs://namenode:8020/backup.
+func NewHDFSStorage(remote string, options *HDFSBackendOptions, opts *ExternalStorageOptions) (*HDFSStorage, error) {
+	u, err := url.Parse(remote)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "invalid hdfs url %s", remote)
+	}
+	if options == nil {
+		options = &HDFSBackendOptions{}
+	}
+	address := strings.TrimSuffix(options.WebHDFSAddress, "/")
+	if len(address) == 0 {
+		if len(u.Hostname()) == 0 {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+				"please specify the namenode in %s or --%s", remote, hdfsWebHDFSAddressOption)
+		}
+		address = "http://" + net.JoinHostPort(u.Hostname(), defaultWebHDFSPort)
+	}
+	user := options.User
+	if len(user) == 0 {
+		user = os.Getenv(hdfsUserEnvVar)
 	}
+	cli := http.DefaultClient
+	if opts != nil && opts.HTTPClient != nil {
+		cli = opts.HTTPClient
+	}
+	return &HDFSStorage{
+		remote:  remote,
+		base:    "/" + strings.Trim(u.Path, "/"),
+		address: address,
+		user:    user,
+		cli:     cli,
+	}, nil
 }
 
-func getHdfsBin() (string, error) {
-	hadoop_home, ok := os.LookupEnv("HADOOP_HOME")
-	if !ok {
-		return "", errors.Annotatef(berrors.ErrEnvNotSpecified, "please specify environment variable HADOOP_HOME")
-	}
-	return filepath.Join(hadoop_home, "bin/hdfs"), nil
+// webHDFSRemoteException is the error returned by WebHDFS.
+type webHDFSRemoteException struct {
+	RemoteException struct {
+		Exception string `json:"exception"`
+		Message   string `json:"message"`
+	} `json:"RemoteException"`
 }
 
-func getLinuxUser() (string, bool) {
-	return os.LookupEnv("HADOOP_LINUX_USER")
+type webHDFSFileStatus struct {
+	PathSuffix string `json:"pathSuffix"`
+	Type       string `json:"type"`
+	Length     int64  `json:"length"`
 }
 
-func dfsCommand(args ...string) (*exec.Cmd, error) {
-	bin, err := getHdfsBin()
-	if err != nil {
-		return nil, err
+// url returns the WebHDFS url of the operation on the file.
+func (s *HDFSStorage) url(name string, op string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
 	}
-	cmd := []string{}
-	user, ok := getLinuxUser()
-	if ok {
-		cmd = append(cmd, "sudo", "-u", user)
+	params.Set("op", op)
+	if len(s.user) > 0 {
+		params.Set("user.name", s.user)
 	}
-	cmd = append(cmd, bin, "dfs")
-	cmd = append(cmd, args...)
-	//nolint:gosec
-	return exec.Command(cmd[0], cmd[1:]...), nil
+	p := path.Join(s.base, name)
+	return s.address + webHDFSPrefix + (&url.URL{Path: p}).EscapedPath() + "?" + params.Encode()
 }
 
-// WriteFile writes a complete file to storage, similar to os.WriteFile
-func (s *HDFSStorage) WriteFile(ctx context.Context, name string, data []byte) error {
-	file_path := fmt.Sprintf("%s/%s", s.remote, name)
-	cmd, err := dfsCommand("-put", "-", file_path)
+// do sends the request, and returns the response if its status is expected.
+func (s *HDFSStorage) do(
+	ctx context.Context,
+	method, reqURL string,
+	body []byte,
+	expected ...int,
+) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, reader)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := s.cli.Do(req)
 	if err != nil {
-		return err
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown, "failed to %s %s: %s", method, s.remote, err)
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	var remoteErr webHDFSRemoteException
+	content, _ := io.ReadAll(resp.Body)
+	_ = json.Unmarshal(content, &remoteErr)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.Trace(&hdfsNotFoundError{message: remoteErr.RemoteException.Message})
+	}
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidPermission, "%s %s",
+			remoteErr.RemoteException.Exception, remoteErr.RemoteException.Message)
 	}
+	return nil, errors.Annotatef(berrors.ErrStorageUnknown, "webhdfs returns %s: %s %s",
+		resp.Status, remoteErr.RemoteException.Exception, remoteErr.RemoteException.Message)
+}
+
+// hdfsNotFoundError is the cause of the errors of the files not found.
+type hdfsNotFoundError struct {
+	message string
+}
 
-	buf := bytes.Buffer{}
-	buf.Write(data)
-	cmd.Stdin = &buf
+func (e *hdfsNotFoundError) Error() string {
+	return "hdfs file not found: " + e.message
+}
+
+func isHDFSNotFound(err error) bool {
+	_, ok := errors.Cause(err).(*hdfsNotFoundError)
+	return ok
+}
 
-	out, err := cmd.CombinedOutput()
+// write sends the data to the datanode redirected by the namenode, op is
+// either CREATE or APPEND.
+func (s *HDFSStorage) write(ctx context.Context, name, method, op string, params url.Values, data []byte) error {
+	// Don't follow the redirection, as the data should be sent to the datanode.
+	cli := *s.cli
+	cli.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.url(name, op, params), nil)
 	if err != nil {
-		return errors.Annotate(err, string(out))
+		return errors.Trace(err)
 	}
-	return nil
+	resp, err := cli.Do(req)
+	if err != nil {
+		return errors.Annotatef(berrors.ErrStorageUnknown, "failed to %s %s: %s", op, name, err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusTemporaryRedirect || len(location) == 0 {
+		return errors.Annotatef(berrors.ErrStorageUnknown, "webhdfs returns %s for %s %s", resp.Status, op, name)
+	}
+	resp, err = s.do(ctx, method, location, data, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return errors.Annotatef(err, "failed to %s %s", op, name)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return errors.Trace(resp.Body.Close())
+}
+
+// WriteFile writes a complete file to storage, similar to os.WriteFile
+func (s *HDFSStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	return s.write(ctx, name, http.MethodPut, "CREATE", url.Values{"overwrite": []string{"true"}}, data)
 }
 
 // ReadFile reads a complete file from storage, similar to os.ReadFile
 func (s *HDFSStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
-	return nil, errors.Annotatef(berrors.ErrUnsupportedOperation, "currently HDFS backend only support rawkv backup")
+	resp, err := s.do(ctx, http.MethodGet, s.url(name, "OPEN", nil), nil, http.StatusOK)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read %s", name)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return data, errors.Trace(err)
 }
 
-// FileExists return true if file exists
-func (s *HDFSStorage) FileExists(ctx context.Context, name string) (bool, error) {
-	file_path := fmt.Sprintf("%s/%s", s.remote, name)
-	cmd, err := dfsCommand("-ls", file_path)
+func (s *HDFSStorage) fileStatus(ctx context.Context, name string) (*webHDFSFileStatus, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.url(name, "GETFILESTATUS", nil), nil, http.StatusOK)
 	if err != nil {
-		return false, err
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	var status struct {
+		FileStatus webHDFSFileStatus `json:"FileStatus"`
 	}
-	out, err := cmd.CombinedOutput()
-	if _, ok := err.(*exec.ExitError); ok {
-		// Successfully exit with non-zero value
-		return false, nil
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown, "invalid file status of %s: %s", name, err)
 	}
+	return &status.FileStatus, nil
+}
+
+// FileExists return true if file exists
+func (s *HDFSStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.fileStatus(ctx, name)
 	if err != nil {
-		return false, errors.Annotate(err, string(out))
+		if isHDFSNotFound(err) {
+			return false, nil
+		}
+		return false, errors.Trace(err)
 	}
-	// Successfully exit with zero value
 	return true, nil
 }
 
 // DeleteFile delete the file in storage
 func (s *HDFSStorage) DeleteFile(ctx context.Context, name string) error {
-	return errors.Annotatef(berrors.ErrUnsupportedOperation, "currently HDFS backend only support rawkv backup")
+	resp, err := s.do(ctx, http.MethodDelete, s.url(name, "DELETE", nil), nil, http.StatusOK)
+	if err != nil {
+		return errors.Annotatef(err, "failed to delete %s", name)
+	}
+	defer resp.Body.Close()
+	var deleted struct {
+		Boolean bool `json:"boolean"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&deleted); err != nil {
+		return errors.Annotatef(berrors.ErrStorageUnknown, "invalid response of deleting %s: %s", name, err)
+	}
+	if !deleted.Boolean {
+		return errors.Annotatef(berrors.ErrStorageUnknown, "failed to delete %s", name)
+	}
+	return nil
 }
 
 // Open a Reader by file path. path is relative path to storage base path
 func (s *HDFSStorage) Open(ctx context.Context, path string) (ExternalFileReader, error) {
-	return nil, errors.Annotatef(berrors.ErrUnsupportedOperation, "currently HDFS backend only support rawkv backup")
+	status, err := s.fileStatus(ctx, path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to open %s", path)
+	}
+	return &hdfsFileReader{ctx: ctx, storage: s, name: path, size: status.Length}, nil
 }
 
 // WalkDir traverse all the files in a dir.
@@ -113,7 +297,43 @@ func (s *HDFSStorage) Open(ctx context.Context, path string) (ExternalFileReader
 // function; the argument `size` is the size in byte of the file determined
 // by path.
 func (s *HDFSStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(path string, size int64) error) error {
-	return errors.Annotatef(berrors.ErrUnsupportedOperation, "currently HDFS backend only support rawkv backup")
+	if opt == nil {
+		opt = &WalkOption{}
+	}
+	return s.walkDir(ctx, opt.SubDir, fn)
+}
+
+func (s *HDFSStorage) walkDir(ctx context.Context, dir string, fn func(path string, size int64) error) error {
+	resp, err := s.do(ctx, http.MethodGet, s.url(dir, "LISTSTATUS", nil), nil, http.StatusOK)
+	if err != nil {
+		if isHDFSNotFound(err) {
+			return nil
+		}
+		return errors.Annotatef(err, "failed to list %s", dir)
+	}
+	var list struct {
+		FileStatuses struct {
+			FileStatus []webHDFSFileStatus `json:"FileStatus"`
+		} `json:"FileStatuses"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		return errors.Annotatef(berrors.ErrStorageUnknown, "invalid file list of %s: %s", dir, err)
+	}
+	for _, status := range list.FileStatuses.FileStatus {
+		name := path.Join(dir, status.PathSuffix)
+		if status.Type == "DIRECTORY" {
+			if err = s.walkDir(ctx, name, fn); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		if err = fn(name, status.Length); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
 }
 
 // URI returns the base path as a URI
@@ -123,5 +343,86 @@ func (s *HDFSStorage) URI() string {
 
 // Create opens a file writer by path. path is relative path to storage base path
 func (s *HDFSStorage) Create(ctx context.Context, path string) (ExternalFileWriter, error) {
-	return nil, errors.Annotatef(berrors.ErrUnsupportedOperation, "currently HDFS backend only support rawkv backup")
+	if err := s.WriteFile(ctx, path, []byte{}); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &hdfsFileWriter{storage: s, name: path}, nil
+}
+
+// hdfsFileReader reads a file from the offset, the file is reopened after seeking.
+type hdfsFileReader struct {
+	ctx     context.Context
+	storage *HDFSStorage
+	name    string
+	size    int64
+	pos     int64
+	reader  io.ReadCloser
+}
+
+func (r *hdfsFileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.reader == nil {
+		params := url.Values{"offset": []string{strconv.FormatInt(r.pos, 10)}}
+		resp, err := r.storage.do(r.ctx, http.MethodGet, r.storage.url(r.name, "OPEN", params), nil, http.StatusOK)
+		if err != nil {
+			return 0, errors.Annotatef(err, "failed to read %s", r.name)
+		}
+		r.reader = resp.Body
+	}
+	n, err := r.reader.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *hdfsFileReader) Seek(offset int64, whence int) (int64, error) {
+	var realOffset int64
+	switch whence {
+	case io.SeekStart:
+		realOffset = offset
+	case io.SeekCurrent:
+		realOffset = r.pos + offset
+	case io.SeekEnd:
+		realOffset = r.size + offset
+	default:
+		return 0, errors.Annotatef(berrors.ErrStorageUnknown, "Seek: invalid whence '%d'", whence)
+	}
+	if realOffset < 0 {
+		return 0, errors.Annotatef(berrors.ErrStorageUnknown, "Seek in '%s': invalid offset to seek '%d'.", r.name, realOffset)
+	}
+	if realOffset != r.pos && r.reader != nil {
+		_ = r.reader.Close()
+		r.reader = nil
+	}
+	r.pos = realOffset
+	return r.pos, nil
+}
+
+func (r *hdfsFileReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return errors.Trace(r.reader.Close())
+}
+
+// hdfsFileWriter appends the data to the file, it should be wrapped by a
+// buffered writer to avoid too many small appends.
+type hdfsFileWriter struct {
+	storage *HDFSStorage
+	name    string
+}
+
+func (w *hdfsFileWriter) Write(ctx context.Context, p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.storage.write(ctx, w.name, http.MethodPost, "APPEND", nil, p); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return len(p), nil
+}
+
+func (w *hdfsFileWriter) Close(ctx context.Context) error {
+	return nil
 }
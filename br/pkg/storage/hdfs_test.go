@@ -0,0 +1,195 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// webHDFSStub is an in-memory namenode and datanode serving WebHDFS.
+type webHDFSStub struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	users map[string]struct{}
+	srv   *httptest.Server
+}
+
+func newWebHDFSStub() *webHDFSStub {
+	stub := &webHDFSStub{files: make(map[string][]byte), users: make(map[string]struct{})}
+	stub.srv = httptest.NewServer(http.HandlerFunc(stub.serve))
+	return stub
+}
+
+func (h *webHDFSStub) notFound(w http.ResponseWriter, p string) {
+	w.WriteHeader(http.StatusNotFound)
+	_, _ = fmt.Fprintf(w, `{"RemoteException":{"exception":"FileNotFoundException","message":"File does not exist: %s"}}`, p)
+}
+
+func (h *webHDFSStub) serve(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	q := r.URL.Query()
+	h.users[q.Get("user.name")] = struct{}{}
+	if strings.HasPrefix(r.URL.Path, "/datanode") {
+		p := strings.TrimPrefix(r.URL.Path, "/datanode")
+		data, _ := io.ReadAll(r.Body)
+		if q.Get("op") == "APPEND" {
+			h.files[p] = append(h.files[p], data...)
+			return
+		}
+		h.files[p] = data
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, webHDFSPrefix)
+	switch q.Get("op") {
+	case "CREATE", "APPEND":
+		w.Header().Set("Location", h.srv.URL+"/datanode"+p+"?"+r.URL.RawQuery)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	case "OPEN":
+		data, ok := h.files[p]
+		if !ok {
+			h.notFound(w, p)
+			return
+		}
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		_, _ = w.Write(data[offset:])
+	case "GETFILESTATUS":
+		data, ok := h.files[p]
+		if !ok {
+			h.notFound(w, p)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"FileStatus":{"pathSuffix":"","type":"FILE","length":%d}}`, len(data))
+	case "DELETE":
+		_, ok := h.files[p]
+		delete(h.files, p)
+		_, _ = fmt.Fprintf(w, `{"boolean":%v}`, ok)
+	case "LISTSTATUS":
+		children := make(map[string]webHDFSFileStatus)
+		for name, data := range h.files {
+			if !strings.HasPrefix(name, p+"/") {
+				continue
+			}
+			rest := strings.TrimPrefix(name, p+"/")
+			if i := strings.Index(rest, "/"); i >= 0 {
+				children[rest[:i]] = webHDFSFileStatus{PathSuffix: rest[:i], Type: "DIRECTORY"}
+			} else {
+				children[rest] = webHDFSFileStatus{PathSuffix: rest, Type: "FILE", Length: int64(len(data))}
+			}
+		}
+		if len(children) == 0 {
+			h.notFound(w, p)
+			return
+		}
+		var list struct {
+			FileStatuses struct {
+				FileStatus []webHDFSFileStatus `json:"FileStatus"`
+			} `json:"FileStatuses"`
+		}
+		for _, status := range children {
+			list.FileStatuses.FileStatus = append(list.FileStatuses.FileStatus, status)
+		}
+		_ = json.NewEncoder(w).Encode(&list)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestHDFSStorage(t *testing.T) {
+	ctx := context.Background()
+	stub := newWebHDFSStub()
+	defer stub.srv.Close()
+
+	backend, err := ParseBackend("hdfs://127.0.0.1:8020/backup", nil)
+	require.NoError(t, err)
+	s, err := New(ctx, backend, &ExternalStorageOptions{
+		HTTPClient: stub.srv.Client(),
+		HDFS:       HDFSBackendOptions{WebHDFSAddress: stub.srv.URL, User: "br"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hdfs://127.0.0.1:8020/backup", s.URI())
+
+	exists, err := s.FileExists(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, s.WriteFile(ctx, "backupmeta", []byte("meta")))
+	require.NoError(t, s.WriteFile(ctx, "1/a.sst", []byte("0123456789")))
+	exists, err = s.FileExists(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.True(t, exists)
+	data, err := s.ReadFile(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.Equal(t, []byte("meta"), data)
+	_, err = s.ReadFile(ctx, "not-exist")
+	require.Error(t, err)
+	require.Contains(t, stub.users, "br")
+
+	r, err := s.Open(ctx, "1/a.sst")
+	require.NoError(t, err)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, "012", string(buf))
+	pos, err := r.Seek(-2, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), pos)
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "89", string(rest))
+	_, err = r.Seek(5, io.SeekStart)
+	require.NoError(t, err)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, "567", string(buf))
+	require.NoError(t, r.Close())
+
+	w, err := s.Create(ctx, "1/b.csv")
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("a,"))
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close(ctx))
+
+	files := make([]string, 0)
+	require.NoError(t, s.WalkDir(ctx, &WalkOption{}, func(path string, size int64) error {
+		files = append(files, fmt.Sprintf("%s:%d", path, size))
+		return nil
+	}))
+	sort.Strings(files)
+	require.Equal(t, []string{"1/a.sst:10", "1/b.csv:3", "backupmeta:4"}, files)
+
+	require.NoError(t, s.DeleteFile(ctx, "1/a.sst"))
+	require.Error(t, s.DeleteFile(ctx, "1/a.sst"))
+	files = files[:0]
+	require.NoError(t, s.WalkDir(ctx, &WalkOption{SubDir: "1"}, func(path string, size int64) error {
+		files = append(files, path)
+		return nil
+	}))
+	require.Equal(t, []string{"1/b.csv"}, files)
+}
+
+func TestHDFSWebHDFSAddress(t *testing.T) {
+	s, err := NewHDFSStorage("hdfs://namenode:8020/backup/", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "http://namenode:9870/webhdfs/v1/backup/a%20b?op=OPEN", s.url("a b", "OPEN", nil))
+
+	_, err = NewHDFSStorage("hdfs:///backup", nil, nil)
+	require.Error(t, err)
+	s, err = NewHDFSStorage("hdfs:///backup", &HDFSBackendOptions{WebHDFSAddress: "https://nn:9871/"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://nn:9871/webhdfs/v1/backup?op=LISTSTATUS", s.url("", "LISTSTATUS", nil))
+}
@@ -20,6 +20,9 @@ type BackendOptions struct {
 	S3     S3BackendOptions     `json:"s3" toml:"s3"`
 	GCS    GCSBackendOptions    `json:"gcs" toml:"gcs"`
 	Azblob AzblobBackendOptions `json:"azblob" toml:"azblob"`
+	HDFS   HDFSBackendOptions   `json:"hdfs" toml:"hdfs"`
+	SFTP   SFTPBackendOptions   `json:"sftp" toml:"sftp"`
+	WebDAV WebDAVBackendOptions `json:"webdav" toml:"webdav"`
 }
 
 // ParseRawURL parse raw url to url object.
@@ -110,6 +113,51 @@ func ParseBackend(rawURL string, options *BackendOptions) (*backuppb.StorageBack
 			return nil, errors.Trace(err)
 		}
 		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_AzureBlobStorage{AzureBlobStorage: azblob}}, nil
+
+	case "sftp":
+		if u.Host == "" {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "please specify the host for sftp in %s", rawURL)
+		}
+		if options == nil {
+			options = &BackendOptions{}
+		}
+		ExtractQueryParameters(u, &options.SFTP)
+		if password, ok := u.User.Password(); ok {
+			options.SFTP.Password = password
+		}
+		// The path is relative to the home directory unless it starts with "//".
+		prefix := strings.TrimSuffix(strings.TrimPrefix(u.Path, "/"), "/")
+		sftp := &backuppb.CloudDynamic{
+			Bucket:       &backuppb.Bucket{Endpoint: u.Host, Prefix: prefix},
+			ProviderName: sftpProviderName,
+		}
+		options.SFTP.apply(sftp, u.User.Username())
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_CloudDynamic{CloudDynamic: sftp}}, nil
+
+	case "webdav", "webdavs":
+		if u.Host == "" {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "please specify the host for webdav in %s", rawURL)
+		}
+		if options == nil {
+			options = &BackendOptions{}
+		}
+		ExtractQueryParameters(u, &options.WebDAV)
+		if u.User != nil {
+			options.WebDAV.User = u.User.Username()
+			if password, ok := u.User.Password(); ok {
+				options.WebDAV.Password = password
+			}
+		}
+		endpoint := "http://" + u.Host
+		if u.Scheme == "webdavs" {
+			endpoint = "https://" + u.Host
+		}
+		webdav := &backuppb.CloudDynamic{
+			Bucket:       &backuppb.Bucket{Endpoint: endpoint, Prefix: strings.Trim(u.Path, "/")},
+			ProviderName: webDAVProviderName,
+		}
+		options.WebDAV.apply(webdav)
+		return &backuppb.StorageBackend{Backend: &backuppb.StorageBackend_CloudDynamic{CloudDynamic: webdav}}, nil
 	default:
 		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %s not support yet", u.Scheme)
 	}
@@ -166,6 +214,46 @@ func ExtractQueryParameters(u *url.URL, options interface{}) {
 	u.RawQuery = ""
 }
 
+// secretQueryParameters are the query parameters of the storage URL carrying
+// the credentials, which are the json tags of the secret backend options.
+var secretQueryParameters = map[string]struct{}{
+	"access-key":        {},
+	"secret-access-key": {},
+	"account-key":       {},
+	"password":          {},
+	"private-key":       {},
+}
+
+// redactedSecret replaces the credentials in the redacted URL.
+const redactedSecret = "xxxxx"
+
+// RedactURL returns the storage URL with the credentials in the query
+// parameters and the user info replaced, so it can be shown and kept. The URL
+// is returned as is if there is no credential in it.
+func RedactURL(rawURL string) string {
+	u, err := ParseRawURL(rawURL)
+	if err != nil {
+		return "?"
+	}
+	redacted := false
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedSecret)
+		redacted = true
+	}
+	query := u.Query()
+	for key := range query {
+		if _, ok := secretQueryParameters[strings.ToLower(strings.ReplaceAll(key, "_", "-"))]; ok {
+			query.Set(key, redactedSecret)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return rawURL
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
 // FormatBackendURL obtains the raw URL which can be used the reconstruct the
 // backend. The returned URL does not contain options for further configurating
 // the backend. This is to avoid exposing secret tokens.
@@ -189,6 +277,21 @@ func FormatBackendURL(backend *backuppb.StorageBackend) (u url.URL) {
 		u.Scheme = "azure"
 		u.Host = b.AzureBlobStorage.Bucket
 		u.Path = b.AzureBlobStorage.Prefix
+	case *backuppb.StorageBackend_CloudDynamic:
+		bucket := b.CloudDynamic.GetBucket()
+		switch b.CloudDynamic.ProviderName {
+		case sftpProviderName:
+			u.Scheme = "sftp"
+			u.Host = bucket.GetEndpoint()
+			u.Path = "/" + bucket.GetPrefix()
+		case webDAVProviderName:
+			u.Scheme = "webdav"
+			if strings.HasPrefix(bucket.GetEndpoint(), "https://") {
+				u.Scheme = "webdavs"
+			}
+			u.Host = bucket.GetEndpoint()[strings.Index(bucket.GetEndpoint(), "://")+3:]
+			u.Path = bucket.GetPrefix()
+		}
 	}
 	return
 }
@@ -0,0 +1,269 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pkg/sftp"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	sftpProviderName = "sftp"
+
+	sftpPrivateKeyOption = "sftp.private-key"
+	sftpKnownHostsOption = "sftp.known-hosts"
+
+	sftpDefaultPort = "22"
+
+	sftpAttrUser                  = "user"
+	sftpAttrPassword              = "password"
+	sftpAttrPrivateKey            = "private-key"
+	sftpAttrKnownHosts            = "known-hosts"
+	sftpAttrInsecureIgnoreHostKey = "insecure-ignore-host-key"
+)
+
+// SFTPBackendOptions contains options for the SFTP storage.
+type SFTPBackendOptions struct {
+	// Password and PrivateKey authenticate the user, at least one of them is required.
+	Password string `json:"password" toml:"password"`
+	// PrivateKey is the path of the private key file.
+	PrivateKey string `json:"private-key" toml:"private-key"`
+	// KnownHosts is the path of the known hosts file to verify the server,
+	// ~/.ssh/known_hosts is used if it's empty.
+	KnownHosts            string `json:"known-hosts" toml:"known-hosts"`
+	InsecureIgnoreHostKey bool   `json:"insecure-ignore-host-key" toml:"insecure-ignore-host-key"`
+}
+
+func (options *SFTPBackendOptions) apply(cloud *backuppb.CloudDynamic, user string) {
+	cloud.Attrs = map[string]string{
+		sftpAttrUser:                  user,
+		sftpAttrPassword:              options.Password,
+		sftpAttrPrivateKey:            options.PrivateKey,
+		sftpAttrKnownHosts:            options.KnownHosts,
+		sftpAttrInsecureIgnoreHostKey: strconv.FormatBool(options.InsecureIgnoreHostKey),
+	}
+}
+
+func defineSFTPFlags(flags *pflag.FlagSet) {
+	flags.String(sftpPrivateKeyOption, "", "(experimental) Set the private key file path for sftp")
+	flags.String(sftpKnownHostsOption, "", "(experimental) Set the known hosts file path to verify the sftp server")
+}
+
+func (options *SFTPBackendOptions) parseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	options.PrivateKey, err = flags.GetString(sftpPrivateKeyOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	options.KnownHosts, err = flags.GetString(sftpKnownHostsOption)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// sftpStorage is the storage on a server accessed by SFTP.
+type sftpStorage struct {
+	host   string
+	base   string
+	client *sftp.Client
+}
+
+func sshClientConfig(attrs map[string]string) (*ssh.ClientConfig, error) {
+	config := &ssh.ClientConfig{User: attrs[sftpAttrUser]}
+	if len(config.User) == 0 {
+		config.User = os.Getenv("USER")
+	}
+
+	if keyFile := attrs[sftpAttrPrivateKey]; len(keyFile) > 0 {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "failed to read private key: %s", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "failed to parse private key: %s", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if password := attrs[sftpAttrPassword]; len(password) > 0 {
+		config.Auth = append(config.Auth, ssh.Password(password))
+	}
+	if len(config.Auth) == 0 {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+			"please specify the password or --%s for sftp", sftpPrivateKeyOption)
+	}
+
+	if insecure, _ := strconv.ParseBool(attrs[sftpAttrInsecureIgnoreHostKey]); insecure {
+		//nolint:gosec
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return config, nil
+	}
+	knownHostsFile := attrs[sftpAttrKnownHosts]
+	if len(knownHostsFile) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+				"please specify --%s for sftp: %s", sftpKnownHostsOption, err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "failed to read known hosts: %s", err)
+	}
+	config.HostKeyCallback = callback
+	return config, nil
+}
+
+func newSFTPStorage(ctx context.Context, cloud *backuppb.CloudDynamic) (*sftpStorage, error) {
+	bucket := cloud.GetBucket()
+	host := bucket.GetEndpoint()
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, sftpDefaultPort)
+	}
+	config, err := sshClientConfig(cloud.Attrs)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown, "failed to connect to sftp server %s: %s", host, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, host, config)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidPermission, "failed to connect to sftp server %s: %s", host, err)
+	}
+	client, err := sftp.NewClient(ssh.NewClient(sshConn, chans, reqs))
+	if err != nil {
+		sshConn.Close()
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown, "failed to start sftp session: %s", err)
+	}
+	return &sftpStorage{host: bucket.GetEndpoint(), base: bucket.GetPrefix(), client: client}, nil
+}
+
+func (s *sftpStorage) path(name string) string {
+	p := path.Join(s.base, name)
+	if len(p) == 0 {
+		// The relative paths are relative to the home directory.
+		return "."
+	}
+	return p
+}
+
+// WriteFile writes data to a file to storage.
+func (s *sftpStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	f, err := s.create(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	return errors.Trace(f.Close())
+}
+
+func (s *sftpStorage) create(name string) (*sftp.File, error) {
+	p := s.path(name)
+	if err := s.client.MkdirAll(path.Dir(p)); err != nil {
+		return nil, errors.Annotatef(err, "failed to create the directory of %s", name)
+	}
+	f, err := s.client.Create(p)
+	return f, errors.Trace(err)
+}
+
+// ReadFile reads the file from the storage and returns the contents.
+func (s *sftpStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	f, err := s.client.Open(s.path(name))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	return data, errors.Trace(err)
+}
+
+// FileExists checks if a file exists.
+func (s *sftpStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.client.Stat(s.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.Trace(err)
+	}
+	return true, nil
+}
+
+// DeleteFile deletes the file.
+func (s *sftpStorage) DeleteFile(ctx context.Context, name string) error {
+	return errors.Trace(s.client.Remove(s.path(name)))
+}
+
+// Open a Reader by file path, path is a relative path to base path.
+func (s *sftpStorage) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	f, err := s.client.Open(s.path(path))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return f, nil
+}
+
+// WalkDir traverse all the files in a dir.
+func (s *sftpStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(string, int64) error) error {
+	if opt == nil {
+		opt = &WalkOption{}
+	}
+	walker := s.client.Walk(s.path(opt.SubDir))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) {
+				// if path not exists, we should return nil to continue.
+				continue
+			}
+			return errors.Trace(err)
+		}
+		stat := walker.Stat()
+		if stat.IsDir() {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), s.base), "/")
+		if err := fn(name, stat.Size()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// URI returns the base path as a URI.
+func (s *sftpStorage) URI() string {
+	return sftpProviderName + "://" + s.host + "/" + s.base
+}
+
+// Create opens a file writer by path, path is a relative path to base path.
+func (s *sftpStorage) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
+	f, err := s.create(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	buf := bufio.NewWriter(f)
+	return newFlushStorageWriter(buf, buf, f), nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSFTPServer starts an in-process SFTP server, and returns its address.
+func startSFTPServer(t *testing.T) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "br" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", conn.User())
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTPConn(conn, config)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func serveSFTPConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+			}
+		}()
+		go func() {
+			defer channel.Close()
+			server, err := sftp.NewServer(channel)
+			if err != nil {
+				return
+			}
+			_ = server.Serve()
+		}()
+	}
+}
+
+func TestSFTPStorage(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	addr := startSFTPServer(t)
+	// The path starts with "//" as it's absolute.
+	uri := "sftp://" + addr + "/" + root + "/backup"
+
+	backend, err := ParseBackend("sftp://br:secret@"+addr+"/"+root+"/backup?insecure-ignore-host-key=true", nil)
+	require.NoError(t, err)
+	backendURL := FormatBackendURL(backend)
+	require.Equal(t, uri, backendURL.String())
+	s, err := New(ctx, backend, &ExternalStorageOptions{})
+	require.NoError(t, err)
+	require.Equal(t, uri, s.URI())
+
+	exists, err := s.FileExists(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, s.WriteFile(ctx, "backupmeta", []byte("meta")))
+	require.NoError(t, s.WriteFile(ctx, "1/a.sst", []byte("0123456789")))
+	exists, err = s.FileExists(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.True(t, exists)
+	data, err := s.ReadFile(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.Equal(t, []byte("meta"), data)
+	data, err = os.ReadFile(root + "/backup/1/a.sst")
+	require.NoError(t, err)
+	require.Equal(t, []byte("0123456789"), data)
+
+	r, err := s.Open(ctx, "1/a.sst")
+	require.NoError(t, err)
+	pos, err := r.Seek(-2, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), pos)
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "89", string(rest))
+	require.NoError(t, r.Close())
+
+	w, err := s.Create(ctx, "2/b.csv")
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("a,"))
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close(ctx))
+
+	files := make([]string, 0)
+	require.NoError(t, s.WalkDir(ctx, &WalkOption{}, func(path string, size int64) error {
+		files = append(files, fmt.Sprintf("%s:%d", path, size))
+		return nil
+	}))
+	sort.Strings(files)
+	require.Equal(t, []string{"1/a.sst:10", "2/b.csv:3", "backupmeta:4"}, files)
+
+	require.NoError(t, s.DeleteFile(ctx, "1/a.sst"))
+	files = files[:0]
+	require.NoError(t, s.WalkDir(ctx, &WalkOption{SubDir: "2"}, func(path string, size int64) error {
+		files = append(files, path)
+		return nil
+	}))
+	require.Equal(t, []string{"2/b.csv"}, files)
+
+	backend, err = ParseBackend("sftp://br:wrong@"+addr+"/"+root+"/backup?insecure-ignore-host-key=true", nil)
+	require.NoError(t, err)
+	_, err = New(ctx, backend, &ExternalStorageOptions{})
+	require.Error(t, err)
+	// The host key must be verified by default.
+	backend, err = ParseBackend("sftp://br:secret@"+addr+"/"+root+"/backup?known-hosts="+root+"/known_hosts", nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(root+"/known_hosts", nil, 0o600))
+	_, err = New(ctx, backend, &ExternalStorageOptions{})
+	require.Error(t, err)
+}
@@ -125,6 +125,10 @@ type ExternalStorageOptions struct {
 	// directly using HTTP (e.g. the local storage).
 	HTTPClient *http.Client
 
+	// HDFS configures the HDFS storage, which can't be expressed by the
+	// storage backend.
+	HDFS HDFSBackendOptions
+
 	// CheckPermissions check the given permission in New() function.
 	// make sure we can access the storage correctly before execute tasks.
 	CheckPermissions []Permission
@@ -152,7 +156,7 @@ func New(ctx context.Context, backend *backuppb.StorageBackend, opts *ExternalSt
 		if backend.Hdfs == nil {
 			return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "hdfs config not found")
 		}
-		return NewHDFSStorage(backend.Hdfs.Remote), nil
+		return NewHDFSStorage(backend.Hdfs.Remote, &opts.HDFS, opts)
 	case *backuppb.StorageBackend_S3:
 		if backend.S3 == nil {
 			return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "s3 config not found")
@@ -167,6 +171,19 @@ func New(ctx context.Context, backend *backuppb.StorageBackend, opts *ExternalSt
 		return newGCSStorage(ctx, backend.Gcs, opts)
 	case *backuppb.StorageBackend_AzureBlobStorage:
 		return newAzureBlobStorage(ctx, backend.AzureBlobStorage, opts)
+	case *backuppb.StorageBackend_CloudDynamic:
+		if backend.CloudDynamic == nil {
+			return nil, errors.Annotate(berrors.ErrStorageInvalidConfig, "cloud dynamic config not found")
+		}
+		switch backend.CloudDynamic.ProviderName {
+		case sftpProviderName:
+			return newSFTPStorage(ctx, backend.CloudDynamic)
+		case webDAVProviderName:
+			return newWebDAVStorage(backend.CloudDynamic, opts), nil
+		default:
+			return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig,
+				"storage provider %s is not supported yet", backend.CloudDynamic.ProviderName)
+		}
 	default:
 		return nil, errors.Annotatef(berrors.ErrStorageInvalidConfig, "storage %T is not supported yet", backend)
 	}
@@ -0,0 +1,406 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+)
+
+const (
+	webDAVProviderName = "webdav"
+
+	webDAVAttrUser     = "user"
+	webDAVAttrPassword = "password"
+)
+
+// WebDAVBackendOptions contains options for the WebDAV storage.
+type WebDAVBackendOptions struct {
+	User     string `json:"user" toml:"user"`
+	Password string `json:"password" toml:"password"`
+}
+
+func (options *WebDAVBackendOptions) apply(cloud *backuppb.CloudDynamic) {
+	cloud.Attrs = map[string]string{
+		webDAVAttrUser:     options.User,
+		webDAVAttrPassword: options.Password,
+	}
+}
+
+// webDAVStorage is the storage on a WebDAV server.
+type webDAVStorage struct {
+	// endpoint is the url of the server, such as http://host:port.
+	endpoint string
+	// base is the path of the base directory on the server.
+	base     string
+	user     string
+	password string
+	cli      *http.Client
+}
+
+func newWebDAVStorage(cloud *backuppb.CloudDynamic, opts *ExternalStorageOptions) *webDAVStorage {
+	cli := http.DefaultClient
+	if opts != nil && opts.HTTPClient != nil {
+		cli = opts.HTTPClient
+	}
+	return &webDAVStorage{
+		endpoint: strings.TrimSuffix(cloud.GetBucket().GetEndpoint(), "/"),
+		base:     strings.Trim(cloud.GetBucket().GetPrefix(), "/"),
+		user:     cloud.Attrs[webDAVAttrUser],
+		password: cloud.Attrs[webDAVAttrPassword],
+		cli:      cli,
+	}
+}
+
+// url returns the url of the path on the server.
+func (s *webDAVStorage) url(p string) string {
+	return s.endpoint + (&url.URL{Path: path.Join("/", p)}).EscapedPath()
+}
+
+// newRequest creates a request to the file, name is a relative path to base path.
+func (s *webDAVStorage) newRequest(
+	ctx context.Context,
+	method, name string,
+	body io.Reader,
+) (*http.Request, error) {
+	return s.newRequestToPath(ctx, method, path.Join(s.base, name), body)
+}
+
+func (s *webDAVStorage) newRequestToPath(
+	ctx context.Context,
+	method, p string,
+	body io.Reader,
+) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(p), body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(s.user) > 0 {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	return req, nil
+}
+
+// do sends the request, and returns the response if its status is expected.
+func (s *webDAVStorage) do(req *http.Request, expected ...int) (*http.Response, error) {
+	resp, err := s.cli.Do(req)
+	if err != nil {
+		return nil, errors.Annotatef(berrors.ErrStorageUnknown, "failed to %s %s: %s", req.Method, req.URL.Path, err)
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, errors.Trace(&webDAVNotFoundError{path: req.URL.Path})
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, errors.Annotatef(berrors.ErrStorageInvalidPermission, "failed to %s %s: %s",
+			req.Method, req.URL.Path, resp.Status)
+	}
+	return nil, errors.Annotatef(berrors.ErrStorageUnknown, "failed to %s %s: %s", req.Method, req.URL.Path, resp.Status)
+}
+
+// webDAVNotFoundError is the cause of the errors of the files not found.
+type webDAVNotFoundError struct {
+	path string
+}
+
+func (e *webDAVNotFoundError) Error() string {
+	return "webdav file not found: " + e.path
+}
+
+func isWebDAVNotFound(err error) bool {
+	_, ok := errors.Cause(err).(*webDAVNotFoundError)
+	return ok
+}
+
+// mkdirAll creates the parent directories of the file, as WebDAV doesn't
+// create them when putting a file.
+func (s *webDAVStorage) mkdirAll(ctx context.Context, name string) error {
+	dir := path.Dir(path.Join("/", s.base, name))
+	if dir == "/" {
+		return nil
+	}
+	parts := strings.Split(strings.Trim(dir, "/"), "/")
+	for i := range parts {
+		req, err := s.newRequestToPath(ctx, "MKCOL", strings.Join(parts[:i+1], "/"), nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		// The directory exists if it's not allowed.
+		resp, err := s.do(req, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+func (s *webDAVStorage) put(ctx context.Context, name string, body io.Reader) error {
+	req, err := s.newRequest(ctx, http.MethodPut, name, body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := s.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(resp.Body.Close())
+}
+
+// WriteFile writes data to a file to storage.
+func (s *webDAVStorage) WriteFile(ctx context.Context, name string, data []byte) error {
+	if err := s.mkdirAll(ctx, name); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.put(ctx, name, bytes.NewReader(data)))
+}
+
+// ReadFile reads the file from the storage and returns the contents.
+func (s *webDAVStorage) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp, err := s.do(req, http.StatusOK)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return data, errors.Trace(err)
+}
+
+// size returns the size of the file.
+func (s *webDAVStorage) size(ctx context.Context, name string) (int64, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, name, nil)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	resp, err := s.do(req, http.StatusOK)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// FileExists checks if a file exists.
+func (s *webDAVStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.size(ctx, name)
+	if err != nil {
+		if isWebDAVNotFound(err) {
+			return false, nil
+		}
+		return false, errors.Trace(err)
+	}
+	return true, nil
+}
+
+// DeleteFile deletes the file.
+func (s *webDAVStorage) DeleteFile(ctx context.Context, name string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := s.do(req, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(resp.Body.Close())
+}
+
+// Open a Reader by file path, path is a relative path to base path.
+func (s *webDAVStorage) Open(ctx context.Context, path string) (ExternalFileReader, error) {
+	size, err := s.size(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &webDAVFileReader{ctx: ctx, storage: s, name: path, size: size}, nil
+}
+
+type webDAVMultiStatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength int64 `xml:"getcontentlength"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+const webDAVPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/></prop></propfind>`
+
+// WalkDir traverse all the files in a dir.
+func (s *webDAVStorage) WalkDir(ctx context.Context, opt *WalkOption, fn func(string, int64) error) error {
+	if opt == nil {
+		opt = &WalkOption{}
+	}
+	return s.walkDir(ctx, strings.Trim(opt.SubDir, "/"), fn)
+}
+
+func (s *webDAVStorage) walkDir(ctx context.Context, dir string, fn func(string, int64) error) error {
+	req, err := s.newRequest(ctx, "PROPFIND", dir+"/", strings.NewReader(webDAVPropfind))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// Not all the servers support listing recursively.
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := s.do(req, http.StatusMultiStatus)
+	if err != nil {
+		if isWebDAVNotFound(err) {
+			// if path not exists, we should return nil to continue.
+			return nil
+		}
+		return errors.Trace(err)
+	}
+	var ms webDAVMultiStatus
+	err = xml.NewDecoder(resp.Body).Decode(&ms)
+	resp.Body.Close()
+	if err != nil {
+		return errors.Annotatef(berrors.ErrStorageUnknown, "invalid response of listing %s: %s", dir, err)
+	}
+	for _, r := range ms.Responses {
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return errors.Annotatef(berrors.ErrStorageUnknown, "invalid href %s", r.Href)
+		}
+		name := strings.Trim(strings.TrimPrefix(strings.Trim(href.Path, "/"), s.base), "/")
+		if name == dir || len(r.Propstat) == 0 {
+			// Skip the directory itself.
+			continue
+		}
+		prop := r.Propstat[0].Prop
+		if prop.ResourceType.Collection != nil {
+			if err = s.walkDir(ctx, name, fn); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		}
+		if err = fn(name, prop.ContentLength); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// URI returns the base path as a URI.
+func (s *webDAVStorage) URI() string {
+	return s.url(s.base)
+}
+
+// Create opens a file writer by path, path is a relative path to base path.
+// The data is streamed to the server by a single request.
+func (s *webDAVStorage) Create(ctx context.Context, name string) (ExternalFileWriter, error) {
+	if err := s.mkdirAll(ctx, name); err != nil {
+		return nil, errors.Trace(err)
+	}
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := s.put(ctx, name, r)
+		// Unblock the writer if the request fails.
+		_ = r.CloseWithError(fmt.Errorf("failed to put %s: %v", name, err))
+		done <- err
+	}()
+	return &webDAVFileWriter{w: w, done: done}, nil
+}
+
+type webDAVFileWriter struct {
+	w    *io.PipeWriter
+	done chan error
+}
+
+func (w *webDAVFileWriter) Write(_ context.Context, p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *webDAVFileWriter) Close(_ context.Context) error {
+	if err := w.w.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(<-w.done)
+}
+
+// webDAVFileReader reads a file from the offset, the file is reopened after seeking.
+type webDAVFileReader struct {
+	ctx     context.Context
+	storage *webDAVStorage
+	name    string
+	size    int64
+	pos     int64
+	reader  io.ReadCloser
+}
+
+func (r *webDAVFileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.reader == nil {
+		req, err := r.storage.newRequest(r.ctx, http.MethodGet, r.name, nil)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.pos))
+		resp, err := r.storage.do(req, http.StatusPartialContent)
+		if err != nil {
+			return 0, errors.Annotatef(err, "failed to read %s", r.name)
+		}
+		r.reader = resp.Body
+	}
+	n, err := r.reader.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *webDAVFileReader) Seek(offset int64, whence int) (int64, error) {
+	var realOffset int64
+	switch whence {
+	case io.SeekStart:
+		realOffset = offset
+	case io.SeekCurrent:
+		realOffset = r.pos + offset
+	case io.SeekEnd:
+		realOffset = r.size + offset
+	default:
+		return 0, errors.Annotatef(berrors.ErrStorageUnknown, "Seek: invalid whence '%d'", whence)
+	}
+	if realOffset < 0 {
+		return 0, errors.Annotatef(berrors.ErrStorageUnknown, "Seek in '%s': invalid offset to seek '%d'.", r.name, realOffset)
+	}
+	if realOffset != r.pos && r.reader != nil {
+		_ = r.reader.Close()
+		r.reader = nil
+	}
+	r.pos = realOffset
+	return r.pos, nil
+}
+
+func (r *webDAVFileReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	return errors.Trace(r.reader.Close())
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVStorage(t *testing.T) {
+	ctx := context.Background()
+	handler := &webdav.Handler{FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "br" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	backend, err := ParseBackend("webdav://br:secret@"+host+"/dav/backup", nil)
+	require.NoError(t, err)
+	backendURL := FormatBackendURL(backend)
+	require.Equal(t, "webdav://"+host+"/dav/backup", backendURL.String())
+	s, err := New(ctx, backend, &ExternalStorageOptions{HTTPClient: srv.Client()})
+	require.NoError(t, err)
+	require.Equal(t, srv.URL+"/dav/backup", s.URI())
+
+	exists, err := s.FileExists(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.False(t, exists)
+	require.NoError(t, s.WriteFile(ctx, "backupmeta", []byte("meta")))
+	require.NoError(t, s.WriteFile(ctx, "1/a.sst", []byte("0123456789")))
+	require.NoError(t, s.WriteFile(ctx, "1/a b.sst", []byte("space")))
+	exists, err = s.FileExists(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.True(t, exists)
+	data, err := s.ReadFile(ctx, "backupmeta")
+	require.NoError(t, err)
+	require.Equal(t, []byte("meta"), data)
+	_, err = s.ReadFile(ctx, "not-exist")
+	require.Error(t, err)
+
+	r, err := s.Open(ctx, "1/a.sst")
+	require.NoError(t, err)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	require.Equal(t, "012", string(buf))
+	pos, err := r.Seek(-2, io.SeekEnd)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), pos)
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "89", string(rest))
+	require.NoError(t, r.Close())
+
+	w, err := s.Create(ctx, "2/3/b.csv")
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("a,"))
+	require.NoError(t, err)
+	_, err = w.Write(ctx, []byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close(ctx))
+
+	files := make([]string, 0)
+	require.NoError(t, s.WalkDir(ctx, &WalkOption{}, func(path string, size int64) error {
+		files = append(files, fmt.Sprintf("%s:%d", path, size))
+		return nil
+	}))
+	sort.Strings(files)
+	require.Equal(t, []string{"1/a b.sst:5", "1/a.sst:10", "2/3/b.csv:3", "backupmeta:4"}, files)
+
+	require.NoError(t, s.DeleteFile(ctx, "1/a.sst"))
+	files = files[:0]
+	require.NoError(t, s.WalkDir(ctx, &WalkOption{SubDir: "1"}, func(path string, size int64) error {
+		files = append(files, path)
+		return nil
+	}))
+	require.Equal(t, []string{"1/a b.sst"}, files)
+	require.NoError(t, s.WalkDir(ctx, &WalkOption{SubDir: "not-exist"}, func(path string, size int64) error {
+		return fmt.Errorf("unexpected file %s", path)
+	}))
+
+	backend, err = ParseBackend("webdav://br:wrong@"+host+"/dav/backup", nil)
+	require.NoError(t, err)
+	s, err = New(ctx, backend, &ExternalStorageOptions{HTTPClient: srv.Client()})
+	require.NoError(t, err)
+	_, err = s.ReadFile(ctx, "backupmeta")
+	require.Error(t, err)
+}
@@ -44,9 +44,14 @@ type LogCollector interface {
 
 	CollectUInt(name string, t uint64)
 
+	CollectStoreUnit(storeID uint64, files int, size uint64)
+
 	SetSuccessStatus(success bool)
 
 	Summary(name string)
+
+	// Snapshot returns the report of the infos collected so far.
+	Snapshot(name string) *Report
 }
 
 type logFunc func(msg string, fields ...zap.Field)
@@ -83,6 +88,7 @@ type logCollector struct {
 	durations        map[string]time.Duration
 	ints             map[string]int
 	uints            map[string]uint64
+	stores           map[uint64]*StoreReport
 	successStatus    bool
 	startTime        time.Time
 
@@ -100,6 +106,7 @@ func NewLogCollector(log logFunc) LogCollector {
 		durations:        make(map[string]time.Duration),
 		ints:             make(map[string]int),
 		uints:            make(map[string]uint64),
+		stores:           make(map[uint64]*StoreReport),
 		log:              log,
 		startTime:        time.Now(),
 	}
@@ -151,6 +158,18 @@ func (tc *logCollector) CollectUInt(name string, t uint64) {
 	tc.uints[name] += t
 }
 
+func (tc *logCollector) CollectStoreUnit(storeID uint64, files int, size uint64) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	store, ok := tc.stores[storeID]
+	if !ok {
+		store = &StoreReport{StoreID: storeID}
+		tc.stores[storeID] = store
+	}
+	store.Files += files
+	store.Bytes += size
+}
+
 func (tc *logCollector) SetSuccessStatus(success bool) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
@@ -168,9 +187,14 @@ func (tc *logCollector) Summary(name string) {
 		tc.ints = make(map[string]int)
 		tc.successCosts = make(map[string]time.Duration)
 		tc.failureReasons = make(map[string]error)
+		tc.stores = make(map[uint64]*StoreReport)
 		tc.mu.Unlock()
 	}()
 
+	if err := WriteJSONEvent(tc.report(name)); err != nil {
+		log.Warn("failed to write the summary", zap.Error(err))
+	}
+
 	logFields := make([]zap.Field, 0, len(tc.durations)+len(tc.ints)+3)
 
 	logFields = append(logFields,
@@ -237,6 +261,12 @@ func (tc *logCollector) Summary(name string) {
 	tc.log(name+" success summary", logFields...)
 }
 
+func (tc *logCollector) Snapshot(name string) *Report {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.report(name)
+}
+
 // SetLogCollector allow pass LogCollector outside.
 func SetLogCollector(l LogCollector) {
 	collector = l
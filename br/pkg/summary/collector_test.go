@@ -3,6 +3,10 @@
 package summary
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 
@@ -38,3 +42,51 @@ func TestSumDurationInt(t *testing.T) {
 	assertContains(zap.Duration("b", 2*time.Second))
 	assertContains(zap.Int("c", 4))
 }
+
+func TestJSONSummary(t *testing.T) {
+	var out bytes.Buffer
+	SetJSONOutput(&out)
+	defer SetJSONOutput(nil)
+
+	col := NewLogCollector(func(string, ...zap.Field) {})
+	col.SetUnit(BackupUnit)
+	col.CollectSuccessUnit("ranges", 2, time.Second)
+	col.CollectSuccessUnit(TotalKV, 1, uint64(10))
+	col.CollectSuccessUnit(TotalBytes, 1, uint64(100))
+	col.CollectSuccessUnit(BackupDataSize, 1, uint64(50))
+	col.CollectStoreUnit(2, 1, 20)
+	col.CollectStoreUnit(1, 2, 30)
+	col.CollectInt("regions", 3)
+	col.CollectFailureUnit("range", errors.New("failed"))
+	require.Equal(t, 3, col.Snapshot("foo").Files)
+	col.Summary("foo")
+
+	var r Report
+	require.NoError(t, json.Unmarshal(out.Bytes(), &r))
+	require.Equal(t, EventSummary, r.Type)
+	require.Equal(t, "foo", r.Name)
+	require.Equal(t, BackupUnit, r.Unit)
+	require.False(t, r.Success)
+	require.Equal(t, 3, r.TotalRanges)
+	require.Equal(t, 1, r.FailedRanges)
+	require.Equal(t, uint64(10), r.TotalKVs)
+	require.Equal(t, uint64(100), r.TotalBytes)
+	require.Equal(t, uint64(50), r.DataSize)
+	require.Equal(t, 3, r.Files)
+	require.Len(t, r.Stores, 2)
+	require.Equal(t, uint64(1), r.Stores[0].StoreID)
+	require.Equal(t, uint64(30), r.Stores[0].Bytes)
+	require.Equal(t, []ErrorReport{{Unit: "range", Error: "failed"}}, r.Errors)
+	require.Equal(t, 3.0, r.Fields["regions"])
+}
+
+func TestCollectorFromContext(t *testing.T) {
+	ctx := context.Background()
+	require.Equal(t, collector, FromContext(ctx))
+
+	col := NewLogCollector(func(string, ...zap.Field) {})
+	taskCtx := WithCollector(ctx, col)
+	FromContext(taskCtx).CollectStoreUnit(1, 2, 30)
+	require.Equal(t, 2, col.Snapshot("foo").Files)
+	require.Equal(t, 0, Snapshot("foo").Files)
+}
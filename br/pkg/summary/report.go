@@ -0,0 +1,170 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package summary
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	// EventProgress is the type of the progress events.
+	EventProgress = "progress"
+	// EventSummary is the type of the summary events.
+	EventSummary = "summary"
+	// EventError is the type of the error events.
+	EventError = "error"
+)
+
+// Report is the machine-readable summary of a task.
+type Report struct {
+	Type            string  `json:"type"`
+	Name            string  `json:"name"`
+	Unit            string  `json:"unit"`
+	Success         bool    `json:"success"`
+	DurationSeconds float64 `json:"duration-seconds"`
+	TotalRanges     int     `json:"total-ranges"`
+	SucceedRanges   int     `json:"ranges-succeed"`
+	FailedRanges    int     `json:"ranges-failed"`
+	TotalKVs        uint64  `json:"total-kvs"`
+	TotalBytes      uint64  `json:"total-bytes"`
+	// DataSize is the size of the backup files after compressed.
+	DataSize uint64 `json:"data-size"`
+	// Files is the number of the files backed up or restored by the stores.
+	Files  int           `json:"files"`
+	Stores []StoreReport `json:"stores"`
+	Errors []ErrorReport `json:"errors"`
+	// Fields are the other collected fields.
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// StoreReport is the amount of data a store backs up or restores.
+type StoreReport struct {
+	StoreID        uint64  `json:"store-id"`
+	Files          int     `json:"files"`
+	Bytes          uint64  `json:"bytes"`
+	BytesPerSecond float64 `json:"bytes-per-second"`
+}
+
+// ErrorReport is the reason why a unit failed.
+type ErrorReport struct {
+	Unit  string `json:"unit"`
+	Error string `json:"error"`
+}
+
+// ErrorEvent is the error failing the task.
+type ErrorEvent struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// ProgressEvent is the machine-readable progress of a step of a task.
+type ProgressEvent struct {
+	Type           string  `json:"type"`
+	Step           string  `json:"step"`
+	Completed      int64   `json:"completed"`
+	Total          int64   `json:"total"`
+	Percent        float64 `json:"percent"`
+	Bytes          uint64  `json:"bytes"`
+	ElapsedSeconds float64 `json:"elapsed-seconds"`
+	// ETASeconds is the estimated remaining time, it's negative if nothing
+	// is completed yet.
+	ETASeconds float64 `json:"eta-seconds"`
+}
+
+var jsonOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// SetJSONOutput makes the progress events and the summary written to w as
+// JSON lines, the output is disabled if w is nil.
+func SetJSONOutput(w io.Writer) {
+	jsonOutput.mu.Lock()
+	defer jsonOutput.mu.Unlock()
+	jsonOutput.w = w
+}
+
+// JSONOutputEnabled returns whether the JSON output is enabled.
+func JSONOutputEnabled() bool {
+	jsonOutput.mu.Lock()
+	defer jsonOutput.mu.Unlock()
+	return jsonOutput.w != nil
+}
+
+// WriteJSONEvent writes the event as a JSON line if the JSON output is enabled.
+func WriteJSONEvent(event interface{}) error {
+	jsonOutput.mu.Lock()
+	defer jsonOutput.mu.Unlock()
+	if jsonOutput.w == nil {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = jsonOutput.w.Write(append(data, '\n'))
+	return errors.Trace(err)
+}
+
+// report builds the report from the collected infos, the caller must hold mu.
+func (tc *logCollector) report(name string) *Report {
+	duration := time.Since(tc.startTime)
+	r := &Report{
+		Type:            EventSummary,
+		Name:            name,
+		Unit:            tc.unit,
+		Success:         len(tc.failureReasons) == 0 && tc.successStatus,
+		DurationSeconds: duration.Seconds(),
+		TotalRanges:     tc.failureUnitCount + tc.successUnitCount,
+		SucceedRanges:   tc.successUnitCount,
+		FailedRanges:    tc.failureUnitCount,
+		Stores:          make([]StoreReport, 0, len(tc.stores)),
+		Errors:          make([]ErrorReport, 0, len(tc.failureReasons)),
+		Fields:          make(map[string]interface{}),
+	}
+	for key, val := range tc.successData {
+		switch key {
+		case TotalKV:
+			r.TotalKVs = val
+		case TotalBytes:
+			r.TotalBytes = val
+		case BackupDataSize, RestoreDataSize:
+			r.DataSize = val
+		default:
+			r.Fields[logKeyFor(key)] = val
+		}
+	}
+	for _, store := range tc.stores {
+		s := *store
+		if duration > 0 {
+			s.BytesPerSecond = float64(s.Bytes) / duration.Seconds()
+		}
+		r.Files += s.Files
+		r.Stores = append(r.Stores, s)
+	}
+	sort.Slice(r.Stores, func(i, j int) bool {
+		return r.Stores[i].StoreID < r.Stores[j].StoreID
+	})
+	for unit, reason := range tc.failureReasons {
+		r.Errors = append(r.Errors, ErrorReport{Unit: unit, Error: reason.Error()})
+	}
+	sort.Slice(r.Errors, func(i, j int) bool {
+		return r.Errors[i].Unit < r.Errors[j].Unit
+	})
+	for key, val := range tc.durations {
+		r.Fields[logKeyFor(key)+"-seconds"] = val.Seconds()
+	}
+	for key, val := range tc.ints {
+		r.Fields[logKeyFor(key)] = val
+	}
+	for key, val := range tc.uints {
+		r.Fields[logKeyFor(key)] = val
+	}
+	return r
+}
@@ -2,7 +2,28 @@
 
 package summary
 
-import "time"
+import (
+	"context"
+	"time"
+)
+
+type collectorKey struct{}
+
+// WithCollector returns a copy of ctx in which the infos are collected into c
+// instead of the global collector, so that the tasks running in the same
+// process don't mix up their summaries.
+func WithCollector(ctx context.Context, c LogCollector) context.Context {
+	return context.WithValue(ctx, collectorKey{}, c)
+}
+
+// FromContext returns the collector of ctx, or the global collector if ctx
+// has none.
+func FromContext(ctx context.Context) LogCollector {
+	if c, ok := ctx.Value(collectorKey{}).(LogCollector); ok {
+		return c
+	}
+	return collector
+}
 
 // SetUnit set unit "backup/restore" for summary log.
 func SetUnit(unit string) {
@@ -34,6 +55,11 @@ func CollectUint(name string, t uint64) {
 	collector.CollectUInt(name, t)
 }
 
+// CollectStoreUnit collects the files and their size a store backs up or restores.
+func CollectStoreUnit(storeID uint64, files int, size uint64) {
+	collector.CollectStoreUnit(storeID, files, size)
+}
+
 // SetSuccessStatus sets final success status.
 func SetSuccessStatus(success bool) {
 	collector.SetSuccessStatus(success)
@@ -43,3 +69,8 @@ func SetSuccessStatus(success bool) {
 func Summary(name string) {
 	collector.Summary(name)
 }
+
+// Snapshot returns the report of the infos collected so far.
+func Snapshot(name string) *Report {
+	return collector.Snapshot(name)
+}
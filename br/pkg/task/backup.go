@@ -257,6 +257,7 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 	opts := storage.ExternalStorageOptions{
 		NoCredentials:   cfg.NoCreds,
 		SendCredentials: cfg.SendCreds,
+		HDFS:            cfg.HDFS,
 	}
 	if err = client.SetStorage(ctx, u, &opts); err != nil {
 		return errors.Trace(err)
@@ -266,12 +267,20 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		return errors.Trace(err)
 	}
 	client.SetGCTTL(cfg.GCTTL)
+	if err = cfg.GenerateDataKey(ctx, client.GetStorage()); err != nil {
+		return errors.Trace(err)
+	}
+	stopRelay, err := cfg.relayBackup(client)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer stopRelay()
 
 	backupTS, err := client.GetTS(ctx, cfg.TimeAgo, cfg.BackupTS)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	g.Record("BackupTS", backupTS)
+	g.Record(ctx, "BackupTS", backupTS)
 	sp := utils.BRServiceSafePoint{
 		BackupTS: backupTS,
 		TTL:      client.GetGCTTL(),
@@ -473,9 +482,9 @@ func RunBackup(c context.Context, g glue.Glue, cmdName string, cfg *BackupConfig
 		}
 	}
 	archiveSize := metawriter.ArchiveSize()
-	g.Record(summary.BackupDataSize, archiveSize)
+	g.Record(ctx, summary.BackupDataSize, archiveSize)
 	//backup from tidb will fetch a general Size issue https://github.com/pingcap/tidb/issues/27247
-	g.Record("Size", archiveSize)
+	g.Record(ctx, "Size", archiveSize)
 	failpoint.Inject("s3-outage-during-writing-file", func(v failpoint.Value) {
 		log.Info("failpoint s3-outage-during-writing-file injected, " +
 			"process will sleep for 3s and notify the shell to kill s3 service.")
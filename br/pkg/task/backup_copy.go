@@ -0,0 +1,576 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/go-units"
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/checksum"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+const (
+	flagCopyFrom              = "from"
+	flagCopyTo                = "to"
+	flagCopyToCipherType      = "to.crypter.method"
+	flagCopyToCipherKey       = "to.crypter.key"
+	flagCopyToCipherKeyFile   = "to.crypter.key-file"
+	flagCopyToMasterKey       = "to.crypter.master-key"
+	flagCopyToCompressionType = "to.compression"
+
+	// defaultCopyConcurrency is the number of files copied concurrently.
+	defaultCopyConcurrency = 4
+	// copyChunkSize is the size of the chunks the files are streamed by.
+	copyChunkSize = units.MiB
+)
+
+// BackupCopyConfig is the configuration of copying a backup to another storage.
+type BackupCopyConfig struct {
+	Config
+
+	// To is the storage the backup is copied to, the backup is read from
+	// Config.Storage.
+	To string `json:"to" toml:"to"`
+	// ReEncrypt re-encrypts the copied backup by ToCipherInfo or by a data key
+	// encrypted by ToMasterKey, instead of copying the files as they are.
+	ReEncrypt    bool                `json:"re-encrypt" toml:"re-encrypt"`
+	ToCipherInfo backuppb.CipherInfo `json:"-" toml:"-"`
+	ToMasterKey  string              `json:"to-master-key" toml:"to-master-key"`
+	// ToCompressionType recompresses the sst files of the copied backup, the
+	// files keep their compression if it's unknown.
+	ToCompressionType backuppb.CompressionType `json:"to-compression-type" toml:"to-compression-type"`
+}
+
+// DefineBackupCopyFlags defines the flags of copying a backup.
+func DefineBackupCopyFlags(command *cobra.Command) {
+	command.Flags().String(flagCopyFrom, "", "the url of the backup to copy, --storage is used if it's empty")
+	command.Flags().String(flagCopyTo, "", `the url to copy the backup to, eg, "gcs://bucket/path/prefix"`)
+	command.Flags().String(flagCopyToCipherType, "",
+		"re-encrypt the copied raw backup by the method, value can be one of plaintext|aes128-ctr|aes192-ctr|aes256-ctr, "+
+			"the files are copied as they are if it's empty")
+	command.Flags().String(flagCopyToCipherKey, "", "aes-crypter key to re-encrypt the copied backup, in hex format")
+	command.Flags().String(flagCopyToCipherKeyFile, "", "FilePath, its content is used as the cipher-key to re-encrypt")
+	command.Flags().String(flagCopyToMasterKey, "",
+		"the master key to encrypt the data key of the copied backup, instead of --"+flagCopyToCipherKey)
+	command.Flags().String(flagCopyToCompressionType, "",
+		"recompress the sst files of the copied raw backup, value can be one of 'zstd|snappy', "+
+			"the files are copied as they are if it's empty")
+}
+
+// ParseFromFlags parses the backup copy flags from the flag set.
+func (cfg *BackupCopyConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	if err := cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	from, err := flags.GetString(flagCopyFrom)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(from) > 0 {
+		cfg.Storage = from
+	}
+	if cfg.To, err = flags.GetString(flagCopyTo); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.Storage) == 0 || len(cfg.To) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "both --%s and --%s are required", flagCopyFrom, flagCopyTo)
+	}
+	if err = cfg.parseToCipherInfo(flags); err != nil {
+		return errors.Trace(err)
+	}
+	compression, err := flags.GetString(flagCopyToCompressionType)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(compression) > 0 {
+		if cfg.ToCompressionType, err = parseCompressionType(compression); err != nil {
+			return errors.Trace(err)
+		}
+		if cfg.ToCompressionType == backuppb.CompressionType_LZ4 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "can't recompress the files by lz4")
+		}
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultCopyConcurrency
+	}
+	return nil
+}
+
+// parseToCipherInfo parses how to re-encrypt the copied backup, it's the
+// same as how Config parses the crypter flags.
+func (cfg *BackupCopyConfig) parseToCipherInfo(flags *pflag.FlagSet) error {
+	method, err := flags.GetString(flagCopyToCipherType)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	key, err := flags.GetString(flagCopyToCipherKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	keyFilePath, err := flags.GetString(flagCopyToCipherKeyFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.ToMasterKey, err = flags.GetString(flagCopyToMasterKey); err != nil {
+		return errors.Trace(err)
+	}
+	if len(method) == 0 {
+		if len(key) > 0 || len(keyFilePath) > 0 || len(cfg.ToMasterKey) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required to re-encrypt the copied backup",
+				flagCopyToCipherType)
+		}
+		return nil
+	}
+	cfg.ReEncrypt = true
+	if cfg.ToCipherInfo.CipherType, err = parseCipherType(method); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.ToMasterKey) > 0 {
+		if len(key) > 0 || len(keyFilePath) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s can't be used with --%s or --%s", flagCopyToMasterKey, flagCopyToCipherKey, flagCopyToCipherKeyFile)
+		}
+		// The data key is generated when copying.
+		return nil
+	}
+	if cfg.ToCipherInfo.CipherType == encryptionpb.EncryptionMethod_PLAINTEXT {
+		return nil
+	}
+	if (len(key) > 0) == (len(keyFilePath) > 0) {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"exactly one of --%s or --%s should be provided", flagCopyToCipherKey, flagCopyToCipherKeyFile)
+	}
+	if cfg.ToCipherInfo.CipherKey, err = getCipherKeyContent(key, keyFilePath); err != nil {
+		return errors.Trace(err)
+	}
+	if !checkCipherKeyMatch(&cfg.ToCipherInfo) {
+		return errors.Annotate(berrors.ErrInvalidArgument, "crypter method and key length not match")
+	}
+	return nil
+}
+
+// rewrites returns whether the data files are rewritten instead of copied as
+// they are.
+func (cfg *BackupCopyConfig) rewrites() bool {
+	return cfg.ReEncrypt || cfg.ToCompressionType != backuppb.CompressionType_UNKNOWN
+}
+
+// copiedFile is the size and checksum of a file written to the destination.
+type copiedFile struct {
+	size   int64
+	sha256 []byte
+}
+
+// backupCopier copies the files of a backup between two storages.
+type backupCopier struct {
+	src, dst   storage.ExternalStorage
+	srcCipher  *backuppb.CipherInfo
+	dstCipher  *backuppb.CipherInfo
+	limiter    *rate.Limiter
+	workerPool *utils.WorkerPool
+
+	mu     sync.Mutex
+	copied map[string]copiedFile
+	// rewritten are the data files rewritten to the destination, by name.
+	rewritten map[string]*backuppb.File
+}
+
+// RunBackupCopy copies the backup in the storage to another storage, including
+// the metafiles referenced by backupmeta v2. The data files of a raw backup
+// can be re-encrypted or recompressed, then the backupmeta is regenerated.
+// All the written files are read back to verify their sizes and checksums.
+func RunBackupCopy(c context.Context, g glue.Glue, cmdName string, cfg *BackupCopyConfig) error {
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, src, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.rewrites() && !backupMeta.IsRawKv {
+		return errors.Annotate(berrors.ErrInvalidArgument, "only the raw backup can be re-encrypted or recompressed")
+	}
+	dstCfg := cfg.Config
+	dstCfg.Storage = cfg.To
+	if cfg.ReEncrypt {
+		dstCfg.CipherInfo = cfg.ToCipherInfo
+		dstCfg.MasterKey = cfg.ToMasterKey
+	}
+	_, dst, err := GetStorage(ctx, &dstCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	exists, err := dst.FileExists(ctx, metautil.MetaFile)
+	if err != nil {
+		return errors.Annotatef(err, "error occurred when checking %s file", metautil.MetaFile)
+	}
+	if exists {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "backup meta file exists in %v, "+
+			"please specify an empty directory to copy the backup to", dst.URI()+"/"+metautil.MetaFile)
+	}
+	if cfg.ReEncrypt {
+		if err = dstCfg.GenerateDataKey(ctx, dst); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	reader := metautil.NewMetaReader(backupMeta, src, &cfg.CipherInfo)
+	dataFiles := make([]*backuppb.File, 0, len(backupMeta.Files))
+	if err = reader.ReadDataFiles(ctx, func(f *backuppb.File) { dataFiles = append(dataFiles, f) }); err != nil {
+		return errors.Trace(err)
+	}
+	metaFiles := make(map[string]struct{})
+	if err = reader.ReadMetaFiles(ctx, func(f *backuppb.File) { metaFiles[f.Name] = struct{}{} }); err != nil {
+		return errors.Trace(err)
+	}
+	names, err := listBackupCopyFiles(ctx, src, dataFiles, metaFiles)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	copier := &backupCopier{
+		src:        src,
+		dst:        dst,
+		srcCipher:  &cfg.CipherInfo,
+		dstCipher:  &dstCfg.CipherInfo,
+		workerPool: utils.NewWorkerPool(uint(cfg.Concurrency), "copy"),
+		copied:     make(map[string]copiedFile),
+		rewritten:  make(map[string]*backuppb.File),
+	}
+	if cfg.RateLimit != unlimited {
+		copier.limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), copyChunkSize)
+	}
+	dataFileByName := make(map[string]*backuppb.File, len(dataFiles))
+	for _, f := range dataFiles {
+		dataFileByName[f.Name] = f
+	}
+
+	updateCh := g.StartProgress(ctx, cmdName, int64(len(names)), !cfg.LogProgress)
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, n := range names {
+		name := n
+		file, isDataFile := dataFileByName[name]
+		_, isMetaFile := metaFiles[name]
+		switch {
+		case name == metautil.MetaFile:
+			// The backupmeta is written at last, so that an interrupted copy
+			// isn't taken as a backup.
+			continue
+		case cfg.ReEncrypt && name == metautil.DataKeyFile:
+			// The data key has been generated.
+			updateCh.Inc()
+			continue
+		case cfg.rewrites() && isMetaFile:
+			// The metafiles are regenerated with the backupmeta.
+			updateCh.Inc()
+			continue
+		}
+		copier.workerPool.ApplyOnErrorGroup(eg, func() error {
+			var err error
+			switch {
+			case cfg.rewrites() && isDataFile:
+				err = copier.rewriteDataFile(ectx, file, cfg.ToCompressionType)
+			case cfg.ReEncrypt && name == metautil.RawChecksumFile:
+				err = copier.rewriteRawChecksumFile(ectx)
+			default:
+				err = copier.copyFile(ectx, name, file)
+			}
+			if err != nil {
+				return errors.Annotatef(err, "failed to copy %s", name)
+			}
+			updateCh.Inc()
+			return nil
+		})
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if cfg.rewrites() {
+		err = copier.writeBackupMeta(ctx, backupMeta, dataFiles)
+	} else {
+		err = copier.copyFile(ctx, metautil.MetaFile, nil)
+	}
+	if err != nil {
+		return errors.Annotatef(err, "failed to copy %s", metautil.MetaFile)
+	}
+	updateCh.Inc()
+	updateCh.Close()
+
+	if err = copier.verify(ctx, len(dataFiles)); err != nil {
+		return errors.Trace(err)
+	}
+	var copiedSize int64
+	for _, f := range copier.copied {
+		copiedSize += f.size
+	}
+	log.Info("backup copied", zap.String("from", src.URI()), zap.String("to", dst.URI()),
+		zap.Int("files", len(copier.copied)), zap.Int64("size", copiedSize))
+	summary.CollectInt("copied files", len(copier.copied))
+	summary.CollectUint("copied size", uint64(copiedSize))
+	summary.SetSuccessStatus(true)
+	return nil
+}
+
+// listBackupCopyFiles returns the files to copy in order, which are all the
+// files in the storage and the files referenced by the backupmeta.
+func listBackupCopyFiles(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	dataFiles []*backuppb.File,
+	metaFiles map[string]struct{},
+) ([]string, error) {
+	files := make(map[string]struct{})
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(name string, _ int64) error {
+		files[strings.TrimPrefix(name, "/")] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The referenced files must be copied even if the storage doesn't list
+	// them, the copy fails if they are missing.
+	for _, f := range dataFiles {
+		files[f.Name] = struct{}{}
+	}
+	for name := range metaFiles {
+		files[name] = struct{}{}
+	}
+	files[metautil.MetaFile] = struct{}{}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// wait blocks until n bytes can be written under the rate limit.
+func (c *backupCopier) wait(ctx context.Context, n int) error {
+	if c.limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		batch := n
+		if batch > c.limiter.Burst() {
+			batch = c.limiter.Burst()
+		}
+		if err := c.limiter.WaitN(ctx, batch); err != nil {
+			return errors.Trace(err)
+		}
+		n -= batch
+	}
+	return nil
+}
+
+func (c *backupCopier) record(name string, f copiedFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.copied[name] = f
+}
+
+// copyFile streams the file to the destination as it is. The checksum of the
+// data file is checked against the backupmeta if file isn't nil.
+func (c *backupCopier) copyFile(ctx context.Context, name string, file *backuppb.File) error {
+	r, err := c.src.Open(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer r.Close()
+	w, err := c.dst.Create(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	hash := sha256.New()
+	buf := make([]byte, copyChunkSize)
+	var size int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := c.wait(ctx, n); err != nil {
+				_ = w.Close(ctx)
+				return errors.Trace(err)
+			}
+			hash.Write(buf[:n])
+			if _, err := w.Write(ctx, buf[:n]); err != nil {
+				_ = w.Close(ctx)
+				return errors.Trace(err)
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			_ = w.Close(ctx)
+			return errors.Trace(err)
+		}
+	}
+	if err = w.Close(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	checksum := hash.Sum(nil)
+	if file != nil && len(file.GetSha256()) > 0 && !bytes.Equal(checksum, file.GetSha256()) {
+		return errors.Annotatef(berrors.ErrBackupChecksumMismatch,
+			"sha256 of file %s mismatch, expect %x, got %x", name, file.GetSha256(), checksum)
+	}
+	c.record(name, copiedFile{size: size, sha256: checksum})
+	return nil
+}
+
+// rewriteDataFile decrypts the data file, recompresses it if compression is
+// known, and writes it encrypted by the destination cipher.
+func (c *backupCopier) rewriteDataFile(
+	ctx context.Context,
+	file *backuppb.File,
+	compression backuppb.CompressionType,
+) error {
+	content, err := restore.ReadRawSSTFile(ctx, c.src, file, c.srcCipher)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if compression != backuppb.CompressionType_UNKNOWN {
+		if content, err = restore.RewriteRawSST(content, compression); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	content, iv, err := metautil.Encrypt(content, c.dstCipher)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = c.wait(ctx, len(content)); err != nil {
+		return errors.Trace(err)
+	}
+	if err = c.dst.WriteFile(ctx, file.Name, content); err != nil {
+		return errors.Trace(err)
+	}
+	checksum := sha256.Sum256(content)
+	rewritten := proto.Clone(file).(*backuppb.File)
+	rewritten.Sha256 = checksum[:]
+	rewritten.Size_ = uint64(len(content))
+	rewritten.CipherIv = iv
+
+	c.record(file.Name, copiedFile{size: int64(len(content)), sha256: checksum[:]})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rewritten[file.Name] = rewritten
+	return nil
+}
+
+// rewriteRawChecksumFile writes the checksums of the raw ranges encrypted by
+// the destination cipher.
+func (c *backupCopier) rewriteRawChecksumFile(ctx context.Context) error {
+	var checksums []checksum.RawRangeChecksum
+	err := metautil.ReadCheckpointFile(ctx, c.src, c.srcCipher, metautil.RawChecksumFile, &checksums)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(metautil.WriteCheckpointFile(ctx, c.dst, c.dstCipher, metautil.RawChecksumFile, checksums))
+}
+
+// writeBackupMeta writes the backupmeta of the rewritten data files, the
+// metafiles of backupmeta v2 are regenerated too.
+func (c *backupCopier) writeBackupMeta(
+	ctx context.Context,
+	backupMeta *backuppb.BackupMeta,
+	dataFiles []*backuppb.File,
+) error {
+	metaWriter := metautil.NewMetaWriter(c.dst, metautil.MetaFileSize, backupMeta.Version == metautil.MetaV2, c.dstCipher)
+	metaWriter.Update(func(m *backuppb.BackupMeta) {
+		m.ClusterId = backupMeta.ClusterId
+		m.ClusterVersion = backupMeta.ClusterVersion
+		m.BrVersion = backupMeta.BrVersion
+		m.StartVersion = backupMeta.StartVersion
+		m.EndVersion = backupMeta.EndVersion
+		m.IsRawKv = backupMeta.IsRawKv
+		m.RawRanges = backupMeta.RawRanges
+		m.ApiVersion = backupMeta.ApiVersion
+	})
+	metaWriter.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+	for _, f := range dataFiles {
+		if err := metaWriter.Send([]*backuppb.File{c.rewritten[f.Name]}, metautil.AppendDataFile); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := metaWriter.FinishWriteMetas(ctx, metautil.AppendDataFile); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(metaWriter.FlushBackupMeta(ctx))
+}
+
+// verify reads all the written files back to check their sizes and
+// checksums, and reads the backupmeta to check the metafiles.
+func (c *backupCopier) verify(ctx context.Context, dataFileCount int) error {
+	eg, ectx := errgroup.WithContext(ctx)
+	for n, f := range c.copied {
+		name, expected := n, f
+		c.workerPool.ApplyOnErrorGroup(eg, func() error {
+			r, err := c.dst.Open(ectx, name)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			defer r.Close()
+			hash := sha256.New()
+			size, err := io.Copy(hash, r)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if size != expected.size || !bytes.Equal(hash.Sum(nil), expected.sha256) {
+				return errors.Annotatef(berrors.ErrBackupVerifyFailed,
+					"the copied file %s mismatches, expect size %d sha256 %x, got size %d sha256 %x",
+					name, expected.size, expected.sha256, size, hash.Sum(nil))
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+
+	data, err := c.dst.ReadFile(ctx, metautil.MetaFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	backupMeta, err := decodeBackupMeta(data, c.dstCipher)
+	if err != nil {
+		return errors.Annotate(err, "failed to read the copied backupmeta")
+	}
+	reader := metautil.NewMetaReader(backupMeta, c.dst, c.dstCipher)
+	if err = reader.ReadMetaFiles(ctx, func(*backuppb.File) {}); err != nil {
+		return errors.Annotate(err, "failed to read the copied metafiles")
+	}
+	count := 0
+	if err = reader.ReadDataFiles(ctx, func(*backuppb.File) { count++ }); err != nil {
+		return errors.Annotate(err, "failed to read the copied metafiles")
+	}
+	if count != dataFileCount {
+		return errors.Annotatef(berrors.ErrBackupVerifyFailed,
+			"the copied backupmeta has %d data files, expect %d", count, dataFileCount)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRawBackupV2 writes a raw backup whose data files are in the metafiles
+// of backupmeta v2.
+func writeRawBackupV2(t *testing.T, dir string, kvs [][2]string) {
+	ctx := context.Background()
+	writeRawBackup(t, dir, kvs)
+	data, err := os.ReadFile(filepath.Join(dir, metautil.MetaFile))
+	require.NoError(t, err)
+	meta := &backuppb.BackupMeta{}
+	require.NoError(t, proto.Unmarshal(data, meta))
+
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	writer := metautil.NewMetaWriter(s, metautil.MetaFileSize, true,
+		&backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT})
+	writer.Update(func(m *backuppb.BackupMeta) {
+		m.IsRawKv = meta.IsRawKv
+		m.ApiVersion = meta.ApiVersion
+		m.RawRanges = meta.RawRanges
+	})
+	writer.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
+	require.NoError(t, writer.Send(meta.Files, metautil.AppendDataFile))
+	require.NoError(t, writer.FinishWriteMetas(ctx, metautil.AppendDataFile))
+	require.NoError(t, writer.FlushBackupMeta(ctx))
+}
+
+func readCopiedRawKVs(t *testing.T, dir string, cipher *backuppb.CipherInfo) []string {
+	ctx := context.Background()
+	cfg := &Config{Storage: "local://" + dir, CipherInfo: *cipher}
+	_, s, meta, err := ReadBackupMeta(ctx, metautil.MetaFile, cfg)
+	require.NoError(t, err)
+	var kvs []string
+	err = metautil.NewMetaReader(meta, s, cipher).ReadDataFiles(ctx, func(file *backuppb.File) {
+		content, err := restore.ReadRawSSTFile(ctx, s, file, cipher)
+		require.NoError(t, err)
+		require.NoError(t, restore.IterateRawSST(content, func(key, value []byte) error {
+			kvs = append(kvs, string(key)+"="+string(value[:len(value)-8]))
+			return nil
+		}))
+	})
+	require.NoError(t, err)
+	return kvs
+}
+
+func TestRunBackupCopy(t *testing.T) {
+	ctx := context.Background()
+	src := t.TempDir()
+	writeRawBackupV2(t, src, [][2]string{{"a", "1"}, {"b", "2"}, {"c", "3"}})
+	require.NoError(t, os.WriteFile(filepath.Join(src, "backup.lock"), []byte("lock"), 0o644))
+	plaintext := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT}
+
+	dst := t.TempDir()
+	cfg := &BackupCopyConfig{
+		Config: Config{
+			Storage:     "local://" + src,
+			CipherInfo:  *plaintext,
+			Concurrency: 2,
+			RateLimit:   1024 * 1024 * 1024,
+		},
+		To: "local://" + dst,
+	}
+	require.NoError(t, RunBackupCopy(ctx, gluetikv.Glue{}, "backup copy", cfg))
+	for _, name := range []string{"a.sst", "b.sst", "c.sst", "backup.lock", metautil.MetaFile, "backupmeta.datafile.000000001"} {
+		expected, err := os.ReadFile(filepath.Join(src, name))
+		require.NoError(t, err)
+		copied, err := os.ReadFile(filepath.Join(dst, name))
+		require.NoError(t, err)
+		require.Equal(t, expected, copied, name)
+	}
+	require.Equal(t, []string{"a=1", "b=2", "c=3"}, readCopiedRawKVs(t, dst, plaintext))
+
+	// The backup isn't copied to overwrite another backup.
+	err := RunBackupCopy(ctx, gluetikv.Glue{}, "backup copy", cfg)
+	require.True(t, berrors.ErrInvalidArgument.Equal(err))
+
+	// Re-encrypt and recompress the backup.
+	encrypted := t.TempDir()
+	cipher := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_AES128_CTR, CipherKey: []byte("0123456789abcdef")}
+	cfg.Storage = "local://" + dst
+	cfg.To = "local://" + encrypted
+	cfg.ReEncrypt = true
+	cfg.ToCipherInfo = *cipher
+	cfg.ToCompressionType = backuppb.CompressionType_ZSTD
+	require.NoError(t, RunBackupCopy(ctx, gluetikv.Glue{}, "backup copy", cfg))
+	require.Equal(t, []string{"a=1", "b=2", "c=3"}, readCopiedRawKVs(t, encrypted, cipher))
+	_, err = decodeBackupMeta(mustReadFile(t, filepath.Join(encrypted, metautil.MetaFile)), plaintext)
+	require.Error(t, err)
+
+	// Decrypt it back.
+	decrypted := t.TempDir()
+	cfg.Storage = "local://" + encrypted
+	cfg.CipherInfo = *cipher
+	cfg.To = "local://" + decrypted
+	cfg.ToCipherInfo = *plaintext
+	cfg.ToCompressionType = backuppb.CompressionType_UNKNOWN
+	require.NoError(t, RunBackupCopy(ctx, gluetikv.Glue{}, "backup copy", cfg))
+	require.Equal(t, []string{"a=1", "b=2", "c=3"}, readCopiedRawKVs(t, decrypted, plaintext))
+
+	// The corrupted file in the source fails the copy.
+	require.NoError(t, os.WriteFile(filepath.Join(src, "b.sst"), []byte("corrupted"), 0o644))
+	cfg = &BackupCopyConfig{
+		Config: Config{Storage: "local://" + src, CipherInfo: *plaintext, Concurrency: 2},
+		To:     "local://" + t.TempDir(),
+	}
+	err = RunBackupCopy(ctx, gluetikv.Glue{}, "backup copy", cfg)
+	require.True(t, berrors.ErrBackupChecksumMismatch.Equal(err))
+}
+
+func mustReadFile(t *testing.T, name string) []byte {
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	return data
+}
@@ -0,0 +1,376 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/client-go/v2/oracle"
+	"go.uber.org/zap"
+)
+
+const (
+	flagKeepLast   = "keep-last"
+	flagKeepWithin = "keep-within"
+	flagDryRun     = "dry-run"
+)
+
+// BackupGCConfig is the configuration of removing the expired backups under a storage.
+type BackupGCConfig struct {
+	Config
+
+	// KeepLast is the number of the latest backups to keep.
+	KeepLast int `json:"keep-last" toml:"keep-last"`
+	// KeepWithin keeps the backups taken within the duration.
+	KeepWithin time.Duration `json:"keep-within" toml:"keep-within"`
+	// DryRun only shows the backups to remove.
+	DryRun bool `json:"dry-run" toml:"dry-run"`
+}
+
+// DefineBackupGCFlags defines the flags of removing the expired backups.
+func DefineBackupGCFlags(command *cobra.Command) {
+	command.Flags().Int(flagKeepLast, 0, "keep the latest N backups")
+	command.Flags().String(flagKeepWithin, "",
+		"keep the backups taken within the duration, such as 12h or 7d")
+	command.Flags().Bool(flagDryRun, false, "only show the backups to remove without removing them")
+}
+
+// ParseFromFlags parses the backup gc flags from the flag set.
+func (cfg *BackupGCConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.KeepLast, err = flags.GetInt(flagKeepLast)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	keepWithin, err := flags.GetString(flagKeepWithin)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(keepWithin) > 0 {
+		cfg.KeepWithin, err = parseRetentionDuration(keepWithin)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if cfg.KeepLast < 0 || cfg.KeepWithin < 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s and --%s can't be negative", flagKeepLast, flagKeepWithin)
+	}
+	if cfg.KeepLast == 0 && cfg.KeepWithin == 0 {
+		// Refuse to remove all the backups.
+		return errors.Annotatef(berrors.ErrInvalidArgument, "at least one of --%s and --%s is required",
+			flagKeepLast, flagKeepWithin)
+	}
+	cfg.DryRun, err = flags.GetBool(flagDryRun)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// parseRetentionDuration parses a duration which can be in days, such as 7d.
+func parseRetentionDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(s, "d"), 10, 32)
+		if err != nil {
+			return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid duration %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.Annotatef(berrors.ErrInvalidArgument, "invalid duration %s", s)
+	}
+	return d, nil
+}
+
+// backupInfo is a backup found under the storage.
+type backupInfo struct {
+	// Dir is the directory of the backup relative to the storage.
+	Dir  string
+	Meta *backuppb.BackupMeta
+	// Files are all the files of the backup relative to the storage.
+	Files []string
+	// Parents are the backups this incremental backup is based on.
+	Parents []*backupInfo
+}
+
+// backupTime returns when the backup was taken.
+func (b *backupInfo) backupTime() time.Time {
+	return oracle.GetTimeFromTS(b.Meta.EndVersion)
+}
+
+// RunBackupGC removes the backups under the storage which are neither the
+// latest KeepLast ones nor taken within KeepWithin. A backup is kept as long
+// as any kept incremental backup is based on it.
+func RunBackupGC(c context.Context, g glue.Glue, cmdName string, cfg *BackupGCConfig) error {
+	cfg.adjust()
+
+	defer summary.FromContext(c).Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, err := GetStorage(ctx, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	backups, err := discoverBackups(ctx, s, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	expired := selectExpiredBackups(backups, cfg.KeepLast, cfg.KeepWithin, time.Now())
+	collector := summary.FromContext(ctx)
+	collector.CollectInt("backups", len(backups))
+	collector.CollectInt("expired backups", len(expired))
+	for _, b := range expired {
+		log.Info("expired backup",
+			zap.String("dir", b.Dir),
+			zap.Time("backup-time", b.backupTime()),
+			zap.Bool("raw", b.Meta.IsRawKv),
+			zap.Bool("incremental", b.Meta.StartVersion > 0),
+			zap.Int("files", len(b.Files)),
+			zap.Bool("dry-run", cfg.DryRun))
+	}
+	if cfg.DryRun {
+		if err = newBackupGCPlan(expired).write(os.Stdout); err != nil {
+			return errors.Trace(err)
+		}
+		collector.SetSuccessStatus(true)
+		return nil
+	}
+
+	updateCh := g.StartProgress(ctx, cmdName, int64(len(expired)), !cfg.LogProgress)
+	for _, b := range expired {
+		if err = removeBackup(ctx, s, b); err != nil {
+			return errors.Annotatef(err, "failed to remove backup %s", b.Dir)
+		}
+		updateCh.Inc()
+	}
+	updateCh.Close()
+
+	collector.SetSuccessStatus(true)
+	return nil
+}
+
+// backupGCPlan is what the backup gc would remove, it's shown by the dry run.
+type backupGCPlan struct {
+	Type    string               `json:"type"`
+	Expired []backupGCPlanBackup `json:"expired-backups"`
+	Files   int                  `json:"files"`
+}
+
+// backupGCPlanBackup is an expired backup.
+type backupGCPlanBackup struct {
+	Dir         string    `json:"dir"`
+	BackupTime  time.Time `json:"backup-time"`
+	Raw         bool      `json:"raw"`
+	Incremental bool      `json:"incremental"`
+	Files       int       `json:"files"`
+}
+
+func newBackupGCPlan(expired []*backupInfo) *backupGCPlan {
+	plan := &backupGCPlan{Type: eventPlan, Expired: make([]backupGCPlanBackup, 0, len(expired))}
+	for _, b := range expired {
+		plan.Expired = append(plan.Expired, backupGCPlanBackup{
+			Dir:         b.Dir,
+			BackupTime:  b.backupTime().UTC(),
+			Raw:         b.Meta.IsRawKv,
+			Incremental: b.Meta.StartVersion > 0,
+			Files:       len(b.Files),
+		})
+		plan.Files += len(b.Files)
+	}
+	return plan
+}
+
+// write writes the plan to out, or as a JSON event if the JSON output is
+// enabled.
+func (p *backupGCPlan) write(out io.Writer) error {
+	if summary.JSONOutputEnabled() {
+		return errors.Trace(summary.WriteJSONEvent(p))
+	}
+	fmt.Fprintf(out, "remove %d expired backups:\n", len(p.Expired))
+	for _, b := range p.Expired {
+		kind := "txn"
+		if b.Raw {
+			kind = "raw"
+		}
+		if b.Incremental {
+			kind += " incremental"
+		} else {
+			kind += " full"
+		}
+		fmt.Fprintf(out, "  %s: %s backup taken at %s, %d files\n",
+			b.Dir, kind, b.BackupTime.Format(time.RFC3339), b.Files)
+	}
+	fmt.Fprintf(out, "total: %d files\n", p.Files)
+	_, err := fmt.Fprintln(out, "nothing is removed in the dry run")
+	return errors.Trace(err)
+}
+
+// discoverBackups finds the backups in the sub directories of the storage by
+// their backupmeta files, and links each incremental backup to its bases.
+func discoverBackups(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cfg *Config,
+) ([]*backupInfo, error) {
+	var files []string
+	err := s.WalkDir(ctx, &storage.WalkOption{}, func(name string, _ int64) error {
+		files = append(files, strings.TrimPrefix(name, "/"))
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	backups := make([]*backupInfo, 0)
+	for _, name := range files {
+		if path.Base(name) != metautil.MetaFile {
+			continue
+		}
+		dir := path.Dir(name)
+		if dir == "." {
+			// Removing a backup in the root would remove all the other backups.
+			log.Warn("skip the backup in the root of the storage")
+			continue
+		}
+		data, err := s.ReadFile(ctx, name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// The backups encrypted by the master key and by --crypter.key may be
+		// mixed in the storage.
+		cipher := &cfg.CipherInfo
+		dataKeyFile := path.Join(dir, metautil.DataKeyFile)
+		hasDataKey, err := s.FileExists(ctx, dataKeyFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if hasDataKey {
+			if cipher, err = cfg.readDataKey(ctx, s, dataKeyFile); err != nil {
+				return nil, errors.Annotatef(err, "failed to read the data key of %s", dir)
+			}
+		}
+		meta, err := decodeBackupMeta(data, cipher)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to read backupmeta of %s", dir)
+		}
+		backups = append(backups, &backupInfo{Dir: dir, Meta: meta})
+	}
+	// Longer directories first, so that the files of a nested backup don't
+	// belong to the backup containing it.
+	sort.Slice(backups, func(i, j int) bool {
+		return len(backups[i].Dir) > len(backups[j].Dir)
+	})
+	for _, name := range files {
+		for _, b := range backups {
+			if strings.HasPrefix(name, b.Dir+"/") {
+				b.Files = append(b.Files, name)
+				break
+			}
+		}
+	}
+
+	for _, b := range backups {
+		if b.Meta.StartVersion == 0 {
+			continue
+		}
+		for _, base := range backups {
+			if base.Meta.EndVersion == b.Meta.StartVersion && base.Meta.ClusterId == b.Meta.ClusterId &&
+				base.Meta.IsRawKv == b.Meta.IsRawKv {
+				b.Parents = append(b.Parents, base)
+			}
+		}
+	}
+	log.Info("discover backups", zap.Int("backups", len(backups)))
+	return backups, nil
+}
+
+// selectExpiredBackups returns the backups to remove in the order they were taken.
+func selectExpiredBackups(
+	backups []*backupInfo,
+	keepLast int,
+	keepWithin time.Duration,
+	now time.Time,
+) []*backupInfo {
+	sorted := make([]*backupInfo, len(backups))
+	copy(sorted, backups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Meta.EndVersion > sorted[j].Meta.EndVersion
+	})
+
+	kept := make(map[*backupInfo]struct{}, len(sorted))
+	var keep func(b *backupInfo)
+	keep = func(b *backupInfo) {
+		if _, ok := kept[b]; ok {
+			return
+		}
+		kept[b] = struct{}{}
+		// The bases of a kept incremental backup are needed to restore it.
+		for _, parent := range b.Parents {
+			keep(parent)
+		}
+	}
+	for i, b := range sorted {
+		if i < keepLast || (keepWithin > 0 && now.Sub(b.backupTime()) <= keepWithin) {
+			keep(b)
+		}
+	}
+
+	expired := make([]*backupInfo, 0, len(sorted)-len(kept))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if _, ok := kept[sorted[i]]; !ok {
+			expired = append(expired, sorted[i])
+		}
+	}
+	return expired
+}
+
+// removeBackup removes all the files of the backup. The backupmeta and the
+// data key decrypting it are removed at last, so that a failed removal can be
+// retried.
+func removeBackup(ctx context.Context, s storage.ExternalStorage, b *backupInfo) error {
+	metaFile := path.Join(b.Dir, metautil.MetaFile)
+	dataKeyFile := path.Join(b.Dir, metautil.DataKeyFile)
+	hasDataKey := false
+	for _, name := range b.Files {
+		if name == metaFile {
+			continue
+		}
+		if name == dataKeyFile {
+			hasDataKey = true
+			continue
+		}
+		if err := s.DeleteFile(ctx, name); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if err := s.DeleteFile(ctx, metaFile); err != nil {
+		return errors.Trace(err)
+	}
+	if hasDataKey {
+		if err := s.DeleteFile(ctx, dataKeyFile); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	log.Info("remove backup", zap.String("dir", b.Dir), zap.Int("files", len(b.Files)))
+	return nil
+}
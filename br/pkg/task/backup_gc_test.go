@@ -0,0 +1,136 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/stretchr/testify/require"
+	"github.com/tikv/client-go/v2/oracle"
+	"go.uber.org/zap"
+)
+
+func TestParseRetentionDuration(t *testing.T) {
+	d, err := parseRetentionDuration("7d")
+	require.NoError(t, err)
+	require.Equal(t, 7*24*time.Hour, d)
+	d, err = parseRetentionDuration("36h")
+	require.NoError(t, err)
+	require.Equal(t, 36*time.Hour, d)
+	_, err = parseRetentionDuration("-1d")
+	require.Error(t, err)
+	_, err = parseRetentionDuration("week")
+	require.Error(t, err)
+}
+
+func TestSelectExpiredBackups(t *testing.T) {
+	now := time.Now()
+	tsBefore := func(d time.Duration) uint64 {
+		return oracle.GoTimeToTS(now.Add(-d))
+	}
+	day := 24 * time.Hour
+	// full1 <- inc1 <- inc2, full2
+	full1 := &backupInfo{Dir: "full1", Meta: &backuppb.BackupMeta{EndVersion: tsBefore(10 * day)}}
+	inc1 := &backupInfo{Dir: "inc1", Meta: &backuppb.BackupMeta{
+		StartVersion: full1.Meta.EndVersion, EndVersion: tsBefore(9 * day)}, Parents: []*backupInfo{full1}}
+	inc2 := &backupInfo{Dir: "inc2", Meta: &backuppb.BackupMeta{
+		StartVersion: inc1.Meta.EndVersion, EndVersion: tsBefore(8 * day)}, Parents: []*backupInfo{inc1}}
+	full2 := &backupInfo{Dir: "full2", Meta: &backuppb.BackupMeta{EndVersion: tsBefore(2 * day)}}
+	backups := []*backupInfo{full2, inc2, full1, inc1}
+
+	dirs := func(backups []*backupInfo) []string {
+		res := make([]string, 0, len(backups))
+		for _, b := range backups {
+			res = append(res, b.Dir)
+		}
+		return res
+	}
+	require.Equal(t, []string{"full1", "inc1", "inc2"}, dirs(selectExpiredBackups(backups, 1, 0, now)))
+	// The bases of inc2 are kept.
+	require.Empty(t, selectExpiredBackups(backups, 2, 0, now))
+	require.Equal(t, []string{"full1", "inc1", "inc2"}, dirs(selectExpiredBackups(backups, 0, 7*day, now)))
+	// full1 is out of the window but it's the base of inc1.
+	require.Empty(t, selectExpiredBackups([]*backupInfo{full1, inc1}, 0, 9*day+time.Hour, now))
+	require.Empty(t, selectExpiredBackups(backups, 1, 30*day, now))
+}
+
+func TestRunBackupGC(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeMeta := func(name string, meta *backuppb.BackupMeta) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0o755))
+		data, err := proto.Marshal(meta)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name, metautil.MetaFile), data, 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name, "1.sst"), []byte("sst"), 0o644))
+	}
+	full1TS := oracle.GoTimeToTS(now.Add(-72 * time.Hour))
+	writeMeta("full1", &backuppb.BackupMeta{ClusterId: 1, IsRawKv: true, EndVersion: full1TS})
+	writeMeta("inc1", &backuppb.BackupMeta{ClusterId: 1, IsRawKv: true, StartVersion: full1TS,
+		EndVersion: oracle.GoTimeToTS(now.Add(-time.Hour))})
+	writeMeta("full2", &backuppb.BackupMeta{ClusterId: 1, IsRawKv: true,
+		EndVersion: oracle.GoTimeToTS(now.Add(-48 * time.Hour))})
+
+	cfg := &BackupGCConfig{
+		Config: Config{
+			Storage:    "local://" + dir,
+			CipherInfo: backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT},
+		},
+		KeepWithin: 24 * time.Hour,
+		DryRun:     true,
+	}
+	// The summary goes to the collector of the context.
+	var fields []zap.Field
+	collector := summary.NewLogCollector(func(_ string, fs ...zap.Field) { fields = fs })
+	ctx := summary.WithCollector(context.Background(), collector)
+	require.NoError(t, RunBackupGC(ctx, gluetikv.Glue{}, "backup gc", cfg))
+	require.Contains(t, fields, zap.Int("expired-backups", 1))
+	require.FileExists(t, filepath.Join(dir, "full2", "1.sst"))
+
+	cfg.DryRun = false
+	require.NoError(t, RunBackupGC(context.Background(), gluetikv.Glue{}, "backup gc", cfg))
+	require.NoFileExists(t, filepath.Join(dir, "full2", "1.sst"))
+	require.NoFileExists(t, filepath.Join(dir, "full2", metautil.MetaFile))
+	// full1 is the base of inc1.
+	require.FileExists(t, filepath.Join(dir, "full1", "1.sst"))
+	require.FileExists(t, filepath.Join(dir, "inc1", metautil.MetaFile))
+}
+
+func TestBackupGCPlan(t *testing.T) {
+	backupTime := time.Date(2022, 3, 1, 8, 0, 0, 0, time.UTC)
+	plan := newBackupGCPlan([]*backupInfo{
+		{Dir: "full1", Meta: &backuppb.BackupMeta{IsRawKv: true, EndVersion: oracle.GoTimeToTS(backupTime)},
+			Files: []string{"full1/backupmeta", "full1/1.sst"}},
+		{Dir: "inc1", Meta: &backuppb.BackupMeta{StartVersion: 1, EndVersion: oracle.GoTimeToTS(backupTime.Add(time.Hour))},
+			Files: []string{"inc1/backupmeta"}},
+	})
+	out := &bytes.Buffer{}
+	require.NoError(t, plan.write(out))
+	require.Equal(t, `remove 2 expired backups:
+  full1: raw full backup taken at 2022-03-01T08:00:00Z, 2 files
+  inc1: txn incremental backup taken at 2022-03-01T09:00:00Z, 1 files
+total: 3 files
+nothing is removed in the dry run
+`, out.String())
+
+	// The plan is written as a JSON event in the JSON output.
+	summary.SetJSONOutput(out)
+	defer summary.SetJSONOutput(nil)
+	out.Reset()
+	require.NoError(t, plan.write(out))
+	decoded := &backupGCPlan{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), decoded))
+	require.Equal(t, plan, decoded)
+}
@@ -5,88 +5,278 @@ package task
 import (
 	"bytes"
 	"context"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/pingcap/errors"
 	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/client-go/v2/oracle"
 	"github.com/tikv/migration/br/pkg/backup"
+	"github.com/tikv/migration/br/pkg/checksum"
 	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/metautil"
-	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/redact"
 	"github.com/tikv/migration/br/pkg/storage"
 	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/tikv/migration/br/pkg/utils"
-	"github.com/spf13/cobra"
-	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
 
 const (
-	flagKeyFormat        = "format"
-	flagTiKVColumnFamily = "cf"
-	flagStartKey         = "start"
-	flagEndKey           = "end"
+	flagKeyFormat          = "format"
+	flagTiKVColumnFamily   = "cf"
+	flagStartKey           = "start"
+	flagEndKey             = "end"
+	flagRawRange           = "range"
+	flagRawRangesFile      = "ranges-file"
+	flagResume             = "resume"
+	flagCheckpointInterval = "checkpoint-interval"
+
+	defaultRawCF = "default"
+
+	defaultCheckpointInterval = 30 * time.Second
 )
 
 // RawKvConfig is the common config for rawkv backup and restore.
 type RawKvConfig struct {
 	Config
 
-	StartKey []byte `json:"start-key" toml:"start-key"`
-	EndKey   []byte `json:"end-key" toml:"end-key"`
-	CF       string `json:"cf" toml:"cf"`
+	// Ranges are the disjoint key ranges sorted by the start key. An empty
+	// Ranges means the whole key space for backup, and all the backed up
+	// ranges for restore.
+	Ranges []KeyRange `json:"ranges" toml:"ranges"`
+	CFs    []string   `json:"cfs" toml:"cfs"`
 	CompressionConfig
 	RemoveSchedulers bool `json:"remove-schedulers" toml:"remove-schedulers"`
+
+	// TimeAgo, BackupTS, LastBackupTS and GCTTL are only used by raw backup.
+	// A raw backup with a non-zero LastBackupTS is an incremental backup which
+	// only contains the keys changed in (LastBackupTS, BackupTS], it's only
+	// supported in an API V2 cluster.
+	TimeAgo      time.Duration `json:"time-ago" toml:"time-ago"`
+	BackupTS     uint64        `json:"backup-ts" toml:"backup-ts"`
+	LastBackupTS uint64        `json:"last-backup-ts" toml:"last-backup-ts"`
+	GCTTL        int64         `json:"gc-ttl" toml:"gc-ttl"`
+
+	// The progress is saved to the storage every CheckpointInterval, and a
+	// failed task can be resumed from it with the same config when Resume is set.
+	Resume             bool          `json:"resume" toml:"resume"`
+	CheckpointInterval time.Duration `json:"checkpoint-interval" toml:"checkpoint-interval"`
+}
+
+// KeyRange is the raw key range [StartKey, EndKey), an empty EndKey means
+// the max key.
+type KeyRange struct {
+	StartKey []byte `json:"start-key" toml:"start-key"`
+	EndKey   []byte `json:"end-key" toml:"end-key"`
 }
 
 // DefineRawBackupFlags defines common flags for the backup command.
 func DefineRawBackupFlags(command *cobra.Command) {
 	command.Flags().StringP(flagKeyFormat, "", "hex", "start/end key format, support raw|escaped|hex")
-	command.Flags().StringP(flagTiKVColumnFamily, "", "default", "backup specify cf, correspond to tikv cf")
+	command.Flags().StringSlice(flagTiKVColumnFamily, []string{defaultRawCF},
+		"backup specify cfs, correspond to tikv cf, multiple cfs are separated by comma")
 	command.Flags().StringP(flagStartKey, "", "", "backup raw kv start key, key is inclusive")
 	command.Flags().StringP(flagEndKey, "", "", "backup raw kv end key, key is exclusive")
+	command.Flags().StringArray(flagRawRange, nil,
+		"backup raw kv range in the format of 'start:end', can be specified multiple times, "+
+			"and can't be used with --start and --end")
+	command.Flags().String(flagRawRangesFile, "",
+		"the local file of raw kv ranges to backup, one 'start:end' range per line")
 	command.Flags().String(flagCompressionType, "zstd",
 		"backup sst file compression algorithm, value can be one of 'lz4|zstd|snappy'")
 	command.Flags().Bool(flagRemoveSchedulers, false,
 		"disable the balance, shuffle and region-merge schedulers in PD to speed up backup")
 	// This flag can impact the online cluster, so hide it in case of abuse.
 	_ = command.Flags().MarkHidden(flagRemoveSchedulers)
+	command.Flags().Bool(flagResume, false,
+		"resume the unfinished backup in the storage from its checkpoint, only the ranges not backed up yet are backed up")
+	command.Flags().Duration(flagCheckpointInterval, defaultCheckpointInterval,
+		"the interval of saving the checkpoint of the backed up ranges to the storage")
+	_ = command.Flags().MarkHidden(flagCheckpointInterval)
 }
 
 // ParseFromFlags parses the raw kv backup&restore common flags from the flag set.
 func (cfg *RawKvConfig) ParseFromFlags(flags *pflag.FlagSet) error {
-	format, err := flags.GetString(flagKeyFormat)
+	var err error
+	cfg.Ranges, err = parseRawRanges(flags)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	start, err := flags.GetString(flagStartKey)
+	cfg.CFs, err = flags.GetStringSlice(flagTiKVColumnFamily)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	cfg.StartKey, err = utils.ParseKey(format, start)
-	if err != nil {
+	if err = checkRawCFs(cfg.CFs); err != nil {
 		return errors.Trace(err)
 	}
+	if err = cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// DefaultRawBackupConfig returns the raw backup config with the default values
+// of the flags, the storage and the PD addresses must be set to run a backup.
+func DefaultRawBackupConfig() RawKvConfig {
+	cfg := RawKvConfig{}
+	if err := parseDefaultFlags(DefineBackupFlags, DefineRawBackupFlags, cfg.ParseBackupConfigFromFlags); err != nil {
+		log.Panic("failed to parse the default raw backup config", zap.Error(err))
+	}
+	return cfg
+}
+
+// Validate checks the config which isn't parsed from the flags, the ranges
+// are sorted by the start key.
+func (cfg *RawKvConfig) Validate() error {
+	for _, rg := range cfg.Ranges {
+		if len(rg.StartKey) > 0 && len(rg.EndKey) > 0 && bytes.Compare(rg.StartKey, rg.EndKey) >= 0 {
+			return errors.Annotate(berrors.ErrBackupInvalidRange, "endKey must be greater than startKey")
+		}
+	}
+	if err := sortRawRanges(cfg.Ranges); err != nil {
+		return errors.Trace(err)
+	}
+	if err := checkRawCFs(cfg.CFs); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.PD) == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "must provide at least one PD server address")
+	}
+	return cfg.normalizePDURLs()
+}
+
+// checkRawIncrementalBackup checks that the cluster keeps the versions of the
+// raw keys, which only API V2 does. TiKV ignores the versions of the raw
+// backup otherwise, and an incremental backup would be a full backup.
+func checkRawIncrementalBackup(apiVersion kvrpcpb.APIVersion) error {
+	if apiVersion != kvrpcpb.APIVersion_V2 {
+		return errors.Annotatef(berrors.ErrUnsupportedOperation,
+			"--%s is only supported in an API V2 cluster, but the cluster is API %s", flagLastBackupTS, apiVersion)
+	}
+	return nil
+}
+
+// parseRawRanges parses the ranges from --start and --end, or from --range and
+// --ranges-file, and returns them sorted by the start key.
+func parseRawRanges(flags *pflag.FlagSet) ([]KeyRange, error) {
+	format, err := flags.GetString(flagKeyFormat)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	start, err := flags.GetString(flagStartKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	end, err := flags.GetString(flagEndKey)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
 	}
-	cfg.EndKey, err = utils.ParseKey(format, end)
+	rangeStrs, err := flags.GetStringArray(flagRawRange)
 	if err != nil {
-		return errors.Trace(err)
+		return nil, errors.Trace(err)
+	}
+	rangesFile, err := flags.GetString(flagRawRangesFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(rangesFile) > 0 {
+		content, err := os.ReadFile(rangesFile)
+		if err != nil {
+			return nil, errors.Annotatef(err, "failed to read ranges file %s", rangesFile)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			rangeStrs = append(rangeStrs, line)
+		}
 	}
 
-	if len(cfg.StartKey) > 0 && len(cfg.EndKey) > 0 && bytes.Compare(cfg.StartKey, cfg.EndKey) >= 0 {
-		return errors.Annotate(berrors.ErrBackupInvalidRange, "endKey must be greater than startKey")
+	ranges := make([]KeyRange, 0, len(rangeStrs))
+	if len(start) > 0 || len(end) > 0 {
+		if len(rangeStrs) > 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s and --%s can't be used with --%s or --%s", flagStartKey, flagEndKey, flagRawRange, flagRawRangesFile)
+		}
+		rg, err := parseKeyRange(format, start, end)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ranges = append(ranges, rg)
+	}
+	for _, str := range rangeStrs {
+		keys := strings.Split(str, ":")
+		if len(keys) != 2 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"range must be in the format of 'start:end', %s is not allowed", str)
+		}
+		rg, err := parseKeyRange(format, keys[0], keys[1])
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		ranges = append(ranges, rg)
+	}
+	if err = sortRawRanges(ranges); err != nil {
+		return nil, errors.Trace(err)
 	}
-	cfg.CF, err = flags.GetString(flagTiKVColumnFamily)
+	return ranges, nil
+}
+
+func parseKeyRange(format, start, end string) (KeyRange, error) {
+	startKey, err := utils.ParseKey(format, start)
 	if err != nil {
-		return errors.Trace(err)
+		return KeyRange{}, errors.Trace(err)
 	}
-	if err = cfg.Config.ParseFromFlags(flags); err != nil {
-		return errors.Trace(err)
+	endKey, err := utils.ParseKey(format, end)
+	if err != nil {
+		return KeyRange{}, errors.Trace(err)
+	}
+	if len(startKey) > 0 && len(endKey) > 0 && bytes.Compare(startKey, endKey) >= 0 {
+		return KeyRange{}, errors.Annotate(berrors.ErrBackupInvalidRange, "endKey must be greater than startKey")
+	}
+	return KeyRange{StartKey: startKey, EndKey: endKey}, nil
+}
+
+// sortRawRanges sorts the ranges by the start key and checks they don't overlap.
+func sortRawRanges(ranges []KeyRange) error {
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].StartKey, ranges[j].StartKey) < 0
+	})
+	for i := 1; i < len(ranges); i++ {
+		prev, cur := ranges[i-1], ranges[i]
+		if utils.CompareEndKey(prev.EndKey, cur.StartKey) > 0 {
+			return errors.Annotatef(berrors.ErrBackupInvalidRange,
+				"range [%s, %s) overlaps with range [%s, %s)",
+				redact.Key(prev.StartKey), redact.Key(prev.EndKey), redact.Key(cur.StartKey), redact.Key(cur.EndKey))
+		}
+	}
+	return nil
+}
+
+func checkRawCFs(cfs []string) error {
+	if len(cfs) == 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "at least one cf must be specified")
+	}
+	seen := make(map[string]struct{}, len(cfs))
+	for _, cf := range cfs {
+		if len(cf) == 0 {
+			return errors.Annotate(berrors.ErrInvalidArgument, "cf can't be empty")
+		}
+		if _, ok := seen[cf]; ok {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "duplicated cf %s", cf)
+		}
+		seen[cf] = struct{}{}
 	}
 	return nil
 }
@@ -114,6 +304,50 @@ func (cfg *RawKvConfig) ParseBackupConfigFromFlags(flags *pflag.FlagSet) error {
 	}
 	cfg.CompressionLevel = level
 
+	cfg.TimeAgo, err = flags.GetDuration(flagBackupTimeago)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.TimeAgo < 0 {
+		return errors.Annotate(berrors.ErrInvalidArgument, "negative timeago is not allowed")
+	}
+	cfg.LastBackupTS, err = flags.GetUint64(flagLastBackupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	backupTS, err := flags.GetString(flagBackupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.BackupTS, err = parseTSString(backupTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.GCTTL, err = flags.GetInt64(flagGCTTL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseCheckpointFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+// parseCheckpointFlags parses the flags of resuming from the checkpoint.
+func (cfg *RawKvConfig) parseCheckpointFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.Resume, err = flags.GetBool(flagResume)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CheckpointInterval, err = flags.GetDuration(flagCheckpointInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.CheckpointInterval <= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s must be positive", flagCheckpointInterval)
+	}
 	return nil
 }
 
@@ -121,7 +355,7 @@ func (cfg *RawKvConfig) ParseBackupConfigFromFlags(flags *pflag.FlagSet) error {
 func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConfig) error {
 	cfg.adjust()
 
-	defer summary.Summary(cmdName)
+	defer summary.FromContext(c).Summary(cmdName)
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
 
@@ -150,12 +384,135 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 	opts := storage.ExternalStorageOptions{
 		NoCredentials:   cfg.NoCreds,
 		SendCredentials: cfg.SendCreds,
+		HDFS:            cfg.HDFS,
 	}
 	if err = client.SetStorage(ctx, u, &opts); err != nil {
 		return errors.Trace(err)
 	}
+	client.SetGCTTL(cfg.GCTTL)
+	if err = cfg.GenerateDataKey(ctx, client.GetStorage()); err != nil {
+		return errors.Trace(err)
+	}
+	stopRelay, err := cfg.relayBackup(client)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer stopRelay()
+
+	// The values are encoded with the expire timestamps when ttl is enabled,
+	// and the keys are encoded with the keyspaces in API V2, so restore must
+	// know the api version of the cluster.
+	apiVersion, err := mgr.GetAPIVersion(ctx)
+	if err != nil {
+		return errors.Annotate(err, "failed to get the api version of the cluster")
+	}
+	log.Info("raw backup api version", zap.Stringer("api-version", apiVersion))
+
+	checkpoint, err := backup.LoadCheckpointRunner(ctx, client.GetStorage(), &cfg.CipherInfo)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if checkpoint != nil && !cfg.Resume {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"there is an unfinished backup in %s, please use --%s to resume it or specify another directory",
+			client.GetStorage().URI(), flagResume)
+	}
+
+	var backupTS uint64
+	if checkpoint != nil {
+		// The resumed ranges must be backed up at the same ts as the checkpoint.
+		backupTS = checkpoint.Meta().EndVersion
+		log.Info("resume backup from checkpoint", zap.Uint64("backupTS", backupTS))
+	} else {
+		if cfg.Resume {
+			log.Info("no checkpoint found, start a new backup")
+		}
+		backupTS, err = client.GetTS(ctx, cfg.TimeAgo, cfg.BackupTS)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	g.Record(ctx, "BackupTS", backupTS)
+	if apiVersion == kvrpcpb.APIVersion_V1TTL {
+		// The expired keys are invisible but kept in the cluster until
+		// compacted, they are removed from the backed up files by rewriting.
+		if cfg.CompressionType != backuppb.CompressionType_SNAPPY && cfg.CompressionType != backuppb.CompressionType_ZSTD {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"the backup of a cluster with ttl enabled must be compressed by snappy or zstd, but it's %s",
+				cfg.CompressionType)
+		}
+		client.SetRawExpireTS(uint64(oracle.GetTimeFromTS(backupTS).Unix()))
+	}
 
-	backupRange := rtree.Range{StartKey: cfg.StartKey, EndKey: cfg.EndKey}
+	isIncrementalBackup := cfg.LastBackupTS > 0
+	if isIncrementalBackup {
+		if err = checkRawIncrementalBackup(apiVersion); err != nil {
+			return errors.Trace(err)
+		}
+		if backupTS <= cfg.LastBackupTS {
+			log.Error("LastBackupTS is larger or equal to current TS")
+			return errors.Annotate(berrors.ErrInvalidArgument, "LastBackupTS is larger or equal to current TS")
+		}
+		err = utils.CheckGCSafePoint(ctx, mgr.GetPDClient(), cfg.LastBackupTS)
+		if err != nil {
+			log.Error("Check gc safepoint for last backup ts failed", zap.Error(err))
+			return errors.Trace(err)
+		}
+		// Keep the versions between LastBackupTS and backupTS from being
+		// garbage collected until the incremental backup finishes.
+		sp := utils.BRServiceSafePoint{
+			BackupTS: cfg.LastBackupTS,
+			TTL:      client.GetGCTTL(),
+			ID:       utils.MakeSafePointID(),
+		}
+		log.Info("current backup safePoint job", zap.Object("safePoint", sp))
+		err = utils.StartServiceSafePointKeeper(ctx, mgr.GetPDClient(), sp)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	backupRanges := cfg.Ranges
+	if len(backupRanges) == 0 {
+		// Backup the whole key space by default.
+		backupRanges = []KeyRange{{}}
+	}
+	rawRanges := make([]*backuppb.RawRange, 0, len(backupRanges)*len(cfg.CFs))
+	for _, cf := range cfg.CFs {
+		for _, rg := range backupRanges {
+			rawRanges = append(rawRanges, &backuppb.RawRange{StartKey: rg.StartKey, EndKey: rg.EndKey, Cf: cf})
+		}
+	}
+
+	checkpointMeta := backup.CheckpointMeta{
+		ClusterID:    client.GetClusterID(),
+		StartVersion: cfg.LastBackupTS,
+		EndVersion:   backupTS,
+		IsRawKv:      true,
+		RawRanges:    rawRanges,
+	}
+	if checkpoint != nil {
+		loadedMeta := checkpoint.Meta()
+		if err = loadedMeta.Check(&checkpointMeta); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		checkpoint, err = backup.NewCheckpointRunner(ctx, client.GetStorage(), &cfg.CipherInfo, checkpointMeta)
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	client.SetCheckpointRunner(checkpoint)
+	checkpointCtx, cancelCheckpoint := context.WithCancel(ctx)
+	go checkpoint.FlushPeriodically(checkpointCtx, cfg.CheckpointInterval)
+	defer func() {
+		cancelCheckpoint()
+		// Save the ranges backed up so far if the backup fails, nothing is
+		// saved if the checkpoint has been removed.
+		if err := checkpoint.Flush(context.Background()); err != nil {
+			log.Warn("failed to flush backup checkpoint", zap.Error(err))
+		}
+	}()
 
 	if cfg.RemoveSchedulers {
 		restore, e := mgr.RemoveSchedulers(ctx)
@@ -179,13 +536,17 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 		return errors.Trace(err)
 	}
 
-	// The number of regions need to backup
-	approximateRegions, err := mgr.GetRegionCount(ctx, backupRange.StartKey, backupRange.EndKey)
-	if err != nil {
-		return errors.Trace(err)
+	// The number of regions need to backup, each cf is backed up separately.
+	approximateRegions := 0
+	for _, rg := range backupRanges {
+		regionCount, err := mgr.GetRegionCount(ctx, rg.StartKey, rg.EndKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		approximateRegions += regionCount * len(cfg.CFs)
 	}
 
-	summary.CollectInt("backup total regions", approximateRegions)
+	summary.FromContext(ctx).CollectInt("backup total regions", approximateRegions)
 
 	// Backup
 	// Redirect to log if there is no log file to avoid unreadable output.
@@ -198,28 +559,35 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 		}
 		updateCh.Inc()
 	}
+	// Each range backed up before resuming is about a region.
+	for i := 0; i < checkpoint.LoadedRanges(); i++ {
+		updateCh.Inc()
+	}
 
 	req := backuppb.BackupRequest{
 		ClusterId:        client.GetClusterID(),
-		StartVersion:     0,
-		EndVersion:       0,
+		StartVersion:     cfg.LastBackupTS,
+		EndVersion:       backupTS,
 		RateLimit:        cfg.RateLimit,
 		Concurrency:      cfg.Concurrency,
 		IsRawKv:          true,
-		Cf:               cfg.CF,
 		CompressionType:  cfg.CompressionType,
 		CompressionLevel: cfg.CompressionLevel,
 		CipherInfo:       &cfg.CipherInfo,
 	}
 	metaWriter := metautil.NewMetaWriter(client.GetStorage(), metautil.MetaFileSize, false, &cfg.CipherInfo)
 	metaWriter.StartWriteMetasAsync(ctx, metautil.AppendDataFile)
-	err = client.BackupRange(ctx, backupRange.StartKey, backupRange.EndKey, req, metaWriter, progressCallBack)
-	if err != nil {
-		return errors.Trace(err)
+	// All the ranges of all the cfs are backed up at the same backupTS, so
+	// they are consistent with each other.
+	for _, rg := range rawRanges {
+		req.Cf = rg.Cf
+		err = client.BackupRange(ctx, rg.StartKey, rg.EndKey, req, metaWriter, progressCallBack)
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
 	// Backup has finished
 	updateCh.Close()
-	rawRanges := []*backuppb.RawRange{{StartKey: backupRange.StartKey, EndKey: backupRange.EndKey, Cf: cfg.CF}}
 	metaWriter.Update(func(m *backuppb.BackupMeta) {
 		m.StartVersion = req.StartVersion
 		m.EndVersion = req.EndVersion
@@ -228,20 +596,43 @@ func RunBackupRaw(c context.Context, g glue.Glue, cmdName string, cfg *RawKvConf
 		m.ClusterId = req.ClusterId
 		m.ClusterVersion = clusterVersion
 		m.BrVersion = brVersion
+		m.ApiVersion = apiVersion
 	})
 	err = metaWriter.FinishWriteMetas(ctx, metautil.AppendDataFile)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	// The checksum of each range is recorded along with the backupmeta, raw
+	// restore verifies the restored ranges against them.
+	rangeChecksums := checksum.CalcRawRangeChecksums(rawRanges, metaWriter.Backupmeta().Files)
+	for _, c := range rangeChecksums {
+		log.Info("raw backup checksum",
+			logutil.Key("startKey", c.StartKey),
+			logutil.Key("endKey", c.EndKey),
+			zap.String("cf", c.CF),
+			zap.Uint64("crc64xor", c.Crc64Xor),
+			zap.Uint64("total kvs", c.TotalKvs),
+			zap.Uint64("total bytes", c.TotalBytes))
+	}
+	err = metautil.WriteCheckpointFile(
+		ctx, client.GetStorage(), &cfg.CipherInfo, metautil.RawChecksumFile, rangeChecksums)
+	if err != nil {
+		return errors.Trace(err)
+	}
 
 	err = metaWriter.FlushBackupMeta(ctx)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
-	g.Record(summary.BackupDataSize, metaWriter.ArchiveSize())
+	// The backup is finished, the checkpoint is useless.
+	if err = checkpoint.Remove(ctx); err != nil {
+		log.Warn("failed to remove backup checkpoint", zap.Error(err))
+	}
+
+	g.Record(ctx, summary.BackupDataSize, metaWriter.ArchiveSize())
 
 	// Set task summary to success status.
-	summary.SetSuccessStatus(true)
+	summary.FromContext(ctx).SetSuccessStatus(true)
 	return nil
 }
@@ -3,10 +3,15 @@
 package task
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	backup "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/require"
 )
 
@@ -52,3 +57,75 @@ func TestParseCompressionType(t *testing.T) {
 	require.Regexp(t, "invalid compression.*", err.Error())
 	require.Zero(t, ct)
 }
+
+func TestParseRawRanges(t *testing.T) {
+	parse := func(args ...string) ([]KeyRange, error) {
+		command := &cobra.Command{}
+		DefineRawBackupFlags(command)
+		require.NoError(t, command.Flags().Parse(args))
+		return parseRawRanges(command.Flags())
+	}
+
+	ranges, err := parse()
+	require.NoError(t, err)
+	require.Len(t, ranges, 0)
+
+	ranges, err = parse("--format", "raw", "--start", "a", "--end", "b")
+	require.NoError(t, err)
+	require.Equal(t, []KeyRange{{StartKey: []byte("a"), EndKey: []byte("b")}}, ranges)
+
+	rangesFile := filepath.Join(t.TempDir(), "ranges")
+	require.NoError(t, os.WriteFile(rangesFile, []byte("# comment\nc:d\n\ne:\n"), 0o644))
+	ranges, err = parse("--format", "raw", "--range", "a:b", "--ranges-file", rangesFile)
+	require.NoError(t, err)
+	require.Equal(t, []KeyRange{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("c"), EndKey: []byte("d")},
+		{StartKey: []byte("e"), EndKey: []byte{}},
+	}, ranges)
+
+	_, err = parse("--format", "raw", "--start", "a", "--range", "c:d")
+	require.True(t, berrors.ErrInvalidArgument.Equal(err))
+	_, err = parse("--format", "raw", "--range", "a")
+	require.True(t, berrors.ErrInvalidArgument.Equal(err))
+	_, err = parse("--format", "raw", "--range", "b:a")
+	require.True(t, berrors.ErrBackupInvalidRange.Equal(err))
+	_, err = parse("--format", "raw", "--range", "c:", "--range", "a:d")
+	require.True(t, berrors.ErrBackupInvalidRange.Equal(err))
+}
+
+func TestCheckRawCFs(t *testing.T) {
+	require.NoError(t, checkRawCFs([]string{"default", "write"}))
+	require.Error(t, checkRawCFs(nil))
+	require.Error(t, checkRawCFs([]string{""}))
+	require.Error(t, checkRawCFs([]string{"default", "default"}))
+}
+
+func TestCheckRawIncrementalBackup(t *testing.T) {
+	require.NoError(t, checkRawIncrementalBackup(kvrpcpb.APIVersion_V2))
+	for _, v := range []kvrpcpb.APIVersion{kvrpcpb.APIVersion_V1, kvrpcpb.APIVersion_V1TTL} {
+		require.True(t, berrors.ErrUnsupportedOperation.Equal(checkRawIncrementalBackup(v)))
+	}
+}
+
+func TestDefaultRawBackupConfig(t *testing.T) {
+	cfg := DefaultRawBackupConfig()
+	require.Equal(t, []string{defaultRawCF}, cfg.CFs)
+	require.Equal(t, backup.CompressionType_ZSTD, cfg.CompressionType)
+	require.Equal(t, defaultCheckpointInterval, cfg.CheckpointInterval)
+	require.True(t, cfg.Checksum)
+
+	cfg.PD = []string{"http://127.0.0.1:2379"}
+	cfg.Ranges = []KeyRange{
+		{StartKey: []byte("c"), EndKey: []byte("d")},
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+	}
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []string{"127.0.0.1:2379"}, cfg.PD)
+	require.Equal(t, []byte("a"), cfg.Ranges[0].StartKey)
+
+	cfg.Ranges = append(cfg.Ranges, KeyRange{StartKey: []byte("a1"), EndKey: []byte("a2")})
+	require.True(t, berrors.ErrBackupInvalidRange.Equal(cfg.Validate()))
+	cfg.Ranges = []KeyRange{{StartKey: []byte("b"), EndKey: []byte("a")}}
+	require.True(t, berrors.ErrBackupInvalidRange.Equal(cfg.Validate()))
+}
@@ -80,6 +80,7 @@ const (
 	flagCipherType    = "crypter.method"
 	flagCipherKey     = "crypter.key"
 	flagCipherKeyFile = "crypter.key-file"
+	flagMasterKey     = "crypter.master-key"
 
 	unlimited           = 0
 	crypterAES128KeyLen = 16
@@ -168,6 +169,14 @@ type Config struct {
 	GRPCKeepaliveTimeout time.Duration `json:"grpc-keepalive-timeout" toml:"grpc-keepalive-timeout"`
 
 	CipherInfo backuppb.CipherInfo `json:"-" toml:"-"`
+	// MasterKey is the url of the master key encrypting the data key, which
+	// is generated for each backup.
+	MasterKey string `json:"master-key" toml:"master-key"`
+
+	// Relay makes TiKV access the storage through BR, see relay.Server.
+	Relay              bool   `json:"relay" toml:"relay"`
+	RelayAddr          string `json:"relay-addr" toml:"relay-addr"`
+	RelayAdvertiseAddr string `json:"relay-advertise-addr" toml:"relay-advertise-addr"`
 }
 
 // DefineCommonFlags defines the flags common to all BRIE commands.
@@ -222,7 +231,11 @@ func DefineCommonFlags(flags *pflag.FlagSet) {
 		"aes-crypter key, used to encrypt/decrypt the data "+
 			"by the hexadecimal string, eg: \"0123456789abcdef0123456789abcdef\"")
 	flags.String(flagCipherKeyFile, "", "FilePath, its content is used as the cipher-key")
+	flags.String(flagMasterKey, "",
+		"the master key to encrypt/decrypt the data key generated for each backup, instead of --crypter.key, "+
+			"support local:///path/to/key-file|vault://host:port/<mount>/<key-name>|aws-kms:///<key-id>")
 
+	defineRelayFlags(flags)
 	storage.DefineFlags(flags)
 }
 
@@ -335,10 +348,6 @@ func (cfg *Config) parseCipherInfo(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 
-	if cfg.CipherInfo.CipherType == encryptionpb.EncryptionMethod_PLAINTEXT {
-		return nil
-	}
-
 	key, err := flags.GetString(flagCipherKey)
 	if err != nil {
 		return errors.Trace(err)
@@ -349,6 +358,23 @@ func (cfg *Config) parseCipherInfo(flags *pflag.FlagSet) error {
 		return errors.Trace(err)
 	}
 
+	cfg.MasterKey, err = flags.GetString(flagMasterKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.MasterKey) > 0 {
+		if len(key) > 0 || len(keyFilePath) > 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s can't be used with --%s or --%s", flagMasterKey, flagCipherKey, flagCipherKeyFile)
+		}
+		// The data key is generated by backup, and read from the backup by the others.
+		return nil
+	}
+
+	if cfg.CipherInfo.CipherType == encryptionpb.EncryptionMethod_PLAINTEXT {
+		return nil
+	}
+
 	cfg.CipherInfo.CipherKey, err = getCipherKeyContent(key, keyFilePath)
 	if err != nil {
 		return errors.Trace(err)
@@ -361,6 +387,92 @@ func (cfg *Config) parseCipherInfo(flags *pflag.FlagSet) error {
 	return nil
 }
 
+// GenerateDataKey generates the data key of the backup in the storage and
+// stores it encrypted by the master key, if the master key is specified.
+// The data key stored by the interrupted backup is used to resume it.
+func (cfg *Config) GenerateDataKey(ctx context.Context, s storage.ExternalStorage) error {
+	if len(cfg.MasterKey) == 0 {
+		return nil
+	}
+	if cfg.CipherInfo.CipherType == encryptionpb.EncryptionMethod_PLAINTEXT {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s to be one of aes*",
+			flagMasterKey, flagCipherType)
+	}
+	masterKey, err := metautil.NewMasterKey(cfg.MasterKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	exists, err := s.FileExists(ctx, metautil.DataKeyFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var dataKey *backuppb.CipherInfo
+	if exists {
+		dataKey, err = metautil.ReadDataKey(ctx, s, metautil.DataKeyFile, masterKey)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if dataKey.CipherType != cfg.CipherInfo.CipherType {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"the data key in %s is for %s, but --%s is %s",
+				s.URI(), dataKey.CipherType, flagCipherType, cfg.CipherInfo.CipherType)
+		}
+		log.Info("use the existing data key", zap.Stringer("master-key", masterKey))
+	} else {
+		dataKey, err = metautil.GenerateDataKey(cfg.CipherInfo.CipherType)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = metautil.WriteDataKey(ctx, s, metautil.DataKeyFile, masterKey, dataKey); err != nil {
+			return errors.Trace(err)
+		}
+		log.Info("generate data key", zap.Stringer("master-key", masterKey))
+	}
+	cfg.CipherInfo = *dataKey
+	return nil
+}
+
+// LoadDataKey loads the data key of the backup in the storage to CipherInfo,
+// if the backup is encrypted by a master key.
+func (cfg *Config) LoadDataKey(ctx context.Context, s storage.ExternalStorage) error {
+	cipher, err := cfg.readDataKey(ctx, s, metautil.DataKeyFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CipherInfo = *cipher
+	return nil
+}
+
+// readDataKey returns the cipher to decrypt the backup whose data key is
+// stored in the file, which is CipherInfo if the backup has no data key.
+func (cfg *Config) readDataKey(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	name string,
+) (*backuppb.CipherInfo, error) {
+	exists, err := s.FileExists(ctx, name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !exists {
+		if len(cfg.MasterKey) > 0 {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+				"the backup isn't encrypted by a master key, %s not found", name)
+		}
+		return &cfg.CipherInfo, nil
+	}
+	if len(cfg.MasterKey) == 0 {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"the backup is encrypted by a master key, please specify --%s", flagMasterKey)
+	}
+	masterKey, err := metautil.NewMasterKey(cfg.MasterKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cipher, err := metautil.ReadDataKey(ctx, s, name, masterKey)
+	return cipher, errors.Trace(err)
+}
+
 func (cfg *Config) normalizePDURLs() error {
 	for i := range cfg.PD {
 		var err error
@@ -372,6 +484,27 @@ func (cfg *Config) normalizePDURLs() error {
 	return nil
 }
 
+// parseDefaultFlags defines the flags of a subcommand the same way as the
+// command line, and parses their default values by parse.
+func parseDefaultFlags(
+	defineParentFlags func(flags *pflag.FlagSet),
+	defineFlags func(command *cobra.Command),
+	parse func(flags *pflag.FlagSet) error,
+) error {
+	root := &cobra.Command{Use: "br"}
+	DefineCommonFlags(root.PersistentFlags())
+	parent := &cobra.Command{Use: "parent"}
+	defineParentFlags(parent.PersistentFlags())
+	command := &cobra.Command{Use: "command"}
+	defineFlags(command)
+	root.AddCommand(parent)
+	parent.AddCommand(command)
+	if err := command.ParseFlags(nil); err != nil {
+		return errors.Trace(err)
+	}
+	return parse(command.Flags())
+}
+
 // ParseFromFlags parses the config from the flag set.
 func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	var err error
@@ -492,6 +625,9 @@ func (cfg *Config) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err = cfg.parseCipherInfo(flags); err != nil {
 		return errors.Trace(err)
 	}
+	if err = cfg.parseRelayFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
 
 	return cfg.normalizePDURLs()
 }
@@ -551,6 +687,7 @@ func storageOpts(cfg *Config) *storage.ExternalStorageOptions {
 	return &storage.ExternalStorageOptions{
 		NoCredentials:   cfg.NoCreds,
 		SendCredentials: cfg.SendCreds,
+		HDFS:            cfg.HDFS,
 	}
 }
 
@@ -564,6 +701,7 @@ func ReadBackupMeta(
 	if err != nil {
 		return nil, nil, nil, errors.Trace(err)
 	}
+	dataKeyFile := metautil.DataKeyFile
 	metaData, err := s.ReadFile(ctx, fileName)
 	if err != nil {
 		if gcsObjectNotFound(err) {
@@ -571,6 +709,7 @@ func ReadBackupMeta(
 			oldPrefix := u.GetGcs().GetPrefix()
 			newPrefix, file := path.Split(oldPrefix)
 			newFileName := file + fileName
+			dataKeyFile = file + metautil.DataKeyFile
 			u.GetGcs().Prefix = newPrefix
 			s, err = storage.New(ctx, u, storageOpts(cfg))
 			if err != nil {
@@ -588,22 +727,39 @@ func ReadBackupMeta(
 		}
 	}
 
+	cipher, err := cfg.readDataKey(ctx, s, dataKeyFile)
+	if err != nil {
+		return nil, nil, nil, errors.Trace(err)
+	}
+	cfg.CipherInfo = *cipher
+	backupMeta, err := decodeBackupMeta(metaData, &cfg.CipherInfo)
+	if err != nil {
+		return nil, nil, nil, errors.Trace(err)
+	}
+	return u, s, backupMeta, nil
+}
+
+// decodeBackupMeta decrypts and unmarshals the content of a backupmeta file.
+func decodeBackupMeta(metaData []byte, cipher *backuppb.CipherInfo) (*backuppb.BackupMeta, error) {
 	// the prefix of backupmeta file is iv(16 bytes) if encryption method is valid
 	var iv []byte
-	if cfg.CipherInfo.CipherType != encryptionpb.EncryptionMethod_PLAINTEXT {
+	if cipher.CipherType != encryptionpb.EncryptionMethod_PLAINTEXT {
+		if len(metaData) < metautil.CrypterIvLen {
+			return nil, errors.Annotate(berrors.ErrInvalidMetaFile, "the encrypted backupmeta is too short")
+		}
 		iv = metaData[:metautil.CrypterIvLen]
 	}
-	decryptBackupMeta, err := metautil.Decrypt(metaData[len(iv):], &cfg.CipherInfo, iv)
+	decryptBackupMeta, err := metautil.Decrypt(metaData[len(iv):], cipher, iv)
 	if err != nil {
-		return nil, nil, nil, errors.Annotate(err, "decrypt failed with wrong key")
+		return nil, errors.Annotate(err, "decrypt failed with wrong key")
 	}
 
 	backupMeta := &backuppb.BackupMeta{}
 	if err = proto.Unmarshal(decryptBackupMeta, backupMeta); err != nil {
-		return nil, nil, nil, errors.Annotate(err,
+		return nil, errors.Annotate(err,
 			"parse backupmeta failed because of wrong aes cipher")
 	}
-	return u, s, backupMeta, nil
+	return backupMeta, nil
 }
 
 // flagToZapField checks whether this flag can be logged,
@@ -614,8 +770,11 @@ func flagToZapField(f *pflag.Flag) zap.Field {
 		if err != nil {
 			return zap.String(f.Name, "<invalid URI>")
 		}
-		// hide all query here.
+		// hide all query and the password in user info here.
 		hiddenQuery.RawQuery = ""
+		if _, ok := hiddenQuery.User.Password(); ok {
+			hiddenQuery.User = url.UserPassword(hiddenQuery.User.Username(), "xxxxx")
+		}
 		return zap.Stringer(f.Name, hiddenQuery)
 	}
 	return zap.Stringer(f.Name, f.Value)
@@ -0,0 +1,303 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+const (
+	flagRawFile      = "file"
+	flagOutputFormat = "output-format"
+	flagEncoding     = "encoding"
+
+	outputFormatText = "text"
+	outputFormatCSV  = "csv"
+	outputFormatJSON = "json"
+)
+
+// DumpRawConfig is the configuration of dumping the kv pairs in raw backup files.
+type DumpRawConfig struct {
+	Config
+
+	KeyRange
+	// CF and Files filter the files to dump, all the files are dumped if they're empty.
+	CF    string   `json:"cf" toml:"cf"`
+	Files []string `json:"files" toml:"files"`
+	// OutputFormat is one of text, csv and json.
+	OutputFormat string `json:"output-format" toml:"output-format"`
+	// Encoding is the format of the dumped keys and values, one of raw, escaped and hex.
+	Encoding string `json:"encoding" toml:"encoding"`
+}
+
+// DefineDumpRawFlags defines the flags of dumping raw backup files.
+func DefineDumpRawFlags(command *cobra.Command) {
+	command.Flags().String(flagKeyFormat, "hex", "start/end key format, support raw|escaped|hex")
+	command.Flags().String(flagStartKey, "", "dump raw kv start key, key is inclusive")
+	command.Flags().String(flagEndKey, "", "dump raw kv end key, key is exclusive")
+	command.Flags().String(flagTiKVColumnFamily, "", "only dump the files of the cf, all the files are dumped by default")
+	command.Flags().StringArray(flagRawFile, nil, "only dump the file, can be specified multiple times")
+	command.Flags().String(flagOutputFormat, outputFormatText, "the output format, support text|csv|json")
+	command.Flags().String(flagEncoding, "hex", "the format of the dumped keys and values, support raw|escaped|hex")
+}
+
+// ParseFromFlags parses the dump raw flags from the flag set.
+func (cfg *DumpRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	format, err := flags.GetString(flagKeyFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	start, err := flags.GetString(flagStartKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	end, err := flags.GetString(flagEndKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.KeyRange, err = parseKeyRange(format, start, end)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CF, err = flags.GetString(flagTiKVColumnFamily)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Files, err = flags.GetStringArray(flagRawFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.OutputFormat, err = flags.GetString(flagOutputFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.Encoding, err = flags.GetString(flagEncoding)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = utils.FormatKey(cfg.Encoding, nil); err != nil {
+		return errors.Annotatef(err, "invalid --%s %s", flagEncoding, cfg.Encoding)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+// RunDumpRaw writes the kv pairs in the raw backup files to out.
+func RunDumpRaw(ctx context.Context, cfg *DumpRawConfig, out io.Writer) error {
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !backupMeta.IsRawKv {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "only the raw backup files can be dumped")
+	}
+	withTTL := backupMeta.ApiVersion == kvrpcpb.APIVersion_V1TTL
+	w, err := newRawKVWriter(out, cfg.OutputFormat, cfg.Encoding, withTTL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	files := filterRawFiles(backupMeta.Files, cfg.KeyRange, cfg.CF, cfg.Files)
+	log.Info("dump raw backup files", zap.Int("files", len(files)), zap.Bool("ttl", withTTL))
+	for _, file := range files {
+		content, err := restore.ReadRawSSTFile(ctx, s, file, &cfg.CipherInfo)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		err = restore.IterateRawSST(content, func(key, value []byte) error {
+			if bytes.Compare(key, cfg.StartKey) < 0 ||
+				(len(cfg.EndKey) > 0 && bytes.Compare(key, cfg.EndKey) >= 0) {
+				return nil
+			}
+			var expireTS uint64
+			if withTTL {
+				var err error
+				if value, expireTS, err = restore.DecodeRawTTLValue(value); err != nil {
+					return errors.Trace(err)
+				}
+			}
+			return errors.Trace(w.Write(key, value, expireTS))
+		})
+		if err != nil {
+			return errors.Annotatef(err, "failed to dump file %s", file.GetName())
+		}
+	}
+	return errors.Trace(w.Flush())
+}
+
+// filterRawFiles returns the files overlapping with the key range in the cf
+// and with the names, an empty cf or names matches all the files.
+func filterRawFiles(files []*backuppb.File, rg KeyRange, cf string, names []string) []*backuppb.File {
+	nameSet := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		nameSet[name] = struct{}{}
+	}
+	filtered := make([]*backuppb.File, 0, len(files))
+	for _, file := range files {
+		if len(cf) > 0 && file.GetCf() != cf {
+			continue
+		}
+		if _, ok := nameSet[file.GetName()]; len(nameSet) > 0 && !ok {
+			continue
+		}
+		if (len(rg.EndKey) > 0 && bytes.Compare(file.GetStartKey(), rg.EndKey) >= 0) ||
+			(len(file.GetEndKey()) > 0 && bytes.Compare(file.GetEndKey(), rg.StartKey) <= 0) {
+			continue
+		}
+		filtered = append(filtered, file)
+	}
+	return filtered
+}
+
+// rawKVWriter writes the raw kv pairs in a format.
+type rawKVWriter interface {
+	// Write writes a kv pair, expireTS is only written if ttl is enabled.
+	Write(key, value []byte, expireTS uint64) error
+	Flush() error
+}
+
+func newRawKVWriter(out io.Writer, outputFormat, encoding string, withTTL bool) (rawKVWriter, error) {
+	base := rawKVEncoder{encoding: encoding, withTTL: withTTL}
+	switch outputFormat {
+	case outputFormatText:
+		return &textRawKVWriter{rawKVEncoder: base, w: bufio.NewWriter(out)}, nil
+	case outputFormatCSV:
+		return &csvRawKVWriter{rawKVEncoder: base, w: csv.NewWriter(out)}, nil
+	case outputFormatJSON:
+		return &jsonRawKVWriter{rawKVEncoder: base, w: bufio.NewWriter(out)}, nil
+	}
+	return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid output format %s", outputFormat)
+}
+
+type rawKVEncoder struct {
+	encoding string
+	withTTL  bool
+}
+
+// encode returns the encoded key, value and expire timestamp.
+func (e rawKVEncoder) encode(key, value []byte, expireTS uint64) ([]string, error) {
+	k, err := utils.FormatKey(e.encoding, key)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	v, err := utils.FormatKey(e.encoding, value)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !e.withTTL {
+		return []string{k, v}, nil
+	}
+	return []string{k, v, strconv.FormatUint(expireTS, 10)}, nil
+}
+
+// textRawKVWriter writes a kv pair per line separated by tabs.
+type textRawKVWriter struct {
+	rawKVEncoder
+	w *bufio.Writer
+}
+
+func (t *textRawKVWriter) Write(key, value []byte, expireTS uint64) error {
+	fields, err := t.encode(key, value, expireTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for i, field := range fields {
+		if i > 0 {
+			_ = t.w.WriteByte('\t')
+		}
+		_, _ = t.w.WriteString(field)
+	}
+	return errors.Trace(t.w.WriteByte('\n'))
+}
+
+func (t *textRawKVWriter) Flush() error {
+	return errors.Trace(t.w.Flush())
+}
+
+// csvRawKVWriter writes the kv pairs in csv with a header.
+type csvRawKVWriter struct {
+	rawKVEncoder
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (c *csvRawKVWriter) writeHeader() error {
+	if c.headerWritten {
+		return nil
+	}
+	c.headerWritten = true
+	header := []string{"key", "value"}
+	if c.withTTL {
+		header = append(header, "expire_ts")
+	}
+	return errors.Trace(c.w.Write(header))
+}
+
+func (c *csvRawKVWriter) Write(key, value []byte, expireTS uint64) error {
+	if err := c.writeHeader(); err != nil {
+		return errors.Trace(err)
+	}
+	fields, err := c.encode(key, value, expireTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(c.w.Write(fields))
+}
+
+func (c *csvRawKVWriter) Flush() error {
+	if err := c.writeHeader(); err != nil {
+		return errors.Trace(err)
+	}
+	c.w.Flush()
+	return errors.Trace(c.w.Error())
+}
+
+// jsonRawKVWriter writes a json object per line.
+type jsonRawKVWriter struct {
+	rawKVEncoder
+	w *bufio.Writer
+}
+
+type jsonRawKV struct {
+	Key      string  `json:"key"`
+	Value    string  `json:"value"`
+	ExpireTS *uint64 `json:"expire-ts,omitempty"`
+}
+
+func (j *jsonRawKVWriter) Write(key, value []byte, expireTS uint64) error {
+	fields, err := j.encode(key, value, expireTS)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	kv := jsonRawKV{Key: fields[0], Value: fields[1]}
+	if j.withTTL {
+		kv.ExpireTS = &expireTS
+	}
+	data, err := json.Marshal(&kv)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, _ = j.w.Write(data)
+	return errors.Trace(j.w.WriteByte('\n'))
+}
+
+func (j *jsonRawKVWriter) Flush() error {
+	return errors.Trace(j.w.Flush())
+}
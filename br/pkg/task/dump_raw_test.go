@@ -0,0 +1,92 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/gogo/protobuf/proto"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRawBackup writes a raw backup with ttl enabled to dir, each of the
+// kvs is written to a file.
+func writeRawBackup(t *testing.T, dir string, kvs [][2]string) {
+	meta := &backuppb.BackupMeta{
+		IsRawKv:    true,
+		ApiVersion: kvrpcpb.APIVersion_V1TTL,
+		RawRanges:  []*backuppb.RawRange{{Cf: "default"}},
+	}
+	for i, kv := range kvs {
+		name := filepath.Join(dir, kv[0]+".sst")
+		f, err := os.Create(name)
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		value := make([]byte, len(kv[1])+8)
+		copy(value, kv[1])
+		binary.BigEndian.PutUint64(value[len(kv[1]):], uint64(i))
+		require.NoError(t, w.Set([]byte("z"+kv[0]), value))
+		require.NoError(t, w.Close())
+
+		content, err := os.ReadFile(name)
+		require.NoError(t, err)
+		checksum := sha256.Sum256(content)
+		meta.Files = append(meta.Files, &backuppb.File{
+			Name:     kv[0] + ".sst",
+			Sha256:   checksum[:],
+			StartKey: []byte(kv[0]),
+			EndKey:   []byte(kv[0] + "\x00"),
+			Cf:       "default",
+		})
+	}
+	data, err := proto.Marshal(meta)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, metautil.MetaFile), data, 0o644))
+}
+
+func TestRunDumpRaw(t *testing.T) {
+	dir := t.TempDir()
+	writeRawBackup(t, dir, [][2]string{{"a", "1"}, {"b", "2\n"}, {"c", "3"}})
+
+	cfg := &DumpRawConfig{
+		Config: Config{
+			Storage:    "local://" + dir,
+			CipherInfo: backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT},
+		},
+		KeyRange: KeyRange{StartKey: []byte("b")},
+		Encoding: "escaped",
+	}
+	cases := []struct {
+		format string
+		output string
+	}{
+		{outputFormatText, "b\t2\\n\t1\nc\t3\t2\n"},
+		{outputFormatCSV, "key,value,expire_ts\nb,2\\n,1\nc,3,2\n"},
+		{outputFormatJSON, `{"key":"b","value":"2\\n","expire-ts":1}` + "\n" + `{"key":"c","value":"3","expire-ts":2}` + "\n"},
+	}
+	for _, ca := range cases {
+		cfg.OutputFormat = ca.format
+		var out bytes.Buffer
+		require.NoError(t, RunDumpRaw(context.Background(), cfg, &out))
+		require.Equal(t, ca.output, out.String(), ca.format)
+	}
+
+	cfg.OutputFormat = outputFormatText
+	cfg.Encoding = "hex"
+	cfg.KeyRange = KeyRange{}
+	cfg.Files = []string{"a.sst"}
+	var out bytes.Buffer
+	require.NoError(t, RunDumpRaw(context.Background(), cfg, &out))
+	require.Equal(t, "61\t31\t0\n", out.String())
+}
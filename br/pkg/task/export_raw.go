@@ -0,0 +1,370 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/kv"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/xitongsys/parquet-go/writer"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	flagOutputStorage = "output-storage"
+	flagDecodeTTL     = "decode-ttl"
+
+	outputFormatParquet = "parquet"
+
+	// exportChunkSize is the size of the chunks uploaded to the output storage.
+	exportChunkSize = 5 * units.MiB
+	// defaultExportConcurrency is the number of files exported concurrently.
+	defaultExportConcurrency = 4
+)
+
+// ExportRawConfig is the configuration of exporting a raw backup to files.
+type ExportRawConfig struct {
+	Config
+
+	KeyRange
+	// CFs filter the files to export, all the files are exported if it's empty.
+	CFs []string `json:"cfs" toml:"cfs"`
+	// OutputStorage is where the exported files are written.
+	OutputStorage string `json:"output-storage" toml:"output-storage"`
+	// OutputFormat is one of parquet and csv.
+	OutputFormat string `json:"output-format" toml:"output-format"`
+	// Encoding is the format of the keys and values in csv, one of raw, escaped and hex.
+	Encoding string `json:"encoding" toml:"encoding"`
+	// DecodeTTL writes the expire timestamps of the keys of a backup with ttl
+	// enabled to a separate column. The expire timestamps are always removed
+	// from the exported values.
+	DecodeTTL bool `json:"decode-ttl" toml:"decode-ttl"`
+	// KeyspaceID is the keyspace to export from a backup of an API V2 cluster,
+	// the keys in the range are the keys in the keyspace.
+	KeyspaceID *uint32 `json:"keyspace-id" toml:"keyspace-id"`
+}
+
+// DefineExportRawFlags defines the flags of exporting a raw backup.
+func DefineExportRawFlags(command *cobra.Command) {
+	command.Flags().String(flagKeyFormat, "hex", "start/end key format, support raw|escaped|hex")
+	command.Flags().String(flagStartKey, "", "export raw kv start key, key is inclusive")
+	command.Flags().String(flagEndKey, "", "export raw kv end key, key is exclusive")
+	command.Flags().StringSlice(flagTiKVColumnFamily, nil,
+		"only export the files of the cfs, multiple cfs are separated by comma, all the files are exported by default")
+	command.Flags().String(flagOutputStorage, "", "the storage url to write the exported files to")
+	command.Flags().String(flagOutputFormat, outputFormatParquet, "the format of the exported files, support parquet|csv")
+	command.Flags().String(flagEncoding, "hex", "the format of the keys and values in csv, support raw|escaped|hex")
+	command.Flags().Bool(flagDecodeTTL, false,
+		"write the expire timestamps of the keys to a separate column, only for the backup with ttl enabled")
+	command.Flags().Uint32(flagKeyspaceID, 0,
+		"the keyspace to export from the backup of an API V2 cluster, the keys in the range are the keys in the keyspace")
+}
+
+// ParseFromFlags parses the export raw flags from the flag set.
+func (cfg *ExportRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	format, err := flags.GetString(flagKeyFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	start, err := flags.GetString(flagStartKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	end, err := flags.GetString(flagEndKey)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.KeyRange, err = parseKeyRange(format, start, end)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CFs, err = flags.GetStringSlice(flagTiKVColumnFamily)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.OutputStorage, err = flags.GetString(flagOutputStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.OutputStorage) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s is required", flagOutputStorage)
+	}
+	cfg.OutputFormat, err = flags.GetString(flagOutputFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.OutputFormat != outputFormatParquet && cfg.OutputFormat != outputFormatCSV {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %s", flagOutputFormat, cfg.OutputFormat)
+	}
+	cfg.Encoding, err = flags.GetString(flagEncoding)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err = utils.FormatKey(cfg.Encoding, nil); err != nil {
+		return errors.Annotatef(err, "invalid --%s %s", flagEncoding, cfg.Encoding)
+	}
+	cfg.DecodeTTL, err = flags.GetBool(flagDecodeTTL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.KeyspaceID, err = parseKeyspaceID(flags, flagKeyspaceID); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(cfg.Config.ParseFromFlags(flags))
+}
+
+func (cfg *ExportRawConfig) adjust() {
+	cfg.Config.adjust()
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultExportConcurrency
+	}
+}
+
+// RunExportRaw exports the kv pairs in a raw backup to the output storage,
+// each backup file is exported to a file.
+func RunExportRaw(c context.Context, g glue.Glue, cmdName string, cfg *ExportRawConfig) error {
+	cfg.adjust()
+
+	defer summary.Summary(cmdName)
+	ctx, cancel := context.WithCancel(c)
+	defer cancel()
+
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !backupMeta.IsRawKv {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "only the raw backup can be exported")
+	}
+	decoder, keyRange, err := cfg.rawKVDecoder(backupMeta.ApiVersion)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	outputCfg := cfg.Config
+	outputCfg.Storage = cfg.OutputStorage
+	_, output, err := GetStorage(ctx, &outputCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	files := make([]*backuppb.File, 0, len(backupMeta.Files))
+	if len(cfg.CFs) == 0 {
+		files = filterRawFiles(backupMeta.Files, keyRange, "", nil)
+	}
+	for _, cf := range cfg.CFs {
+		files = append(files, filterRawFiles(backupMeta.Files, keyRange, cf, nil)...)
+	}
+	summary.CollectInt("export files", len(files))
+	log.Info("export raw backup", zap.Int("files", len(files)), zap.String("format", cfg.OutputFormat))
+
+	updateCh := g.StartProgress(ctx, cmdName, int64(len(files)), !cfg.LogProgress)
+	workerPool := utils.NewWorkerPool(uint(cfg.Concurrency), "export")
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, f := range files {
+		file := f
+		workerPool.ApplyOnErrorGroup(eg, func() error {
+			defer updateCh.Inc()
+			return errors.Annotatef(exportRawFile(ectx, s, output, cfg, file, keyRange, decoder),
+				"failed to export file %s", file.GetName())
+		})
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	updateCh.Close()
+
+	summary.SetSuccessStatus(true)
+	return nil
+}
+
+// rawKVDecoder checks the flags against the api version of the backup, and
+// returns the decoder of the kv pairs in the backup files and the key range
+// to export in the backup files.
+func (cfg *ExportRawConfig) rawKVDecoder(apiVersion kvrpcpb.APIVersion) (rawKVDecoder, KeyRange, error) {
+	decoder := rawKVDecoder{apiVersion: apiVersion}
+	if cfg.DecodeTTL && apiVersion != kvrpcpb.APIVersion_V1TTL && apiVersion != kvrpcpb.APIVersion_V2 {
+		return decoder, KeyRange{}, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s requires a backup of the cluster with ttl enabled", flagDecodeTTL)
+	}
+	if apiVersion != kvrpcpb.APIVersion_V2 {
+		if cfg.KeyspaceID != nil {
+			return decoder, KeyRange{}, errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s requires a backup of an API V2 cluster, but the backup is taken with api version %s",
+				flagKeyspaceID, apiVersion)
+		}
+		return decoder, cfg.KeyRange, nil
+	}
+	// The keys of the keyspaces can't be told apart without the keyspace prefixes.
+	if cfg.KeyspaceID == nil {
+		return decoder, KeyRange{}, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s is required to export a backup of an API V2 cluster", flagKeyspaceID)
+	}
+	decoder.keyPrefix = utils.APIV2RawKeyPrefix(*cfg.KeyspaceID)
+	keyRange := KeyRange{
+		StartKey: append(append([]byte{}, decoder.keyPrefix...), cfg.StartKey...),
+		EndKey:   append(append([]byte{}, decoder.keyPrefix...), cfg.EndKey...),
+	}
+	if len(cfg.EndKey) == 0 {
+		keyRange.EndKey = kv.Key(decoder.keyPrefix).PrefixNext()
+	}
+	return decoder, keyRange, nil
+}
+
+// rawKVDecoder decodes the kv pairs in the backup files into the user keys
+// and values in the same way as they're restored.
+type rawKVDecoder struct {
+	apiVersion kvrpcpb.APIVersion
+	// keyPrefix is the keyspace prefix removed from the keys in API V2.
+	keyPrefix []byte
+}
+
+// decode returns the user key, the user value and the expire timestamp of a
+// kv pair, skip is true if the kv pair is deleted.
+func (d rawKVDecoder) decode(key, value []byte) (userKey, userValue []byte, expireTS uint64, skip bool, err error) {
+	switch d.apiVersion {
+	case kvrpcpb.APIVersion_V1TTL:
+		userValue, expireTS, err = restore.DecodeRawTTLValue(value)
+		return key, userValue, expireTS, false, errors.Trace(err)
+	case kvrpcpb.APIVersion_V2:
+		if !bytes.HasPrefix(key, d.keyPrefix) {
+			return nil, nil, 0, false, errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+				"the key %s isn't in the keyspace", redact.Key(key))
+		}
+		userValue, expireTS, skip, err = restore.DecodeRawV2Value(value)
+		return key[len(d.keyPrefix):], userValue, expireTS, skip, errors.Trace(err)
+	default:
+		return key, value, 0, false, nil
+	}
+}
+
+// exportRawFile exports the kv pairs in the key range of a backup file to a
+// file with the same name and the extension of the output format.
+func exportRawFile(
+	ctx context.Context,
+	s, output storage.ExternalStorage,
+	cfg *ExportRawConfig,
+	file *backuppb.File,
+	keyRange KeyRange,
+	decoder rawKVDecoder,
+) error {
+	content, err := restore.ReadRawSSTFile(ctx, s, file, &cfg.CipherInfo)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	name := strings.TrimSuffix(file.GetName(), ".sst") + "." + cfg.OutputFormat
+	fileWriter, err := output.Create(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fileWriter = storage.NewUploaderWriter(fileWriter, exportChunkSize, storage.NoCompression)
+	out := &ioWriter{ctx: ctx, w: fileWriter}
+
+	var w rawKVWriter
+	if cfg.OutputFormat == outputFormatParquet {
+		w, err = newParquetRawKVWriter(out, cfg.DecodeTTL)
+	} else {
+		w = &csvRawKVWriter{rawKVEncoder: rawKVEncoder{encoding: cfg.Encoding, withTTL: cfg.DecodeTTL}, w: csv.NewWriter(out)}
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var kvs int
+	err = restore.IterateRawSST(content, func(key, value []byte) error {
+		if bytes.Compare(key, keyRange.StartKey) < 0 ||
+			(len(keyRange.EndKey) > 0 && bytes.Compare(key, keyRange.EndKey) >= 0) {
+			return nil
+		}
+		key, value, expireTS, skip, err := decoder.decode(key, value)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if skip {
+			return nil
+		}
+		kvs++
+		return errors.Trace(w.Write(key, value, expireTS))
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = w.Flush(); err != nil {
+		return errors.Trace(err)
+	}
+	if err = fileWriter.Close(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	log.Debug("export raw file", zap.String("file", file.GetName()), zap.String("output", name), zap.Int("kvs", kvs))
+	return nil
+}
+
+// ioWriter adapts a storage.ExternalFileWriter to io.Writer.
+type ioWriter struct {
+	ctx context.Context
+	w   storage.ExternalFileWriter
+}
+
+func (w *ioWriter) Write(p []byte) (int, error) {
+	return w.w.Write(w.ctx, p)
+}
+
+type parquetRawKV struct {
+	Key   string `parquet:"name=key, type=BYTE_ARRAY"`
+	Value string `parquet:"name=value, type=BYTE_ARRAY"`
+}
+
+type parquetRawKVWithTTL struct {
+	Key      string `parquet:"name=key, type=BYTE_ARRAY"`
+	Value    string `parquet:"name=value, type=BYTE_ARRAY"`
+	ExpireTS uint64 `parquet:"name=expire_ts, type=UINT_64"`
+}
+
+// parquetRawKVWriter writes the kv pairs to a parquet file, the keys and
+// values are written as binary.
+type parquetRawKVWriter struct {
+	w       *writer.ParquetWriter
+	withTTL bool
+}
+
+func newParquetRawKVWriter(out *ioWriter, withTTL bool) (*parquetRawKVWriter, error) {
+	var schema interface{} = new(parquetRawKV)
+	if withTTL {
+		schema = new(parquetRawKVWithTTL)
+	}
+	w, err := writer.NewParquetWriterFromWriter(out, schema, 1)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &parquetRawKVWriter{w: w, withTTL: withTTL}, nil
+}
+
+func (p *parquetRawKVWriter) Write(key, value []byte, expireTS uint64) error {
+	if p.withTTL {
+		return errors.Trace(p.w.Write(parquetRawKVWithTTL{Key: string(key), Value: string(value), ExpireTS: expireTS}))
+	}
+	return errors.Trace(p.w.Write(parquetRawKV{Key: string(key), Value: string(value)}))
+}
+
+func (p *parquetRawKVWriter) Flush() error {
+	return errors.Trace(p.w.WriteStop())
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cockroachdb/pebble/sstable"
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/gluetikv"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func TestRunExportRaw(t *testing.T) {
+	dir := t.TempDir()
+	writeRawBackup(t, dir, [][2]string{{"a", "1"}, {"b", "2"}, {"c", "3"}})
+	outputDir := t.TempDir()
+
+	cfg := &ExportRawConfig{
+		Config: Config{
+			Storage:    "local://" + dir,
+			CipherInfo: backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT},
+		},
+		KeyRange:      KeyRange{StartKey: []byte("b")},
+		OutputStorage: "local://" + outputDir,
+		OutputFormat:  outputFormatCSV,
+		Encoding:      "raw",
+		DecodeTTL:     true,
+	}
+	require.NoError(t, RunExportRaw(context.Background(), gluetikv.Glue{}, "export", cfg))
+	_, err := os.Stat(filepath.Join(outputDir, "a.csv"))
+	require.True(t, os.IsNotExist(err))
+	content, err := os.ReadFile(filepath.Join(outputDir, "b.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "key,value,expire_ts\nb,2,1\n", string(content))
+
+	// The expire timestamps are removed from the values without --decode-ttl.
+	cfg.DecodeTTL = false
+	require.NoError(t, RunExportRaw(context.Background(), gluetikv.Glue{}, "export", cfg))
+	content, err = os.ReadFile(filepath.Join(outputDir, "b.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "key,value\nb,2\n", string(content))
+
+	cfg.DecodeTTL = true
+	cfg.OutputFormat = outputFormatParquet
+	require.NoError(t, RunExportRaw(context.Background(), gluetikv.Glue{}, "export", cfg))
+	f, err := local.NewLocalFileReader(filepath.Join(outputDir, "c.parquet"))
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := reader.NewParquetReader(f, new(parquetRawKVWithTTL), 1)
+	require.NoError(t, err)
+	defer r.ReadStop()
+	rows := make([]parquetRawKVWithTTL, r.GetNumRows())
+	require.NoError(t, r.Read(&rows))
+	require.Equal(t, []parquetRawKVWithTTL{{Key: "c", Value: "3", ExpireTS: 2}}, rows)
+}
+
+func TestRunExportRawV2(t *testing.T) {
+	dir := t.TempDir()
+	keyspace1 := string(utils.APIV2RawKeyPrefix(1))
+	keyspace2 := string(utils.APIV2RawKeyPrefix(2))
+	meta := &backuppb.BackupMeta{IsRawKv: true, ApiVersion: kvrpcpb.APIVersion_V2}
+	kvs := []struct {
+		key   string
+		value string
+	}{
+		{keyspace1 + "a", "1\x00"},
+		// The value with the expire timestamp 10.
+		{keyspace1 + "b", "2\x00\x00\x00\x00\x00\x00\x00\x0a\x01"},
+		// The deleted value.
+		{keyspace1 + "c", "\x02"},
+		{keyspace2 + "a", "3\x00"},
+	}
+	for i, kv := range kvs {
+		name := fmt.Sprintf("%d.sst", i)
+		f, err := os.Create(filepath.Join(dir, name))
+		require.NoError(t, err)
+		w := sstable.NewWriter(f, sstable.WriterOptions{})
+		require.NoError(t, w.Set([]byte("z"+kv.key), []byte(kv.value)))
+		require.NoError(t, w.Close())
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		checksum := sha256.Sum256(content)
+		meta.Files = append(meta.Files, &backuppb.File{
+			Name:     name,
+			Sha256:   checksum[:],
+			StartKey: []byte(kv.key),
+			EndKey:   []byte(kv.key + "\x00"),
+			Cf:       "default",
+		})
+	}
+	data, err := proto.Marshal(meta)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, metautil.MetaFile), data, 0o644))
+
+	outputDir := t.TempDir()
+	cfg := &ExportRawConfig{
+		Config: Config{
+			Storage:    "local://" + dir,
+			CipherInfo: backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT},
+		},
+		OutputStorage: "local://" + outputDir,
+		OutputFormat:  outputFormatCSV,
+		Encoding:      "raw",
+		DecodeTTL:     true,
+	}
+	err = RunExportRaw(context.Background(), gluetikv.Glue{}, "export", cfg)
+	require.True(t, berrors.ErrInvalidArgument.Equal(errors.Cause(err)))
+
+	keyspaceID := uint32(1)
+	cfg.KeyspaceID = &keyspaceID
+	require.NoError(t, RunExportRaw(context.Background(), gluetikv.Glue{}, "export", cfg))
+	for name, expected := range map[string]string{
+		"0.csv": "key,value,expire_ts\na,1,0\n",
+		"1.csv": "key,value,expire_ts\nb,2,10\n",
+		"2.csv": "key,value,expire_ts\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(outputDir, name))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(content), name)
+	}
+	// The files of the other keyspace aren't exported.
+	_, err = os.Stat(filepath.Join(outputDir, "3.csv"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"net"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/backup"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/relay"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+const (
+	flagRelay              = "relay"
+	flagRelayAddr          = "relay.addr"
+	flagRelayAdvertiseAddr = "relay.advertise-addr"
+
+	defaultRelayAddr = "0.0.0.0:0"
+
+	relayBackupBucket = "backup"
+)
+
+func defineRelayFlags(flags *pflag.FlagSet) {
+	flags.Bool(flagRelay, false,
+		"(experimental) relay the backup files between TiKV and the storage through BR, "+
+			"for the clusters in which only BR can reach the storage")
+	flags.String(flagRelayAddr, defaultRelayAddr, "the address the relay server listens on")
+	flags.String(flagRelayAdvertiseAddr, "",
+		"the address for TiKV to reach the relay server, "+
+			"the local address connecting to PD is used if it's empty and --relay.addr doesn't specify the host")
+}
+
+func (cfg *Config) parseRelayFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.Relay, err = flags.GetBool(flagRelay); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.RelayAddr, err = flags.GetString(flagRelayAddr); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.RelayAdvertiseAddr, err = flags.GetString(flagRelayAdvertiseAddr); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// startRelay starts the relay server if the relay mode is enabled, otherwise
+// it returns nil.
+func (cfg *Config) startRelay() (*relay.Server, error) {
+	if !cfg.Relay {
+		return nil, nil
+	}
+	listenAddr := cfg.RelayAddr
+	if len(listenAddr) == 0 {
+		listenAddr = defaultRelayAddr
+	}
+	advertiseAddr := cfg.RelayAdvertiseAddr
+	if len(advertiseAddr) == 0 {
+		host, _, err := net.SplitHostPort(listenAddr)
+		if err != nil {
+			return nil, errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %s", flagRelayAddr, listenAddr)
+		}
+		if ip := net.ParseIP(host); len(host) == 0 || (ip != nil && ip.IsUnspecified()) {
+			// TiKV is likely able to reach the address BR uses to reach PD.
+			if advertiseAddr, err = relay.LocalAddrTo(cfg.PD[0]); err != nil {
+				return nil, errors.Annotatef(err, "please specify --%s", flagRelayAdvertiseAddr)
+			}
+		}
+	}
+	server, err := relay.NewServer(listenAddr, advertiseAddr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("TiKV accesses the storage through the relay server", zap.String("storage", cfg.Storage))
+	return server, nil
+}
+
+// relayBackup makes TiKV write the backup files through the relay server if
+// the relay mode is enabled. The returned function stops the relay server.
+func (cfg *Config) relayBackup(client *backup.Client) (func(), error) {
+	server, err := cfg.startRelay()
+	if err != nil || server == nil {
+		return func() {}, errors.Trace(err)
+	}
+	server.Register(relayBackupBucket, client.GetStorage())
+	client.SetRelayBackend(server.Backend(relayBackupBucket))
+	return func() { _ = server.Close() }, nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartRelay(t *testing.T) {
+	cfg := &Config{PD: []string{"http://127.0.0.1:2379"}}
+	server, err := cfg.startRelay()
+	require.NoError(t, err)
+	require.Nil(t, server)
+
+	cfg.Relay = true
+	server, err = cfg.startRelay()
+	require.NoError(t, err)
+	defer server.Close()
+	// The address connecting to PD is advertised.
+	endpoint := server.Backend(relayBackupBucket).GetS3().Endpoint
+	require.True(t, strings.HasPrefix(endpoint, "http://127.0.0.1:"), endpoint)
+
+	cfg.RelayAddr = "127.0.0.1:0"
+	cfg.RelayAdvertiseAddr = "br.example.com:8286"
+	server2, err := cfg.startRelay()
+	require.NoError(t, err)
+	defer server2.Close()
+	require.Equal(t, "http://br.example.com:8286", server2.Backend(relayBackupBucket).GetS3().Endpoint)
+
+	cfg.RelayAddr = "invalid"
+	cfg.RelayAdvertiseAddr = ""
+	_, err = cfg.startRelay()
+	require.Error(t, err)
+}
@@ -3,35 +3,207 @@
 package task
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/log"
+	"github.com/pingcap/tidb/kv"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/checksum"
+	"github.com/tikv/migration/br/pkg/conn"
 	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/glue"
+	"github.com/tikv/migration/br/pkg/logutil"
 	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/redact"
 	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
 	"github.com/tikv/migration/br/pkg/summary"
-	"github.com/spf13/cobra"
-	"github.com/spf13/pflag"
+	"github.com/tikv/migration/br/pkg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	flagIncrementalStorage = "incremental-storage"
+	flagRewritePrefix      = "rewrite-prefix"
+	flagTTLMode            = "ttl-mode"
+	flagOnConflict         = "on-conflict"
+	flagCheckpointStorage  = "checkpoint-storage"
+	flagRestoreStoreLabels = "restore-store-labels"
+	flagKeyspaceID         = "keyspace-id"
+	flagTargetKeyspaceID   = "target-keyspace-id"
+
+	flagAdaptiveThrottle                = "adaptive-throttle"
+	flagAdaptiveThrottleInterval        = "adaptive-throttle.interval"
+	flagAdaptiveThrottleMaxSlowScore    = "adaptive-throttle.max-slow-score"
+	flagAdaptiveThrottleMaxHeartbeatLag = "adaptive-throttle.max-heartbeat-lag"
+
+	defaultAdaptiveThrottleInterval = 10 * time.Second
+	// defaultAdaptiveThrottleMaxSlowScore is the slow score from which PD
+	// regards a store as slow.
+	defaultAdaptiveThrottleMaxSlowScore    = 80
+	defaultAdaptiveThrottleMaxHeartbeatLag = 30 * time.Second
+
+	// ttlModeAbsolute keeps the original absolute expiry of the keys.
+	ttlModeAbsolute = "absolute"
+	// ttlModeRemaining recomputes the expiry of the keys from the remaining
+	// TTL at backup time and the restore time.
+	ttlModeRemaining = "remaining"
+
+	// onConflictOverwrite ingests the files, overwriting the keys in the
+	// target ranges.
+	onConflictOverwrite = "overwrite"
+	// onConflictSkipExisting writes the keys through the raw kv api, keeping
+	// the keys existing in the target ranges.
+	onConflictSkipExisting = "skip-existing"
+	// onConflictFail refuses to restore if there is any key in the target
+	// ranges.
+	onConflictFail = "fail"
 )
 
 // RestoreRawConfig is the configuration specific for raw kv restore tasks.
 type RestoreRawConfig struct {
 	RawKvConfig
 	RestoreCommonConfig
+
+	// IncrementalStorages are the incremental raw backups to apply in order
+	// after the backup in `Storage` has been restored.
+	IncrementalStorages []string `json:"incremental-storages" toml:"incremental-storages"`
+
+	// OldKeyPrefix and NewKeyPrefix rewrite the restored keys with the old
+	// prefix to the new prefix, only the keys with the old prefix are restored.
+	OldKeyPrefix []byte `json:"old-key-prefix" toml:"old-key-prefix"`
+	NewKeyPrefix []byte `json:"new-key-prefix" toml:"new-key-prefix"`
+
+	// KeyspaceID is the keyspace to restore from a backup of an API V2
+	// cluster, the ranges and the rewrite prefixes are the keys in it.
+	// The backup is restored as a whole if it's nil.
+	KeyspaceID *uint32 `json:"keyspace-id" toml:"keyspace-id"`
+	// TargetKeyspaceID is the keyspace of the API V2 cluster to restore the
+	// keys into, it's required to convert a backup of an API V1 cluster, and
+	// defaults to KeyspaceID.
+	TargetKeyspaceID *uint32 `json:"target-keyspace-id" toml:"target-keyspace-id"`
+
+	// TTLMode is how to restore the expiry of the keys with TTL.
+	TTLMode string `json:"ttl-mode" toml:"ttl-mode"`
+
+	// OnConflict is how to restore into the target ranges with existing keys.
+	OnConflict string `json:"on-conflict" toml:"on-conflict"`
+
+	// CheckpointStorage is where the ingested files are recorded, the restore
+	// can only be resumed if it's set.
+	CheckpointStorage string `json:"checkpoint-storage" toml:"checkpoint-storage"`
+
+	// RestoreStoreLabels are the labels of the stores to restore the data
+	// onto, the restored regions are released to PD after ingesting.
+	RestoreStoreLabels map[string]string `json:"restore-store-labels" toml:"restore-store-labels"`
+
+	// AdaptiveThrottle lowers the concurrency and the download speed limit
+	// when the cluster is under pressure in the online restore.
+	AdaptiveThrottle                bool          `json:"adaptive-throttle" toml:"adaptive-throttle"`
+	AdaptiveThrottleInterval        time.Duration `json:"adaptive-throttle-interval" toml:"adaptive-throttle-interval"`
+	AdaptiveThrottleMaxSlowScore    uint64        `json:"adaptive-throttle-max-slow-score" toml:"adaptive-throttle-max-slow-score"`
+	AdaptiveThrottleMaxHeartbeatLag time.Duration `json:"adaptive-throttle-max-heartbeat-lag" toml:"adaptive-throttle-max-heartbeat-lag"`
+
+	// DryRun only prints the plan of the restore without splitting or
+	// ingesting anything.
+	DryRun bool `json:"dry-run" toml:"dry-run"`
+
+	// checksumRequested is whether --checksum is set explicitly, the restore
+	// fails rather than skipping the checksum if it's unsupported.
+	checksumRequested bool
 }
 
 // DefineRawRestoreFlags defines common flags for the backup command.
 func DefineRawRestoreFlags(command *cobra.Command) {
 	command.Flags().StringP(flagKeyFormat, "", "hex", "start/end key format, support raw|escaped|hex")
-	command.Flags().StringP(flagTiKVColumnFamily, "", "default", "restore specify cf, correspond to tikv cf")
+	command.Flags().StringSlice(flagTiKVColumnFamily, []string{defaultRawCF},
+		"restore specify cfs, correspond to tikv cf, multiple cfs are separated by comma")
 	command.Flags().StringP(flagStartKey, "", "", "restore raw kv start key, key is inclusive")
 	command.Flags().StringP(flagEndKey, "", "", "restore raw kv end key, key is exclusive")
+	command.Flags().StringArray(flagRawRange, nil,
+		"restore raw kv range in the format of 'start:end', can be specified multiple times, "+
+			"and can't be used with --start and --end, all the backed up ranges are restored by default")
+	command.Flags().String(flagRawRangesFile, "",
+		"the local file of raw kv ranges to restore, one 'start:end' range per line")
+	command.Flags().StringArray(flagIncrementalStorage, nil,
+		"incremental raw backups to apply after the base backup, in the order they were taken")
+	command.Flags().String(flagRewritePrefix, "",
+		"restore the keys with the old prefix under the new prefix, in the format of 'old:new', "+
+			"the prefixes are in the key format")
+	command.Flags().Uint32(flagKeyspaceID, 0,
+		"restore only the keyspace from the backup of an API V2 cluster, the keys in the ranges and "+
+			"the rewrite prefixes are the keys in the keyspace")
+	command.Flags().Uint32(flagTargetKeyspaceID, 0,
+		"the keyspace of the API V2 cluster to restore into, it's required to convert the backup of "+
+			"an API V1 cluster into API V2, and defaults to --"+flagKeyspaceID)
+	command.Flags().String(flagTTLMode, ttlModeAbsolute,
+		"how to restore the expiry of the keys with ttl, value can be one of 'absolute|remaining', "+
+			"'absolute' keeps the original expiry, 'remaining' makes the keys expire after "+
+			"the remaining ttl at backup time from now, which writes the keys through the raw kv api "+
+			"and is much slower")
+	command.Flags().String(flagOnConflict, onConflictOverwrite,
+		"how to restore into the ranges with existing keys, value can be one of 'overwrite|skip-existing|fail', "+
+			"'overwrite' overwrites the existing keys, 'skip-existing' keeps the existing keys, "+
+			"which writes every key with a compare-and-swap through the raw kv api and is much slower, "+
+			"the compare-and-swap only guards against the other compare-and-swap writers, so a plain put "+
+			"of an online client may still be overwritten unless the cluster is in the atomic mode, "+
+			"'fail' refuses to restore if there is any key in the ranges to restore into")
+	command.Flags().String(flagCheckpointStorage, "",
+		"the storage url to record the ingested files in, so that a failed restore can be resumed with --resume")
+	command.Flags().Bool(flagResume, false,
+		"resume the unfinished restore from its checkpoint in --checkpoint-storage, "+
+			"only the files not ingested yet are restored")
+	command.Flags().Duration(flagCheckpointInterval, defaultCheckpointInterval,
+		"the interval of saving the checkpoint of the ingested files to the storage")
+	_ = command.Flags().MarkHidden(flagCheckpointInterval)
+	command.Flags().StringToString(flagRestoreStoreLabels, nil,
+		"(experimental) restore the data onto the stores with all the labels in the format of 'key=value', "+
+			"multiple labels are separated by comma, the restored regions are scheduled by PD as usual "+
+			"after ingesting, it implies --online")
+	command.Flags().Bool(flagDryRun, false,
+		"only print the plan of the restore, including the files, the split keys, the target stores "+
+			"and whether there is data in the target ranges, without splitting or ingesting anything")
+	command.Flags().Bool(flagAdaptiveThrottle, false,
+		"(experimental) lower the restore concurrency and the download speed limit when a store is busy, "+
+			"slow or lagging in heartbeats, and raise them back when the pressure is gone, "+
+			"the download speed limit is adjusted within --ratelimit, only for the online restore")
+	command.Flags().Duration(flagAdaptiveThrottleInterval, defaultAdaptiveThrottleInterval,
+		"the interval of checking the pressure of the cluster")
+	command.Flags().Uint64(flagAdaptiveThrottleMaxSlowScore, defaultAdaptiveThrottleMaxSlowScore,
+		"the slow score of a store, computed by TiKV from the disk latency in [1, 100], "+
+			"from which the cluster is under pressure, 0 means not to check it")
+	command.Flags().Duration(flagAdaptiveThrottleMaxHeartbeatLag, defaultAdaptiveThrottleMaxHeartbeatLag,
+		"the time since the last heartbeat of a store, from which the cluster is under pressure, "+
+			"0 means not to check it")
 
 	DefineRestoreCommonFlags(command.PersistentFlags())
 }
 
+// DefaultRawRestoreConfig returns the raw restore config with the default
+// values of the flags, the storage and the PD addresses must be set to run a
+// restore.
+func DefaultRawRestoreConfig() RestoreRawConfig {
+	cfg := RestoreRawConfig{}
+	if err := parseDefaultFlags(DefineRestoreFlags, DefineRawRestoreFlags, cfg.ParseFromFlags); err != nil {
+		log.Panic("failed to parse the default raw restore config", zap.Error(err))
+	}
+	return cfg
+}
+
 // ParseFromFlags parses the backup-related flags from the flag set.
 func (cfg *RestoreRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	var err error
@@ -43,7 +215,301 @@ func (cfg *RestoreRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
-	return cfg.RawKvConfig.ParseFromFlags(flags)
+	cfg.IncrementalStorages, err = flags.GetStringArray(flagIncrementalStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.RawKvConfig.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.TTLMode, err = flags.GetString(flagTTLMode)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cfg.checksumRequested = flags.Changed(flagChecksum) && cfg.Checksum
+	cfg.OnConflict, err = flags.GetString(flagOnConflict)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.CheckpointStorage, err = flags.GetString(flagCheckpointStorage)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.parseCheckpointFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Resume && len(cfg.CheckpointStorage) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s", flagResume, flagCheckpointStorage)
+	}
+	cfg.RestoreStoreLabels, err = flags.GetStringToString(flagRestoreStoreLabels)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for key := range cfg.RestoreStoreLabels {
+		if len(key) == 0 {
+			return errors.Annotatef(berrors.ErrInvalidArgument, "the label key of --%s can't be empty", flagRestoreStoreLabels)
+		}
+	}
+	if err = cfg.parseAdaptiveThrottle(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.KeyspaceID, err = parseKeyspaceID(flags, flagKeyspaceID); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.TargetKeyspaceID, err = parseKeyspaceID(flags, flagTargetKeyspaceID); err != nil {
+		return errors.Trace(err)
+	}
+	cfg.DryRun, err = flags.GetBool(flagDryRun)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return cfg.parseRewritePrefix(flags)
+}
+
+// Validate checks the restore options which can't be checked by the raw kv
+// config.
+func (cfg *RestoreRawConfig) Validate() error {
+	if cfg.TTLMode != ttlModeAbsolute && cfg.TTLMode != ttlModeRemaining {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %s", flagTTLMode, cfg.TTLMode)
+	}
+	switch cfg.OnConflict {
+	case onConflictOverwrite, onConflictSkipExisting, onConflictFail:
+	default:
+		return errors.Annotatef(berrors.ErrInvalidArgument, "invalid --%s %s", flagOnConflict, cfg.OnConflict)
+	}
+	return nil
+}
+
+// parseKeyspaceID returns the keyspace ID of the flag, or nil if it isn't set.
+func parseKeyspaceID(flags *pflag.FlagSet, name string) (*uint32, error) {
+	if !flags.Changed(name) {
+		return nil, nil
+	}
+	id, err := flags.GetUint32(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if id > utils.MaxKeyspaceID {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s %d exceeds the max keyspace ID %d", name, id, utils.MaxKeyspaceID)
+	}
+	return &id, nil
+}
+
+func (cfg *RestoreRawConfig) parseAdaptiveThrottle(flags *pflag.FlagSet) error {
+	var err error
+	if cfg.AdaptiveThrottle, err = flags.GetBool(flagAdaptiveThrottle); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AdaptiveThrottleInterval, err = flags.GetDuration(flagAdaptiveThrottleInterval); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AdaptiveThrottleMaxSlowScore, err = flags.GetUint64(flagAdaptiveThrottleMaxSlowScore); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.AdaptiveThrottleMaxHeartbeatLag, err = flags.GetDuration(flagAdaptiveThrottleMaxHeartbeatLag); err != nil {
+		return errors.Trace(err)
+	}
+	if !cfg.AdaptiveThrottle {
+		return nil
+	}
+	if !cfg.Online && len(cfg.RestoreStoreLabels) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "--%s requires --%s", flagAdaptiveThrottle, flagOnline)
+	}
+	if cfg.AdaptiveThrottleInterval <= 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"invalid --%s %s", flagAdaptiveThrottleInterval, cfg.AdaptiveThrottleInterval)
+	}
+	return nil
+}
+
+// throttleConfig returns the configuration of the adaptive throttling.
+func (cfg *RestoreRawConfig) throttleConfig() restore.ThrottleConfig {
+	return restore.ThrottleConfig{
+		Interval:        cfg.AdaptiveThrottleInterval,
+		Concurrency:     uint(cfg.Concurrency),
+		RateLimit:       cfg.RateLimit,
+		MaxSlowScore:    cfg.AdaptiveThrottleMaxSlowScore,
+		MaxHeartbeatLag: cfg.AdaptiveThrottleMaxHeartbeatLag,
+	}
+}
+
+func (cfg *RestoreRawConfig) parseRewritePrefix(flags *pflag.FlagSet) error {
+	rewritePrefix, err := flags.GetString(flagRewritePrefix)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(rewritePrefix) == 0 {
+		return nil
+	}
+	format, err := flags.GetString(flagKeyFormat)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	prefixes := strings.Split(rewritePrefix, ":")
+	if len(prefixes) != 2 {
+		return errors.Annotatef(berrors.ErrInvalidArgument,
+			"--%s must be in the format of 'old:new', %s is not allowed", flagRewritePrefix, rewritePrefix)
+	}
+	if cfg.OldKeyPrefix, err = utils.ParseKey(format, prefixes[0]); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.NewKeyPrefix, err = utils.ParseKey(format, prefixes[1]); err != nil {
+		return errors.Trace(err)
+	}
+	if len(cfg.OldKeyPrefix) == 0 || len(cfg.NewKeyPrefix) == 0 {
+		return errors.Annotatef(berrors.ErrInvalidArgument, "the prefixes of --%s can't be empty", flagRewritePrefix)
+	}
+	return nil
+}
+
+// rewriteRules returns the rules to rewrite the restored keys.
+func (cfg *RestoreRawConfig) rewriteRules() *restore.RewriteRules {
+	if bytes.Equal(cfg.OldKeyPrefix, cfg.NewKeyPrefix) {
+		// RawKV restore does not need to rewrite keys by default.
+		return &restore.RewriteRules{}
+	}
+	return &restore.RewriteRules{
+		Data: []*import_sstpb.RewriteRule{{OldKeyPrefix: cfg.OldKeyPrefix, NewKeyPrefix: cfg.NewKeyPrefix}},
+	}
+}
+
+// keyspacePrefixes checks whether the backup taken with backupVersion can be
+// restored into the cluster with clusterVersion, and returns the prefixes of
+// the keyspaces the keys are restored from and into in API V2.
+func (cfg *RestoreRawConfig) keyspacePrefixes(
+	backupVersion, clusterVersion kvrpcpb.APIVersion,
+) (srcPrefix, dstPrefix []byte, err error) {
+	backupV2 := backupVersion == kvrpcpb.APIVersion_V2
+	clusterV2 := clusterVersion == kvrpcpb.APIVersion_V2
+	if cfg.KeyspaceID != nil && !backupV2 {
+		return nil, nil, errors.Annotatef(berrors.ErrRestoreAPIVersionMismatch,
+			"--%s requires a backup of an API V2 cluster, but the backup is taken with api version %s",
+			flagKeyspaceID, backupVersion)
+	}
+	if cfg.TargetKeyspaceID != nil && !clusterV2 {
+		return nil, nil, errors.Annotatef(berrors.ErrRestoreAPIVersionMismatch,
+			"--%s requires an API V2 cluster, but the api version of the cluster is %s",
+			flagTargetKeyspaceID, clusterVersion)
+	}
+	switch {
+	case backupV2 && !clusterV2:
+		// The keys can't be restored without the keyspaces.
+		return nil, nil, errors.Annotatef(berrors.ErrRestoreAPIVersionMismatch,
+			"the backup of an API V2 cluster can't be restored into the cluster with api version %s", clusterVersion)
+	case !backupV2 && clusterV2:
+		if cfg.TargetKeyspaceID == nil {
+			return nil, nil, errors.Annotatef(berrors.ErrRestoreAPIVersionMismatch,
+				"the backup is taken with api version %s, but the cluster is in API V2, "+
+					"please use --%s to convert the keys into a keyspace", backupVersion, flagTargetKeyspaceID)
+		}
+		return nil, utils.APIV2RawKeyPrefix(*cfg.TargetKeyspaceID), nil
+	case backupV2 && clusterV2:
+		if cfg.KeyspaceID == nil {
+			if cfg.TargetKeyspaceID != nil {
+				return nil, nil, errors.Annotatef(berrors.ErrInvalidArgument,
+					"--%s requires --%s to restore a backup of an API V2 cluster", flagTargetKeyspaceID, flagKeyspaceID)
+			}
+			return nil, nil, nil
+		}
+		targetKeyspaceID := *cfg.KeyspaceID
+		if cfg.TargetKeyspaceID != nil {
+			targetKeyspaceID = *cfg.TargetKeyspaceID
+		}
+		return utils.APIV2RawKeyPrefix(*cfg.KeyspaceID), utils.APIV2RawKeyPrefix(targetKeyspaceID), nil
+	default:
+		return nil, nil, nil
+	}
+}
+
+// withKeyspacePrefixes returns a copy of the config, whose ranges and rewrite
+// prefixes are moved into the keyspace prefixes, so that only the keys of the
+// source keyspace are restored and they're rewritten into the target one.
+func (cfg *RestoreRawConfig) withKeyspacePrefixes(srcPrefix, dstPrefix []byte) *RestoreRawConfig {
+	newCfg := *cfg
+	if len(srcPrefix) == 0 && len(dstPrefix) == 0 {
+		return &newCfg
+	}
+	prefixed := func(prefix, key []byte) []byte {
+		return append(append([]byte{}, prefix...), key...)
+	}
+	newCfg.Ranges = make([]KeyRange, 0, len(cfg.Ranges))
+	for _, rg := range cfg.Ranges {
+		newRange := KeyRange{StartKey: prefixed(srcPrefix, rg.StartKey), EndKey: prefixed(srcPrefix, rg.EndKey)}
+		if len(rg.EndKey) == 0 && len(srcPrefix) > 0 {
+			newRange.EndKey = kv.Key(srcPrefix).PrefixNext()
+		}
+		newCfg.Ranges = append(newCfg.Ranges, newRange)
+	}
+	newCfg.OldKeyPrefix = prefixed(srcPrefix, cfg.OldKeyPrefix)
+	newCfg.NewKeyPrefix = prefixed(dstPrefix, cfg.NewKeyPrefix)
+	return &newCfg
+}
+
+// restoreStoreLabels returns the labels of the stores to restore onto, sorted
+// by the keys.
+func (cfg *RestoreRawConfig) restoreStoreLabels() []*metapb.StoreLabel {
+	labels := make([]*metapb.StoreLabel, 0, len(cfg.RestoreStoreLabels))
+	for key, value := range cfg.RestoreStoreLabels {
+		labels = append(labels, &metapb.StoreLabel{Key: key, Value: value})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Key < labels[j].Key
+	})
+	return labels
+}
+
+// rawRestoreRange is a key range of a cf to restore.
+type rawRestoreRange struct {
+	KeyRange
+	CF string
+}
+
+// restoreRanges returns the ranges to restore of each cf. All the ranges in
+// the backup are restored if no range is given, and the ranges are narrowed
+// to the keys with the old prefix if the keys are rewritten.
+func (cfg *RestoreRawConfig) restoreRanges(meta *backuppb.BackupMeta) ([]rawRestoreRange, error) {
+	restoreRanges := make([]rawRestoreRange, 0)
+	for _, cf := range cfg.CFs {
+		if len(cfg.Ranges) > 0 {
+			for _, rg := range cfg.Ranges {
+				restoreRanges = append(restoreRanges, rawRestoreRange{KeyRange: rg, CF: cf})
+			}
+			continue
+		}
+		for _, rawRange := range meta.RawRanges {
+			if rawRange.Cf == cf {
+				rg := KeyRange{StartKey: rawRange.StartKey, EndKey: rawRange.EndKey}
+				restoreRanges = append(restoreRanges, rawRestoreRange{KeyRange: rg, CF: cf})
+			}
+		}
+	}
+	if len(restoreRanges) == 0 {
+		return nil, errors.Annotatef(berrors.ErrRestoreRangeMismatch,
+			"no backup data of cf %s", strings.Join(cfg.CFs, ","))
+	}
+	if len(cfg.OldKeyPrefix) == 0 {
+		return restoreRanges, nil
+	}
+
+	prefixRange := rtree.Range{StartKey: cfg.OldKeyPrefix, EndKey: kv.Key(cfg.OldKeyPrefix).PrefixNext()}
+	narrowed := restoreRanges[:0]
+	for _, rg := range restoreRanges {
+		startKey, endKey, ok := prefixRange.Intersect(rg.StartKey, rg.EndKey)
+		if !ok {
+			continue
+		}
+		rg.KeyRange = KeyRange{StartKey: startKey, EndKey: endKey}
+		narrowed = append(narrowed, rg)
+	}
+	if len(narrowed) == 0 {
+		return nil, errors.Annotatef(berrors.ErrRestoreInvalidRange,
+			"no key with the prefix %s in the ranges to restore", redact.Key(cfg.OldKeyPrefix))
+	}
+	return narrowed, nil
 }
 
 func (cfg *RestoreRawConfig) adjust() {
@@ -59,7 +525,7 @@ func (cfg *RestoreRawConfig) adjust() {
 func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreRawConfig) (err error) {
 	cfg.adjust()
 
-	defer summary.Summary(cmdName)
+	defer summary.FromContext(c).Summary(cmdName)
 	ctx, cancel := context.WithCancel(c)
 	defer cancel()
 
@@ -83,58 +549,110 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 	client.SetRateLimit(cfg.RateLimit)
 	client.SetCrypter(&cfg.CipherInfo)
 	client.SetConcurrency(uint(cfg.Concurrency))
-	if cfg.Online {
+	if cfg.Online || len(cfg.RestoreStoreLabels) > 0 {
+		// The cluster isn't switched to import mode, so the stores serving
+		// online traffic aren't affected.
 		client.EnableOnline()
 	}
+	client.SetRestoreStoreLabels(cfg.restoreStoreLabels())
 	client.SetSwitchModeInterval(cfg.SwitchModeInterval)
 
-	u, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	backups, err := readRawBackupChain(ctx, cfg)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	reader := metautil.NewMetaReader(backupMeta, s, &cfg.CipherInfo)
-	if err = client.InitBackupMeta(c, backupMeta, u, s, reader); err != nil {
+	clusterVersion, err := getRawRestoreAPIVersion(ctx, mgr, backups[0].meta)
+	if err != nil {
 		return errors.Trace(err)
 	}
-
-	if !client.IsRawKvMode() {
-		return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do raw restore from transactional data")
+	srcPrefix, dstPrefix, err := cfg.keyspacePrefixes(backups[0].meta.ApiVersion, clusterVersion)
+	if err != nil {
+		return errors.Trace(err)
 	}
-
-	files, err := client.GetFilesInRawRange(cfg.StartKey, cfg.EndKey, cfg.CF)
+	cfg = cfg.withKeyspacePrefixes(srcPrefix, dstPrefix)
+	restoreRanges, err := cfg.restoreRanges(backups[0].meta)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	archiveSize := reader.ArchiveSize(ctx, files)
-	g.Record(summary.RestoreDataSize, archiveSize)
+	rewriteRules := cfg.rewriteRules()
 
-	if len(files) == 0 {
-		log.Info("all files are filtered out from the backup archive, nothing to restore")
-		return nil
+	// The raw kv client only speaks API V1.
+	rawKVClientSupported := clusterVersion != kvrpcpb.APIVersion_V2
+	if rawKVClientSupported {
+		if err = checkRawRestoreTTL(ctx, mgr, cfg, backups[0].meta); err != nil {
+			return errors.Trace(err)
+		}
+	} else if cfg.TTLMode == ttlModeRemaining {
+		return errors.Annotatef(berrors.ErrRestoreAPIVersionMismatch,
+			"--%s=%s isn't supported in an API V2 cluster", flagTTLMode, ttlModeRemaining)
+	} else if cfg.OnConflict != onConflictOverwrite {
+		return errors.Annotatef(berrors.ErrRestoreAPIVersionMismatch,
+			"--%s=%s isn't supported in an API V2 cluster", flagOnConflict, cfg.OnConflict)
+	}
+	if cfg.DryRun {
+		return errors.Trace(runRawRestorePlan(
+			ctx, client, cfg, backups, restoreRanges, rewriteRules, rawKVClientSupported, os.Stdout))
 	}
-	summary.CollectInt("restore files", len(files))
 
-	ranges, _, err := restore.MergeFileRanges(
-		files, cfg.MergeSmallRegionKeyCount, cfg.MergeSmallRegionKeyCount)
+	needChecksum := cfg.Checksum
+	if reason := rawRestoreChecksumUnsupported(cfg, backups); needChecksum && len(reason) > 0 {
+		if cfg.checksumRequested {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"--%s isn't supported %s, please use --%s=false", flagChecksum, reason, flagChecksum)
+		}
+		log.Warn("Skip raw checksum, the restored data won't be verified", zap.String("reason", reason))
+		needChecksum = false
+	}
+
+	relayServer, err := cfg.startRelay()
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if relayServer != nil {
+		defer relayServer.Close()
+		// Every backup in the chain is relayed as a bucket.
+		for i := range backups {
+			bucket := fmt.Sprintf("%s-%d", relayBackupBucket, i)
+			relayServer.Register(bucket, backups[i].storage)
+			backups[i].backend = relayServer.Backend(bucket)
+		}
+	}
 
-	// Redirect to log if there is no log file to avoid unreadable output.
-	// TODO: How to show progress?
-	updateCh := g.StartProgress(
-		ctx,
-		"Raw Restore",
-		// Split/Scatter + Download/Ingest
-		int64(len(ranges)+len(files)),
-		!cfg.LogProgress)
+	var rawClient restore.RawKVBatchClient
+	if cfg.TTLMode == ttlModeRemaining || cfg.OnConflict != onConflictOverwrite {
+		cli, err := newRawKVClient(ctx, &cfg.Config)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer cli.Close()
+		if cfg.OnConflict == onConflictFail {
+			if cfg.Resume {
+				// The keys restored before resuming are in the target ranges.
+				log.Info("Skip checking the existing data when resuming")
+			} else if err = checkRawRestoreTargetEmpty(ctx, cli, restoreRanges, rewriteRules); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if cfg.TTLMode == ttlModeRemaining || cfg.OnConflict == onConflictSkipExisting {
+			rawClient = cli
+		}
+	}
 
-	// RawKV restore does not need to rewrite keys.
-	rewrite := &restore.RewriteRules{}
-	err = restore.SplitRanges(ctx, client, ranges, rewrite, updateCh)
+	checkpoint, err := newRawRestoreCheckpoint(ctx, cfg, mgr.GetPDClient().GetClusterID(ctx), backups, restoreRanges)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	client.SetCheckpointRunner(checkpoint)
+	checkpointCtx, cancelCheckpoint := context.WithCancel(ctx)
+	go checkpoint.FlushPeriodically(checkpointCtx, cfg.CheckpointInterval)
+	defer func() {
+		cancelCheckpoint()
+		// Save the files ingested so far if the restore fails, nothing is
+		// saved if the checkpoint has been removed.
+		if err := checkpoint.Flush(context.Background()); err != nil {
+			log.Warn("failed to flush restore checkpoint", zap.Error(err))
+		}
+	}()
 
 	restoreSchedulers, err := restorePreWork(ctx, client, mgr)
 	if err != nil {
@@ -142,15 +660,567 @@ func RunRestoreRaw(c context.Context, g glue.Glue, cmdName string, cfg *RestoreR
 	}
 	defer restorePostWork(ctx, client, restoreSchedulers)
 
-	err = client.RestoreRaw(ctx, cfg.StartKey, cfg.EndKey, files, updateCh)
+	if err = client.LoadRestoreStores(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	placementRanges := rawPlacementRanges(restoreRanges, rewriteRules)
+	if err = client.SetupRawPlacementRules(ctx, placementRanges); err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		// The regions are released to PD even if the restore fails.
+		if err := client.ResetRawPlacementRules(context.Background(), placementRanges); err != nil {
+			log.Warn("failed to reset placement rules", zap.Error(err))
+		}
+	}()
+	if err = client.WaitRawPlacementSchedule(ctx, placementRanges); err != nil {
+		return errors.Trace(err)
+	}
+
+	if cfg.AdaptiveThrottle {
+		throttler := restore.NewThrottler(cfg.throttleConfig(), mgr, client.SetDownloadSpeedLimit)
+		client.SetThrottler(throttler)
+		throttleCtx, cancelThrottle := context.WithCancel(ctx)
+		throttleDone := make(chan struct{})
+		go func() {
+			defer close(throttleDone)
+			throttler.Run(throttleCtx)
+		}()
+		// Wait for the download speed limit to be set back.
+		defer func() {
+			cancelThrottle()
+			<-throttleDone
+		}()
+	}
+
+	order := make([]int, 0, len(backups))
+	for i := range backups {
+		order = append(order, i)
+	}
+	if cfg.OnConflict == onConflictSkipExisting {
+		// The newer backups are restored first, so that the keys restored
+		// from them are kept as the existing keys, as well as the keys
+		// written online.
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+	}
+	for n, i := range order {
+		progressName := "Raw Restore"
+		if len(backups) > 1 {
+			progressName = fmt.Sprintf("Raw Restore (%d/%d)", n+1, len(backups))
+		}
+		if err = restoreRawBackup(
+			ctx, g, client, cfg, backups[i], restoreRanges, rewriteRules, rawClient, checkpoint, progressName,
+			needChecksum); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	// The restore is finished, the checkpoint is useless.
+	if err = checkpoint.Remove(ctx); err != nil {
+		log.Warn("failed to remove restore checkpoint", zap.Error(err))
+	}
+
+	// Set task summary to success status.
+	summary.FromContext(ctx).SetSuccessStatus(true)
+	return nil
+}
+
+// rawRestoreChecksumUnsupported returns why the restored data can't be
+// compared with the checksum of the backup, or "" if it can.
+func rawRestoreChecksumUnsupported(cfg *RestoreRawConfig, backups []rawBackup) string {
+	switch {
+	case len(backups) > 1 || backups[0].meta.StartVersion > 0:
+		// The checksum of the files can only be compared with the restored
+		// data when a single full backup is restored.
+		return "in incremental restore"
+	case !bytes.Equal(cfg.OldKeyPrefix, cfg.NewKeyPrefix):
+		// The checksum covers the keys, so it changes after rewriting.
+		return "when rewriting the key prefix or the keyspace"
+	case cfg.TTLMode == ttlModeRemaining:
+		// The expire timestamps in the values are changed.
+		return fmt.Sprintf("with --%s=%s", flagTTLMode, ttlModeRemaining)
+	case cfg.OnConflict == onConflictSkipExisting:
+		// The existing keys are kept.
+		return fmt.Sprintf("with --%s=%s", flagOnConflict, onConflictSkipExisting)
+	}
+	return ""
+}
+
+// rawPlacementRanges returns the ranges of the restored keys, which are placed
+// onto the restore stores.
+func rawPlacementRanges(restoreRanges []rawRestoreRange, rewriteRules *restore.RewriteRules) []rtree.Range {
+	ranges := make([]rtree.Range, 0, len(restoreRanges))
+	for _, rr := range restoreRanges {
+		rg := []rtree.Range{{StartKey: rr.StartKey, EndKey: rr.EndKey}}
+		ranges = append(ranges, restore.RewriteRawRanges(rg, rr.StartKey, rr.EndKey, rewriteRules)...)
+	}
+	return restore.MergeRawPlacementRanges(ranges)
+}
+
+// runRawRestorePlan prints the plan of the restore to out, the existing data
+// is checked only if checkExistingData is true.
+func runRawRestorePlan(
+	ctx context.Context,
+	client *restore.Client,
+	cfg *RestoreRawConfig,
+	backups []rawBackup,
+	restoreRanges []rawRestoreRange,
+	rewriteRules *restore.RewriteRules,
+	checkExistingData bool,
+	out io.Writer,
+) error {
+	var scanner rawKVScanner
+	if checkExistingData {
+		rawClient, err := newRawKVClient(ctx, &cfg.Config)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer rawClient.Close()
+		scanner = rawClient
+	}
+	plan, err := planRawRestore(ctx, client, cfg, backups, restoreRanges, rewriteRules, scanner)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = plan.write(out); err != nil {
+		return errors.Trace(err)
+	}
+	summary.FromContext(ctx).SetSuccessStatus(true)
+	return nil
+}
+
+// rawBackup is a raw backup to be restored, along with the storage it is read from.
+type rawBackup struct {
+	backend *backuppb.StorageBackend
+	storage storage.ExternalStorage
+	meta    *backuppb.BackupMeta
+	// cipher decrypts the backup, each backup has its own data key if it's
+	// encrypted by a master key.
+	cipher backuppb.CipherInfo
+	// checksums are the checksums of the backed up ranges, which aren't
+	// recorded by the older versions.
+	checksums []checksum.RawRangeChecksum
+}
+
+// readRawBackupChain reads the backupmeta of the base backup and all the
+// incremental backups, and checks that they form a continuous chain.
+func readRawBackupChain(ctx context.Context, cfg *RestoreRawConfig) ([]rawBackup, error) {
+	storages := append([]string{cfg.Storage}, cfg.IncrementalStorages...)
+	backups := make([]rawBackup, 0, len(storages))
+	metas := make([]*backuppb.BackupMeta, 0, len(storages))
+	for _, storageURL := range storages {
+		backupCfg := cfg.Config
+		backupCfg.Storage = storageURL
+		u, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &backupCfg)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		b := rawBackup{backend: u, storage: s, meta: backupMeta, cipher: backupCfg.CipherInfo}
+		if b.checksums, err = readRawRangeChecksums(ctx, s, &b.cipher); err != nil {
+			return nil, errors.Trace(err)
+		}
+		backups = append(backups, b)
+		metas = append(metas, backupMeta)
+	}
+	if err := checkRawBackupChain(metas); err != nil {
+		return nil, errors.Trace(err)
+	}
+	// The checkpoint is encrypted by the data key of the base backup.
+	cfg.CipherInfo = backups[0].cipher
+	return backups, nil
+}
+
+// readRawRangeChecksums reads the checksums of the backed up ranges, nil is
+// returned if they aren't recorded.
+func readRawRangeChecksums(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	cipher *backuppb.CipherInfo,
+) ([]checksum.RawRangeChecksum, error) {
+	exists, err := s.FileExists(ctx, metautil.RawChecksumFile)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	var checksums []checksum.RawRangeChecksum
+	err = metautil.ReadCheckpointFile(ctx, s, cipher, metautil.RawChecksumFile, &checksums)
+	return checksums, errors.Trace(err)
+}
+
+// checkRawBackupChain checks that every backup is a raw backup of the same
+// cluster, and that each incremental backup starts where the previous one ends.
+func checkRawBackupChain(metas []*backuppb.BackupMeta) error {
+	for i, m := range metas {
+		if !m.IsRawKv {
+			return errors.Annotate(berrors.ErrRestoreModeMismatch, "cannot do raw restore from transactional data")
+		}
+		if i == 0 {
+			continue
+		}
+		prev := metas[i-1]
+		if m.ApiVersion != prev.ApiVersion {
+			return errors.Annotatef(berrors.ErrRestoreTTLMismatch,
+				"backup %d is taken with api version %s, but backup %d is taken with api version %s",
+				i, m.ApiVersion, i-1, prev.ApiVersion)
+		}
+		if m.ClusterId != prev.ClusterId {
+			return errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+				"backup %d is taken from cluster %d, but backup %d is taken from cluster %d",
+				i, m.ClusterId, i-1, prev.ClusterId)
+		}
+		if m.StartVersion == 0 || m.StartVersion != prev.EndVersion {
+			return errors.Annotatef(berrors.ErrRestoreInvalidBackup,
+				"backup %d starts at %d, which does not match the end %d of backup %d, "+
+					"the incremental backups must be given in the order they were taken",
+				i, m.StartVersion, prev.EndVersion, i-1)
+		}
+	}
+	return nil
+}
+
+// getRawRestoreAPIVersion returns the api version of the cluster, the keys
+// and the values can't be restored correctly without knowing it.
+func getRawRestoreAPIVersion(
+	ctx context.Context,
+	mgr *conn.Mgr,
+	meta *backuppb.BackupMeta,
+) (kvrpcpb.APIVersion, error) {
+	clusterVersion, err := mgr.GetAPIVersion(ctx)
+	if err != nil {
+		return kvrpcpb.APIVersion_V1, errors.Annotate(err, "failed to get the api version of the cluster")
+	}
+	log.Info("raw restore api version",
+		zap.Stringer("backup", meta.ApiVersion), zap.Stringer("cluster", clusterVersion))
+	return clusterVersion, nil
+}
+
+// checkRawRestoreTTL checks whether the ttl setting of the cluster matches the
+// backup, the values of the keys can't be read correctly otherwise.
+func checkRawRestoreTTL(ctx context.Context, mgr *conn.Mgr, cfg *RestoreRawConfig, meta *backuppb.BackupMeta) error {
+	backupTTL := meta.ApiVersion == kvrpcpb.APIVersion_V1TTL
+	if cfg.TTLMode == ttlModeRemaining && !backupTTL {
+		return errors.Annotatef(berrors.ErrRestoreTTLMismatch,
+			"--%s=%s requires a backup of the cluster with ttl enabled", flagTTLMode, ttlModeRemaining)
+	}
+	ttlEnabled, err := mgr.IsTTLEnabled(ctx)
+	if err != nil {
+		return errors.Annotate(err, "failed to check whether ttl is enabled")
+	}
+	if backupTTL && !ttlEnabled {
+		return errors.Annotate(berrors.ErrRestoreTTLMismatch,
+			"the backup is taken with ttl enabled, but ttl isn't enabled in the cluster")
+	}
+	if !backupTTL && ttlEnabled {
+		// The backups taken by the older versions don't record whether ttl is enabled.
+		log.Warn("ttl is enabled in the cluster, but the backup isn't recorded to be taken with ttl enabled")
+	}
+	return nil
+}
+
+// checkRawRestoreTargetEmpty checks that there is no key in the ranges to
+// restore into. Only the default cf can be scanned, so it fails if any range
+// is of another cf.
+func checkRawRestoreTargetEmpty(
+	ctx context.Context,
+	scanner rawKVScanner,
+	restoreRanges []rawRestoreRange,
+	rewriteRules *restore.RewriteRules,
+) error {
+	for _, rr := range restoreRanges {
+		if rr.CF != defaultRawCF {
+			return errors.Annotatef(berrors.ErrInvalidArgument,
+				"can't check the existing data of cf %s, only the default cf can be scanned, use --%s=%s to restore it",
+				rr.CF, flagOnConflict, onConflictOverwrite)
+		}
+	}
+	for _, rr := range restoreRanges {
+		target := restore.RewriteRawRanges(
+			[]rtree.Range{{StartKey: rr.StartKey, EndKey: rr.EndKey}}, rr.StartKey, rr.EndKey, rewriteRules)[0]
+		keys, _, err := scanner.Scan(ctx, target.StartKey, target.EndKey, 1)
+		if err != nil {
+			return errors.Annotate(err, "failed to check the existing data")
+		}
+		if len(keys) > 0 {
+			return errors.Annotatef(berrors.ErrRestoreDataExists,
+				"found key %s in range [%s, %s), use --%s=%s or %s to restore into it",
+				redact.Key(keys[0]), redact.Key(target.StartKey), redact.Key(target.EndKey),
+				flagOnConflict, onConflictOverwrite, onConflictSkipExisting)
+		}
+	}
+	return nil
+}
+
+// newRawRestoreCheckpoint loads the checkpoint to resume the restore from, or
+// creates a new one. It returns nil if the checkpoint storage isn't set.
+func newRawRestoreCheckpoint(
+	ctx context.Context,
+	cfg *RestoreRawConfig,
+	clusterID uint64,
+	backups []rawBackup,
+	restoreRanges []rawRestoreRange,
+) (*restore.CheckpointRunner, error) {
+	if len(cfg.CheckpointStorage) == 0 {
+		return nil, nil
+	}
+	checkpointCfg := cfg.Config
+	checkpointCfg.Storage = cfg.CheckpointStorage
+	_, s, err := GetStorage(ctx, &checkpointCfg)
 	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	meta := restore.CheckpointMeta{
+		ClusterID:    clusterID,
+		BackupTSs:    make([]uint64, 0, len(backups)),
+		Ranges:       make([]*backuppb.RawRange, 0, len(restoreRanges)),
+		OldKeyPrefix: cfg.OldKeyPrefix,
+		NewKeyPrefix: cfg.NewKeyPrefix,
+		TTLMode:      cfg.TTLMode,
+		OnConflict:   cfg.OnConflict,
+	}
+	for _, b := range backups {
+		meta.BackupTSs = append(meta.BackupTSs, b.meta.EndVersion)
+	}
+	for _, rr := range restoreRanges {
+		meta.Ranges = append(meta.Ranges, &backuppb.RawRange{StartKey: rr.StartKey, EndKey: rr.EndKey, Cf: rr.CF})
+	}
+
+	checkpoint, err := restore.LoadCheckpointRunner(ctx, s, &cfg.CipherInfo)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if checkpoint == nil {
+		if cfg.Resume {
+			log.Info("no checkpoint found, start a new restore")
+		}
+		checkpoint, err = restore.NewCheckpointRunner(ctx, s, &cfg.CipherInfo, meta)
+		return checkpoint, errors.Trace(err)
+	}
+	if !cfg.Resume {
+		return nil, errors.Annotatef(berrors.ErrInvalidArgument,
+			"there is an unfinished restore in %s, please use --%s to resume it or specify another --%s",
+			s.URI(), flagResume, flagCheckpointStorage)
+	}
+	loadedMeta := checkpoint.Meta()
+	if err = loadedMeta.Check(&meta); err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.Info("resume restore from checkpoint")
+	return checkpoint, nil
+}
+
+// restoreRawBackup restores the files of a single raw backup in the given ranges.
+// The files ingested before resuming are skipped.
+func restoreRawBackup(
+	ctx context.Context,
+	g glue.Glue,
+	client *restore.Client,
+	cfg *RestoreRawConfig,
+	b rawBackup,
+	restoreRanges []rawRestoreRange,
+	rewriteRules *restore.RewriteRules,
+	rawClient restore.RawKVBatchClient,
+	checkpoint *restore.CheckpointRunner,
+	progressName string,
+	needChecksum bool,
+) error {
+	client.SetCrypter(&b.cipher)
+	reader := metautil.NewMetaReader(b.meta, b.storage, &b.cipher)
+	if err := client.InitBackupMeta(ctx, b.meta, b.backend, b.storage, reader); err != nil {
 		return errors.Trace(err)
 	}
+	log.Info("restore raw backup",
+		zap.Uint64("start-version", b.meta.StartVersion),
+		zap.Uint64("end-version", b.meta.EndVersion))
+
+	// The files to restore, the merged ranges of them, and the merged ranges
+	// of all the files for checksum of each restore range.
+	filesOfRanges := make([][]*backuppb.File, 0, len(restoreRanges))
+	splitRangesOfRanges := make([][]rtree.Range, 0, len(restoreRanges))
+	rangesOfRanges := make([][]rtree.Range, 0, len(restoreRanges))
+	totalFiles, totalRanges, skippedFiles := 0, 0, 0
+	var archiveSize uint64
+	for _, rr := range restoreRanges {
+		files, err := client.GetFilesInRawRange(rr.StartKey, rr.EndKey, rr.CF)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		ranges, _, err := restore.MergeFileRanges(
+			files, cfg.MergeSmallRegionKeyCount, cfg.MergeSmallRegionKeyCount)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		archiveSize += reader.ArchiveSize(ctx, files)
+		rangesOfRanges = append(rangesOfRanges, ranges)
+
+		remainingFiles := make([]*backuppb.File, 0, len(files))
+		for _, f := range files {
+			if checkpoint.IsIngested(b.meta.EndVersion, rr.StartKey, f) {
+				skippedFiles++
+				continue
+			}
+			remainingFiles = append(remainingFiles, f)
+		}
+		splitRanges := ranges
+		if len(remainingFiles) < len(files) {
+			splitRanges, _, err = restore.MergeFileRanges(
+				remainingFiles, cfg.MergeSmallRegionKeyCount, cfg.MergeSmallRegionKeyCount)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		filesOfRanges = append(filesOfRanges, remainingFiles)
+		splitRangesOfRanges = append(splitRangesOfRanges, splitRanges)
+		totalFiles += len(remainingFiles)
+		totalRanges += len(splitRanges)
+	}
+	g.Record(ctx, summary.RestoreDataSize, archiveSize)
+
+	if totalFiles == 0 && skippedFiles == 0 {
+		log.Info("all files are filtered out from the backup archive, nothing to restore")
+		return nil
+	}
+	if skippedFiles > 0 {
+		log.Info("skip the files ingested before resuming", zap.Int("files", skippedFiles))
+	}
+	summary.FromContext(ctx).CollectInt("restore files", totalFiles)
+
+	// Redirect to log if there is no log file to avoid unreadable output.
+	updateCh := g.StartProgress(
+		ctx,
+		progressName,
+		// Split/Scatter + Download/Ingest
+		int64(totalRanges+totalFiles),
+		!cfg.LogProgress)
+
+	for i, rr := range restoreRanges {
+		files, ranges := filesOfRanges[i], splitRangesOfRanges[i]
+		if len(files) == 0 {
+			continue
+		}
+		// The ranges are rewritten before splitting, so the splitter doesn't
+		// need to rewrite the keys again.
+		splitRanges := restore.RewriteRawRanges(ranges, rr.StartKey, rr.EndKey, rewriteRules)
+		err := restore.SplitRanges(ctx, client, splitRanges, &restore.RewriteRules{}, updateCh)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if rawClient != nil {
+			opts := restore.RawWriteOptions{
+				TTLEnabled:   b.meta.ApiVersion == kvrpcpb.APIVersion_V1TTL,
+				RemainingTTL: cfg.TTLMode == ttlModeRemaining,
+				SkipExisting: cfg.OnConflict == onConflictSkipExisting,
+			}
+			err = client.RestoreRawByWriting(ctx, rr.StartKey, rr.EndKey, files, rewriteRules, rawClient, opts, updateCh)
+		} else {
+			err = client.RestoreRaw(ctx, rr.StartKey, rr.EndKey, files, rewriteRules, updateCh)
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
 
 	// Restore has finished.
 	updateCh.Close()
 
-	// Set task summary to success status.
-	summary.SetSuccessStatus(true)
+	if !needChecksum {
+		return nil
+	}
+	for i, rr := range restoreRanges {
+		if rr.CF != defaultRawCF {
+			log.Warn("Skip raw checksum, only the default cf can be checksummed", zap.String("cf", rr.CF))
+			continue
+		}
+		if err := checksumRawRanges(ctx, client, cfg, rr, rangesOfRanges[i], b.checksums); err != nil {
+			return errors.Trace(err)
+		}
+	}
 	return nil
 }
+
+// checksumRawRanges checks the restored data of each range against the
+// checksum of the backup files in the range. The whole restore range is
+// checked if it's a backed up range with the checksum recorded.
+func checksumRawRanges(
+	ctx context.Context,
+	client *restore.Client,
+	cfg *RestoreRawConfig,
+	restoreRange rawRestoreRange,
+	ranges []rtree.Range,
+	recorded []checksum.RawRangeChecksum,
+) error {
+	start := time.Now()
+	defer func() {
+		summary.FromContext(ctx).CollectDuration("restore raw checksum", time.Since(start))
+	}()
+
+	checkRanges, err := rawChecksumRanges(restoreRange, ranges, recorded)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	exec := checksum.NewRawExecutor(
+		client.GetPDClient(), client.GetTLSConfig(), GetKeepalive(&cfg.Config), cfg.ChecksumConcurrency)
+	defer exec.Close()
+	mismatched, err := exec.ValidateRanges(ctx, checkRanges)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(mismatched) > 0 {
+		return errors.Annotatef(berrors.ErrRestoreChecksumMismatch,
+			"checksum mismatch in %d of %d ranges: %s", len(mismatched), len(checkRanges), formatRanges(mismatched))
+	}
+	log.Info("raw checksum success", zap.Int("ranges", len(checkRanges)))
+	return nil
+}
+
+// rawChecksumRanges returns the ranges to check after restoring the files of
+// ranges into the restore range.
+func rawChecksumRanges(
+	restoreRange rawRestoreRange,
+	ranges []rtree.Range,
+	recorded []checksum.RawRangeChecksum,
+) ([]rtree.Range, error) {
+	for _, c := range recorded {
+		if c.CF != restoreRange.CF || !bytes.Equal(c.StartKey, restoreRange.StartKey) ||
+			!bytes.Equal(c.EndKey, restoreRange.EndKey) {
+			continue
+		}
+		whole := rtree.Range{StartKey: restoreRange.StartKey, EndKey: restoreRange.EndKey}
+		for _, rg := range ranges {
+			whole.Files = append(whole.Files, rg.Files...)
+		}
+		// The files of the range are missing in the backupmeta otherwise.
+		if files := checksum.CalcRawFilesChecksum(whole.Files); files != c.RawChecksum {
+			return nil, errors.Annotatef(berrors.ErrRestoreChecksumMismatch,
+				"the files of range [%s, %s) don't match the checksum recorded at backup time, "+
+					"recorded %d kvs, but the files have %d kvs",
+				redact.Key(c.StartKey), redact.Key(c.EndKey), c.TotalKvs, files.TotalKvs)
+		}
+		return []rtree.Range{whole}, nil
+	}
+
+	// Files crossing the boundaries of the restore range are only partially
+	// restored, so their checksum can't be compared with the restored data.
+	checkRanges := make([]rtree.Range, 0, len(ranges))
+	for _, rg := range ranges {
+		if bytes.Compare(rg.StartKey, restoreRange.StartKey) < 0 ||
+			utils.CompareEndKey(rg.EndKey, restoreRange.EndKey) > 0 {
+			log.Warn("skip raw checksum for the range partially restored",
+				logutil.Key("startKey", rg.StartKey), logutil.Key("endKey", rg.EndKey))
+			continue
+		}
+		checkRanges = append(checkRanges, rg)
+	}
+	return checkRanges, nil
+}
+
+func formatRanges(ranges []rtree.Range) string {
+	rangeStrs := make([]string, 0, len(ranges))
+	for _, rg := range ranges {
+		rangeStrs = append(rangeStrs, fmt.Sprintf("[%s, %s)", redact.Key(rg.StartKey), redact.Key(rg.EndKey)))
+	}
+	return strings.Join(rangeStrs, ", ")
+}
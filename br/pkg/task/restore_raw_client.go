@@ -0,0 +1,111 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/rawkv"
+	"github.com/tikv/client-go/v2/tikv"
+	"github.com/tikv/client-go/v2/tikvrpc"
+	pd "github.com/tikv/pd/client"
+)
+
+// rawKVMaxBackoff is the max sleep time in milliseconds of writing a key.
+const rawKVMaxBackoff = 20000
+
+// rawKVClient is the raw kv client of the cluster. Besides the raw kv client
+// of client-go, it can put a key with ttl only if the key doesn't exist, which
+// is a compare-and-swap against the absent value in TiKV.
+type rawKVClient struct {
+	*rawkv.Client
+	pdClient    pd.Client
+	regionCache *tikv.RegionCache
+	rpcClient   tikv.Client
+}
+
+// newRawKVClient returns a raw kv client of the cluster.
+func newRawKVClient(ctx context.Context, cfg *Config) (*rawKVClient, error) {
+	security := config.Security{
+		ClusterSSLCA:   cfg.TLS.CA,
+		ClusterSSLCert: cfg.TLS.Cert,
+		ClusterSSLKey:  cfg.TLS.Key,
+	}
+	cli, err := rawkv.NewClient(ctx, cfg.PD, security)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pdClient, err := pd.NewClientWithContext(ctx, cfg.PD, pd.SecurityOption{
+		CAPath:   cfg.TLS.CA,
+		CertPath: cfg.TLS.Cert,
+		KeyPath:  cfg.TLS.Key,
+	})
+	if err != nil {
+		_ = cli.Close()
+		return nil, errors.Trace(err)
+	}
+	return &rawKVClient{
+		Client:      cli,
+		pdClient:    pdClient,
+		regionCache: tikv.NewRegionCache(pdClient),
+		rpcClient:   tikv.NewRPCClient(tikv.WithSecurity(security)),
+	}, nil
+}
+
+// PutIfAbsent puts the key with the ttl in seconds if it doesn't exist in the
+// cluster, the check and the put are atomic. It returns whether the key is
+// put. A zero ttl means the key never expires.
+func (c *rawKVClient) PutIfAbsent(ctx context.Context, key, value []byte, ttl uint64) (bool, error) {
+	req := tikvrpc.NewRequest(tikvrpc.CmdRawCompareAndSwap, &kvrpcpb.RawCASRequest{
+		Key:              key,
+		Value:            value,
+		PreviousNotExist: true,
+		Ttl:              ttl,
+	})
+	req.MaxExecutionDurationMs = uint64(tikv.MaxWriteExecutionTime.Milliseconds())
+	bo := tikv.NewBackofferWithVars(ctx, rawKVMaxBackoff, nil)
+	sender := tikv.NewRegionRequestSender(c.regionCache, c.rpcClient)
+	for {
+		loc, err := c.regionCache.LocateKey(bo, key)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		resp, err := sender.SendReq(bo, req, loc.Region, tikv.ReadTimeoutShort)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if regionErr != nil {
+			if err = bo.Backoff(tikv.BoRegionMiss(), errors.New(regionErr.String())); err != nil {
+				return false, errors.Trace(err)
+			}
+			continue
+		}
+		cmdResp, ok := resp.Resp.(*kvrpcpb.RawCASResponse)
+		if !ok {
+			return false, errors.Annotate(berrors.ErrKVUnknown, "missing the response of compare-and-swap")
+		}
+		if len(cmdResp.GetError()) > 0 {
+			return false, errors.Annotate(berrors.ErrKVUnknown, cmdResp.GetError())
+		}
+		return cmdResp.GetSucceed(), nil
+	}
+}
+
+// Close closes the client.
+func (c *rawKVClient) Close() error {
+	c.regionCache.Close()
+	c.pdClient.Close()
+	err := c.rpcClient.Close()
+	if closeErr := c.Client.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Trace(err)
+}
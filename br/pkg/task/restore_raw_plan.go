@@ -0,0 +1,250 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/docker/go-units"
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/redact"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/summary"
+	"github.com/tikv/pd/pkg/codec"
+	"go.uber.org/zap"
+)
+
+const (
+	// eventPlan is the type of the plan event in the JSON output.
+	eventPlan = "plan"
+
+	existingDataFound     = "found"
+	existingDataNone      = "none"
+	existingDataUnchecked = "unchecked"
+)
+
+// rawKVScanner scans the raw kv pairs of the default cf.
+type rawKVScanner interface {
+	Scan(ctx context.Context, startKey, endKey []byte, limit int) (keys [][]byte, values [][]byte, err error)
+}
+
+// rawRestorePlan is what the raw restore would do, it's computed without
+// changing anything in the cluster.
+type rawRestorePlan struct {
+	Type    string                 `json:"type"`
+	Backups []rawRestorePlanBackup `json:"backups"`
+	Ranges  []*rawRestorePlanRange `json:"ranges"`
+
+	Files      int    `json:"files"`
+	TotalKVs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+	DataSize   uint64 `json:"data-size"`
+	SplitKeys  int    `json:"split-keys"`
+	// Regions is the number of the regions in the target ranges now.
+	Regions int `json:"regions"`
+	// Stores are the stores the data is restored onto, which are the stores of
+	// the regions in the target ranges, or the stores with the restore labels.
+	Stores []uint64 `json:"target-stores"`
+}
+
+// rawRestorePlanBackup is a backup in the chain to restore.
+type rawRestorePlanBackup struct {
+	Storage      string `json:"storage"`
+	StartVersion uint64 `json:"start-version"`
+	EndVersion   uint64 `json:"end-version"`
+	Files        int    `json:"files"`
+}
+
+// rawRestorePlanRange is what would be restored into a range.
+type rawRestorePlanRange struct {
+	CF       string `json:"cf"`
+	StartKey string `json:"start-key"`
+	EndKey   string `json:"end-key"`
+	// TargetStartKey and TargetEndKey are the range the keys are restored to,
+	// which differs from the range if the keys are rewritten.
+	TargetStartKey string `json:"target-start-key"`
+	TargetEndKey   string `json:"target-end-key"`
+
+	Files      int    `json:"files"`
+	TotalKVs   uint64 `json:"total-kvs"`
+	TotalBytes uint64 `json:"total-bytes"`
+	DataSize   uint64 `json:"data-size"`
+	SplitKeys  int    `json:"split-keys"`
+	Regions    int    `json:"regions"`
+	// ExistingData is whether there are keys in the target range already, it
+	// can only be checked for the default cf.
+	ExistingData string `json:"existing-data"`
+
+	targetRange rtree.Range
+}
+
+// planRawRestore computes the plan of restoring the backups by reading the
+// backups and the regions, nothing is split or ingested.
+func planRawRestore(
+	ctx context.Context,
+	client *restore.Client,
+	cfg *RestoreRawConfig,
+	backups []rawBackup,
+	restoreRanges []rawRestoreRange,
+	rewriteRules *restore.RewriteRules,
+	scanner rawKVScanner,
+) (*rawRestorePlan, error) {
+	plan := &rawRestorePlan{
+		Type:    eventPlan,
+		Backups: make([]rawRestorePlanBackup, 0, len(backups)),
+		Ranges:  make([]*rawRestorePlanRange, 0, len(restoreRanges)),
+		Stores:  make([]uint64, 0),
+	}
+	for _, rr := range restoreRanges {
+		target := restore.RewriteRawRanges(
+			[]rtree.Range{{StartKey: rr.StartKey, EndKey: rr.EndKey}}, rr.StartKey, rr.EndKey, rewriteRules)[0]
+		plan.Ranges = append(plan.Ranges, &rawRestorePlanRange{
+			CF:             rr.CF,
+			StartKey:       redact.Key(rr.StartKey),
+			EndKey:         redact.Key(rr.EndKey),
+			TargetStartKey: redact.Key(target.StartKey),
+			TargetEndKey:   redact.Key(target.EndKey),
+			ExistingData:   existingDataUnchecked,
+			targetRange:    target,
+		})
+	}
+
+	for _, b := range backups {
+		client.SetCrypter(&b.cipher)
+		reader := metautil.NewMetaReader(b.meta, b.storage, &b.cipher)
+		if err := client.InitBackupMeta(ctx, b.meta, b.backend, b.storage, reader); err != nil {
+			return nil, errors.Trace(err)
+		}
+		backupFiles := 0
+		for i, rr := range restoreRanges {
+			files, err := client.GetFilesInRawRange(rr.StartKey, rr.EndKey, rr.CF)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			ranges, _, err := restore.MergeFileRanges(
+				files, cfg.MergeSmallRegionKeyCount, cfg.MergeSmallRegionKeyCount)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			splitRanges := restore.RewriteRawRanges(ranges, rr.StartKey, rr.EndKey, rewriteRules)
+			splitKeys, err := restore.PlanSplitRanges(ctx, client, splitRanges, &restore.RewriteRules{})
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			planRange := plan.Ranges[i]
+			for _, keys := range splitKeys {
+				planRange.SplitKeys += len(keys)
+			}
+			planRange.addFiles(files)
+			planRange.DataSize += reader.ArchiveSize(ctx, files)
+			backupFiles += len(files)
+		}
+		plan.Backups = append(plan.Backups, rawRestorePlanBackup{
+			Storage:      b.storage.URI(),
+			StartVersion: b.meta.StartVersion,
+			EndVersion:   b.meta.EndVersion,
+			Files:        backupFiles,
+		})
+	}
+
+	splitClient := restore.NewSplitClient(client.GetPDClient(), client.GetTLSConfig())
+	regionIDs := make(map[uint64]struct{})
+	storeIDs := make(map[uint64]struct{})
+	for _, planRange := range plan.Ranges {
+		startKey := codec.EncodeBytes(planRange.targetRange.StartKey)
+		endKey := []byte{}
+		if len(planRange.targetRange.EndKey) > 0 {
+			endKey = codec.EncodeBytes(planRange.targetRange.EndKey)
+		}
+		regions, err := restore.PaginateScanRegion(ctx, splitClient, startKey, endKey, restore.ScanRegionPaginationLimit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		planRange.Regions = len(regions)
+		for _, r := range regions {
+			regionIDs[r.Region.GetId()] = struct{}{}
+			for _, p := range r.Region.GetPeers() {
+				storeIDs[p.GetStoreId()] = struct{}{}
+			}
+		}
+
+		if planRange.CF == defaultRawCF && scanner != nil {
+			keys, _, err := scanner.Scan(ctx, planRange.targetRange.StartKey, planRange.targetRange.EndKey, 1)
+			if err != nil {
+				return nil, errors.Annotate(err, "failed to check the existing data")
+			}
+			planRange.ExistingData = existingDataNone
+			if len(keys) > 0 {
+				planRange.ExistingData = existingDataFound
+			}
+		}
+		plan.Files += planRange.Files
+		plan.TotalKVs += planRange.TotalKVs
+		plan.TotalBytes += planRange.TotalBytes
+		plan.DataSize += planRange.DataSize
+		plan.SplitKeys += planRange.SplitKeys
+	}
+	plan.Regions = len(regionIDs)
+
+	if err := client.LoadRestoreStores(ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if restoreStores := client.RestoreStores(); len(restoreStores) > 0 {
+		plan.Stores = append(plan.Stores, restoreStores...)
+	} else {
+		for id := range storeIDs {
+			plan.Stores = append(plan.Stores, id)
+		}
+	}
+	sort.Slice(plan.Stores, func(i, j int) bool { return plan.Stores[i] < plan.Stores[j] })
+	log.Info("raw restore plan",
+		zap.Int("files", plan.Files),
+		zap.Int("split-keys", plan.SplitKeys),
+		zap.Int("regions", plan.Regions))
+	return plan, nil
+}
+
+func (r *rawRestorePlanRange) addFiles(files []*backuppb.File) {
+	r.Files += len(files)
+	for _, f := range files {
+		r.TotalKVs += f.GetTotalKvs()
+		r.TotalBytes += f.GetTotalBytes()
+	}
+}
+
+// write writes the plan to out, or as a JSON event if the JSON output is
+// enabled.
+func (p *rawRestorePlan) write(out io.Writer) error {
+	if summary.JSONOutputEnabled() {
+		return errors.Trace(summary.WriteJSONEvent(p))
+	}
+	fmt.Fprintf(out, "restore %d backups:\n", len(p.Backups))
+	for _, b := range p.Backups {
+		fmt.Fprintf(out, "  %s: versions (%d, %d], %d files\n",
+			redact.String(b.Storage), b.StartVersion, b.EndVersion, b.Files)
+	}
+	fmt.Fprintf(out, "restore %d ranges:\n", len(p.Ranges))
+	for _, r := range p.Ranges {
+		target := ""
+		if r.TargetStartKey != r.StartKey || r.TargetEndKey != r.EndKey {
+			target = fmt.Sprintf(" to [%s, %s)", r.TargetStartKey, r.TargetEndKey)
+		}
+		fmt.Fprintf(out, "  cf %s [%s, %s)%s: %d files, %d kvs, %s, archive %s, "+
+			"%d split keys, %d regions, existing data: %s\n",
+			r.CF, r.StartKey, r.EndKey, target, r.Files, r.TotalKVs,
+			units.HumanSize(float64(r.TotalBytes)), units.HumanSize(float64(r.DataSize)),
+			r.SplitKeys, r.Regions, r.ExistingData)
+	}
+	fmt.Fprintf(out, "total: %d files, %d kvs, %s, archive %s, %d split keys, %d regions, target stores %v\n",
+		p.Files, p.TotalKVs, units.HumanSize(float64(p.TotalBytes)), units.HumanSize(float64(p.DataSize)),
+		p.SplitKeys, p.Regions, p.Stores)
+	_, err := fmt.Fprintln(out, "nothing is split or ingested in the dry run")
+	return errors.Trace(err)
+}
@@ -3,9 +3,19 @@
 package task
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"testing"
 
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/tikv/migration/br/pkg/checksum"
+	berrors "github.com/tikv/migration/br/pkg/errors"
 	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/stretchr/testify/require"
 )
 
@@ -18,3 +28,382 @@ func TestRestoreConfigAdjust(t *testing.T) {
 	require.Equal(t, restore.DefaultMergeRegionKeyCount, cfg.MergeSmallRegionKeyCount)
 	require.Equal(t, restore.DefaultMergeRegionSizeBytes, cfg.MergeSmallRegionSizeBytes)
 }
+
+func TestCheckRawBackupChain(t *testing.T) {
+	full := &backuppb.BackupMeta{ClusterId: 1, IsRawKv: true, StartVersion: 0, EndVersion: 100}
+	inc1 := &backuppb.BackupMeta{ClusterId: 1, IsRawKv: true, StartVersion: 100, EndVersion: 200}
+	inc2 := &backuppb.BackupMeta{ClusterId: 1, IsRawKv: true, StartVersion: 200, EndVersion: 300}
+
+	require.NoError(t, checkRawBackupChain([]*backuppb.BackupMeta{full}))
+	require.NoError(t, checkRawBackupChain([]*backuppb.BackupMeta{full, inc1, inc2}))
+
+	// out of order
+	err := checkRawBackupChain([]*backuppb.BackupMeta{full, inc2, inc1})
+	require.True(t, berrors.Is(err, berrors.ErrRestoreInvalidBackup))
+
+	// a full backup can't follow another backup
+	err = checkRawBackupChain([]*backuppb.BackupMeta{full, full})
+	require.True(t, berrors.Is(err, berrors.ErrRestoreInvalidBackup))
+
+	// from another cluster
+	other := &backuppb.BackupMeta{ClusterId: 2, IsRawKv: true, StartVersion: 100, EndVersion: 200}
+	err = checkRawBackupChain([]*backuppb.BackupMeta{full, other})
+	require.True(t, berrors.Is(err, berrors.ErrRestoreInvalidBackup))
+
+	// transactional backup
+	txn := &backuppb.BackupMeta{ClusterId: 1, StartVersion: 100, EndVersion: 200}
+	err = checkRawBackupChain([]*backuppb.BackupMeta{full, txn})
+	require.True(t, berrors.Is(err, berrors.ErrRestoreModeMismatch))
+
+	// taken with a different ttl setting
+	ttl := &backuppb.BackupMeta{
+		ClusterId: 1, IsRawKv: true, StartVersion: 100, EndVersion: 200, ApiVersion: kvrpcpb.APIVersion_V1TTL,
+	}
+	err = checkRawBackupChain([]*backuppb.BackupMeta{full, ttl})
+	require.True(t, berrors.Is(err, berrors.ErrRestoreTTLMismatch))
+}
+
+func TestCheckRawRestoreTTL(t *testing.T) {
+	// The backup without ttl can't be restored with the remaining ttl, and
+	// the cluster isn't accessed in this case.
+	cfg := &RestoreRawConfig{TTLMode: ttlModeRemaining}
+	err := checkRawRestoreTTL(context.Background(), nil, cfg, &backuppb.BackupMeta{IsRawKv: true})
+	require.True(t, berrors.Is(err, berrors.ErrRestoreTTLMismatch))
+}
+
+func TestValidateRawRestoreConfig(t *testing.T) {
+	cfg := DefaultRawRestoreConfig()
+	require.NoError(t, cfg.Validate())
+	cfg.OnConflict = onConflictSkipExisting
+	require.NoError(t, cfg.Validate())
+	cfg.OnConflict = "ignore"
+	require.True(t, berrors.ErrInvalidArgument.Equal(cfg.Validate()))
+	cfg.OnConflict = onConflictFail
+	cfg.TTLMode = "relative"
+	require.True(t, berrors.ErrInvalidArgument.Equal(cfg.Validate()))
+}
+
+type fakeRawKVScanner struct {
+	keys [][]byte
+}
+
+func (s *fakeRawKVScanner) Scan(_ context.Context, startKey, endKey []byte, limit int) ([][]byte, [][]byte, error) {
+	var keys, values [][]byte
+	for _, key := range s.keys {
+		if bytes.Compare(key, startKey) >= 0 && (len(endKey) == 0 || bytes.Compare(key, endKey) < 0) &&
+			len(keys) < limit {
+			keys = append(keys, key)
+			values = append(values, []byte("v"))
+		}
+	}
+	return keys, values, nil
+}
+
+func TestCheckRawRestoreTargetEmpty(t *testing.T) {
+	ctx := context.Background()
+	restoreRanges := []rawRestoreRange{
+		{KeyRange: KeyRange{StartKey: []byte("a"), EndKey: []byte("c")}, CF: defaultRawCF},
+		{KeyRange: KeyRange{StartKey: []byte("a"), EndKey: []byte("c")}, CF: "write"},
+	}
+	noRewrite := &restore.RewriteRules{}
+	scanner := &fakeRawKVScanner{keys: [][]byte{[]byte("c"), []byte("xb")}}
+	// The cfs other than the default cf can't be checked.
+	err := checkRawRestoreTargetEmpty(ctx, scanner, restoreRanges, noRewrite)
+	require.True(t, berrors.ErrInvalidArgument.Equal(err))
+	restoreRanges = restoreRanges[:1]
+	require.NoError(t, checkRawRestoreTargetEmpty(ctx, scanner, restoreRanges, noRewrite))
+
+	scanner.keys = append(scanner.keys, []byte("b"))
+	err = checkRawRestoreTargetEmpty(ctx, scanner, restoreRanges, noRewrite)
+	require.True(t, berrors.ErrRestoreDataExists.Equal(err))
+
+	// The rewritten ranges are checked.
+	cfg := &RestoreRawConfig{OldKeyPrefix: []byte("a"), NewKeyPrefix: []byte("x")}
+	restoreRanges = []rawRestoreRange{
+		{KeyRange: KeyRange{StartKey: []byte("a"), EndKey: []byte("b")}, CF: defaultRawCF},
+	}
+	err = checkRawRestoreTargetEmpty(ctx, scanner, restoreRanges, cfg.rewriteRules())
+	require.True(t, berrors.ErrRestoreDataExists.Equal(err))
+	scanner.keys = [][]byte{[]byte("a1")}
+	require.NoError(t, checkRawRestoreTargetEmpty(ctx, scanner, restoreRanges, cfg.rewriteRules()))
+}
+
+func TestRawRestoreChecksumUnsupported(t *testing.T) {
+	full := []rawBackup{{meta: &backuppb.BackupMeta{EndVersion: 100}}}
+	cfg := DefaultRawRestoreConfig()
+	require.Empty(t, rawRestoreChecksumUnsupported(&cfg, full))
+	// The keys aren't rewritten if the prefixes are the same.
+	cfg.OldKeyPrefix, cfg.NewKeyPrefix = []byte("a"), []byte("a")
+	require.Empty(t, rawRestoreChecksumUnsupported(&cfg, full))
+
+	inc := append(full, rawBackup{meta: &backuppb.BackupMeta{StartVersion: 100, EndVersion: 200}})
+	require.NotEmpty(t, rawRestoreChecksumUnsupported(&cfg, inc))
+	cfg.NewKeyPrefix = []byte("b")
+	require.NotEmpty(t, rawRestoreChecksumUnsupported(&cfg, full))
+	cfg = DefaultRawRestoreConfig()
+	cfg.TTLMode = ttlModeRemaining
+	require.NotEmpty(t, rawRestoreChecksumUnsupported(&cfg, full))
+	cfg = DefaultRawRestoreConfig()
+	cfg.OnConflict = onConflictSkipExisting
+	require.NotEmpty(t, rawRestoreChecksumUnsupported(&cfg, full))
+}
+
+func TestRawChecksumRanges(t *testing.T) {
+	file := func(start, end string, kvs uint64) *backuppb.File {
+		return &backuppb.File{StartKey: []byte(start), EndKey: []byte(end), Cf: defaultRawCF, TotalKvs: kvs}
+	}
+	ranges := []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("b"), Files: []*backuppb.File{file("a", "b", 1)}},
+		{StartKey: []byte("c"), EndKey: []byte("e"), Files: []*backuppb.File{file("c", "e", 2)}},
+	}
+	rr := rawRestoreRange{KeyRange: KeyRange{StartKey: []byte("a"), EndKey: []byte("d")}, CF: defaultRawCF}
+
+	// The range partially restored is skipped without the recorded checksum.
+	checkRanges, err := rawChecksumRanges(rr, ranges, nil)
+	require.NoError(t, err)
+	require.Equal(t, ranges[:1], checkRanges)
+
+	// The whole backed up range is checked against the recorded checksum.
+	rr.EndKey = []byte("e")
+	recorded := []checksum.RawRangeChecksum{{
+		StartKey: []byte("a"), EndKey: []byte("e"), CF: defaultRawCF, RawChecksum: checksum.RawChecksum{TotalKvs: 3},
+	}}
+	checkRanges, err = rawChecksumRanges(rr, ranges, recorded)
+	require.NoError(t, err)
+	require.Len(t, checkRanges, 1)
+	require.Equal(t, []byte("a"), checkRanges[0].StartKey)
+	require.Equal(t, []byte("e"), checkRanges[0].EndKey)
+	require.Len(t, checkRanges[0].Files, 2)
+
+	// A file is missing.
+	_, err = rawChecksumRanges(rr, ranges[1:], recorded)
+	require.True(t, berrors.ErrRestoreChecksumMismatch.Equal(err))
+}
+
+func TestRestoreRawRanges(t *testing.T) {
+	meta := &backuppb.BackupMeta{
+		IsRawKv: true,
+		RawRanges: []*backuppb.RawRange{
+			{StartKey: []byte("a"), EndKey: []byte("c"), Cf: "default"},
+			{StartKey: []byte("e"), EndKey: []byte("g"), Cf: "default"},
+			{StartKey: []byte("a"), EndKey: []byte("c"), Cf: "write"},
+			{StartKey: []byte("e"), EndKey: []byte("g"), Cf: "write"},
+		},
+	}
+
+	// All the backed up ranges of the cf are restored by default.
+	cfg := &RestoreRawConfig{RawKvConfig: RawKvConfig{CFs: []string{"write"}}}
+	ranges, err := cfg.restoreRanges(meta)
+	require.NoError(t, err)
+	require.Equal(t, []rawRestoreRange{
+		{KeyRange: KeyRange{StartKey: []byte("a"), EndKey: []byte("c")}, CF: "write"},
+		{KeyRange: KeyRange{StartKey: []byte("e"), EndKey: []byte("g")}, CF: "write"},
+	}, ranges)
+	require.Len(t, cfg.rewriteRules().Data, 0)
+
+	cfg = &RestoreRawConfig{RawKvConfig: RawKvConfig{
+		CFs:    []string{"default", "write"},
+		Ranges: []KeyRange{{StartKey: []byte("e1"), EndKey: []byte("e2")}},
+	}}
+	ranges, err = cfg.restoreRanges(meta)
+	require.NoError(t, err)
+	require.Equal(t, []rawRestoreRange{
+		{KeyRange: KeyRange{StartKey: []byte("e1"), EndKey: []byte("e2")}, CF: "default"},
+		{KeyRange: KeyRange{StartKey: []byte("e1"), EndKey: []byte("e2")}, CF: "write"},
+	}, ranges)
+
+	cfg = &RestoreRawConfig{RawKvConfig: RawKvConfig{CFs: []string{"lock"}}}
+	_, err = cfg.restoreRanges(meta)
+	require.True(t, berrors.ErrRestoreRangeMismatch.Equal(err))
+
+	// The ranges are narrowed to the keys with the old prefix.
+	cfg = &RestoreRawConfig{
+		RawKvConfig:  RawKvConfig{CFs: []string{"default"}},
+		OldKeyPrefix: []byte("f"),
+		NewKeyPrefix: []byte("x"),
+	}
+	ranges, err = cfg.restoreRanges(meta)
+	require.NoError(t, err)
+	require.Equal(t, []rawRestoreRange{
+		{KeyRange: KeyRange{StartKey: []byte("f"), EndKey: []byte("g")}, CF: "default"},
+	}, ranges)
+	require.Len(t, cfg.rewriteRules().Data, 1)
+
+	cfg.OldKeyPrefix = []byte("d")
+	_, err = cfg.restoreRanges(meta)
+	require.True(t, berrors.ErrRestoreInvalidRange.Equal(err))
+}
+
+func TestDefaultRawRestoreConfig(t *testing.T) {
+	cfg := DefaultRawRestoreConfig()
+	require.Equal(t, []string{defaultRawCF}, cfg.CFs)
+	require.Equal(t, ttlModeAbsolute, cfg.TTLMode)
+	require.Equal(t, onConflictOverwrite, cfg.OnConflict)
+	require.Equal(t, defaultAdaptiveThrottleInterval, cfg.AdaptiveThrottleInterval)
+	require.Nil(t, cfg.KeyspaceID)
+	require.NotZero(t, cfg.SwitchModeInterval)
+}
+
+func TestRawKeyspacePrefixes(t *testing.T) {
+	v1, v1TTL, v2 := kvrpcpb.APIVersion_V1, kvrpcpb.APIVersion_V1TTL, kvrpcpb.APIVersion_V2
+	keyspace := func(id uint32) *uint32 { return &id }
+
+	// The keys are restored as they are between the clusters of API V1.
+	cfg := &RestoreRawConfig{}
+	src, dst, err := cfg.keyspacePrefixes(v1TTL, v1TTL)
+	require.NoError(t, err)
+	require.Empty(t, src)
+	require.Empty(t, dst)
+	src, dst, err = cfg.keyspacePrefixes(v2, v2)
+	require.NoError(t, err)
+	require.Empty(t, src)
+	require.Empty(t, dst)
+
+	// The incompatible restores are refused by default.
+	_, _, err = cfg.keyspacePrefixes(v1, v2)
+	require.True(t, berrors.ErrRestoreAPIVersionMismatch.Equal(err))
+	_, _, err = cfg.keyspacePrefixes(v2, v1TTL)
+	require.True(t, berrors.ErrRestoreAPIVersionMismatch.Equal(err))
+
+	// The backup of API V1 is converted into the target keyspace.
+	cfg = &RestoreRawConfig{TargetKeyspaceID: keyspace(1)}
+	src, dst, err = cfg.keyspacePrefixes(v1, v2)
+	require.NoError(t, err)
+	require.Empty(t, src)
+	require.Equal(t, []byte("r\x00\x00\x01"), dst)
+	_, _, err = cfg.keyspacePrefixes(v1, v1)
+	require.True(t, berrors.ErrRestoreAPIVersionMismatch.Equal(err))
+	_, _, err = cfg.keyspacePrefixes(v2, v2)
+	require.True(t, berrors.ErrInvalidArgument.Equal(err))
+
+	// A keyspace is extracted from the backup of API V2.
+	cfg = &RestoreRawConfig{KeyspaceID: keyspace(2)}
+	src, dst, err = cfg.keyspacePrefixes(v2, v2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("r\x00\x00\x02"), src)
+	require.Equal(t, []byte("r\x00\x00\x02"), dst)
+	cfg.TargetKeyspaceID = keyspace(3)
+	src, dst, err = cfg.keyspacePrefixes(v2, v2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("r\x00\x00\x02"), src)
+	require.Equal(t, []byte("r\x00\x00\x03"), dst)
+	_, _, err = cfg.keyspacePrefixes(v1, v2)
+	require.True(t, berrors.ErrRestoreAPIVersionMismatch.Equal(err))
+}
+
+func TestRestoreRawRangesWithKeyspace(t *testing.T) {
+	meta := &backuppb.BackupMeta{
+		IsRawKv:    true,
+		ApiVersion: kvrpcpb.APIVersion_V2,
+		RawRanges: []*backuppb.RawRange{
+			{StartKey: []byte("r\x00\x00\x01"), EndKey: []byte("r\x00\x00\x03"), Cf: "default"},
+		},
+	}
+
+	// Only the keys of the keyspace are restored into the same keyspace.
+	cfg := &RestoreRawConfig{RawKvConfig: RawKvConfig{CFs: []string{"default"}}}
+	newCfg := cfg.withKeyspacePrefixes([]byte("r\x00\x00\x02"), []byte("r\x00\x00\x02"))
+	ranges, err := newCfg.restoreRanges(meta)
+	require.NoError(t, err)
+	require.Equal(t, []rawRestoreRange{
+		{KeyRange: KeyRange{StartKey: []byte("r\x00\x00\x02"), EndKey: []byte("r\x00\x00\x03")}, CF: "default"},
+	}, ranges)
+	require.Len(t, newCfg.rewriteRules().Data, 0)
+
+	// The ranges and the rewrite prefixes are the keys in the keyspace.
+	cfg.Ranges = []KeyRange{{StartKey: []byte("a"), EndKey: []byte("")}}
+	cfg.OldKeyPrefix = []byte("a")
+	cfg.NewKeyPrefix = []byte("b")
+	newCfg = cfg.withKeyspacePrefixes([]byte("r\x00\x00\x02"), []byte("r\x00\x00\x05"))
+	ranges, err = newCfg.restoreRanges(meta)
+	require.NoError(t, err)
+	require.Equal(t, []rawRestoreRange{
+		{KeyRange: KeyRange{StartKey: []byte("r\x00\x00\x02a"), EndKey: []byte("r\x00\x00\x02b")}, CF: "default"},
+	}, ranges)
+	require.Equal(t, []byte("r\x00\x00\x02a"), newCfg.rewriteRules().Data[0].OldKeyPrefix)
+	require.Equal(t, []byte("r\x00\x00\x05b"), newCfg.rewriteRules().Data[0].NewKeyPrefix)
+	// The original config isn't changed.
+	require.Equal(t, []byte("a"), cfg.Ranges[0].StartKey)
+
+	// All the keys of the backup of API V1 are moved into the keyspace.
+	meta.ApiVersion = kvrpcpb.APIVersion_V1
+	meta.RawRanges[0] = &backuppb.RawRange{StartKey: []byte("a"), EndKey: []byte(""), Cf: "default"}
+	cfg = &RestoreRawConfig{RawKvConfig: RawKvConfig{CFs: []string{"default"}}}
+	newCfg = cfg.withKeyspacePrefixes(nil, []byte("r\x00\x00\x01"))
+	ranges, err = newCfg.restoreRanges(meta)
+	require.NoError(t, err)
+	rewriteRules := newCfg.rewriteRules()
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("r\x00\x00\x01a"), EndKey: []byte("r\x00\x00\x02")},
+	}, rawPlacementRanges(ranges, rewriteRules))
+}
+
+func TestRawPlacementRanges(t *testing.T) {
+	restoreRanges := []rawRestoreRange{
+		{KeyRange: KeyRange{StartKey: []byte("f"), EndKey: []byte("g")}, CF: "default"},
+		{KeyRange: KeyRange{StartKey: []byte("f"), EndKey: []byte("g")}, CF: "write"},
+		{KeyRange: KeyRange{StartKey: []byte("a"), EndKey: []byte("b")}, CF: "default"},
+	}
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("f"), EndKey: []byte("g")},
+	}, rawPlacementRanges(restoreRanges, &restore.RewriteRules{}))
+
+	// The rules are set for the rewritten keys.
+	cfg := &RestoreRawConfig{OldKeyPrefix: []byte("f"), NewKeyPrefix: []byte("x")}
+	require.Equal(t, []rtree.Range{
+		{StartKey: []byte("x"), EndKey: []byte("y")},
+	}, rawPlacementRanges(restoreRanges[:2], cfg.rewriteRules()))
+
+	cfg.RestoreStoreLabels = map[string]string{"zone": "z1", "disk": "ssd"}
+	require.Equal(t, []*metapb.StoreLabel{
+		{Key: "disk", Value: "ssd"},
+		{Key: "zone", Value: "z1"},
+	}, cfg.restoreStoreLabels())
+}
+
+func TestWriteRawRestorePlan(t *testing.T) {
+	plan := &rawRestorePlan{
+		Type:    eventPlan,
+		Backups: []rawRestorePlanBackup{{Storage: "local:///backup", EndVersion: 100, Files: 2}},
+		Ranges: []*rawRestorePlanRange{{
+			CF:             "default",
+			StartKey:       "66",
+			EndKey:         "67",
+			TargetStartKey: "78",
+			TargetEndKey:   "79",
+			Files:          2,
+			TotalKVs:       10,
+			TotalBytes:     2048,
+			DataSize:       1024,
+			SplitKeys:      1,
+			Regions:        1,
+			ExistingData:   existingDataNone,
+		}},
+		Files:      2,
+		TotalKVs:   10,
+		TotalBytes: 2048,
+		DataSize:   1024,
+		SplitKeys:  1,
+		Regions:    1,
+		Stores:     []uint64{1, 2},
+	}
+	out := &bytes.Buffer{}
+	require.NoError(t, plan.write(out))
+	require.Equal(t, `restore 1 backups:
+  local:///backup: versions (0, 100], 2 files
+restore 1 ranges:
+  cf default [66, 67) to [78, 79): 2 files, 10 kvs, 2.048kB, archive 1.024kB, 1 split keys, 1 regions, existing data: none
+total: 2 files, 10 kvs, 2.048kB, archive 1.024kB, 1 split keys, 1 regions, target stores [1 2]
+nothing is split or ingested in the dry run
+`, out.String())
+
+	// The plan is written as a JSON event in the JSON output.
+	summary.SetJSONOutput(out)
+	defer summary.SetJSONOutput(nil)
+	out.Reset()
+	require.NoError(t, plan.write(out))
+	decoded := &rawRestorePlan{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), decoded))
+	require.Equal(t, plan, decoded)
+}
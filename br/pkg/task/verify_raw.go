@@ -0,0 +1,252 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/pingcap/errors"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	"github.com/pingcap/log"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/restore"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/tikv/migration/br/pkg/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	flagAllowHoles = "allow-holes"
+
+	// defaultVerifyConcurrency is the number of files verified concurrently.
+	defaultVerifyConcurrency = 4
+)
+
+// VerifyRawConfig is the configuration of verifying a raw backup offline.
+type VerifyRawConfig struct {
+	Config
+
+	// AllowHoles doesn't fail the verification if some backed up ranges
+	// aren't covered by the files, which is expected if there was no data in
+	// the ranges.
+	AllowHoles bool `json:"allow-holes" toml:"allow-holes"`
+}
+
+// DefineVerifyRawFlags defines the flags of verifying raw backups.
+func DefineVerifyRawFlags(command *cobra.Command) {
+	command.Flags().Bool(flagAllowHoles, false,
+		"don't fail if some backed up ranges aren't covered by the files, "+
+			"the ranges without any data when backing up have no files")
+}
+
+// ParseFromFlags parses the verify raw flags from the flag set.
+func (cfg *VerifyRawConfig) ParseFromFlags(flags *pflag.FlagSet) error {
+	var err error
+	cfg.AllowHoles, err = flags.GetBool(flagAllowHoles)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = cfg.Config.ParseFromFlags(flags); err != nil {
+		return errors.Trace(err)
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultVerifyConcurrency
+	}
+	return nil
+}
+
+// rawBackupProblems are the problems found by verifying a raw backup.
+type rawBackupProblems struct {
+	mu sync.Mutex
+	// missing and corrupted are the names of the files, and the reasons of
+	// the corrupted files.
+	missing   []string
+	corrupted map[string]string
+	holes     []rawBackupHole
+}
+
+// rawBackupHole is a backed up range which isn't covered by any file.
+type rawBackupHole struct {
+	cf string
+	rtree.Range
+}
+
+func (p *rawBackupProblems) addMissing(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.missing = append(p.missing, name)
+}
+
+func (p *rawBackupProblems) addCorrupted(name, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.corrupted[name] = reason
+}
+
+// RunVerifyRaw verifies that the files of the raw backup are intact and cover
+// all the backed up ranges, by reading the storage only. The problems are
+// written to out, and an error is returned if there is any problem.
+func RunVerifyRaw(ctx context.Context, cfg *VerifyRawConfig, out io.Writer) error {
+	_, s, backupMeta, err := ReadBackupMeta(ctx, metautil.MetaFile, &cfg.Config)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !backupMeta.IsRawKv {
+		return errors.Annotate(berrors.ErrRestoreModeMismatch, "only the raw backup can be verified")
+	}
+	log.Info("verify raw backup", zap.Int("files", len(backupMeta.Files)), zap.Int("ranges", len(backupMeta.RawRanges)))
+
+	problems := &rawBackupProblems{corrupted: make(map[string]string)}
+	workerPool := utils.NewWorkerPool(uint(cfg.Concurrency), "verify")
+	eg, ectx := errgroup.WithContext(ctx)
+	for _, f := range backupMeta.Files {
+		file := f
+		workerPool.ApplyOnErrorGroup(eg, func() error {
+			return errors.Trace(verifyRawFile(ectx, s, file, &cfg.CipherInfo, problems))
+		})
+	}
+	if err = eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+	problems.holes = findRawBackupHoles(backupMeta.RawRanges, backupMeta.Files)
+
+	sort.Strings(problems.missing)
+	for _, name := range problems.missing {
+		fmt.Fprintf(out, "missing file: %s\n", name)
+	}
+	corrupted := make([]string, 0, len(problems.corrupted))
+	for name := range problems.corrupted {
+		corrupted = append(corrupted, name)
+	}
+	sort.Strings(corrupted)
+	for _, name := range corrupted {
+		fmt.Fprintf(out, "corrupted file: %s: %s\n", name, problems.corrupted[name])
+	}
+	for _, hole := range problems.holes {
+		fmt.Fprintf(out, "uncovered range: cf %s [%X, %X)\n", hole.cf, hole.StartKey, hole.EndKey)
+	}
+	fmt.Fprintf(out, "verified %d files of %d ranges: %d missing, %d corrupted, %d uncovered ranges\n",
+		len(backupMeta.Files), len(backupMeta.RawRanges), len(problems.missing), len(corrupted), len(problems.holes))
+
+	if len(problems.missing) > 0 || len(corrupted) > 0 || (len(problems.holes) > 0 && !cfg.AllowHoles) {
+		return errors.Annotatef(berrors.ErrBackupVerifyFailed, "%d missing files, %d corrupted files, %d uncovered ranges",
+			len(problems.missing), len(corrupted), len(problems.holes))
+	}
+	return nil
+}
+
+// verifyRawFile checks the size, checksum and the kv pairs of the file, the
+// problems are recorded and the error is returned only if the storage fails.
+func verifyRawFile(
+	ctx context.Context,
+	s storage.ExternalStorage,
+	file *backuppb.File,
+	cipher *backuppb.CipherInfo,
+	problems *rawBackupProblems,
+) error {
+	name := file.GetName()
+	exists, err := s.FileExists(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !exists {
+		problems.addMissing(name)
+		return nil
+	}
+	content, err := s.ReadFile(ctx, name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if file.GetSize_() > 0 && uint64(len(content)) != file.GetSize_() {
+		problems.addCorrupted(name, fmt.Sprintf("size mismatch, expect %d, got %d", file.GetSize_(), len(content)))
+		return nil
+	}
+	if checksum := sha256.Sum256(content); len(file.GetSha256()) > 0 && !bytes.Equal(checksum[:], file.GetSha256()) {
+		problems.addCorrupted(name, fmt.Sprintf("sha256 mismatch, expect %x, got %x", file.GetSha256(), checksum[:]))
+		return nil
+	}
+	if cipher.CipherType != encryptionpb.EncryptionMethod_PLAINTEXT && len(file.GetCipherIv()) != aes.BlockSize {
+		problems.addCorrupted(name, fmt.Sprintf("invalid cipher iv %x", file.GetCipherIv()))
+		return nil
+	}
+	content, err = metautil.Decrypt(content, cipher, file.GetCipherIv())
+	if err != nil {
+		problems.addCorrupted(name, fmt.Sprintf("failed to decrypt: %s", err))
+		return nil
+	}
+	// The content can't be parsed if it's decrypted by a wrong key.
+	var kvs uint64
+	err = restore.IterateRawSST(content, func(_, _ []byte) error {
+		kvs++
+		return nil
+	})
+	if err != nil {
+		problems.addCorrupted(name, fmt.Sprintf("failed to read sst: %s", err))
+		return nil
+	}
+	if file.GetTotalKvs() > 0 && kvs != file.GetTotalKvs() {
+		problems.addCorrupted(name, fmt.Sprintf("kv count mismatch, expect %d, got %d", file.GetTotalKvs(), kvs))
+	}
+	return nil
+}
+
+// findRawBackupHoles returns the parts of the backed up ranges which aren't
+// covered by any file of the same cf.
+func findRawBackupHoles(ranges []*backuppb.RawRange, files []*backuppb.File) []rawBackupHole {
+	filesByCF := make(map[string][]*backuppb.File)
+	for _, file := range files {
+		filesByCF[file.GetCf()] = append(filesByCF[file.GetCf()], file)
+	}
+	trees := make(map[string]rtree.RangeTree, len(filesByCF))
+	for cf, cfFiles := range filesByCF {
+		sort.Slice(cfFiles, func(i, j int) bool {
+			return bytes.Compare(cfFiles[i].GetStartKey(), cfFiles[j].GetStartKey()) < 0
+		})
+		// The tree requires the ranges not overlapping, so the adjacent and
+		// overlapping files are merged.
+		tree := rtree.NewRangeTree()
+		cur := rtree.Range{StartKey: cfFiles[0].GetStartKey(), EndKey: cfFiles[0].GetEndKey()}
+		for _, file := range cfFiles[1:] {
+			if len(cur.EndKey) == 0 {
+				break
+			}
+			if bytes.Compare(file.GetStartKey(), cur.EndKey) > 0 {
+				tree.InsertRange(cur)
+				cur = rtree.Range{StartKey: file.GetStartKey(), EndKey: file.GetEndKey()}
+				continue
+			}
+			if len(file.GetEndKey()) == 0 || bytes.Compare(file.GetEndKey(), cur.EndKey) > 0 {
+				cur.EndKey = file.GetEndKey()
+			}
+		}
+		tree.InsertRange(cur)
+		trees[cf] = tree
+	}
+
+	holes := make([]rawBackupHole, 0)
+	for _, r := range ranges {
+		tree, ok := trees[r.GetCf()]
+		if !ok {
+			// The whole range is uncovered without any file.
+			holes = append(holes, rawBackupHole{cf: r.GetCf(), Range: rtree.Range{StartKey: r.GetStartKey(), EndKey: r.GetEndKey()}})
+			continue
+		}
+		for _, hole := range tree.GetIncompleteRange(r.GetStartKey(), r.GetEndKey()) {
+			holes = append(holes, rawBackupHole{cf: r.GetCf(), Range: hole})
+		}
+	}
+	return holes
+}
@@ -0,0 +1,104 @@
+// Copyright 2022 TiKV Project Authors. Licensed under Apache-2.0.
+
+package task
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	backuppb "github.com/pingcap/kvproto/pkg/brpb"
+	"github.com/pingcap/kvproto/pkg/encryptionpb"
+	berrors "github.com/tikv/migration/br/pkg/errors"
+	"github.com/tikv/migration/br/pkg/metautil"
+	"github.com/tikv/migration/br/pkg/rtree"
+	"github.com/tikv/migration/br/pkg/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunVerifyRaw(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeRawBackup(t, dir, [][2]string{{"a", "1"}, {"b", "2"}, {"c", "3"}})
+	// Make the files cover the whole key space.
+	metaPath := filepath.Join(dir, metautil.MetaFile)
+	data, err := os.ReadFile(metaPath)
+	require.NoError(t, err)
+	meta := &backuppb.BackupMeta{}
+	require.NoError(t, proto.Unmarshal(data, meta))
+	meta.Files[0].StartKey, meta.Files[0].EndKey = nil, []byte("b")
+	meta.Files[1].EndKey = []byte("c")
+	meta.Files[2].EndKey = nil
+	for _, file := range meta.Files {
+		file.TotalKvs = 1
+	}
+	data, err = proto.Marshal(meta)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(metaPath, data, 0o644))
+
+	cfg := &VerifyRawConfig{
+		Config: Config{
+			Storage:     "local://" + dir,
+			CipherInfo:  backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_PLAINTEXT},
+			Concurrency: 2,
+		},
+	}
+	var out bytes.Buffer
+	require.NoError(t, RunVerifyRaw(ctx, cfg, &out))
+	require.Equal(t, "verified 3 files of 1 ranges: 0 missing, 0 corrupted, 0 uncovered ranges\n", out.String())
+
+	// The files decrypted by a wrong key can't be read.
+	s, err := storage.NewLocalStorage(dir)
+	require.NoError(t, err)
+	problems := &rawBackupProblems{corrupted: make(map[string]string)}
+	wrongKey := &backuppb.CipherInfo{CipherType: encryptionpb.EncryptionMethod_AES128_CTR, CipherKey: make([]byte, 16)}
+	require.NoError(t, verifyRawFile(ctx, s, meta.Files[0], wrongKey, problems))
+	require.Contains(t, problems.corrupted["a.sst"], "invalid cipher iv")
+	meta.Files[0].CipherIv = make([]byte, 16)
+	require.NoError(t, verifyRawFile(ctx, s, meta.Files[0], wrongKey, problems))
+	require.Contains(t, problems.corrupted["a.sst"], "failed to read sst")
+
+	f, err := os.OpenFile(filepath.Join(dir, "b.sst"), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("x"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(filepath.Join(dir, "c.sst")))
+	out.Reset()
+	err = RunVerifyRaw(ctx, cfg, &out)
+	require.True(t, berrors.Is(err, berrors.ErrBackupVerifyFailed))
+	require.Regexp(t, "^missing file: c.sst\ncorrupted file: b.sst: sha256 mismatch.*\n"+
+		"verified 3 files of 1 ranges: 1 missing, 1 corrupted, 0 uncovered ranges\n$", out.String())
+}
+
+func TestFindRawBackupHoles(t *testing.T) {
+	file := func(cf, start, end string) *backuppb.File {
+		return &backuppb.File{Cf: cf, StartKey: []byte(start), EndKey: []byte(end)}
+	}
+	hole := func(cf, start, end string) rawBackupHole {
+		return rawBackupHole{cf: cf, Range: rtree.Range{StartKey: []byte(start), EndKey: []byte(end)}}
+	}
+	ranges := []*backuppb.RawRange{
+		{StartKey: []byte("a"), EndKey: []byte("z"), Cf: "default"},
+		{StartKey: []byte("a"), EndKey: []byte("z"), Cf: "write"},
+	}
+	// The overlapping files are merged.
+	files := []*backuppb.File{
+		file("default", "m", "z"),
+		file("default", "a", "d"),
+		file("default", "c", "f"),
+		file("default", "f", "g"),
+	}
+	holes := findRawBackupHoles(ranges, files)
+	require.Equal(t, []rawBackupHole{hole("default", "g", "m"), hole("write", "a", "z")}, holes)
+
+	// The files may exceed the ranges.
+	files = []*backuppb.File{file("default", "", "b"), file("default", "c", ""), file("write", "", "")}
+	holes = findRawBackupHoles(ranges, files)
+	require.Equal(t, []rawBackupHole{hole("default", "b", "c")}, holes)
+	holes = findRawBackupHoles([]*backuppb.RawRange{{Cf: "default"}}, files)
+	require.Equal(t, []rawBackupHole{hole("default", "b", "c")}, holes)
+}
@@ -30,6 +30,37 @@ func ParseKey(format, key string) ([]byte, error) {
 	return nil, errors.Annotate(berrors.ErrInvalidArgument, "unknown format")
 }
 
+// FormatKey formats key by given format, it's the inverse of ParseKey.
+func FormatKey(format string, key []byte) (string, error) {
+	switch format {
+	case "raw":
+		return string(key), nil
+	case "escaped":
+		return escapedKey(key), nil
+	case "hex":
+		return hex.EncodeToString(key), nil
+	}
+	return "", errors.Annotate(berrors.ErrInvalidArgument, "unknown format")
+}
+
+// escapedKey escapes the non-printable bytes of key, which can be parsed by unescapedKey.
+func escapedKey(key []byte) string {
+	var buf strings.Builder
+	for _, c := range key {
+		if idx := strings.IndexByte("\a\b\f\n\r\t\v\\'\"", c); idx != -1 {
+			buf.WriteByte('\\')
+			buf.WriteByte(`abfnrtv\'"`[idx])
+			continue
+		}
+		if c < 0x20 || c >= 0x7f {
+			fmt.Fprintf(&buf, "\\x%02x", c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return buf.String()
+}
+
 // Ref PD: https://github.com/pingcap/pd/blob/master/tools/pd-ctl/pdctl/command/region_command.go#L334
 func unescapedKey(text string) ([]byte, error) {
 	var buf []byte
@@ -89,3 +120,16 @@ func CompareEndKey(a, b []byte) int {
 
 	return bytes.Compare(a, b)
 }
+
+const (
+	// apiV2RawModePrefix is the first byte of the raw keys in API V2.
+	apiV2RawModePrefix = 'r'
+	// MaxKeyspaceID is the max keyspace ID in API V2, which is encoded in 3 bytes.
+	MaxKeyspaceID = 1<<24 - 1
+)
+
+// APIV2RawKeyPrefix returns the prefix of the raw keys of the keyspace in API
+// V2, which is the mode prefix followed by the big-endian keyspace ID.
+func APIV2RawKeyPrefix(keyspaceID uint32) []byte {
+	return []byte{apiV2RawModePrefix, byte(keyspaceID >> 16), byte(keyspaceID >> 8), byte(keyspaceID)}
+}
@@ -111,3 +111,26 @@ func TestCompareEndKey(t *testing.T) {
 		require.Equal(t, tt.ans, res)
 	}
 }
+
+func TestFormatKey(t *testing.T) {
+	key := []byte("a\x00\xff\n\\'\"z")
+	for _, format := range []string{"raw", "escaped", "hex"} {
+		formatted, err := FormatKey(format, key)
+		require.NoError(t, err)
+		parsed, err := ParseKey(format, formatted)
+		require.NoError(t, err)
+		require.Equal(t, key, parsed)
+	}
+	escaped, err := FormatKey("escaped", key)
+	require.NoError(t, err)
+	require.Equal(t, `a\x00\xff\n\\\'\"z`, escaped)
+
+	_, err = FormatKey("unknown", key)
+	require.Error(t, err)
+}
+
+func TestAPIV2RawKeyPrefix(t *testing.T) {
+	require.Equal(t, []byte("r\x00\x00\x00"), APIV2RawKeyPrefix(0))
+	require.Equal(t, []byte("r\x01\x02\x03"), APIV2RawKeyPrefix(0x010203))
+	require.Equal(t, []byte("r\xff\xff\xff"), APIV2RawKeyPrefix(MaxKeyspaceID))
+}
@@ -13,11 +13,16 @@ import (
 	"github.com/cheggaaa/pb/v3"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/log"
+	"github.com/tikv/migration/br/pkg/summary"
 	"go.uber.org/zap"
 )
 
 type logFunc func(msg string, fields ...zap.Field)
 
+// progressEventInterval is the interval of the progress events if the JSON
+// output is enabled.
+var progressEventInterval = 5 * time.Second
+
 // ProgressPrinter prints a progress bar.
 type ProgressPrinter struct {
 	name        string
@@ -70,6 +75,10 @@ func (pp *ProgressPrinter) goPrintProgress(
 	logFuncImpl logFunc,
 	testWriter io.Writer, // Only for tests
 ) {
+	if summary.JSONOutputEnabled() {
+		pp.goPrintJSONProgress(ctx)
+		return
+	}
 	bar := pb.New64(pp.total)
 	if pp.redirectLog || testWriter != nil {
 		tmpl := `{"P":"{{percent .}}","C":"{{counters . }}","E":"{{etime .}}","R":"{{rtime .}}","S":"{{speed .}}"}`
@@ -131,6 +140,63 @@ func (pp *ProgressPrinter) goPrintProgress(
 	}()
 }
 
+// goPrintJSONProgress starts a goroutine and writes the progress events
+// periodically to the JSON output instead of printing a progress bar. The
+// bytes are read from the summary collector of the context.
+func (pp *ProgressPrinter) goPrintJSONProgress(ctx context.Context) {
+	start := time.Now()
+	collector := summary.FromContext(ctx)
+	closeCh := make(chan struct{}, 1)
+	closed := make(chan struct{})
+	pp.closeMu.Lock()
+	pp.closeCh = closeCh
+	pp.closed = closed
+	pp.closeMu.Unlock()
+	go func() {
+		defer close(closed)
+		t := time.NewTicker(progressEventInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-closeCh:
+				pp.writeJSONProgress(collector, start, pp.total)
+				return
+			case <-t.C:
+			}
+			pp.writeJSONProgress(collector, start, atomic.LoadInt64(&pp.progress))
+		}
+	}()
+}
+
+func (pp *ProgressPrinter) writeJSONProgress(collector summary.LogCollector, start time.Time, completed int64) {
+	if completed > pp.total {
+		completed = pp.total
+	}
+	elapsed := time.Since(start)
+	event := summary.ProgressEvent{
+		Type:           summary.EventProgress,
+		Step:           pp.name,
+		Completed:      completed,
+		Total:          pp.total,
+		Percent:        100,
+		Bytes:          collector.Snapshot(pp.name).TotalBytes,
+		ElapsedSeconds: elapsed.Seconds(),
+		ETASeconds:     -1,
+	}
+	if pp.total > 0 {
+		event.Percent = float64(completed) * 100 / float64(pp.total)
+	}
+	if completed > 0 {
+		event.ETASeconds = elapsed.Seconds() * float64(pp.total-completed) / float64(completed)
+	}
+	if err := summary.WriteJSONEvent(event); err != nil {
+		log.Warn("failed to write the progress", zap.Error(err))
+	}
+}
+
 type wrappedWriter struct {
 	name string
 	log  logFunc
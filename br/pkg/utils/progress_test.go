@@ -4,10 +4,13 @@ package utils
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 	"time"
 
+	"github.com/tikv/migration/br/pkg/summary"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 type testWriter struct {
@@ -74,3 +77,40 @@ func TestProgress(t *testing.T) {
 	require.Contains(t, p, `"P":"25.00%"`)
 	progress8.Close()
 }
+
+func TestJSONProgress(t *testing.T) {
+	defer func(interval time.Duration) { progressEventInterval = interval }(progressEventInterval)
+	progressEventInterval = 100 * time.Millisecond
+	events := make(chan summary.ProgressEvent, 16)
+	summary.SetJSONOutput(&testWriter{fn: func(p string) {
+		var event summary.ProgressEvent
+		require.NoError(t, json.Unmarshal([]byte(p), &event))
+		events <- event
+	}})
+	defer summary.SetJSONOutput(nil)
+
+	// The bytes are read from the collector of the task.
+	collector := summary.NewLogCollector(func(string, ...zap.Field) {})
+	collector.CollectSuccessUnit(summary.TotalBytes, 1, uint64(1024))
+	summary.CollectSuccessUnit(summary.TotalBytes, 1, uint64(1))
+	ctx := summary.WithCollector(context.Background(), collector)
+	progress := StartProgress(ctx, "test", 4, false, nil)
+	progress.Inc()
+	event := <-events
+	require.Equal(t, summary.EventProgress, event.Type)
+	require.Equal(t, "test", event.Step)
+	require.Equal(t, int64(1), event.Completed)
+	require.Equal(t, int64(4), event.Total)
+	require.Equal(t, 25.0, event.Percent)
+	require.GreaterOrEqual(t, event.ETASeconds, 0.0)
+	require.Equal(t, uint64(1024), event.Bytes)
+
+	progress.Close()
+	for event = range events {
+		if event.Completed == 4 {
+			break
+		}
+	}
+	require.Equal(t, 100.0, event.Percent)
+	require.Equal(t, 0.0, event.ETASeconds)
+}